@@ -0,0 +1,325 @@
+package main
+
+import (
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// importChunkSize is the size of the chunks import streams the export in.
+const importChunkSize = 64 * 1024
+
+// adminCommands are the subcommands of admin, managing the server through the AdminService
+// and the ReplicationService.
+var adminCommands = map[string]func(cli *CLI, args []string){
+	"quotas":             showQuotas,
+	"set-quotas":         setQuotas,
+	"replication-status": showReplicationStatus,
+	"purge":              purgeQueue,
+	"export":             exportQueue,
+	"import":             importQueue,
+	"snapshot":           snapshotQueue,
+}
+
+func admin(cli *CLI, args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: cli [flags] %s", commands["admin"].usage)
+	}
+	command, ok := adminCommands[args[0]]
+	if !ok {
+		log.Fatalf("unknown admin command %q, usage: cli [flags] %s", args[0], commands["admin"].usage)
+	}
+	command(cli, args[1:])
+}
+
+func describeQueue(cli *CLI, _ []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	description, err := cli.adminClient().DescribeQueue(ctx, &netinternal.DescribeQueueRequest{})
+	if err != nil {
+		log.Fatalf("failed to describe the queue: %v", err)
+	}
+	cli.printResponse(description, func(w io.Writer) {
+		fmt.Fprintf(w, "queue %s: %d messages, head id %d, tail id %d, next id %d, %d bytes\n",
+			description.Name, description.Messages, description.HeadId, description.TailId, description.NextId, description.TotalBytes)
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "SEGMENT\tBYTES\tMESSAGES\tFIRST ID\tLAST ID\tLOCATION")
+		for _, segment := range description.Segments {
+			location := "local"
+			if !segment.Local {
+				location = "archive"
+			}
+			if segment.Active {
+				location += ", active"
+			}
+			fmt.Fprintf(writer, "%d\t%d\t%d\t%d\t%d\t%s\n", segment.Id, segment.SizeInBytes, segment.Messages, segment.FirstId, segment.LastId, location)
+		}
+		writer.Flush()
+	})
+}
+
+func listConsumers(cli *CLI, _ []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	list, err := cli.adminClient().ListConsumers(ctx, &netinternal.ListConsumersRequest{})
+	if err != nil {
+		log.Fatalf("failed to list consumers: %v", err)
+	}
+	cli.printResponse(list, func(w io.Writer) {
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "CONSUMER\tOFFSET\tLAG\tONLINE")
+		for _, consumer := range list.Consumers {
+			fmt.Fprintf(writer, "%d\t%d\t%d\t%t\n", consumer.ConsumerId, consumer.Offset, consumer.Lag, consumer.Online)
+		}
+		writer.Flush()
+	})
+}
+
+// seek moves a consumer to earliest, latest or the message ID given as arguments.
+func seek(cli *CLI, args []string) {
+	if len(args) != 2 {
+		log.Fatalf("usage: cli [flags] %s", commands["seek"].usage)
+	}
+	consumerId, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		log.Fatalf("invalid consumer id %q", args[0])
+	}
+	request := &netinternal.ResetConsumerRequest{ConsumerId: consumerId}
+	switch args[1] {
+	case "earliest":
+		request.Position = netinternal.ResetConsumerRequest_EARLIEST
+	case "latest":
+		request.Position = netinternal.ResetConsumerRequest_LATEST
+	default:
+		if request.MessageId, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			log.Fatalf("invalid position %q, expected earliest, latest or a message id", args[1])
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	consumer, err := cli.adminClient().ResetConsumer(ctx, request)
+	if err != nil {
+		log.Fatalf("failed to move consumer %d: %v", consumerId, err)
+	}
+	cli.printResponse(consumer, func(w io.Writer) {
+		fmt.Fprintf(w, "consumer %d: offset %d, lag %d\n", consumer.ConsumerId, consumer.Offset, consumer.Lag)
+	})
+}
+
+func purgeQueue(cli *CLI, _ []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	response, err := cli.adminClient().PurgeQueue(ctx, &netinternal.PurgeQueueRequest{})
+	if err != nil {
+		log.Fatalf("failed to purge the queue: %v", err)
+	}
+	cli.printResponse(response, func(w io.Writer) {
+		fmt.Fprintf(w, "purged %d messages, next id %d\n", response.Dropped, response.NextId)
+	})
+}
+
+// snapshotQueue has the server write a snapshot of its data directories to the directory given as argument,
+// which is a path on the server.
+func snapshotQueue(cli *CLI, args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: cli [flags] admin snapshot <server-directory>")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	snapshot, err := cli.adminClient().Snapshot(ctx, &netinternal.SnapshotRequest{Directory: args[0]})
+	if err != nil {
+		log.Fatalf("failed to snapshot: %v", err)
+	}
+	cli.printResponse(snapshot, func(w io.Writer) {
+		fmt.Fprintf(w, "snapshot %s: %d segments, %d bytes, next id %d, %d consumers\n",
+			snapshot.Directory, snapshot.Segments, snapshot.SizeInBytes, snapshot.NextId, snapshot.Consumers)
+	})
+}
+
+// newAdminFlagSet returns the flags of an admin subcommand, printing usage on errors.
+func newAdminFlagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet("admin "+name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: cli [flags] admin %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// exportQueue writes the messages of a range of IDs to stdout, or the file given with -o,
+// in a format import reads back.
+func exportQueue(cli *CLI, args []string) {
+	flags := newAdminFlagSet("export", "[flags]")
+	from := flags.Uint64("from", 0, "ID of the first message to export")
+	to := flags.Uint64("to", 0, "ID after the last message to export, 0 exports up to the newest message")
+	format := flags.String("format", "jsonl", "format of the export: jsonl, one JSON object per message, or binary")
+	outputFile := flags.String("o", "", "file to write the export to instead of stdout")
+	flags.Parse(args)
+
+	request := &netinternal.ExportQueueRequest{FromId: *from, ToId: *to}
+	switch *format {
+	case "jsonl":
+		request.Format = netinternal.ExportQueueRequest_JSONL
+	case "binary":
+		request.Format = netinternal.ExportQueueRequest_BINARY
+	default:
+		log.Fatalf("unknown export format %q, expected jsonl or binary", *format)
+	}
+	out := os.Stdout
+	if *outputFile != "" {
+		file, err := os.Create(*outputFile)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *outputFile, err)
+		}
+		out = file
+	}
+
+	stream, err := cli.adminClient().ExportQueue(context.Background(), request)
+	if err != nil {
+		log.Fatalf("failed to export: %v", err)
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("failed to export: %v", err)
+		}
+		if _, err := out.Write(chunk.Data); err != nil {
+			log.Fatalf("failed to write the export: %v", err)
+		}
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			log.Fatalf("failed to write the export: %v", err)
+		}
+	}
+}
+
+// importQueue appends the messages of an export read from the file given as argument, or stdin.
+// Only an import from a file is retried against the leader a follower redirects to.
+func importQueue(cli *CLI, args []string) {
+	flags := newAdminFlagSet("import", "[flags] [file]")
+	preserveIds := flags.Bool("preserve-ids", false, "keep the exported message IDs, which must follow the newest message of the queue")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	open := func() io.ReadCloser {
+		if flags.NArg() == 0 {
+			return os.Stdin
+		}
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatalf("failed to open %s: %v", flags.Arg(0), err)
+		}
+		return file
+	}
+	for {
+		input := open()
+		response, trailer, err := cli.importFrom(input, *preserveIds)
+		input.Close()
+		if err != nil && flags.NArg() == 1 && cli.followLeader(err, trailer) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("failed to import: %v", err)
+		}
+		cli.printResponse(response, func(w io.Writer) {
+			fmt.Fprintf(w, "imported %d messages, next id %d\n", response.Imported, response.NextId)
+		})
+		return
+	}
+}
+
+// importFrom streams input to ImportQueue, returning the trailer to follow a redirect with.
+func (c *CLI) importFrom(input io.Reader, preserveIds bool) (*netinternal.ImportQueueResponse, metadata.MD, error) {
+	var trailer metadata.MD
+	stream, err := c.adminClient().ImportQueue(context.Background(), grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, trailer, err
+	}
+	buf := make([]byte, importChunkSize)
+	for {
+		n, readErr := input.Read(buf)
+		if n > 0 {
+			// A failed send is reported by CloseAndRecv with the status of the stream.
+			if err := stream.Send(&netinternal.ImportChunk{PreserveIds: preserveIds, Data: buf[:n]}); err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			log.Fatalf("failed to read the export: %v", readErr)
+		}
+	}
+	response, err := stream.CloseAndRecv()
+	return response, trailer, err
+}
+
+func showReplicationStatus(cli *CLI, _ []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	replicationStatus, err := netinternal.NewReplicationServiceClient(cli.conn).Status(ctx, &netinternal.ReplicationStatusRequest{})
+	if err != nil {
+		log.Fatalf("failed to get replication status: %v", err)
+	}
+	cli.printResponse(replicationStatus, func(w io.Writer) {
+		fmt.Fprintf(w, "leader next id: %d\n", replicationStatus.NextId)
+		for _, follower := range replicationStatus.Followers {
+			fmt.Fprintf(w, "follower %s: next id %d, lag %d, connected %t\n", follower.FollowerId, follower.NextId, follower.Lag, follower.Connected)
+		}
+	})
+}
+
+func showQuotas(cli *CLI, _ []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	quotas, err := cli.adminClient().GetQuotas(ctx, &netinternal.GetQuotasRequest{})
+	if err != nil {
+		log.Fatalf("failed to get quotas: %v", err)
+	}
+	cli.printQuotas(quotas)
+}
+
+// setQuotas replaces the rate limits of the server with the JSON given as argument, e.g.
+// {"produce": {"messagesPerSecond": 100}, "clients": [{"client": "billing", "produce": {"bytesPerSecond": 1048576}}]}
+func setQuotas(cli *CLI, args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: cli [flags] admin set-quotas <json>")
+	}
+	requested := &netinternal.Quotas{}
+	if err := protojson.Unmarshal([]byte(args[0]), requested); err != nil {
+		log.Fatalf("invalid quotas: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	quotas, err := cli.adminClient().SetQuotas(ctx, requested)
+	if err != nil {
+		log.Fatalf("failed to set quotas: %v", err)
+	}
+	cli.printQuotas(quotas)
+}
+
+// printQuotas prints quotas as indented JSON in text, they have too many optional fields for a table.
+func (c *CLI) printQuotas(quotas *netinternal.Quotas) {
+	c.printResponse(quotas, func(w io.Writer) {
+		fmt.Fprintln(w, protojson.Format(quotas))
+	})
+}
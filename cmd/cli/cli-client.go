@@ -1,84 +1,227 @@
 package main
 
 import (
+	"ashishkujoy/queue/internal/auth"
+	queuenet "ashishkujoy/queue/internal/net"
 	netinternal "ashishkujoy/queue/proto"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"io"
-	"log"
-	"time"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// CLIOptions are the flags shared by every subcommand, given before its name.
 type CLIOptions struct {
-	msg        string
-	publish    bool
-	consumerId uint64
+	addr   string
+	output string
+	tls    tlsOptions
+	token  string
+}
+
+// tlsOptions configure how the CLI authenticates the server, and itself with mutual TLS.
+type tlsOptions struct {
+	enabled    bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+}
+
+// command is a subcommand of the CLI, run with the arguments following its name.
+type command struct {
+	usage   string
+	summary string
+	run     func(cli *CLI, args []string)
+}
+
+// commands is filled in init, subcommands print their usage from it.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"produce":   {usage: "produce [flags] [file ...]", summary: "enqueue the lines of stdin or of the files, or each file as a message", run: produce},
+		"consume":   {usage: "consume [flags]", summary: "receive messages as a consumer, moving its position", run: consume},
+		"fetch":     {usage: "fetch [flags]", summary: "read messages from a message ID on without moving any consumer", run: fetch},
+		"seek":      {usage: "seek <consumer-id> <earliest|latest|message-id>", summary: "move the position of a consumer", run: seek},
+		"describe":  {usage: "describe", summary: "show the message IDs, size and segments of the queue", run: describeQueue},
+		"consumers": {usage: "consumers", summary: "list the consumers with their position and lag", run: listConsumers},
+		"admin":     {usage: "admin <quotas | set-quotas <json> | replication-status | purge | export [flags] | import [flags] [file] | snapshot <server-directory>>", summary: "manage the server", run: admin},
+	}
 }
 
 func NewCLIOptions() *CLIOptions {
-	msg := flag.String("msg", "", "message to send")
-	publish := flag.Bool("publish", false, "publish message to the queue")
-	consumerId := flag.Uint64("consumer-id", 0, "consumer id")
+	addr := flag.String("addr", "localhost:50051", "address of the queue server")
+	output := flag.String("output", "text", "format of the output: text, json or hex, hex prints keys and messages hex encoded")
+	token := flag.String("token", os.Getenv("QUEUE_TOKEN"), "API token to authenticate with, defaults to $QUEUE_TOKEN")
+	useTLS := flag.Bool("tls", false, "connect over TLS, implied by the other -tls flags")
+	caFile := flag.String("tls-ca-file", "", "PEM bundle of the CAs the server certificate is verified against, defaults to the system CAs")
+	certFile := flag.String("tls-cert-file", "", "PEM client certificate presented to a server requiring mutual TLS")
+	keyFile := flag.String("tls-key-file", "", "PEM private key of the client certificate")
+	serverName := flag.String("tls-server-name", "", "name the server certificate is verified for, defaults to the host of -addr")
 
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: cli [flags] <command> [command flags] [arguments]\n\ncommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].summary)
+		}
+		fmt.Fprintf(out, "\nflags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	return &CLIOptions{
-		msg:        *msg,
-		publish:    *publish,
-		consumerId: *consumerId,
+		addr:   *addr,
+		output: *output,
+		token:  *token,
+		tls: tlsOptions{
+			enabled:    *useTLS || *caFile != "" || *certFile != "" || *serverName != "",
+			caFile:     *caFile,
+			certFile:   *certFile,
+			keyFile:    *keyFile,
+			serverName: *serverName,
+		},
+	}
+}
+
+// transportCredentials returns the credentials the CLI connects with, plaintext unless TLS is enabled.
+func (o tlsOptions) transportCredentials() (credentials.TransportCredentials, error) {
+	if !o.enabled {
+		return insecure.NewCredentials(), nil
 	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: o.serverName}
+	if o.caFile != "" {
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.caFile)
+		}
+	}
+	if o.certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// tokenCredentials sends an API token with every RPC.
+type tokenCredentials struct {
+	token string
 }
 
-func createQueueClient() (netinternal.QueueServiceClient, *grpc.ClientConn) {
-	conn, err := grpc.NewClient("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+func (c tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{auth.TokenMetadataKey: "Bearer " + c.token}, nil
+}
+
+// RequireTransportSecurity returns false so tokens also work with a server without TLS on a trusted network.
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// CLI is the connection subcommands talk to the server through.
+type CLI struct {
+	options *CLIOptions
+	conn    *grpc.ClientConn
+	out     *printer
+}
+
+func dial(addr string, cliOptions *CLIOptions) *grpc.ClientConn {
+	creds, err := cliOptions.tls.transportCredentials()
+	if err != nil {
+		log.Fatalf("failed to load TLS credentials: %v", err)
+	}
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// The server bounds the size of messages, the CLI receives whatever it was configured to accept.
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32)),
+	}
+	if cliOptions.token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(tokenCredentials{token: cliOptions.token}))
+	}
+	conn, err := grpc.NewClient(addr, dialOptions...)
 	if err != nil {
 		log.Fatalf("failed to connect: %v", err)
 	}
-	client := netinternal.NewQueueServiceClient(conn)
-	return client, conn
+	return conn
 }
 
-func enqueueMsg(cliOptions *CLIOptions, client netinternal.QueueServiceClient) {
-	fmt.Printf("publishing message to queue: %s\n", cliOptions.msg)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	_, err := client.Enqueue(ctx, &netinternal.EnqueueRequest{Message: []byte(cliOptions.msg)})
-	if err != nil {
-		log.Fatalf("failed to enqueue: %v", err)
+func (c *CLI) queueClient() netinternal.QueueServiceClient {
+	return netinternal.NewQueueServiceClient(c.conn)
+}
+
+func (c *CLI) adminClient() netinternal.AdminServiceClient {
+	return netinternal.NewAdminServiceClient(c.conn)
+}
+
+// followLeader reconnects to the leader a server that does not lead redirected the call to,
+// and reports whether the call should be retried.
+func (c *CLI) followLeader(err error, trailer metadata.MD) bool {
+	leader := leaderAddress(err, trailer)
+	if leader == "" {
+		return false
 	}
-	return
+	fmt.Fprintf(os.Stderr, "redirected to the leader %s\n", leader)
+	c.conn.Close()
+	c.conn = dial(leader, c.options)
+	return true
 }
 
-func observeQueueMsg(cliOptions *CLIOptions, client netinternal.QueueServiceClient) {
-	fmt.Printf("Observing message from queue, consumer id = %d\n", cliOptions.consumerId)
-	queue, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: cliOptions.consumerId})
-	if err != nil {
-		log.Fatalf("failed to observe: %v", err)
+// leaderAddress returns the leader a server that does not lead redirected the call to, if any.
+func leaderAddress(err error, trailer metadata.MD) string {
+	if status.Code(err) != codes.FailedPrecondition {
+		return ""
 	}
-	for {
-		queueMessage, err := queue.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatalf("failed to receive: %v", err)
-		}
-		fmt.Printf("received message: %s\n", string(queueMessage.Message))
+	if leader := trailer.Get(queuenet.LeaderMetadataKey); len(leader) != 0 {
+		return leader[0]
 	}
+	return ""
+}
+
+// newFlagSet returns the flags of a subcommand, printing its usage line on errors.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: cli [flags] %s\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
 }
 
 func main() {
 	cliOptions := NewCLIOptions()
-	client, conn := createQueueClient()
-	defer conn.Close()
-
-	if cliOptions.publish {
-		enqueueMsg(cliOptions, client)
-		return
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
 	}
-
-	observeQueueMsg(cliOptions, client)
+	out, err := newPrinter(cliOptions.output, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cli := &CLI{options: cliOptions, conn: dial(cliOptions.addr, cliOptions), out: out}
+	defer func() { cli.conn.Close() }()
+	command.run(cli, flag.Args()[1:])
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fetchPollInterval is how often fetch asks for new messages once it has caught up, while waiting for them.
+const fetchPollInterval = 200 * time.Millisecond
+
+// consume prints the messages delivered to a consumer until -max-messages were received,
+// no message arrived for -timeout, or the server ends the stream.
+func consume(cli *CLI, args []string) {
+	flags := newFlagSet("consume")
+	consumerId := flags.Uint64("consumer-id", 0, "ID of the consumer, whose position is kept by the server")
+	maxMessages := flags.Uint64("max-messages", 0, "exit after receiving this many messages, 0 for no limit")
+	timeout := flags.Duration("timeout", 0, "exit once no message arrived for this long, 0 to wait forever")
+	flags.Parse(args)
+
+	request := &netinternal.ObserveQueueRequest{ConsumerId: *consumerId, MaxMessages: *maxMessages}
+	for redirected := false; ; redirected = true {
+		trailer, err := cli.observe(request, *timeout)
+		if err == nil {
+			return
+		}
+		if redirected || !cli.followLeader(err, trailer) {
+			log.Fatalf("failed to consume: %v", err)
+		}
+	}
+}
+
+// observe prints the messages of the stream until the server ends it or it was idle for timeout.
+// It returns the trailer of a stream that failed, which may redirect to the leader.
+func (c *CLI) observe(request *netinternal.ObserveQueueRequest, timeout time.Duration) (metadata.MD, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := stopWhenIdle(timeout, cancel)
+	defer idle.Stop()
+	stream, err := c.queueClient().ObserveQueue(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		message, err := stream.Recv()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if status.Code(err) == codes.Canceled && ctx.Err() != nil {
+			return nil, nil
+		}
+		if err != nil {
+			return stream.Trailer(), err
+		}
+		idle.Reset(timeout)
+		if err := c.out.message(message); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// idleTimer calls a function once it has not been reset for a while, or never without a timeout.
+type idleTimer struct {
+	timer *time.Timer
+}
+
+func stopWhenIdle(timeout time.Duration, stop func()) idleTimer {
+	if timeout <= 0 {
+		return idleTimer{}
+	}
+	return idleTimer{timer: time.AfterFunc(timeout, stop)}
+}
+
+func (t idleTimer) Reset(timeout time.Duration) {
+	if t.timer != nil {
+		t.timer.Reset(timeout)
+	}
+}
+
+func (t idleTimer) Stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// fetch prints messages from a message ID on without moving any consumer. It exits once it
+// has caught up with the queue, or with -timeout once no new message arrived for that long.
+func fetch(cli *CLI, args []string) {
+	flags := newFlagSet("fetch")
+	fromId := flags.Uint64("from", 0, "ID of the first message to print")
+	maxMessages := flags.Uint64("max-messages", 0, "exit after printing this many messages, 0 for no limit")
+	timeout := flags.Duration("timeout", 0, "wait for new messages until none arrived for this long instead of exiting once caught up")
+	flags.Parse(args)
+
+	request := &netinternal.FetchRequest{FromId: *fromId}
+	printed := uint64(0)
+	lastMessage := time.Now()
+	for *maxMessages == 0 || printed < *maxMessages {
+		if *maxMessages != 0 {
+			request.MaxMessages = *maxMessages - printed
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		response, err := cli.queueClient().Fetch(ctx, request)
+		cancel()
+		if err != nil {
+			log.Fatalf("failed to fetch: %v", err)
+		}
+		for _, message := range response.Messages {
+			if err := cli.out.message(message); err != nil {
+				log.Fatalf("failed to print: %v", err)
+			}
+		}
+		printed += uint64(len(response.Messages))
+		request.FromId = response.NextId
+		if len(response.Messages) != 0 {
+			lastMessage = time.Now()
+			continue
+		}
+		if time.Since(lastMessage) >= *timeout {
+			return
+		}
+		time.Sleep(fetchPollInterval)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	netinternal "ashishkujoy/queue/proto"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputHex  = "hex"
+)
+
+// printer writes the results of subcommands in the format selected with -output.
+// text prints one tab separated line per message, json one object per line and hex
+// is text with the keys and messages hex encoded, for binary payloads.
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	switch format {
+	case outputText, outputJSON, outputHex:
+		return &printer{format: format, w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected text, json or hex", format)
+}
+
+// jsonMessage is a message printed as JSON. The key and message are strings when
+// they are valid UTF-8, and are base64 encoded into the fields suffixed with Base64 otherwise.
+type jsonMessage struct {
+	Id            uint64            `json:"id"`
+	Key           string            `json:"key,omitempty"`
+	KeyBase64     string            `json:"keyBase64,omitempty"`
+	Message       string            `json:"message,omitempty"`
+	MessageBase64 string            `json:"messageBase64,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+}
+
+// message prints a message received from the queue: its ID, key and payload.
+func (p *printer) message(message *netinternal.QueueMessage) error {
+	switch p.format {
+	case outputJSON:
+		printed := jsonMessage{Id: message.Id, Headers: message.Headers}
+		printed.Key, printed.KeyBase64 = stringOrBase64(message.Key)
+		printed.Message, printed.MessageBase64 = stringOrBase64(message.Message)
+		return p.json(printed)
+	case outputHex:
+		_, err := fmt.Fprintf(p.w, "%d\t%s\t%s\n", message.Id, hex.EncodeToString(message.Key), hex.EncodeToString(message.Message))
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, "%d\t%s\t%s\n", message.Id, message.Key, message.Message)
+	return err
+}
+
+func stringOrBase64(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(data)
+}
+
+// produced prints the ID a message was enqueued under.
+func (p *printer) produced(messageId uint64) error {
+	if p.format == outputJSON {
+		return p.json(map[string]uint64{"id": messageId})
+	}
+	_, err := fmt.Fprintln(p.w, messageId)
+	return err
+}
+
+// response prints the response of an RPC as JSON, or calls text to print it otherwise.
+func (p *printer) response(response proto.Message, text func(w io.Writer)) error {
+	if p.format != outputJSON {
+		text(p.w)
+		return nil
+	}
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(response)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
+
+func (p *printer) json(value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
+
+// printResponse prints the response of an RPC in the selected format, text printing it through text.
+func (c *CLI) printResponse(response proto.Message, text func(w io.Writer)) {
+	if err := c.out.response(response, text); err != nil {
+		log.Fatalf("failed to print: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	netinternal "ashishkujoy/queue/proto"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// maxLineSize bounds the lines produce reads, the server rejects larger messages anyway.
+const maxLineSize = 64 << 20
+
+// headerFlags collects the repeated -header key=value flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for key, value := range h {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlags) Set(value string) error {
+	key, headerValue, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid header %q, expected key=value", value)
+	}
+	h[key] = headerValue
+	return nil
+}
+
+// produce enqueues every line of stdin, or of the files given as arguments, as a message.
+// With -per-file every file is enqueued as a single message instead.
+func produce(cli *CLI, args []string) {
+	flags := newFlagSet("produce")
+	key := flags.String("key", "", "key of the messages, only the newest message of each key is kept by a compacted queue")
+	perFile := flags.Bool("per-file", false, "enqueue every file as a single message instead of a message per line")
+	timeout := flags.Duration("timeout", 5*time.Second, "how long to wait for each message to be stored")
+	headers := headerFlags{}
+	flags.Var(headers, "header", "key=value header stored with the messages, may be repeated")
+	flags.Parse(args)
+
+	send := func(message []byte) {
+		request := &netinternal.EnqueueRequest{Message: message, Key: []byte(*key), Headers: headers}
+		messageId, err := cli.enqueue(request, *timeout)
+		if err != nil {
+			log.Fatalf("failed to enqueue: %v", err)
+		}
+		if err := cli.out.produced(messageId); err != nil {
+			log.Fatalf("failed to print: %v", err)
+		}
+	}
+	if flags.NArg() == 0 {
+		if *perFile {
+			log.Fatalf("-per-file needs files to read")
+		}
+		if err := readLines(os.Stdin, send); err != nil {
+			log.Fatalf("failed to read stdin: %v", err)
+		}
+		return
+	}
+	for _, path := range flags.Args() {
+		if *perFile {
+			message, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatalf("failed to read %s: %v", path, err)
+			}
+			send(message)
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", path, err)
+		}
+		err = readLines(file, send)
+		file.Close()
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", path, err)
+		}
+	}
+}
+
+// readLines calls send with every line of r, without its line ending.
+func readLines(r io.Reader, send func(line []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		send(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+// enqueue sends the request to the server, following a redirect to the leader, and returns the ID of the message.
+func (c *CLI) enqueue(request *netinternal.EnqueueRequest, timeout time.Duration) (uint64, error) {
+	for redirected := false; ; redirected = true {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		var trailer metadata.MD
+		response, err := c.queueClient().Enqueue(ctx, request, grpc.Trailer(&trailer))
+		cancel()
+		if err == nil {
+			return response.Id, nil
+		}
+		if redirected || !c.followLeader(err, trailer) {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Command queuectl inspects and repairs the data directories of a queue server that is not running.
+// It reads the same settings as the server, so -config, the directory flags and the QUEUE_*
+// environment variables point it at the segments, metadata and encryption keys of a server.
+package main
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"ashishkujoy/queue/internal/consumer"
+	"ashishkujoy/queue/internal/storage"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// command is a subcommand of queuectl, run with the arguments following its name.
+type command struct {
+	usage   string
+	summary string
+	run     func(conf *config.Config, args []string)
+}
+
+// commands is filled in init, subcommands print their usage from it.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"dump-segment":  {usage: "dump-segment [flags] <segment-id>", summary: "print the records of a segment with the offsets of their batches", run: dumpSegment},
+		"dump-index":    {usage: "dump-index", summary: "print the entries of the message index", run: dumpIndex},
+		"dump-offsets":  {usage: "dump-offsets", summary: "print the consumer offsets of every consumer_index_* file", run: dumpOffsets},
+		"verify":        {usage: "verify", summary: "check the framing and batch checksums of every file and the index against the segments", run: verify},
+		"truncate":      {usage: "truncate [flags]", summary: "cut the torn tails off the segments and the index", run: truncate},
+		"rebuild-index": {usage: "rebuild-index", summary: "regenerate the message index from the segments", run: rebuildIndex},
+		"migrate":       {usage: "migrate", summary: "rewrite the segments written before segments had a header in the current format", run: migrate},
+	}
+}
+
+func main() {
+	settings := config.NewSettings(flag.CommandLine)
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: queuectl [settings] <command> [command flags] [arguments]\n\n")
+		fmt.Fprintf(out, "Only run queuectl while the server is stopped.\n\ncommands:\n")
+		for _, name := range slices.Sorted(maps.Keys(commands)) {
+			fmt.Fprintf(out, "  %-14s %s\n", name, commands[name].summary)
+		}
+		fmt.Fprintf(out, "\nsettings:\n")
+		flag.PrintDefaults()
+	}
+	if err := settings.Load(os.Args[1:], os.LookupEnv); err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	conf, err := settings.Config()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	command.run(conf, flag.Args()[1:])
+}
+
+// newFlagSet returns the flags of a subcommand, printing its usage line on errors.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: queuectl [settings] %s\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func dumpSegment(conf *config.Config, args []string) {
+	flags := newFlagSet("dump-segment")
+	hexPayload := flags.Bool("hex", false, "print keys and messages hex encoded instead of quoted")
+	noPayload := flags.Bool("no-payload", false, "leave the keys, headers and messages out")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	segmentId, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("invalid segment id %q", flags.Arg(0))
+	}
+
+	format := func(data []byte) string {
+		if *hexPayload {
+			return hex.EncodeToString(data)
+		}
+		return strconv.Quote(string(data))
+	}
+	check, err := storage.CheckSegment(conf, segmentId, func(offset int, record storage.Record) error {
+		if *noPayload {
+			_, err := fmt.Printf("offset %d\tid %d\tsize %d\n", offset, record.Id, len(record.Data))
+			return err
+		}
+		_, err := fmt.Printf("offset %d\tid %d\tsize %d\tkey %s\theaders %v\tmessage %s\n",
+			offset, record.Id, len(record.Data), format(record.Key), record.Headers, format(record.Data))
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Failed to read segment %d: %v", segmentId, err)
+	}
+	printSegmentCheck(check)
+}
+
+func dumpIndex(conf *config.Config, _ []string) {
+	check, err := storage.CheckIndex(conf, func(offset int, entry storage.MessageEntry) error {
+		if entry.Removed() {
+			_, err := fmt.Printf("offset %d\tid %d\tremoved\n", offset, entry.MessageId())
+			return err
+		}
+		_, err := fmt.Printf("offset %d\tid %d\tsegment %d\tbatch offset %d\n", offset, entry.MessageId(), entry.SegmentId(), entry.Offset())
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Failed to read the index: %v", err)
+	}
+	printIndexCheck(check)
+}
+
+func dumpOffsets(conf *config.Config, _ []string) {
+	files, err := consumer.ReadOffsetsFiles(conf)
+	if err != nil {
+		log.Fatalf("Failed to read the consumer offsets: %v", err)
+	}
+	for i, file := range files {
+		state := "stale"
+		if i == 0 {
+			state = "current"
+		}
+		fmt.Printf("%s (%s)\n", file.Path, state)
+		if file.Err != nil {
+			fmt.Printf("  error: %v\n", file.Err)
+		}
+		for _, consumerId := range slices.Sorted(maps.Keys(file.Offsets)) {
+			fmt.Printf("  consumer %d\toffset %d\n", consumerId, file.Offsets[consumerId])
+		}
+	}
+}
+
+// verify checks every local segment, the index and the consumer offsets, then compares the index
+// against the segments. It exits with a non-zero status when any problem is found.
+func verify(conf *config.Config, _ []string) {
+	segmentIds, err := storage.SegmentIds(conf)
+	if err != nil {
+		log.Fatalf("Failed to list segments: %v", err)
+	}
+	ok := true
+	lastId := -1
+	for _, segmentId := range segmentIds {
+		check, err := storage.CheckSegment(conf, segmentId, nil)
+		if err != nil {
+			log.Fatalf("Failed to read segment %d: %v", segmentId, err)
+		}
+		printSegmentCheck(check)
+		ok = ok && check.Err == nil
+		if check.FirstId >= 0 && check.FirstId <= lastId {
+			fmt.Printf("  error: first message %d is not after message %d of the previous segment\n", check.FirstId, lastId)
+			ok = false
+		}
+		lastId = max(lastId, check.LastId)
+	}
+
+	indexCheck, err := storage.CheckIndex(conf, nil)
+	if err != nil {
+		log.Fatalf("Failed to read the index: %v", err)
+	}
+	printIndexCheck(indexCheck)
+	ok = ok && indexCheck.Err == nil
+
+	files, err := consumer.ReadOffsetsFiles(conf)
+	if err != nil {
+		log.Fatalf("Failed to read the consumer offsets: %v", err)
+	}
+	if len(files) != 0 {
+		fmt.Printf("consumer offsets %s: %d consumers\n", files[0].Path, len(files[0].Offsets))
+		if files[0].Err != nil {
+			fmt.Printf("  error: %v\n", files[0].Err)
+			ok = false
+		}
+	}
+
+	diff, err := storage.VerifyIndex(conf)
+	if err != nil {
+		log.Fatalf("Failed to compare the index with the segments: %v", err)
+	}
+	fmt.Printf("index against segments: %s\n", diff)
+	if !ok || !diff.Matches() {
+		os.Exit(1)
+	}
+}
+
+// truncate cuts torn tails off the local segments and the index. Other problems stop the
+// file from being read any further too, but cutting them drops the data that follows, so
+// they are only truncated with -force.
+func truncate(conf *config.Config, args []string) {
+	flags := newFlagSet("truncate")
+	force := flags.Bool("force", false, "also truncate files at a corrupt entry, dropping everything after it")
+	dryRun := flags.Bool("dry-run", false, "only print what would be truncated")
+	flags.Parse(args)
+
+	cut := func(name string, size int, validSize int, problem error, torn bool, truncateFile func() error) {
+		if problem == nil {
+			return
+		}
+		if !torn && !*force {
+			fmt.Printf("%s: not truncated, %v is not a torn tail, use -force to drop it and the %d bytes after it\n", name, problem, size-validSize)
+			return
+		}
+		fmt.Printf("%s: truncating %d bytes at offset %d: %v\n", name, size-validSize, validSize, problem)
+		if *dryRun {
+			return
+		}
+		if err := truncateFile(); err != nil {
+			log.Fatalf("Failed to truncate %s: %v", name, err)
+		}
+	}
+
+	segmentIds, err := storage.SegmentIds(conf)
+	if err != nil {
+		log.Fatalf("Failed to list segments: %v", err)
+	}
+	for _, segmentId := range segmentIds {
+		check, err := storage.CheckSegment(conf, segmentId, nil)
+		if err != nil {
+			log.Fatalf("Failed to read segment %d: %v", segmentId, err)
+		}
+		cut(fmt.Sprintf("segment %d", segmentId), check.SizeInBytes, check.ValidSize, check.Err, check.TornTail, func() error {
+			return storage.TruncateSegment(conf, segmentId, check.ValidSize)
+		})
+	}
+	indexCheck, err := storage.CheckIndex(conf, nil)
+	if err != nil {
+		log.Fatalf("Failed to read the index: %v", err)
+	}
+	cut("index", indexCheck.SizeInBytes, indexCheck.ValidSize, indexCheck.Err, indexCheck.TornTail, func() error {
+		return storage.TruncateIndex(conf, indexCheck.ValidSize)
+	})
+}
+
+func rebuildIndex(conf *config.Config, _ []string) {
+	diff, err := storage.RebuildIndex(conf)
+	if err != nil {
+		log.Fatalf("Failed to rebuild index: %v", err)
+	}
+	fmt.Printf("Rebuilt index: %s\n", diff)
+}
+
+func migrate(conf *config.Config, _ []string) {
+	segments, messages, err := storage.MigrateBaselineSegments(conf)
+	if err != nil {
+		log.Fatalf("Failed to migrate segments: %v", err)
+	}
+	fmt.Printf("Migrated %d segments holding %d messages\n", segments, messages)
+}
+
+func printSegmentCheck(check *storage.SegmentCheck) {
+	encryption := "unencrypted"
+	if check.KeyId != "" {
+		encryption = "encrypted with key " + check.KeyId
+	}
+	fmt.Printf("segment %d: version %d, %s, %s, %d bytes, %d batches, %d messages, ids %d to %d\n",
+		check.Id, check.Version, check.Codec, encryption, check.SizeInBytes, check.Batches, check.Records, check.FirstId, check.LastId)
+	printProblem(check.Err, check.TornTail, check.SizeInBytes-check.ValidSize)
+}
+
+func printIndexCheck(check *storage.IndexCheck) {
+	fmt.Printf("index: %d bytes, %d entries, %d removed, next id %d\n", check.SizeInBytes, check.Entries, check.Removed, check.NextId)
+	printProblem(check.Err, check.TornTail, check.SizeInBytes-check.ValidSize)
+}
+
+func printProblem(problem error, torn bool, unreadable int) {
+	if problem == nil {
+		return
+	}
+	kind := "corrupt"
+	if torn {
+		kind = "torn tail"
+	}
+	fmt.Printf("  error (%s, %d bytes unreadable): %v\n", kind, unreadable, problem)
+}
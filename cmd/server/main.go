@@ -3,25 +3,133 @@ package main
 import (
 	"ashishkujoy/queue/internal/config"
 	netinternal "ashishkujoy/queue/internal/net"
+	"ashishkujoy/queue/internal/storage"
+	"ashishkujoy/queue/internal/tracing"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"time"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	conf := config.NewConfig(
-		"data/segments",
-		"data/metadata",
-		1024*1024*10,
-		time.Second*2,
-	)
-	server, err := netinternal.NewQueueServer(conf, ":50051")
+	settings := config.NewSettings(flag.CommandLine)
+	rebuildIndex := flag.Bool("rebuild-index", false, "rebuild the message index from the segment files and exit")
+	verifyIndex := flag.Bool("verify-index", false, "compare the message index against the segment files and exit")
+	if err := settings.Load(os.Args[1:], os.LookupEnv); err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+	conf, err := settings.Config()
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logger := conf.Logger()
+	slog.SetDefault(logger)
+	logger.Info("loaded configuration", "settings", settings)
+
+	if *rebuildIndex || *verifyIndex {
+		runIndexCommand(conf, *rebuildIndex)
 		return
 	}
 
-	err = server.Run()
+	probes := &netinternal.Probes{}
+	httpServer := serveHTTP(settings.HTTPAddress(), conf, probes, logger)
+	server, err := netinternal.NewQueueServer(conf)
+	if err != nil {
+		fatal(logger, "failed to create server", err)
+	}
+	probes.Serving(server)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Run()
+	}()
+	select {
+	case err = <-served:
+		logger.Error("server stopped serving", "error", err)
+	case <-ctx.Done():
+		logger.Info("shutting down", "timeout", settings.ShutdownTimeout())
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout())
+	defer cancel()
+	if httpServer != nil {
+		if httpErr := httpServer.Shutdown(shutdownCtx); httpErr != nil {
+			logger.Warn("failed to shut the HTTP server down", "error", httpErr)
+		}
+	}
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		fatal(logger, "shutdown failed", shutdownErr)
+	}
+	if tracingErr := tracing.Shutdown(shutdownCtx, conf.TracerProvider()); tracingErr != nil {
+		logger.Warn("failed to export the remaining spans", "error", tracingErr)
+	}
+	logger.Info("shut down")
 	if err != nil {
-		log.Fatalf("Server run failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+// fatal logs err and exits with a non-zero status.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// serveHTTP serves /metrics and the health probes on address in the background,
+// it returns nil when address is empty.
+func serveHTTP(address string, conf *config.Config, probes *netinternal.Probes, logger *slog.Logger) *http.Server {
+	if address == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", conf.Metrics().Handler())
+	probes.Register(mux)
+	httpServer := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server failed", "address", address, "error", err)
+		}
+	}()
+	logger.Info("serving metrics and health probes", "address", address)
+	return httpServer
+}
+
+// runIndexCommand verifies, and optionally rebuilds, the message index from the segment files.
+// It exits with a non-zero status when a verification finds differences.
+func runIndexCommand(conf *config.Config, rebuild bool) {
+	if rebuild {
+		diff, err := storage.RebuildIndex(conf)
+		if err != nil {
+			log.Fatalf("Failed to rebuild index: %v", err)
+		}
+		fmt.Printf("Rebuilt index: %s\n", diff)
+		return
+	}
+
+	diff, err := storage.VerifyIndex(conf)
+	if err != nil {
+		log.Fatalf("Failed to verify index: %v", err)
+	}
+	fmt.Printf("Verified index: %s\n", diff)
+	if !diff.Matches() {
+		printIds("missing", diff.Missing)
+		printIds("mismatched", diff.Mismatched)
+		printIds("unknown", diff.Unknown)
+		os.Exit(1)
+	}
+}
+
+func printIds(kind string, ids []int) {
+	if len(ids) != 0 {
+		fmt.Printf("%s message ids: %v\n", kind, ids)
 	}
 }
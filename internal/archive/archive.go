@@ -0,0 +1,30 @@
+package archive
+
+import "fmt"
+
+// SegmentArchive is an object store closed segments are offloaded to.
+// Segments are identified by their ID, and their files are copied as they are,
+// so archived segments keep their compression and encryption.
+type SegmentArchive interface {
+	// Upload copies the segment file at filePath to the archive.
+	Upload(segmentId int, filePath string) error
+	// Download copies the archived segment to a file at filePath.
+	Download(segmentId int, filePath string) error
+	// List returns the IDs of all the archived segments.
+	List() ([]int, error)
+	// Delete removes the archived segment, deleting a segment that is not archived is not an error.
+	Delete(segmentId int) error
+}
+
+func objectName(segmentId int) string {
+	return fmt.Sprintf("segment-%d", segmentId)
+}
+
+// parseObjectName returns the segment ID of an archived segment object, or false for any other object.
+func parseObjectName(name string) (int, bool) {
+	var segmentId int
+	if _, err := fmt.Sscanf(name, "segment-%d", &segmentId); err != nil || objectName(segmentId) != name {
+		return 0, false
+	}
+	return segmentId, true
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// DirectoryArchive archives segments into a directory, e.g. a mounted network filesystem.
+type DirectoryArchive struct {
+	root string
+}
+
+// NewDirectoryArchive creates an archive storing segments under root, creating it if needed.
+func NewDirectoryArchive(root string) (*DirectoryArchive, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &DirectoryArchive{root: root}, nil
+}
+
+// Upload copies the segment into the archive directory.
+// The copy is written to a temporary file first, so a partial upload is never visible.
+func (a *DirectoryArchive) Upload(segmentId int, filePath string) error {
+	return copyFile(filePath, filepath.Join(a.root, objectName(segmentId)))
+}
+
+func (a *DirectoryArchive) Download(segmentId int, filePath string) error {
+	return copyFile(filepath.Join(a.root, objectName(segmentId)), filePath)
+}
+
+func (a *DirectoryArchive) Delete(segmentId int) error {
+	if err := os.Remove(filepath.Join(a.root, objectName(segmentId))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (a *DirectoryArchive) List() ([]int, error) {
+	entries, err := os.ReadDir(a.root)
+	if err != nil {
+		return nil, err
+	}
+	var segmentIds []int
+	for _, entry := range entries {
+		if segmentId, ok := parseObjectName(entry.Name()); ok {
+			segmentIds = append(segmentIds, segmentId)
+		}
+	}
+	slices.Sort(segmentIds)
+	return segmentIds, nil
+}
+
+// copyFile copies src to dst through a temporary file that is synced and renamed into place.
+func copyFile(src, dst string) error {
+	reader, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return writeFileAtomically(dst, reader)
+}
+
+func writeFileAtomically(filePath string, content io.Reader) error {
+	tmpPath := filePath + ".tmp"
+	writer, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, content); err != nil {
+		writer.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := writer.Sync(); err != nil {
+		writer.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectoryArchiveUploadAndDownload(t *testing.T) {
+	dir := t.TempDir()
+	segmentArchive, err := NewDirectoryArchive(filepath.Join(dir, "archive"))
+	assert.NoError(t, err)
+
+	src := filepath.Join(dir, "segment-3")
+	assert.NoError(t, os.WriteFile(src, []byte("segment content"), 0644))
+	assert.NoError(t, segmentArchive.Upload(3, src))
+
+	dst := filepath.Join(dir, "fetched")
+	assert.NoError(t, segmentArchive.Download(3, dst))
+	data, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("segment content"), data)
+
+	assert.Error(t, segmentArchive.Download(4, dst))
+
+	assert.NoError(t, segmentArchive.Delete(3))
+	assert.Error(t, segmentArchive.Download(3, dst))
+	assert.NoError(t, segmentArchive.Delete(3))
+}
+
+func TestDirectoryArchiveList(t *testing.T) {
+	dir := t.TempDir()
+	segmentArchive, err := NewDirectoryArchive(dir)
+	assert.NoError(t, err)
+
+	src := filepath.Join(t.TempDir(), "segment")
+	assert.NoError(t, os.WriteFile(src, []byte("data"), 0644))
+	for _, segmentId := range []int{10, 2, 1} {
+		assert.NoError(t, segmentArchive.Upload(segmentId, src))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "segment-5.tmp"), nil, 0644))
+
+	segmentIds, err := segmentArchive.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 10}, segmentIds)
+}
@@ -0,0 +1,270 @@
+package archive
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3Config describes an S3 compatible bucket, such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the name of every segment object.
+	Prefix          string
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+// S3Archive archives segments as objects of an S3 compatible bucket.
+// Requests use path style addressing and are signed with AWS Signature Version 4.
+type S3Archive struct {
+	config S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3Archive creates an archive storing segments in the configured bucket.
+func NewS3Archive(config S3Config) (*S3Archive, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("s3 archive needs an endpoint and a bucket")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &S3Archive{
+		config: config,
+		client: &http.Client{Timeout: 5 * time.Minute},
+		now:    time.Now,
+	}, nil
+}
+
+// Upload streams the segment file to its object, reading it once to hash it for the signature
+// and once to send it, so segments are never held in memory.
+func (a *S3Archive) Upload(segmentId int, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	response, err := a.do(http.MethodPut, a.config.Prefix+objectName(segmentId), nil, file)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (a *S3Archive) Download(segmentId int, filePath string) error {
+	response, err := a.do(http.MethodGet, a.config.Prefix+objectName(segmentId), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return writeFileAtomically(filePath, response.Body)
+}
+
+// Delete removes the object of the segment, S3 answers a deletion of a missing object with success.
+func (a *S3Archive) Delete(segmentId int) error {
+	response, err := a.do(http.MethodDelete, a.config.Prefix+objectName(segmentId), nil, nil)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (a *S3Archive) List() ([]int, error) {
+	var segmentIds []int
+	query := url.Values{"list-type": {"2"}, "prefix": {a.config.Prefix}}
+	for {
+		response, err := a.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := listBucketResult{}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			if segmentId, ok := parseObjectName(strings.TrimPrefix(object.Key, a.config.Prefix)); ok {
+				segmentIds = append(segmentIds, segmentId)
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	slices.Sort(segmentIds)
+	return segmentIds, nil
+}
+
+// do sends a signed request for the given object of the bucket, or for the bucket itself when key is empty.
+// The body, if any, is read to the end to hash it and sent from the start. A response with a non 2xx
+// status is turned into an error.
+func (a *S3Archive) do(method string, key string, query url.Values, body io.ReadSeeker) (*http.Response, error) {
+	path := "/" + a.config.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	requestUrl := a.config.Endpoint + escapePath(path)
+	if len(query) != 0 {
+		requestUrl += "?" + canonicalQuery(query)
+	}
+	payloadHash, size, reader := emptyPayloadHash, int64(0), io.Reader(http.NoBody)
+	if body != nil {
+		hash := sha256.New()
+		var err error
+		if size, err = io.Copy(hash, body); err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash, reader = hex.EncodeToString(hash.Sum(nil)), io.NopCloser(body)
+	}
+	request, err := http.NewRequest(method, requestUrl, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = size
+	a.sign(request, payloadHash)
+	response, err := a.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	return response, nil
+}
+
+// s3SignedHeaders are the headers signed on every request, in the sorted order SigV4 requires.
+var s3SignedHeaders = []string{"host", "x-amz-content-sha256", "x-amz-date"}
+
+// sign adds the AWS Signature Version 4 headers to a request whose body hashes to payloadHash.
+func (a *S3Archive) sign(request *http.Request, payloadHash string) {
+	amzDate := a.now().UTC().Format(amzDateFormat)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if a.config.AccessKeyId == "" {
+		return
+	}
+	scope := credentialScope(amzDate, a.config.Region, "s3")
+	signature := signature(a.config.SecretAccessKey, amzDate, a.config.Region, "s3", canonicalRequest(request, s3SignedHeaders, payloadHash))
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.config.AccessKeyId, scope, strings.Join(s3SignedHeaders, ";"), signature,
+	))
+}
+
+// amzDateFormat is the layout of the X-Amz-Date header.
+const amzDateFormat = "20060102T150405Z"
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// canonicalRequest lays the request out as SigV4 signs it. signedHeaders are lower case and sorted,
+// the host is taken from the URL and the other headers from the request.
+func canonicalRequest(request *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		value := request.Header.Get(name)
+		if name == "host" {
+			value = request.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	return strings.Join([]string{
+		request.Method,
+		escapePath(request.URL.Path),
+		canonicalQuery(request.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// credentialScope returns the scope a signature made at amzDate is valid for.
+func credentialScope(amzDate string, region string, service string) string {
+	return amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+}
+
+// signature signs the canonical request with a key derived from the secret access key for the scope.
+func signature(secretAccessKey string, amzDate string, region string, service string, canonicalRequest string) string {
+	scope := credentialScope(amzDate, region, service)
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSha256([]byte("AWS4"+secretAccessKey), amzDate[:8])
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
+
+// canonicalQuery encodes the query sorted by encoded key then value, with spaces escaped as %20 as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	var pairs [][2]string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, [2]string{uriEncode(key), uriEncode(value)})
+		}
+	}
+	slices.SortFunc(pairs, func(a, b [2]string) int {
+		if c := strings.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		return strings.Compare(a[1], b[1])
+	})
+	parts := make([]string, len(pairs))
+	for i, pair := range pairs {
+		parts[i] = pair[0] + "=" + pair[1]
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapePath encodes every segment of the path, keeping the slashes between them.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func uriEncode(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Permission is a right an ACL rule grants.
+type Permission string
+
+const (
+	// Produce allows enqueuing messages.
+	Produce Permission = "produce"
+	// Consume allows observing the queue as a consumer group.
+	Consume Permission = "consume"
+	// Admin allows administrative and replication RPCs, and implies every other permission.
+	Admin Permission = "admin"
+)
+
+// Wildcard matches every principal, queue or consumer group in a rule.
+const Wildcard = "*"
+
+// Rule grants permissions to a principal on queues and, for consumers, on consumer groups.
+type Rule struct {
+	Principal   string       `yaml:"principal"`
+	Permissions []Permission `yaml:"permissions"`
+	// Queues the rule applies to, every queue when empty.
+	Queues []string `yaml:"queues"`
+	// ConsumerGroups the rule applies to, as consumer IDs, every group when empty.
+	ConsumerGroups []string `yaml:"consumer-groups"`
+}
+
+func (r *Rule) grants(principal string, permission Permission, queue string, consumerGroup string) bool {
+	if !matches([]string{r.Principal}, principal) || !matches(r.Queues, queue) {
+		return false
+	}
+	for _, granted := range r.Permissions {
+		if granted == Admin {
+			return true
+		}
+		if granted == permission {
+			return permission != Consume || matches(r.ConsumerGroups, consumerGroup)
+		}
+	}
+	return false
+}
+
+func matches(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == Wildcard || pattern == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL is a list of rules, a request is allowed when any rule grants it and denied otherwise.
+type ACL struct {
+	Rules []Rule
+}
+
+// Allowed reports whether the principal has the permission on the queue.
+// consumerGroup is only checked for the Consume permission.
+func (a *ACL) Allowed(principal string, permission Permission, queue string, consumerGroup string) bool {
+	for _, rule := range a.Rules {
+		if rule.grants(principal, permission, queue, consumerGroup) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy holds the API tokens clients authenticate with and the ACL authorizing them.
+type Policy struct {
+	Tokens []TokenPrincipal `yaml:"tokens"`
+	Rules  []Rule           `yaml:"acl"`
+}
+
+// TokenPrincipal maps an API token to the principal it authenticates.
+type TokenPrincipal struct {
+	Token     string `yaml:"token"`
+	Principal string `yaml:"principal"`
+}
+
+// LoadPolicy reads a policy from a YAML file such as
+//
+//	tokens:
+//	  - token: 4f6c...
+//	    principal: billing-producer
+//	acl:
+//	  - principal: billing-producer
+//	    permissions: [produce]
+//	    queues: [billing]
+//	  - principal: reporting            # client certificate common name
+//	    permissions: [consume]
+//	    consumer-groups: ["7"]
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
+
+func (p *Policy) validate() error {
+	for i, token := range p.Tokens {
+		if token.Token == "" || token.Principal == "" {
+			return fmt.Errorf("token %d needs a token and a principal", i+1)
+		}
+	}
+	for i, rule := range p.Rules {
+		if rule.Principal == "" {
+			return fmt.Errorf("acl rule %d needs a principal", i+1)
+		}
+		for _, permission := range rule.Permissions {
+			if permission != Produce && permission != Consume && permission != Admin {
+				return fmt.Errorf("acl rule %d: unknown permission %q", i+1, permission)
+			}
+		}
+		for _, group := range rule.ConsumerGroups {
+			if _, err := strconv.ParseUint(group, 10, 64); err != nil && group != Wildcard {
+				return fmt.Errorf("acl rule %d: consumer group %q is not a consumer id", i+1, group)
+			}
+		}
+	}
+	return nil
+}
+
+// ACL returns the access control list of the policy.
+func (p *Policy) ACL() *ACL {
+	return &ACL{Rules: p.Rules}
+}
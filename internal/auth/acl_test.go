@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLAllowsOnlyGrantedPermissions(t *testing.T) {
+	acl := &ACL{Rules: []Rule{
+		{Principal: "producer", Permissions: []Permission{Produce}, Queues: []string{"billing"}},
+		{Principal: "reporting", Permissions: []Permission{Consume}, ConsumerGroups: []string{"7"}},
+		{Principal: "operator", Permissions: []Permission{Admin}},
+		{Principal: Wildcard, Permissions: []Permission{Consume}, Queues: []string{"public"}},
+	}}
+
+	assert.True(t, acl.Allowed("producer", Produce, "billing", ""))
+	assert.False(t, acl.Allowed("producer", Produce, "orders", ""))
+	assert.False(t, acl.Allowed("producer", Consume, "billing", "1"))
+
+	assert.True(t, acl.Allowed("reporting", Consume, "billing", "7"))
+	assert.False(t, acl.Allowed("reporting", Consume, "billing", "8"))
+	assert.False(t, acl.Allowed("reporting", Produce, "billing", ""))
+
+	assert.True(t, acl.Allowed("operator", Admin, "billing", ""))
+	assert.True(t, acl.Allowed("operator", Consume, "billing", "8"))
+
+	assert.True(t, acl.Allowed("anyone", Consume, "public", "3"))
+	assert.False(t, acl.Allowed("anyone", Produce, "public", ""))
+	assert.False(t, (&ACL{}).Allowed("operator", Produce, "billing", ""))
+}
+
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadPolicy(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, `
+tokens:
+  - token: s3cret
+    principal: producer
+acl:
+  - principal: producer
+    permissions: [produce]
+    queues: [billing]
+  - principal: reporting
+    permissions: [consume]
+    consumer-groups: ["7", "*"]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []TokenPrincipal{{Token: "s3cret", Principal: "producer"}}, policy.Tokens)
+	assert.True(t, policy.ACL().Allowed("producer", Produce, "billing", ""))
+	assert.True(t, policy.ACL().Allowed("reporting", Consume, "billing", "9"))
+}
+
+func TestLoadPolicyRejectsInvalidPolicies(t *testing.T) {
+	for _, content := range []string{
+		"acl:\n  - principal: a\n    permissions: [write]\n",
+		"acl:\n  - permissions: [produce]\n",
+		"acl:\n  - principal: a\n    consumer-groups: [billing]\n",
+		"tokens:\n  - token: s3cret\n",
+		"users: []\n",
+	} {
+		_, err := LoadPolicy(writePolicy(t, content))
+		assert.Error(t, err, content)
+	}
+}
@@ -0,0 +1,114 @@
+// Package auth authenticates the clients of a queue server and authorizes their requests.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TokenMetadataKey is the request metadata carrying an API token, as "Bearer <token>".
+const TokenMetadataKey = "authorization"
+
+// ErrUnauthenticated is returned when a request carries no credentials an authenticator accepts.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator identifies the principal making a request.
+type Authenticator interface {
+	// Authenticate returns the principal of the request,
+	// or ErrUnauthenticated when the request does not carry credentials it recognises.
+	Authenticate(ctx context.Context) (string, error)
+}
+
+// TokenAuthenticator authenticates requests carrying a static API token.
+type TokenAuthenticator struct {
+	tokens []TokenPrincipal
+}
+
+func NewTokenAuthenticator(tokens []TokenPrincipal) *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: tokens}
+}
+
+func (a *TokenAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(TokenMetadataKey) {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if !ok {
+			continue
+		}
+		for _, known := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known.Token)) == 1 {
+				return known.Principal, nil
+			}
+		}
+	}
+	return "", ErrUnauthenticated
+}
+
+// CertificateAuthenticator authenticates requests made over mutual TLS,
+// the principal being the common name of the verified client certificate's subject.
+type CertificateAuthenticator struct{}
+
+func (CertificateAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", ErrUnauthenticated
+	}
+	commonName := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+	if commonName == "" {
+		return "", ErrUnauthenticated
+	}
+	return commonName, nil
+}
+
+// Chain authenticates a request with the first authenticator that recognises its credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context) (string, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx)
+		if !errors.Is(err, ErrUnauthenticated) {
+			return principal, err
+		}
+	}
+	return "", ErrUnauthenticated
+}
+
+// AuditDenied records to logger that a request was denied, principal being empty for unauthenticated requests.
+// Audit records are logged at warn level with audit=true, so they can be routed apart from the other logs.
+func AuditDenied(logger *slog.Logger, principal string, method string, permission Permission, queue string, consumerGroup string, reason string) {
+	if principal == "" {
+		principal = "<unauthenticated>"
+	}
+	logger.Warn("request denied",
+		"audit", true,
+		"principal", principal,
+		"method", method,
+		"permission", string(permission),
+		"queue", queue,
+		"consumer_group", consumerGroup,
+		"reason", reason)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated principal of a request.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the authenticated principal of a request, false when authentication is disabled.
+func PrincipalFrom(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
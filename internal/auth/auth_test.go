@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(TokenMetadataKey, "Bearer "+token))
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	authenticator := NewTokenAuthenticator([]TokenPrincipal{{Token: "s3cret", Principal: "producer"}})
+
+	principal, err := authenticator.Authenticate(withToken("s3cret"))
+	assert.NoError(t, err)
+	assert.Equal(t, "producer", principal)
+
+	_, err = authenticator.Authenticate(withToken("guess"))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = authenticator.Authenticate(context.Background())
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestChainUsesTheFirstAuthenticatorRecognisingTheRequest(t *testing.T) {
+	chain := Chain{
+		NewTokenAuthenticator([]TokenPrincipal{{Token: "s3cret", Principal: "producer"}}),
+		CertificateAuthenticator{},
+	}
+	principal, err := chain.Authenticate(withToken("s3cret"))
+	assert.NoError(t, err)
+	assert.Equal(t, "producer", principal)
+
+	_, err = chain.Authenticate(withToken("guess"))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestPrincipalContext(t *testing.T) {
+	_, ok := PrincipalFrom(context.Background())
+	assert.False(t, ok)
+	principal, ok := PrincipalFrom(WithPrincipal(context.Background(), "producer"))
+	assert.True(t, ok)
+	assert.Equal(t, "producer", principal)
+}
+
+func TestAuditDeniedLogsAStructuredRecord(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&output, nil))
+
+	AuditDenied(logger, "", "/QueueService/Enqueue", Produce, "billing", "", "missing credentials")
+	assert.Contains(t, output.String(), `"level":"WARN","msg":"request denied","audit":true,"principal":"<unauthenticated>"`)
+	assert.Contains(t, output.String(), `"permission":"produce","queue":"billing"`)
+}
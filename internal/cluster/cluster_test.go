@@ -0,0 +1,339 @@
+package cluster
+
+import (
+	"ashishkujoy/queue/internal/config"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	"ashishkujoy/queue/internal/storage"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTempDir(suffix string) string {
+	dir := os.TempDir() + "/" + suffix
+	os.MkdirAll(dir, 0755)
+	return dir
+}
+
+func removeTempDir(suffix string) {
+	dir := os.TempDir() + "/" + suffix
+	os.RemoveAll(dir)
+}
+
+func init() {
+	newRaftConfig = func() *raft.Config {
+		raftConfig := raft.DefaultConfig()
+		raftConfig.LogLevel = "ERROR"
+		raftConfig.HeartbeatTimeout = 100 * time.Millisecond
+		raftConfig.ElectionTimeout = 100 * time.Millisecond
+		raftConfig.LeaderLeaseTimeout = 100 * time.Millisecond
+		raftConfig.CommitTimeout = 5 * time.Millisecond
+		return raftConfig
+	}
+}
+
+// freeAddress returns a localhost address nothing listens on.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+type testMember struct {
+	cfg          *config.Config
+	transportTLS *TransportTLS
+	service      *queueinternal.QueueService
+	node         *Node
+}
+
+func (m *testMember) start(t *testing.T) {
+	service, err := queueinternal.NewQueueService(m.cfg)
+	assert.NoError(t, err)
+	node, err := NewNode(m.cfg, service, m.transportTLS, nil)
+	assert.NoError(t, err)
+	m.service, m.node = service, node
+}
+
+func (m *testMember) stop() {
+	if m.node == nil {
+		return
+	}
+	m.node.Shutdown()
+	m.service.Close()
+	m.node = nil
+}
+
+func startCluster(t *testing.T, name string, size int) []*testMember {
+	var peers []config.ClusterPeer
+	for i := 0; i < size; i++ {
+		peers = append(peers, config.ClusterPeer{
+			Id:            fmt.Sprintf("node-%d", i),
+			RaftAddress:   freeAddress(t),
+			ClientAddress: fmt.Sprintf("client-%d:50051", i),
+		})
+	}
+	members := make([]*testMember, size)
+	for i, peer := range peers {
+		dir := fmt.Sprintf("%s%d", name, i)
+		cfg := config.NewConfig(createTempDir(dir), createTempDir(dir+"Metadata"), 1024, time.Second).
+			WithNodeId(peer.Id).
+			WithClusterPeers(peers)
+		members[i] = &testMember{cfg: cfg}
+		members[i].start(t)
+		t.Cleanup(func() {
+			removeTempDir(dir)
+			removeTempDir(dir + "Metadata")
+		})
+	}
+	t.Cleanup(func() {
+		for _, member := range members {
+			member.stop()
+		}
+	})
+	return members
+}
+
+func waitForLeader(t *testing.T, members []*testMember) *testMember {
+	var leader *testMember
+	assert.Eventually(t, func() bool {
+		for _, member := range members {
+			if member.node != nil && member.node.IsLeader() {
+				leader = member
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func readAll(t *testing.T, queue *queueinternal.Queue) []storage.Record {
+	var records []storage.Record
+	iterator := queue.NewIterator(0)
+	for {
+		record, err := iterator.Next()
+		if err == storage.ErrNoMoreMessages {
+			return records
+		}
+		assert.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func assertReplicated(t *testing.T, members []*testMember, leader *testMember) {
+	expected := readAll(t, leader.service.Queue())
+	for _, member := range members {
+		if member.node == nil {
+			continue
+		}
+		assert.Eventually(t, func() bool {
+			return member.service.Queue().NextId() == leader.service.Queue().NextId()
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, expected, readAll(t, member.service.Queue()))
+	}
+}
+
+func enqueue(t *testing.T, leader *testMember, messages ...string) []int {
+	var messageIds []int
+	for _, message := range messages {
+		messageId, err := leader.node.Enqueue([]byte("key-"+message), nil, []byte(message))
+		assert.NoError(t, err)
+		messageIds = append(messageIds, messageId)
+	}
+	return messageIds
+}
+
+func TestClusterReplicatesMessagesUnderTheSameIds(t *testing.T) {
+	members := startCluster(t, "ClusterTestReplicate", 3)
+	leader := waitForLeader(t, members)
+
+	messageIds := enqueue(t, leader, "one", "two", "three")
+	assert.Len(t, messageIds, 3)
+	assert.True(t, messageIds[0] < messageIds[1] && messageIds[1] < messageIds[2])
+
+	assertReplicated(t, members, leader)
+	records := readAll(t, members[0].service.Queue())
+	assert.Len(t, records, 3)
+	for i, record := range records {
+		assert.Equal(t, messageIds[i], record.Id)
+	}
+	assert.Equal(t, []byte("two"), records[1].Data)
+	assert.Equal(t, []byte("key-two"), records[1].Key)
+}
+
+func TestClusterFollowerRejectsWritesAndKnowsTheLeader(t *testing.T) {
+	members := startCluster(t, "ClusterTestFollower", 3)
+	leader := waitForLeader(t, members)
+
+	for _, member := range members {
+		if member == leader {
+			continue
+		}
+		_, err := member.node.Enqueue(nil, nil, []byte("rejected"))
+		assert.ErrorIs(t, err, ErrNotLeader)
+		assert.ErrorIs(t, member.node.Ack(1, 0), ErrNotLeader)
+		assert.Eventually(t, func() bool {
+			return member.node.LeaderClientAddress() == leader.node.LeaderClientAddress()
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	assert.NotEmpty(t, leader.node.LeaderClientAddress())
+}
+
+func TestClusterReplicatesConsumerOffsets(t *testing.T) {
+	members := startCluster(t, "ClusterTestOffsets", 3)
+	leader := waitForLeader(t, members)
+	messageIds := enqueue(t, leader, "one", "two")
+
+	assert.NoError(t, leader.node.Ack(7, messageIds[0]))
+
+	for _, member := range members {
+		assert.Eventually(t, func() bool {
+			return member.service.ConsumerOffsets()[7] == messageIds[0]
+		}, 5*time.Second, 10*time.Millisecond)
+		data, err := member.service.Dequeue(7)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("two"), data)
+	}
+
+	assert.NoError(t, leader.node.Ack(8, messageIds[1]))
+	assert.NoError(t, leader.node.ResetConsumer(8, messageIds[0]))
+	for _, member := range members {
+		assert.Eventually(t, func() bool {
+			return member.service.ConsumerOffsets()[8] == messageIds[0]-1
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestClusterElectsANewLeaderAndKeepsCommittedMessages(t *testing.T) {
+	members := startCluster(t, "ClusterTestFailover", 3)
+	leader := waitForLeader(t, members)
+	enqueue(t, leader, "one", "two")
+	assertReplicated(t, members, leader)
+
+	leader.stop()
+	newLeader := waitForLeader(t, members)
+	assert.NotSame(t, leader, newLeader)
+	enqueue(t, newLeader, "three")
+	assertReplicated(t, members, newLeader)
+
+	// The old leader catches up on restart without applying its own log twice.
+	leader.start(t)
+	assertReplicated(t, members, newLeader)
+	assert.Len(t, readAll(t, leader.service.Queue()), 3)
+}
+
+func TestClusterAssignsConsecutiveIdsAcrossOffsetUpdatesAndSnapshots(t *testing.T) {
+	members := startCluster(t, "ClusterTestConsecutiveIds", 3)
+	leader := waitForLeader(t, members)
+
+	messageIds := enqueue(t, leader, "one")
+	assert.NoError(t, leader.node.Ack(7, messageIds[0]))
+	assert.NoError(t, leader.node.ResetConsumer(7, messageIds[0]))
+	messageIds = append(messageIds, enqueue(t, leader, "two")...)
+	assert.Equal(t, []int{0, 1}, messageIds)
+	assertReplicated(t, members, leader)
+
+	// A member restarting from a snapshot carries on counting from the next ID it records.
+	var follower *testMember
+	for _, member := range members {
+		if member != leader {
+			follower = member
+		}
+	}
+	assert.NoError(t, follower.node.raft.Snapshot().Error())
+	follower.stop()
+	follower.start(t)
+	messageIds = append(messageIds, enqueue(t, leader, "three")...)
+	assert.Equal(t, []int{0, 1, 2}, messageIds)
+	assertReplicated(t, members, leader)
+	assert.Len(t, readAll(t, follower.service.Queue()), 3)
+}
+
+func TestClusterRefusesToBootstrapOverAnExistingQueue(t *testing.T) {
+	name := "ClusterTestExistingQueue"
+	t.Cleanup(func() {
+		removeTempDir(name)
+		removeTempDir(name + "Metadata")
+	})
+	peers := []config.ClusterPeer{{Id: "node-0", RaftAddress: freeAddress(t), ClientAddress: "client-0:50051"}}
+	cfg := config.NewConfig(createTempDir(name), createTempDir(name+"Metadata"), 1024, time.Second).
+		WithNodeId("node-0").
+		WithClusterPeers(peers)
+	service, err := queueinternal.NewQueueService(cfg)
+	assert.NoError(t, err)
+	for _, message := range []string{"one", "two", "three"} {
+		_, err := service.Queue().Enqueue([]byte(message))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, service.Close())
+
+	service, err = queueinternal.NewQueueService(cfg)
+	assert.NoError(t, err)
+	defer service.Close()
+	_, err = NewNode(cfg, service, nil, nil)
+	assert.ErrorIs(t, err, ErrNonEmptyQueue)
+	data, err := service.Queue().Dequeue(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("one"), data)
+}
+
+func TestStateMachineRejectsReplayedEnqueuesOfOtherMessages(t *testing.T) {
+	name := "ClusterTestReplay"
+	t.Cleanup(func() {
+		removeTempDir(name)
+		removeTempDir(name + "Metadata")
+	})
+	service, err := queueinternal.NewQueueService(config.NewConfig(createTempDir(name), createTempDir(name+"Metadata"), 1024, time.Second))
+	assert.NoError(t, err)
+	defer service.Close()
+	_, err = service.Queue().Enqueue([]byte("stored"))
+	assert.NoError(t, err)
+
+	enqueue := func(data string) applyResult {
+		c := command{kind: commandEnqueue, data: []byte(data)}
+		return (&stateMachine{service: service}).Apply(&raft.Log{Data: c.encode()}).(applyResult)
+	}
+	result := enqueue("stored")
+	assert.NoError(t, result.err)
+	assert.Equal(t, 0, result.messageId)
+	assert.ErrorContains(t, enqueue("other").err, "not enqueued through the Raft log")
+}
+
+func TestStateMachineRestoresMetadataOnlySnapshots(t *testing.T) {
+	name := "ClusterTestRestore"
+	t.Cleanup(func() {
+		removeTempDir(name)
+		removeTempDir(name + "Metadata")
+	})
+	service, err := queueinternal.NewQueueService(config.NewConfig(createTempDir(name), createTempDir(name+"Metadata"), 1024, time.Second))
+	assert.NoError(t, err)
+	defer service.Close()
+	for _, message := range []string{"one", "two", "three"} {
+		_, err := service.Queue().Enqueue([]byte(message))
+		assert.NoError(t, err)
+	}
+	service.Ack(7, 2)
+
+	taken := &snapshot{nextId: 3, offsets: map[int]int{7: 0}}
+	var data bytes.Buffer
+	assert.NoError(t, taken.write(&data))
+	assert.Equal(t, 12+16, data.Len(), "messages are not part of the snapshot")
+
+	fsm := &stateMachine{service: service}
+	assert.NoError(t, fsm.Restore(io.NopCloser(bytes.NewReader(data.Bytes()))))
+	assert.Equal(t, 3, fsm.nextId)
+	assert.Equal(t, 0, service.ConsumerOffset(7), "a consumer reset backwards keeps its restored offset")
+
+	ahead := &snapshot{nextId: 5}
+	data.Reset()
+	assert.NoError(t, ahead.write(&data))
+	assert.ErrorContains(t, fsm.Restore(io.NopCloser(&data)), "only up to 3")
+}
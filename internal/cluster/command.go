@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"ashishkujoy/queue/internal/storage"
+	"encoding/binary"
+	"fmt"
+)
+
+// Kinds of commands written to the Raft log.
+const (
+	commandEnqueue byte = 1
+	commandAck     byte = 2
+	// commandEnqueueRecord enqueues a message with headers, laid out as a storage record.
+	commandEnqueueRecord byte = 3
+	// commandResetConsumer moves a consumer to a message, laid out as an ack.
+	commandResetConsumer byte = 4
+)
+
+// command is an operation agreed on through the Raft log and applied by every member.
+type command struct {
+	kind       byte
+	key        []byte
+	headers    map[string]string
+	data       []byte
+	consumerId int
+	messageId  int
+}
+
+// encode lays an enqueue out as kind, key length, key and data,
+// an enqueue with headers as kind and storage record, and an ack or a consumer reset as kind, consumer ID and message ID.
+func (c *command) encode() []byte {
+	switch c.kind {
+	case commandEnqueueRecord:
+		record := storage.Record{Key: c.key, Headers: c.headers, Data: c.data}
+		return append([]byte{c.kind}, record.Encode()...)
+	case commandEnqueue:
+		encoded := make([]byte, 0, 5+len(c.key)+len(c.data))
+		encoded = append(encoded, c.kind)
+		encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(c.key)))
+		encoded = append(encoded, c.key...)
+		return append(encoded, c.data...)
+	default:
+		encoded := make([]byte, 0, 17)
+		encoded = append(encoded, c.kind)
+		encoded = binary.BigEndian.AppendUint64(encoded, uint64(c.consumerId))
+		return binary.BigEndian.AppendUint64(encoded, uint64(c.messageId))
+	}
+}
+
+func decodeCommand(data []byte) (command, error) {
+	if len(data) == 0 {
+		return command{}, fmt.Errorf("empty command")
+	}
+	c := command{kind: data[0]}
+	switch c.kind {
+	case commandEnqueue:
+		if len(data) < 5 {
+			return c, fmt.Errorf("enqueue command truncated")
+		}
+		keySize := int(binary.BigEndian.Uint32(data[1:5]))
+		if len(data) < 5+keySize {
+			return c, fmt.Errorf("enqueue command truncated")
+		}
+		if keySize != 0 {
+			c.key = data[5 : 5+keySize]
+		}
+		c.data = data[5+keySize:]
+	case commandEnqueueRecord:
+		record := storage.Record{}
+		if err := record.Decode(data[1:]); err != nil {
+			return c, fmt.Errorf("enqueue command: %w", err)
+		}
+		c.key, c.headers, c.data = record.Key, record.Headers, record.Data
+	case commandAck, commandResetConsumer:
+		if len(data) != 17 {
+			return c, fmt.Errorf("command %d of %d bytes", c.kind, len(data))
+		}
+		c.consumerId = int(binary.BigEndian.Uint64(data[1:9]))
+		c.messageId = int(binary.BigEndian.Uint64(data[9:17]))
+	default:
+		return c, fmt.Errorf("unknown command %d", c.kind)
+	}
+	return c, nil
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandEncodeDecode(t *testing.T) {
+	enqueue := command{kind: commandEnqueue, key: []byte("key"), data: []byte("data")}
+	decoded, err := decodeCommand(enqueue.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, enqueue, decoded)
+
+	withoutKey := command{kind: commandEnqueue, data: []byte("data")}
+	decoded, err = decodeCommand(withoutKey.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, withoutKey, decoded)
+
+	withHeaders := command{kind: commandEnqueueRecord, key: []byte("key"), headers: map[string]string{"traceparent": "00-01"}, data: []byte("data")}
+	decoded, err = decodeCommand(withHeaders.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, withHeaders, decoded)
+
+	ack := command{kind: commandAck, consumerId: 3, messageId: 42}
+	decoded, err = decodeCommand(ack.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, ack, decoded)
+
+	reset := command{kind: commandResetConsumer, consumerId: 3, messageId: 7}
+	decoded, err = decodeCommand(reset.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, reset, decoded)
+}
+
+func TestDecodeCommandRejectsMalformedCommands(t *testing.T) {
+	for _, data := range [][]byte{nil, {9}, {commandEnqueue, 0, 0}, {commandEnqueue, 0, 0, 0, 8, 'k'}, {commandAck, 1}, {commandEnqueueRecord, 0}} {
+		_, err := decodeCommand(data)
+		assert.Error(t, err)
+	}
+}
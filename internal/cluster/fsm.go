@@ -0,0 +1,155 @@
+package cluster
+
+import (
+	queueinternal "ashishkujoy/queue/internal/queue"
+	"ashishkujoy/queue/internal/storage"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/hashicorp/raft"
+)
+
+// stateMachine applies the commands of the Raft log to the queue and the consumer offsets.
+//
+// Messages are assigned consecutive IDs by counting the enqueues applied, from the next ID
+// recorded in the latest snapshot, so every member assigns the same IDs and acks or consumer
+// resets use none up. A cluster is only bootstrapped over an empty queue, so every message of
+// the queue comes from the Raft log. Entries replayed after a restart are recognised by their
+// ID being below the next ID of the queue and checked against the message stored under it,
+// which makes applying them idempotent.
+type stateMachine struct {
+	service *queueinternal.QueueService
+	// onApply is called after every message enqueued.
+	onApply func()
+	// nextId is the ID the next enqueue applied is assigned, it only moves once the message
+	// is stored. Raft calls Apply, Snapshot and Restore one at a time, so it needs no lock.
+	nextId int
+}
+
+// applyResult is the response of an applied command.
+type applyResult struct {
+	messageId int
+	err       error
+}
+
+func (f *stateMachine) Apply(log *raft.Log) interface{} {
+	c, err := decodeCommand(log.Data)
+	if err != nil {
+		return applyResult{err: err}
+	}
+	switch c.kind {
+	case commandEnqueue, commandEnqueueRecord:
+		messageId := f.nextId
+		queue := f.service.Queue()
+		record := storage.Record{Id: messageId, Key: c.key, Headers: c.headers, Data: c.data}
+		if messageId < queue.NextId() {
+			if err := checkReplayed(queue, record); err != nil {
+				return applyResult{err: err}
+			}
+			f.nextId++
+			return applyResult{messageId: messageId}
+		}
+		if err := queue.AppendReplicated([]storage.Record{record}); err != nil {
+			return applyResult{err: err}
+		}
+		f.nextId++
+		if f.onApply != nil {
+			f.onApply()
+		}
+		return applyResult{messageId: messageId}
+	case commandResetConsumer:
+		f.service.ResetConsumer(c.consumerId, c.messageId)
+		return applyResult{messageId: c.messageId}
+	default:
+		f.service.Ack(c.consumerId, c.messageId)
+		return applyResult{messageId: c.messageId}
+	}
+}
+
+// checkReplayed verifies that the message the queue holds under the ID of a replayed enqueue
+// is the one the entry enqueued, so a message stored outside the Raft log is never taken for it.
+// A message dropped since by retention or compaction is taken as applied.
+func checkReplayed(queue *queueinternal.Queue, record storage.Record) error {
+	stored, err := queue.NewIterator(record.Id).Next()
+	if err == storage.ErrNoMoreMessages || (err == nil && stored.Id != record.Id) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored.Key, record.Key) || !maps.Equal(stored.Headers, record.Headers) || !bytes.Equal(stored.Data, record.Data) {
+		return fmt.Errorf("message %d of the queue was not enqueued through the Raft log", record.Id)
+	}
+	return nil
+}
+
+// Snapshot syncs the queue to disk and captures the next ID along with the consumer offsets.
+// The messages themselves are not part of the snapshot, they stay in the queue of every member.
+// Raft does not call Apply while Snapshot runs.
+func (f *stateMachine) Snapshot() (raft.FSMSnapshot, error) {
+	if err := f.service.Queue().Sync(); err != nil {
+		return nil, err
+	}
+	return &snapshot{nextId: f.nextId, offsets: f.service.ConsumerOffsets()}, nil
+}
+
+// Restore brings the state machine up to a snapshot, setting the next ID and the consumer
+// offsets it records. The queue must already hold every message below the next ID, which a
+// member restarting does, since its queue was synced when the snapshot was taken. A member
+// whose queue is behind, such as one sent a snapshot by a leader that no longer has the
+// entries it misses, needs a copy of the segments of another member.
+func (f *stateMachine) Restore(snapshotReader io.ReadCloser) error {
+	defer snapshotReader.Close()
+	reader := bufio.NewReader(snapshotReader)
+	var header [12]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return err
+	}
+	nextId := int(binary.BigEndian.Uint64(header[:8]))
+	if queueNextId := f.service.Queue().NextId(); queueNextId < nextId {
+		return fmt.Errorf("the snapshot is at message %d but the queue of this member only up to %d", nextId, queueNextId)
+	}
+	offsetCount := int(binary.BigEndian.Uint32(header[8:]))
+	for i := 0; i < offsetCount; i++ {
+		var offset [16]byte
+		if _, err := io.ReadFull(reader, offset[:]); err != nil {
+			return err
+		}
+		f.service.ResetConsumer(int(binary.BigEndian.Uint64(offset[:8])), int(int64(binary.BigEndian.Uint64(offset[8:])))+1)
+	}
+	f.nextId = nextId
+	return nil
+}
+
+// snapshot holds the state captured by stateMachine.Snapshot.
+// It is written as the next message ID, the number of consumer offsets, then the offsets
+// as consumer ID and message ID pairs.
+type snapshot struct {
+	nextId  int
+	offsets map[int]int
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.write(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) write(sink io.Writer) error {
+	data := binary.BigEndian.AppendUint64(nil, uint64(s.nextId))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(s.offsets)))
+	for consumerId, messageId := range s.offsets {
+		data = binary.BigEndian.AppendUint64(data, uint64(consumerId))
+		data = binary.BigEndian.AppendUint64(data, uint64(messageId))
+	}
+	_, err := sink.Write(data)
+	return err
+}
+
+func (s *snapshot) Release() {}
@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"ashishkujoy/queue/internal/config"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// ErrNotLeader is returned for operations that only the cluster leader accepts.
+var ErrNotLeader = errors.New("not the cluster leader")
+
+// ErrNonEmptyQueue is returned when bootstrapping a cluster over a queue that holds messages.
+var ErrNonEmptyQueue = errors.New("a cluster can only be bootstrapped over an empty queue")
+
+// newRaftConfig returns the Raft settings of a node, tests shorten its timeouts.
+var newRaftConfig = func() *raft.Config {
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LogLevel = "WARN"
+	return raftConfig
+}
+
+// Node is a member of a Raft cluster replicating the queue and the consumer offsets.
+// Enqueues and offset updates are committed to the Raft log by the leader and applied
+// by every member once a majority has stored them, so the cluster keeps every
+// acknowledged message and offset as long as a majority of its members is up.
+// The leader is elected automatically.
+type Node struct {
+	raft         *raft.Raft
+	store        *raftboltdb.BoltStore
+	transport    *raft.NetworkTransport
+	peers        []config.ClusterPeer
+	applyTimeout time.Duration
+}
+
+// NewNode starts the cluster member NodeId of the configured peers, applying the Raft log to the service.
+// onApply is called every time a message is enqueued. A member starting without any Raft state
+// bootstraps the cluster with the configured peers, which it refuses to do over a queue that
+// already holds messages, since the other members could not agree on them. The members talk over mutual TLS when
+// transportTLS is set, over plain TCP, without authenticating each other, otherwise.
+func NewNode(cfg *config.Config, service *queueinternal.QueueService, transportTLS *TransportTLS, onApply func()) (*Node, error) {
+	var self *config.ClusterPeer
+	for _, peer := range cfg.ClusterPeers() {
+		if peer.Id == cfg.NodeId() {
+			self = &peer
+		}
+	}
+	if self == nil {
+		return nil, fmt.Errorf("node id %q is not one of the cluster peers", cfg.NodeId())
+	}
+	if err := os.MkdirAll(cfg.RaftDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	raftConfig := newRaftConfig()
+	raftConfig.LocalID = raft.ServerID(self.Id)
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.RaftDir(), "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.RaftDir(), 2, os.Stderr)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	transport, err := newTransport(self.RaftAddress, transportTLS, os.Stderr)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	hasState, err := raft.HasExistingState(store, store, snapshots)
+	if err == nil && !hasState && service.Queue().NextId() != 0 {
+		err = fmt.Errorf("%w, it already holds messages up to %d", ErrNonEmptyQueue, service.Queue().NextId()-1)
+	}
+	if err == nil && !hasState {
+		membership := raft.Configuration{}
+		for _, peer := range cfg.ClusterPeers() {
+			membership.Servers = append(membership.Servers, raft.Server{
+				Suffrage: raft.Voter,
+				ID:       raft.ServerID(peer.Id),
+				Address:  raft.ServerAddress(peer.RaftAddress),
+			})
+		}
+		err = raft.BootstrapCluster(raftConfig, store, store, snapshots, transport, membership)
+	}
+	if err != nil {
+		transport.Close()
+		store.Close()
+		return nil, err
+	}
+
+	fsm := &stateMachine{service: service, onApply: onApply}
+	node, err := raft.NewRaft(raftConfig, fsm, store, store, snapshots, transport)
+	if err != nil {
+		transport.Close()
+		store.Close()
+		return nil, err
+	}
+	return &Node{
+		raft:         node,
+		store:        store,
+		transport:    transport,
+		peers:        cfg.ClusterPeers(),
+		applyTimeout: cfg.ReplicationTimeout(),
+	}, nil
+}
+
+// Enqueue commits a message to the Raft log and returns the ID it was assigned
+// once a majority of the members stored it. Messages with headers are committed as records.
+func (n *Node) Enqueue(key []byte, headers map[string]string, data []byte) (int, error) {
+	if len(headers) != 0 {
+		return n.apply(command{kind: commandEnqueueRecord, key: key, headers: headers, data: data})
+	}
+	return n.apply(command{kind: commandEnqueue, key: key, data: data})
+}
+
+// Ack commits the last message delivered to a consumer to the Raft log.
+func (n *Node) Ack(consumerId int, messageId int) error {
+	_, err := n.apply(command{kind: commandAck, consumerId: consumerId, messageId: messageId})
+	return err
+}
+
+// ResetConsumer commits a new position of a consumer to the Raft log, the next message
+// delivered to it being the first with an ID of at least messageId.
+func (n *Node) ResetConsumer(consumerId int, messageId int) error {
+	_, err := n.apply(command{kind: commandResetConsumer, consumerId: consumerId, messageId: messageId})
+	return err
+}
+
+func (n *Node) apply(c command) (int, error) {
+	future := n.raft.Apply(c.encode(), n.applyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return 0, ErrNotLeader
+		}
+		return 0, err
+	}
+	result := future.Response().(applyResult)
+	return result.messageId, result.err
+}
+
+// IsLeader reports whether this member currently leads the cluster.
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// LeaderClientAddress returns the client address of the current leader, or an empty string when there is none.
+func (n *Node) LeaderClientAddress() string {
+	_, leaderId := n.raft.LeaderWithID()
+	for _, peer := range n.peers {
+		if raft.ServerID(peer.Id) == leaderId {
+			return peer.ClientAddress
+		}
+	}
+	return ""
+}
+
+// Shutdown leaves the cluster running without this member and releases the Raft log.
+func (n *Node) Shutdown() error {
+	err := n.raft.Shutdown().Error()
+	if closeErr := n.transport.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := n.store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// TransportTLS secures the Raft traffic between the members of a cluster with mutual TLS,
+// so only members holding a certificate issued by the cluster CA can append to the log.
+type TransportTLS struct {
+	// Server accepts the connections of the other members, it must require and verify client certificates.
+	Server *tls.Config
+	// Client dials the other members and presents the certificate of this member.
+	Client *tls.Config
+}
+
+// tlsStreamLayer is a raft.StreamLayer over TLS connections.
+type tlsStreamLayer struct {
+	net.Listener
+	client *tls.Config
+}
+
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), l.client)
+}
+
+// newTransport returns the Raft transport of a member listening on address, over TLS when transportTLS is set.
+func newTransport(address string, transportTLS *TransportTLS, logOutput io.Writer) (*raft.NetworkTransport, error) {
+	if transportTLS == nil {
+		return raft.NewTCPTransport(address, nil, 3, 10*time.Second, logOutput)
+	}
+	listener, err := tls.Listen("tcp", address, transportTLS.Server)
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); !ok || addr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, fmt.Errorf("raft address %s is not advertisable", address)
+	}
+	return raft.NewNetworkTransport(&tlsStreamLayer{Listener: listener, client: transportTLS.Client}, 3, 10*time.Second, logOutput), nil
+}
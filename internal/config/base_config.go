@@ -1,12 +1,90 @@
 package config
 
-import "time"
+import (
+	"ashishkujoy/queue/internal/archive"
+	"ashishkujoy/queue/internal/auth"
+	"ashishkujoy/queue/internal/encryption"
+	"ashishkujoy/queue/internal/metrics"
+	"ashishkujoy/queue/internal/quota"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults of the tunables a Config created by DefaultConfig starts with.
+const (
+	DefaultListenAddress             = ":50051"
+	DefaultHTTPAddress               = ":9090"
+	DefaultOTLPEndpoint              = "localhost:4317"
+	DefaultQueueName                 = "default"
+	DefaultSegmentsRoot              = "data/segments"
+	DefaultMetadataPath              = "data/metadata"
+	DefaultMaxSegmentSizeInBytes     = 1024 * 1024 * 10
+	DefaultConsumerIndexSyncInterval = time.Second * 2
+	DefaultMaxMessageSize            = 4 * 1024 * 1024
+	DefaultMinFreeDiskSpace          = 64 * 1024 * 1024
+)
+
+// Policies applied to an enqueue that would grow the backlog past its maximum.
+const (
+	// BacklogReject fails the enqueue.
+	BacklogReject = "reject"
+	// BacklogBlock holds the enqueue until consumers catch up.
+	BacklogBlock = "block"
+	// BacklogSkipOldest skips the oldest undelivered messages of the consumers lagging the most.
+	// It bounds how far consumers lag, not the storage used: skipped messages stay stored until retention removes them.
+	BacklogSkipOldest = "skip-oldest"
+)
+
+// Backends storing the messages of a queue.
+const (
+	// StorageSegments appends messages to segment files indexed by message ID.
+	StorageSegments = "segments"
+	// StorageMemory keeps messages in memory, they are lost on restart.
+	StorageMemory = "memory"
+	// StorageKV stores messages in an embedded key-value database.
+	StorageKV = "kv"
+)
 
 type Config struct {
 	segmentsRoot              string
+	storageBackend            string
 	MetadataPath              string
 	consumerIndexSyncInterval time.Duration
 	maxSegmentSizeInBytes     int
+	compression               string
+	recompressOnRollover      bool
+	keyring                   *encryption.Keyring
+	archive                   archive.SegmentArchive
+	localSegmentsRetained     int
+	archiveCacheSegments      int
+	compactionInterval        time.Duration
+	nodeId                    string
+	replicationLeader         string
+	replicationAcks           string
+	replicationFactor         int
+	replicationTimeout        time.Duration
+	clusterPeers              []ClusterPeer
+	listenAddress             string
+	tlsCertFile               string
+	tlsKeyFile                string
+	tlsClientCAFile           string
+	queueName                 string
+	authPolicy                *auth.Policy
+	quotas                    quota.Quotas
+	maxMessageSize            int
+	maxBacklog                int
+	backlogPolicy             string
+	minFreeDiskSpace          int
+	metrics                   *metrics.Metrics
+	logger                    *slog.Logger
+	tracerProvider            trace.TracerProvider
 }
 
 func (c *Config) MaxSegmentSizeInBytes() int {
@@ -25,6 +103,459 @@ func (c *Config) IndexFilePath() string {
 	return c.MetadataPath + "/index"
 }
 
+// StorageBackend returns the backend storing the messages of the queue, StorageSegments by default.
+func (c *Config) StorageBackend() string {
+	if c.storageBackend == "" {
+		return StorageSegments
+	}
+	return c.storageBackend
+}
+
+// WithStorageBackend sets the backend (segments, memory or kv) storing the messages of the queue.
+// Compaction, compression, encryption and archiving need the segments backend.
+func (c *Config) WithStorageBackend(backend string) *Config {
+	c.storageBackend = backend
+	return c
+}
+
+// Compression returns the name of the codec used to compress batches in new segments.
+// An empty name means batches are stored uncompressed.
+func (c *Config) Compression() string {
+	return c.compression
+}
+
+// WithCompression sets the codec (none, gzip, snappy or zstd) used to compress batches in new segments.
+// Existing segments keep the codec they were written with.
+func (c *Config) WithCompression(codec string) *Config {
+	c.compression = codec
+	return c
+}
+
+// RecompressOnRollover reports whether a segment is rewritten into large compressed batches in the background once it is closed.
+func (c *Config) RecompressOnRollover() bool {
+	return c.recompressOnRollover
+}
+
+// WithRecompressOnRollover enables rewriting closed segments into large compressed batches.
+func (c *Config) WithRecompressOnRollover(recompress bool) *Config {
+	c.recompressOnRollover = recompress
+	return c
+}
+
+// Keyring returns the keys used to encrypt segments, the index and consumer offsets at rest.
+// A nil keyring means new files are written unencrypted.
+func (c *Config) Keyring() *encryption.Keyring {
+	return c.keyring
+}
+
+// WithKeyring enables encryption at rest with the keyring's active key.
+func (c *Config) WithKeyring(keyring *encryption.Keyring) *Config {
+	c.keyring = keyring
+	return c
+}
+
+// Archive returns the object store closed segments are offloaded to, or nil when segments stay local.
+func (c *Config) Archive() archive.SegmentArchive {
+	return c.archive
+}
+
+// WithArchive enables uploading closed segments to the given archive.
+func (c *Config) WithArchive(segmentArchive archive.SegmentArchive) *Config {
+	c.archive = segmentArchive
+	return c
+}
+
+// LocalSegmentsRetained returns how many archived closed segments are kept on local disk.
+// Zero means archived segments are never removed from local disk.
+func (c *Config) LocalSegmentsRetained() int {
+	return c.localSegmentsRetained
+}
+
+// WithLocalSegmentsRetained keeps only the newest count archived segments on local disk,
+// older ones are removed locally once uploaded and fetched back from the archive on demand.
+func (c *Config) WithLocalSegmentsRetained(count int) *Config {
+	c.localSegmentsRetained = count
+	return c
+}
+
+// ArchiveCacheSegments returns how many segments fetched back from the archive are cached locally.
+func (c *Config) ArchiveCacheSegments() int {
+	return max(c.archiveCacheSegments, 1)
+}
+
+// WithArchiveCacheSegments sets how many segments fetched back from the archive are cached locally.
+func (c *Config) WithArchiveCacheSegments(count int) *Config {
+	c.archiveCacheSegments = count
+	return c
+}
+
+// ArchiveCacheDir is the directory segments fetched back from the archive are cached in.
+func (c *Config) ArchiveCacheDir() string {
+	return c.segmentsRoot + "/archive-cache"
+}
+
+// CompactionInterval returns how often closed segments are compacted by key.
+// Zero means the queue is not compacted.
+func (c *Config) CompactionInterval() time.Duration {
+	return c.compactionInterval
+}
+
+// WithCompaction turns the queue into a compacted queue, where closed segments are
+// rewritten every interval keeping only the newest message of each key.
+func (c *Config) WithCompaction(interval time.Duration) *Config {
+	c.compactionInterval = interval
+	return c
+}
+
+// NodeId identifies this server to the leader it replicates from.
+func (c *Config) NodeId() string {
+	return c.nodeId
+}
+
+func (c *Config) WithNodeId(nodeId string) *Config {
+	c.nodeId = nodeId
+	return c
+}
+
+// ReplicationLeader returns the address of the leader this server follows,
+// or an empty string when this server is a leader.
+func (c *Config) ReplicationLeader() string {
+	return c.replicationLeader
+}
+
+// WithReplicationLeader makes this server a follower replicating the leader listening at address.
+// Followers reject enqueues, messages are only appended by replication.
+func (c *Config) WithReplicationLeader(address string) *Config {
+	c.replicationLeader = address
+	return c
+}
+
+// ReplicationAcks returns when an enqueued message is acknowledged,
+// "leader" once stored by the leader or "quorum" once stored by a majority of the replicas.
+func (c *Config) ReplicationAcks() string {
+	if c.replicationAcks == "" {
+		return "leader"
+	}
+	return c.replicationAcks
+}
+
+func (c *Config) WithReplicationAcks(acks string) *Config {
+	c.replicationAcks = acks
+	return c
+}
+
+// ReplicationFactor returns the number of copies of the log, the leader's included.
+func (c *Config) ReplicationFactor() int {
+	return max(c.replicationFactor, 1)
+}
+
+func (c *Config) WithReplicationFactor(factor int) *Config {
+	c.replicationFactor = factor
+	return c
+}
+
+// ReplicationTimeout returns how long an enqueue waits for a quorum of replicas in quorum acks mode.
+func (c *Config) ReplicationTimeout() time.Duration {
+	if c.replicationTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return c.replicationTimeout
+}
+
+func (c *Config) WithReplicationTimeout(timeout time.Duration) *Config {
+	c.replicationTimeout = timeout
+	return c
+}
+
+// ListenAddress returns the address the queue server accepts clients on.
+func (c *Config) ListenAddress() string {
+	if c.listenAddress == "" {
+		return DefaultListenAddress
+	}
+	return c.listenAddress
+}
+
+func (c *Config) WithListenAddress(address string) *Config {
+	c.listenAddress = address
+	return c
+}
+
+// TLSCertFile returns the PEM certificate the server presents to clients, or an empty string when clients connect in plaintext.
+func (c *Config) TLSCertFile() string {
+	return c.tlsCertFile
+}
+
+// TLSKeyFile returns the PEM private key of TLSCertFile.
+func (c *Config) TLSKeyFile() string {
+	return c.tlsKeyFile
+}
+
+// WithTLS makes the server accept TLS connections only, presenting the certificate in certFile.
+// The certificate is loaded again whenever certFile or keyFile changes.
+func (c *Config) WithTLS(certFile string, keyFile string) *Config {
+	c.tlsCertFile = certFile
+	c.tlsKeyFile = keyFile
+	return c
+}
+
+// TLSClientCAFile returns the PEM bundle of the CAs client certificates are verified against,
+// or an empty string when clients do not authenticate with a certificate.
+func (c *Config) TLSClientCAFile() string {
+	return c.tlsClientCAFile
+}
+
+// WithTLSClientCA enables mutual TLS, requiring every client to present a certificate issued by a CA in caFile.
+// Replication followers also trust the CAs in caFile to verify their leader.
+func (c *Config) WithTLSClientCA(caFile string) *Config {
+	c.tlsClientCAFile = caFile
+	return c
+}
+
+func (c *Config) WithSegmentsRoot(segmentsRoot string) *Config {
+	c.segmentsRoot = segmentsRoot
+	return c
+}
+
+func (c *Config) WithMetadataPath(metadataPath string) *Config {
+	c.MetadataPath = metadataPath
+	return c
+}
+
+func (c *Config) WithMaxSegmentSize(sizeInBytes int) *Config {
+	c.maxSegmentSizeInBytes = sizeInBytes
+	return c
+}
+
+func (c *Config) WithConsumerIndexSyncInterval(interval time.Duration) *Config {
+	c.consumerIndexSyncInterval = interval
+	return c
+}
+
+// QueueName returns the name ACL rules refer to the queue of this server by.
+func (c *Config) QueueName() string {
+	if c.queueName == "" {
+		return DefaultQueueName
+	}
+	return c.queueName
+}
+
+func (c *Config) WithQueueName(name string) *Config {
+	c.queueName = name
+	return c
+}
+
+// AuthPolicy returns the tokens and ACL clients are authenticated and authorized with,
+// or nil when every client may do anything.
+func (c *Config) AuthPolicy() *auth.Policy {
+	return c.authPolicy
+}
+
+// WithAuthPolicy requires every request to be authenticated, with an API token or a client
+// certificate when mutual TLS is enabled, and allowed by the policy's ACL.
+func (c *Config) WithAuthPolicy(policy *auth.Policy) *Config {
+	c.authPolicy = policy
+	return c
+}
+
+// Quotas returns the rate limits the server starts with, they can be changed at runtime through the AdminService.
+func (c *Config) Quotas() quota.Quotas {
+	return c.quotas
+}
+
+func (c *Config) WithQuotas(quotas quota.Quotas) *Config {
+	c.quotas = quotas
+	return c
+}
+
+// MaxMessageSize returns the largest message, key included, the queue accepts in bytes.
+func (c *Config) MaxMessageSize() int {
+	if c.maxMessageSize == 0 {
+		return DefaultMaxMessageSize
+	}
+	return c.maxMessageSize
+}
+
+func (c *Config) WithMaxMessageSize(sizeInBytes int) *Config {
+	c.maxMessageSize = sizeInBytes
+	return c
+}
+
+// MaxBacklog returns how many messages the slowest consumer may lag behind the head of the queue.
+// Zero means the backlog is unbounded.
+func (c *Config) MaxBacklog() int {
+	return c.maxBacklog
+}
+
+// BacklogPolicy returns what happens to an enqueue when the backlog is full, reject, block or skip-oldest.
+func (c *Config) BacklogPolicy() string {
+	if c.backlogPolicy == "" {
+		return BacklogReject
+	}
+	return c.backlogPolicy
+}
+
+// WithMaxBacklog bounds how far producers can run ahead of the slowest consumer,
+// applying policy to the enqueues made while the backlog is full.
+func (c *Config) WithMaxBacklog(messages int, policy string) *Config {
+	c.maxBacklog = messages
+	c.backlogPolicy = policy
+	return c
+}
+
+// MinFreeDiskSpace returns the free space in bytes below which the disk holding the data
+// is considered full and the server reports itself not serving. Zero disables the check.
+func (c *Config) MinFreeDiskSpace() int {
+	return c.minFreeDiskSpace
+}
+
+func (c *Config) WithMinFreeDiskSpace(sizeInBytes int) *Config {
+	c.minFreeDiskSpace = sizeInBytes
+	return c
+}
+
+// Metrics returns where the queue records its metrics, nil when metrics are disabled.
+func (c *Config) Metrics() *metrics.Metrics {
+	return c.metrics
+}
+
+func (c *Config) WithMetrics(m *metrics.Metrics) *Config {
+	c.metrics = m
+	return c
+}
+
+// Logger returns the logger every component of the queue logs to, slog.Default when none is set.
+func (c *Config) Logger() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
+func (c *Config) WithLogger(logger *slog.Logger) *Config {
+	c.logger = logger
+	return c
+}
+
+// TracerProvider returns the provider of the tracers spans are started with,
+// the global provider of OpenTelemetry when none is set.
+func (c *Config) TracerProvider() trace.TracerProvider {
+	if c.tracerProvider == nil {
+		return otel.GetTracerProvider()
+	}
+	return c.tracerProvider
+}
+
+func (c *Config) WithTracerProvider(provider trace.TracerProvider) *Config {
+	c.tracerProvider = provider
+	return c
+}
+
+// Validate reports every tunable that is out of range or inconsistent with the others.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.segmentsRoot == "" {
+		errs = append(errs, errors.New("segments directory is required"))
+	}
+	if c.MetadataPath == "" {
+		errs = append(errs, errors.New("metadata directory is required"))
+	}
+	errs = append(errs, c.storageBackendErrors()...)
+	if c.maxSegmentSizeInBytes <= 0 {
+		errs = append(errs, fmt.Errorf("segment size must be positive, got %d", c.maxSegmentSizeInBytes))
+	}
+	if c.consumerIndexSyncInterval <= 0 {
+		errs = append(errs, fmt.Errorf("consumer index sync interval must be positive, got %s", c.consumerIndexSyncInterval))
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddress()); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address: %w", err))
+	}
+	if (c.tlsCertFile == "") != (c.tlsKeyFile == "") {
+		errs = append(errs, errors.New("TLS needs both a certificate and a key file"))
+	}
+	if c.tlsClientCAFile != "" && c.tlsCertFile == "" {
+		errs = append(errs, errors.New("mutual TLS needs a server certificate"))
+	}
+	if c.localSegmentsRetained < 0 {
+		errs = append(errs, fmt.Errorf("local segments retained must not be negative, got %d", c.localSegmentsRetained))
+	}
+	if c.compactionInterval < 0 {
+		errs = append(errs, fmt.Errorf("compaction interval must not be negative, got %s", c.compactionInterval))
+	}
+	if acks := c.ReplicationAcks(); acks != "leader" && acks != "quorum" {
+		errs = append(errs, fmt.Errorf("unknown acks mode %q, expected leader or quorum", acks))
+	}
+	if c.replicationFactor < 0 {
+		errs = append(errs, fmt.Errorf("replication factor must be positive, got %d", c.replicationFactor))
+	}
+	if len(c.clusterPeers) != 0 {
+		if c.replicationLeader != "" {
+			errs = append(errs, errors.New("a cluster member cannot follow a replication leader"))
+		}
+		if c.StorageBackend() == StorageMemory {
+			errs = append(errs, errors.New("a cluster member needs a persistent storage backend, not memory"))
+		}
+		if c.tlsCertFile != "" && c.tlsClientCAFile == "" {
+			errs = append(errs, errors.New("a cluster with TLS needs client CAs to authenticate the Raft traffic of its members"))
+		}
+		if !c.isClusterPeer(c.nodeId) {
+			errs = append(errs, fmt.Errorf("node id %q is not one of the cluster peers", c.nodeId))
+		}
+	}
+	if c.maxMessageSize < 0 || c.maxMessageSize > math.MaxInt32 {
+		errs = append(errs, fmt.Errorf("max message size must be between 1 and %d bytes, got %d", math.MaxInt32, c.maxMessageSize))
+	}
+	if c.maxBacklog < 0 {
+		errs = append(errs, fmt.Errorf("max backlog must not be negative, got %d", c.maxBacklog))
+	}
+	if policy := c.BacklogPolicy(); policy != BacklogReject && policy != BacklogBlock && policy != BacklogSkipOldest {
+		errs = append(errs, fmt.Errorf("unknown backlog policy %q, expected reject, block or skip-oldest", policy))
+	}
+	if c.minFreeDiskSpace < 0 {
+		errs = append(errs, fmt.Errorf("min free disk space must not be negative, got %d", c.minFreeDiskSpace))
+	}
+	for name, limits := range map[string]quota.Limits{"produce": c.quotas.Produce, "consume": c.quotas.Consume, "queue": c.quotas.Queue} {
+		if limits.MessagesPerSecond < 0 || limits.BytesPerSecond < 0 {
+			errs = append(errs, fmt.Errorf("%s quota must not be negative", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// storageBackendErrors reports the settings the storage backend does not support. It is checked again
+// once settings have loaded the keyring and the archive, which Validate runs before.
+func (c *Config) storageBackendErrors() []error {
+	backend := c.StorageBackend()
+	if backend == StorageSegments {
+		return nil
+	}
+	if backend != StorageMemory && backend != StorageKV {
+		return []error{fmt.Errorf("unknown storage backend %q, expected segments, memory or kv", backend)}
+	}
+	var errs []error
+	if c.compactionInterval > 0 {
+		errs = append(errs, fmt.Errorf("compaction needs the segments storage backend, not %s", backend))
+	}
+	if c.compression != "" && c.compression != "none" {
+		errs = append(errs, fmt.Errorf("compression needs the segments storage backend, not %s", backend))
+	}
+	if c.recompressOnRollover {
+		errs = append(errs, fmt.Errorf("recompression needs the segments storage backend, not %s", backend))
+	}
+	if c.keyring != nil {
+		errs = append(errs, fmt.Errorf("encryption needs the segments storage backend, not %s", backend))
+	}
+	if c.archive != nil {
+		errs = append(errs, fmt.Errorf("archiving needs the segments storage backend, not %s", backend))
+	}
+	return errs
+}
+
+// DefaultConfig returns a Config holding the default of every tunable, to be adjusted with its With methods.
+func DefaultConfig() *Config {
+	return NewConfig(DefaultSegmentsRoot, DefaultMetadataPath, DefaultMaxSegmentSizeInBytes, DefaultConsumerIndexSyncInterval)
+}
+
+// NewConfig returns a Config storing segments under segmentsRoot and metadata under metadataPath.
 func NewConfig(
 	segmentsRoot string,
 	metadataPath string,
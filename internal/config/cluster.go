@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClusterPeer is a member of a Raft cluster.
+type ClusterPeer struct {
+	Id string
+	// RaftAddress is the address the member exchanges Raft messages on.
+	RaftAddress string
+	// ClientAddress is the address clients reach the member's queue server on, clients are redirected to it when the member leads.
+	ClientAddress string
+}
+
+// ClusterPeers returns the members of the Raft cluster this server belongs to, itself included.
+// No peers means the server does not run in clustered mode.
+func (c *Config) ClusterPeers() []ClusterPeer {
+	return c.clusterPeers
+}
+
+// WithClusterPeers runs the server as the member NodeId of a Raft cluster made of the given peers.
+func (c *Config) WithClusterPeers(peers []ClusterPeer) *Config {
+	c.clusterPeers = peers
+	return c
+}
+
+func (c *Config) isClusterPeer(nodeId string) bool {
+	for _, peer := range c.clusterPeers {
+		if peer.Id == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// RaftDir is the directory the Raft log and snapshots are stored in.
+func (c *Config) RaftDir() string {
+	return c.MetadataPath + "/raft"
+}
+
+// ParseClusterPeers parses a comma separated list of <id>=<raft-address>/<client-address> members.
+func ParseClusterPeers(spec string) ([]ClusterPeer, error) {
+	var peers []ClusterPeer
+	for _, member := range strings.Split(spec, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		id, addresses, ok := strings.Cut(member, "=")
+		raftAddress, clientAddress, ok2 := strings.Cut(addresses, "/")
+		if !ok || !ok2 || id == "" || raftAddress == "" || clientAddress == "" {
+			return nil, fmt.Errorf("invalid cluster member %q, expected <id>=<raft-address>/<client-address>", member)
+		}
+		peers = append(peers, ClusterPeer{Id: id, RaftAddress: raftAddress, ClientAddress: clientAddress})
+	}
+	return peers, nil
+}
@@ -0,0 +1,392 @@
+package config
+
+import (
+	"ashishkujoy/queue/internal/archive"
+	"ashishkujoy/queue/internal/auth"
+	"ashishkujoy/queue/internal/encryption"
+	"ashishkujoy/queue/internal/metrics"
+	"ashishkujoy/queue/internal/quota"
+	"ashishkujoy/queue/internal/tracing"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables settings are read from,
+// the setting listen-address being read from QUEUE_LISTEN_ADDRESS.
+const EnvPrefix = "QUEUE_"
+
+// Settings are the tunables of a server as an operator sets them.
+//
+// Every setting is a command line flag, a key of the YAML config file named by -config
+// and an environment variable, named after the flag. A flag takes precedence over the
+// environment, which takes precedence over the config file.
+type Settings struct {
+	flags *flag.FlagSet
+	// names are the flags registered for the settings, in registration order.
+	names []string
+
+	configFile                string
+	listenAddress             string
+	httpAddress               string
+	logLevel                  string
+	logFormat                 string
+	tracingExporter           string
+	otlpEndpoint              string
+	segmentsDir               string
+	metadataDir               string
+	segmentSize               int
+	consumerIndexSyncInterval time.Duration
+	tlsCertFile               string
+	tlsKeyFile                string
+	tlsClientCAFile           string
+	queueName                 string
+	authFile                  string
+	storageBackend            string
+	compression               string
+	recompressOnRollover      bool
+	encryptionKeyFile         string
+	archiveDir                string
+	s3Endpoint                string
+	s3Bucket                  string
+	s3Region                  string
+	s3Prefix                  string
+	localSegments             int
+	cacheSegments             int
+	compactionInterval        time.Duration
+	nodeId                    string
+	replicateFrom             string
+	acks                      string
+	replicationFactor         int
+	replicationTimeout        time.Duration
+	cluster                   string
+	shutdownTimeout           time.Duration
+	quotas                    quota.Quotas
+	maxMessageSize            int
+	minFreeDiskSpace          int
+	maxBacklog                int
+	backlogPolicy             string
+}
+
+// NewSettings registers a flag for every setting on flags.
+func NewSettings(flags *flag.FlagSet) *Settings {
+	s := &Settings{flags: flags}
+	flags.StringVar(&s.configFile, "config", "", "YAML file to read the settings from, its keys are the flag names")
+	s.stringVar(&s.listenAddress, "listen-address", DefaultListenAddress, "address the server accepts clients on")
+	s.stringVar(&s.httpAddress, "http-address", DefaultHTTPAddress, "address of the HTTP server exposing /metrics, /healthz and /readyz, disabled when empty")
+	s.stringVar(&s.logLevel, "log-level", "info", "lowest level of the messages logged (debug|info|warn|error)")
+	s.stringVar(&s.logFormat, "log-format", "text", "format of the log lines written to stderr (text|json)")
+	s.stringVar(&s.tracingExporter, "tracing-exporter", tracing.ExporterNone, "where spans are exported to (none|otlp|stdout)")
+	s.stringVar(&s.otlpEndpoint, "otlp-endpoint", DefaultOTLPEndpoint, "address of the OTLP collector spans are exported to over gRPC")
+	s.stringVar(&s.segmentsDir, "segments-dir", DefaultSegmentsRoot, "directory the segments are stored in")
+	s.stringVar(&s.metadataDir, "metadata-dir", DefaultMetadataPath, "directory the message index and consumer offsets are stored in")
+	s.intVar(&s.segmentSize, "segment-size", DefaultMaxSegmentSizeInBytes, "size in bytes at which a segment is closed and a new one started")
+	s.durationVar(&s.consumerIndexSyncInterval, "consumer-index-sync-interval", DefaultConsumerIndexSyncInterval, "how often consumer offsets are persisted")
+	s.intVar(&s.maxMessageSize, "max-message-size", DefaultMaxMessageSize, "largest message, key included, accepted in bytes")
+	s.intVar(&s.minFreeDiskSpace, "min-free-disk-space", DefaultMinFreeDiskSpace, "free bytes on the data disk below which the server reports not serving, 0 disables the check")
+	s.intVar(&s.maxBacklog, "max-backlog", 0, "messages the slowest consumer may lag behind the newest message, 0 is unbounded")
+	s.stringVar(&s.backlogPolicy, "backlog-policy", BacklogReject, "what an enqueue does while the backlog is full (reject|block|skip-oldest)")
+	s.stringVar(&s.tlsCertFile, "tls-cert-file", "", "PEM certificate presented to clients, enables TLS")
+	s.stringVar(&s.tlsKeyFile, "tls-key-file", "", "PEM private key of the TLS certificate")
+	s.stringVar(&s.tlsClientCAFile, "tls-client-ca-file", "", "PEM bundle of the CAs client certificates must be issued by, enables mutual TLS")
+	s.stringVar(&s.queueName, "queue-name", DefaultQueueName, "name ACL rules refer to the queue by")
+	s.stringVar(&s.authFile, "auth-file", "", "YAML file of the API tokens and ACL rules clients are authenticated and authorized with, anyone may do anything without it")
+	s.stringVar(&s.storageBackend, "storage-backend", StorageSegments, "backend storing the messages, compaction, compression, encryption and archiving need segments (segments|memory|kv)")
+	s.stringVar(&s.compression, "compression", "", "codec compressing the batches of new segments (none|gzip|snappy|zstd)")
+	s.boolVar(&s.recompressOnRollover, "recompress-on-rollover", false, "rewrite closed segments into large compressed batches")
+	s.stringVar(&s.encryptionKeyFile, "encryption-keyfile", "", "file holding the keys used to encrypt data at rest, defaults to $"+encryption.KeysEnvVar)
+	s.stringVar(&s.archiveDir, "archive-dir", "", "directory closed segments are offloaded to")
+	s.stringVar(&s.s3Endpoint, "archive-s3-endpoint", "", "endpoint of the S3 compatible store closed segments are offloaded to, credentials are read from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	s.stringVar(&s.s3Bucket, "archive-s3-bucket", "", "bucket closed segments are offloaded to")
+	s.stringVar(&s.s3Region, "archive-s3-region", "us-east-1", "region of the S3 bucket")
+	s.stringVar(&s.s3Prefix, "archive-s3-prefix", "", "prefix of the archived segment objects")
+	s.intVar(&s.localSegments, "archive-local-segments", 0, "number of archived segments kept on local disk, 0 keeps all of them")
+	s.intVar(&s.cacheSegments, "archive-cache-segments", 4, "number of segments fetched back from the archive cached on local disk")
+	s.durationVar(&s.compactionInterval, "compaction-interval", 0, "compact the queue by message key at this interval, 0 disables compaction")
+	s.stringVar(&s.nodeId, "node-id", "", "identity of this server towards its replication leader or within its cluster, defaults to the hostname")
+	s.stringVar(&s.replicateFrom, "replicate-from", "", "address of the leader to replicate, making this server a follower")
+	s.stringVar(&s.acks, "acks", "leader", "acknowledge enqueued messages once stored by the leader or by a quorum of replicas (leader|quorum)")
+	s.intVar(&s.replicationFactor, "replication-factor", 1, "number of copies of the log, the leader's included, a quorum is a majority of them")
+	s.durationVar(&s.replicationTimeout, "replication-timeout", 5*time.Second, "how long an enqueue waits for a quorum of replicas")
+	s.stringVar(&s.cluster, "cluster", "", "members of the Raft cluster this server belongs to as <id>=<raft-address>/<client-address>,..., this server being the member -node-id")
+	s.durationVar(&s.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long a shutdown waits for in-flight RPCs before cancelling them")
+	s.float64Var(&s.quotas.Produce.MessagesPerSecond, "produce-messages-per-second", 0, "messages every client may enqueue per second, 0 is unlimited")
+	s.float64Var(&s.quotas.Produce.BytesPerSecond, "produce-bytes-per-second", 0, "bytes every client may enqueue per second, 0 is unlimited")
+	s.float64Var(&s.quotas.Consume.MessagesPerSecond, "consume-messages-per-second", 0, "messages delivered to every client per second, 0 is unlimited")
+	s.float64Var(&s.quotas.Consume.BytesPerSecond, "consume-bytes-per-second", 0, "bytes delivered to every client per second, 0 is unlimited")
+	s.float64Var(&s.quotas.Queue.MessagesPerSecond, "queue-messages-per-second", 0, "messages all clients together may enqueue per second, 0 is unlimited")
+	s.float64Var(&s.quotas.Queue.BytesPerSecond, "queue-bytes-per-second", 0, "bytes all clients together may enqueue per second, 0 is unlimited")
+	return s
+}
+
+func (s *Settings) stringVar(value *string, name string, defaultValue string, usage string) {
+	s.flags.StringVar(value, name, defaultValue, usage)
+	s.names = append(s.names, name)
+}
+
+func (s *Settings) intVar(value *int, name string, defaultValue int, usage string) {
+	s.flags.IntVar(value, name, defaultValue, usage)
+	s.names = append(s.names, name)
+}
+
+func (s *Settings) boolVar(value *bool, name string, defaultValue bool, usage string) {
+	s.flags.BoolVar(value, name, defaultValue, usage)
+	s.names = append(s.names, name)
+}
+
+func (s *Settings) float64Var(value *float64, name string, defaultValue float64, usage string) {
+	s.flags.Float64Var(value, name, defaultValue, usage)
+	s.names = append(s.names, name)
+}
+
+func (s *Settings) durationVar(value *time.Duration, name string, defaultValue time.Duration, usage string) {
+	s.flags.DurationVar(value, name, defaultValue, usage)
+	s.names = append(s.names, name)
+}
+
+// Load parses the command line arguments, then fills the settings they leave unset
+// from the environment, looked up with lookupEnv, and from the config file.
+func (s *Settings) Load(args []string, lookupEnv func(string) (string, bool)) error {
+	if err := s.flags.Parse(args); err != nil {
+		return err
+	}
+	setOnCommandLine := make(map[string]bool)
+	s.flags.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+	if s.configFile == "" {
+		s.configFile, _ = lookupEnv(envName("config"))
+	}
+
+	fileValues := make(map[string]string)
+	if s.configFile != "" {
+		values, err := readSettingsFile(s.configFile)
+		if err != nil {
+			return err
+		}
+		fileValues = values
+	}
+	for name := range fileValues {
+		if !s.isSetting(name) {
+			return fmt.Errorf("%s: unknown setting %q", s.configFile, name)
+		}
+	}
+
+	for _, name := range s.names {
+		if setOnCommandLine[name] {
+			continue
+		}
+		if value, ok := lookupEnv(envName(name)); ok {
+			if err := s.flags.Set(name, value); err != nil {
+				return fmt.Errorf("$%s: %w", envName(name), err)
+			}
+			continue
+		}
+		if value, ok := fileValues[name]; ok {
+			if err := s.flags.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %s: %w", s.configFile, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Settings) isSetting(name string) bool {
+	for _, setting := range s.names {
+		if setting == name {
+			return true
+		}
+	}
+	return false
+}
+
+// envName returns the environment variable a setting is read from.
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readSettingsFile reads a YAML file mapping setting names to scalar values.
+func readSettingsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := make(map[string]string, len(document))
+	for name, value := range document {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s: setting %q must be a single value", path, name)
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// ShutdownTimeout returns how long a shutdown waits for in-flight RPCs.
+func (s *Settings) ShutdownTimeout() time.Duration {
+	return s.shutdownTimeout
+}
+
+// HTTPAddress returns the address of the HTTP server exposing /metrics and the health probes, empty when it is disabled.
+func (s *Settings) HTTPAddress() string {
+	return s.httpAddress
+}
+
+// Config builds and validates the server configuration the settings describe,
+// loading the encryption keys and opening the archive they name.
+func (s *Settings) Config() (*Config, error) {
+	clusterPeers, err := ParseClusterPeers(s.cluster)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := newLogger(s.logLevel, s.logFormat, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	nodeId := s.nodeId
+	if nodeId == "" && (len(clusterPeers) != 0 || s.replicateFrom != "") {
+		if nodeId, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("a node id is required to replicate: %w", err)
+		}
+	}
+	conf := DefaultConfig().
+		WithListenAddress(s.listenAddress).
+		WithSegmentsRoot(s.segmentsDir).
+		WithMetadataPath(s.metadataDir).
+		WithMaxSegmentSize(s.segmentSize).
+		WithConsumerIndexSyncInterval(s.consumerIndexSyncInterval).
+		WithMaxMessageSize(s.maxMessageSize).
+		WithMinFreeDiskSpace(s.minFreeDiskSpace).
+		WithMaxBacklog(s.maxBacklog, s.backlogPolicy).
+		WithTLS(s.tlsCertFile, s.tlsKeyFile).
+		WithTLSClientCA(s.tlsClientCAFile).
+		WithQueueName(s.queueName).
+		WithStorageBackend(s.storageBackend).
+		WithCompression(s.compression).
+		WithRecompressOnRollover(s.recompressOnRollover).
+		WithLocalSegmentsRetained(s.localSegments).
+		WithArchiveCacheSegments(s.cacheSegments).
+		WithCompaction(s.compactionInterval).
+		WithNodeId(nodeId).
+		WithReplicationLeader(s.replicateFrom).
+		WithReplicationAcks(s.acks).
+		WithReplicationFactor(s.replicationFactor).
+		WithReplicationTimeout(s.replicationTimeout).
+		WithClusterPeers(clusterPeers).
+		WithQuotas(s.quotas).
+		WithLogger(logger)
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	if s.authFile != "" {
+		policy, err := auth.LoadPolicy(s.authFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load auth policy: %w", err)
+		}
+		conf.WithAuthPolicy(policy)
+	}
+
+	if s.httpAddress != "" {
+		conf.WithMetrics(metrics.New())
+	}
+
+	tracerProvider, err := tracing.NewProvider(context.Background(), s.tracingExporter, s.otlpEndpoint, "queue")
+	if err != nil {
+		return nil, err
+	}
+	if tracerProvider != nil {
+		conf.WithTracerProvider(tracerProvider)
+	}
+
+	keyring, err := encryption.LoadKeyring(s.encryptionKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	conf.WithKeyring(keyring)
+
+	switch {
+	case s.archiveDir != "":
+		segmentArchive, err := archive.NewDirectoryArchive(s.archiveDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		conf.WithArchive(segmentArchive)
+	case s.s3Endpoint != "":
+		segmentArchive, err := archive.NewS3Archive(archive.S3Config{
+			Endpoint:        s.s3Endpoint,
+			Region:          s.s3Region,
+			Bucket:          s.s3Bucket,
+			Prefix:          s.s3Prefix,
+			AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		conf.WithArchive(segmentArchive)
+	}
+	if err := errors.Join(conf.storageBackendErrors()...); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// newLogger returns a logger writing the messages of level and above to w in format.
+func newLogger(level string, format string, w io.Writer) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+// LogValue logs every setting with its resolved value.
+func (s *Settings) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(s.names)+1)
+	if s.configFile != "" {
+		attrs = append(attrs, slog.String("config", s.configFile))
+	}
+	for _, name := range s.names {
+		attrs = append(attrs, slog.String(name, s.flags.Lookup(name).Value.String()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// String lists every setting with its resolved value, one per line.
+func (s *Settings) String() string {
+	names := append([]string(nil), s.names...)
+	sort.Strings(names)
+	var builder strings.Builder
+	if s.configFile != "" {
+		fmt.Fprintf(&builder, "config = %s\n", s.configFile)
+	}
+	for _, name := range names {
+		fmt.Fprintf(&builder, "%s = %s\n", name, s.flags.Lookup(name).Value)
+	}
+	return builder.String()
+}
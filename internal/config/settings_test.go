@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func writeSettingsFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "queue.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestSettingsDefaults(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load(nil, lookupIn(nil)))
+
+	conf, err := settings.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultListenAddress, conf.ListenAddress())
+	assert.Equal(t, DefaultSegmentsRoot, conf.SegmentsRoot())
+	assert.Equal(t, DefaultMetadataPath, conf.MetadataPath)
+	assert.Equal(t, DefaultMaxSegmentSizeInBytes, conf.MaxSegmentSizeInBytes())
+	assert.Equal(t, DefaultConsumerIndexSyncInterval, conf.ConsumerIndexSyncInterval())
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	path := writeSettingsFile(t, `
+listen-address: 127.0.0.1:6000
+segments-dir: /var/queue/segments
+segment-size: 2048
+consumer-index-sync-interval: 10s
+recompress-on-rollover: true
+`)
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	env := map[string]string{
+		"QUEUE_SEGMENTS_DIR": "/env/segments",
+		"QUEUE_SEGMENT_SIZE": "4096",
+	}
+	assert.NoError(t, settings.Load([]string{"-config", path, "-segment-size", "8192"}, lookupIn(env)))
+
+	conf, err := settings.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:6000", conf.ListenAddress())
+	assert.Equal(t, "/env/segments", conf.SegmentsRoot())
+	assert.Equal(t, 8192, conf.MaxSegmentSizeInBytes())
+	assert.Equal(t, 10*time.Second, conf.ConsumerIndexSyncInterval())
+	assert.True(t, conf.RecompressOnRollover())
+}
+
+func TestSettingsConfigFileFromEnvironment(t *testing.T) {
+	path := writeSettingsFile(t, "metadata-dir: /var/queue/metadata\n")
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load(nil, lookupIn(map[string]string{"QUEUE_CONFIG": path})))
+
+	conf, err := settings.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/queue/metadata", conf.MetadataPath)
+}
+
+func TestSettingsRejectUnknownAndMalformedValues(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.ErrorContains(t, settings.Load([]string{"-config", writeSettingsFile(t, "segment-sise: 10\n")}, lookupIn(nil)), "unknown setting")
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.ErrorContains(t, settings.Load(nil, lookupIn(map[string]string{"QUEUE_SEGMENT_SIZE": "big"})), "QUEUE_SEGMENT_SIZE")
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.ErrorContains(t, settings.Load([]string{"-config", writeSettingsFile(t, "cluster:\n  - a\n")}, lookupIn(nil)), "single value")
+}
+
+func TestSettingsValidateTheConfig(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{
+		"-segment-size", "0",
+		"-listen-address", "localhost",
+		"-tls-cert-file", "server.pem",
+		"-acks", "all",
+		"-max-backlog", "-1",
+		"-backlog-policy", "shed",
+	}, lookupIn(nil)))
+
+	_, err := settings.Config()
+	assert.ErrorContains(t, err, "segment size must be positive")
+	assert.ErrorContains(t, err, "invalid listen address")
+	assert.ErrorContains(t, err, "TLS needs both a certificate and a key file")
+	assert.ErrorContains(t, err, "unknown acks mode")
+	assert.ErrorContains(t, err, "max backlog must not be negative")
+	assert.ErrorContains(t, err, "unknown backlog policy")
+}
+
+func TestSettingsValidateClusterMembers(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{
+		"-node-id", "a",
+		"-cluster", "a=127.0.0.1:7000/127.0.0.1:50051",
+		"-tls-cert-file", "server.pem",
+		"-tls-key-file", "server-key.pem",
+	}, lookupIn(nil)))
+	_, err := settings.Config()
+	assert.ErrorContains(t, err, "a cluster with TLS needs client CAs")
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{
+		"-node-id", "a",
+		"-cluster", "a=127.0.0.1:7000/127.0.0.1:50051",
+		"-storage-backend", "memory",
+	}, lookupIn(nil)))
+	_, err = settings.Config()
+	assert.ErrorContains(t, err, "a cluster member needs a persistent storage backend")
+}
+
+func TestSettingsRejectFeaturesTheStorageBackendLacks(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{"-storage-backend", "disk"}, lookupIn(nil)))
+	_, err := settings.Config()
+	assert.ErrorContains(t, err, "unknown storage backend")
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{
+		"-storage-backend", "kv",
+		"-compression", "zstd",
+		"-compaction-interval", "1m",
+		"-archive-dir", t.TempDir(),
+	}, lookupIn(nil)))
+	_, err = settings.Config()
+	assert.ErrorContains(t, err, "compression needs the segments storage backend, not kv")
+	assert.ErrorContains(t, err, "compaction needs the segments storage backend")
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{"-storage-backend", "memory", "-archive-dir", t.TempDir()}, lookupIn(nil)))
+	_, err = settings.Config()
+	assert.ErrorContains(t, err, "archiving needs the segments storage backend, not memory")
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{"-storage-backend", "memory"}, lookupIn(nil)))
+	conf, err := settings.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, StorageMemory, conf.StorageBackend())
+}
+
+func TestSettingsListResolvedValues(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{"-listen-address", ":7000"}, lookupIn(nil)))
+	assert.Contains(t, settings.String(), "listen-address = :7000\n")
+	assert.Contains(t, settings.String(), "segment-size = 10485760\n")
+}
+
+func TestSettingsBuildTheLogger(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{"-log-level", "debug", "-log-format", "json"}, lookupIn(nil)))
+	conf, err := settings.Config()
+	assert.NoError(t, err)
+	assert.True(t, conf.Logger().Enabled(context.Background(), slog.LevelDebug))
+
+	var output bytes.Buffer
+	logger, err := newLogger("warn", "json", &output)
+	assert.NoError(t, err)
+	logger.Info("dropped")
+	logger.Warn("kept", "segment", 3)
+	assert.NotContains(t, output.String(), "dropped")
+	assert.Contains(t, output.String(), `"msg":"kept","segment":3`)
+
+	_, err = newLogger("verbose", "text", &output)
+	assert.ErrorContains(t, err, "unknown log level")
+	_, err = newLogger("info", "xml", &output)
+	assert.ErrorContains(t, err, "unknown log format")
+}
+
+func TestSettingsBuildTheTracerProvider(t *testing.T) {
+	settings := NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load(nil, lookupIn(nil)))
+	conf, err := settings.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, otel.GetTracerProvider(), conf.TracerProvider())
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load(nil, lookupIn(map[string]string{"QUEUE_TRACING_EXPORTER": "stdout"})))
+	conf, err = settings.Config()
+	assert.NoError(t, err)
+	assert.IsType(t, &sdktrace.TracerProvider{}, conf.TracerProvider())
+
+	settings = NewSettings(flag.NewFlagSet("server", flag.ContinueOnError))
+	assert.NoError(t, settings.Load([]string{"-tracing-exporter", "jaeger"}, lookupIn(nil)))
+	_, err = settings.Config()
+	assert.ErrorContains(t, err, "unknown tracing exporter")
+}
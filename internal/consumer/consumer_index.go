@@ -3,10 +3,14 @@ package consumer
 import (
 	"ashishkujoy/queue/internal"
 	"ashishkujoy/queue/internal/config"
+	"ashishkujoy/queue/internal/encryption"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,6 +27,8 @@ type ConsumerIndex struct {
 	mu      *sync.RWMutex
 	config  *config.Config
 	indexes map[int]int
+	// stopPersist stops the periodic persistence and waits for a running persist to finish.
+	stopPersist func()
 }
 
 // NewConsumerIndex initializes a new ConsumerIndex instance.
@@ -42,18 +48,26 @@ func NewConsumerIndex(config *config.Config) (*ConsumerIndex, error) {
 }
 
 func (ci *ConsumerIndex) schedulePersist() {
-	fmt.Printf("Starting consumer index persistence with interval: %s\n", ci.config.ConsumerIndexSyncInterval())
+	logger := ci.config.Logger()
+	logger.Info("persisting consumer offsets periodically", "interval", ci.config.ConsumerIndexSyncInterval())
 	ticker := time.NewTicker(ci.config.ConsumerIndexSyncInterval())
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ci.stopPersist = sync.OnceFunc(func() {
+		close(stop)
+		<-done
+	})
 	go func() {
+		defer close(done)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				fmt.Printf("Starting consumer index persistence\n")
-				err := ci.Persist()
-				if err != nil {
-					fmt.Printf("Error persisting consumer index: %v\n", err)
+				if err := ci.Persist(); err != nil {
+					logger.Error("failed to persist consumer offsets", "error", err)
 				}
-				fmt.Printf("Done with consumer index persistence\n")
+			case <-stop:
+				return
 			}
 		}
 	}()
@@ -101,32 +115,104 @@ func getSortedIndexFiles(config *config.Config) ([]*os.File, error) {
 	return files, nil
 }
 
-func restoreIndexesFromFile(file *os.File) (map[int]int, error) {
-	stat, err := file.Stat()
+func restoreIndexesFromFile(file *os.File, keyring *encryption.Keyring) (map[int]int, error) {
+	data, err := io.ReadAll(file)
 	if err != nil {
 		return nil, err
 	}
-	indexSize := stat.Size() / 8
+	data, err = openSnapshot(data, keyring)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name(), err)
+	}
+	return decodeSnapshot(data), nil
+}
+
+// decodeSnapshot decodes the offsets of a snapshot created by CreateSnapshot,
+// ignoring a trailing partial entry.
+func decodeSnapshot(data []byte) map[int]int {
+	indexSize := len(data) / 8
 	offset := 0
-	indexes := make(map[int]int, stat.Size()/8)
-	for i := int64(0); i < indexSize; i++ {
-		buf := make([]byte, 4)
-		_, err := file.ReadAt(buf, int64(offset))
-		if err != nil {
-			return nil, err
-		}
+	indexes := make(map[int]int, indexSize)
+	for i := 0; i < indexSize; i++ {
+		consumerId := binary.BigEndian.Uint32(data[offset:])
 		offset += 4
-		consumerId := binary.BigEndian.Uint32(buf)
-		buf = make([]byte, 4)
-		_, err = file.ReadAt(buf, int64(offset))
-		if err != nil {
-			return nil, err
-		}
+		consumerIndex := binary.BigEndian.Uint32(data[offset:])
 		offset += 4
-		consumerIndex := binary.BigEndian.Uint32(buf)
-		indexes[int(consumerId)] = int(consumerIndex)
+		// Offsets are stored as 32 bits, -1 being a consumer that has received no message yet.
+		indexes[int(consumerId)] = int(int32(consumerIndex))
 	}
-	return indexes, nil
+	return indexes
+}
+
+// OffsetsFile is a consumer offsets file decoded by ReadOffsetsFiles.
+type OffsetsFile struct {
+	Path    string
+	Offsets map[int]int
+	// Err is set when the file cannot be read or decrypted, or ends with a partial entry.
+	Err error
+}
+
+// ReadOffsetsFiles decodes every consumer offsets file in the metadata directory, newest first.
+// Offsets are restored from the newest file, the older ones are leftovers of an interrupted persist.
+func ReadOffsetsFiles(config *config.Config) ([]OffsetsFile, error) {
+	entries, err := os.ReadDir(config.MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []OffsetsFile
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "consumer_index_") {
+			files = append(files, readOffsetsFile(filepath.Join(config.MetadataPath, entry.Name()), config.Keyring()))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return extractTimestamp(files[i].Path) > extractTimestamp(files[j].Path)
+	})
+	return files, nil
+}
+
+func readOffsetsFile(path string, keyring *encryption.Keyring) OffsetsFile {
+	file := OffsetsFile{Path: path}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		data, err = openSnapshot(data, keyring)
+	}
+	if err != nil {
+		file.Err = err
+		return file
+	}
+	file.Offsets = decodeSnapshot(data)
+	if len(data)%8 != 0 {
+		file.Err = fmt.Errorf("partial entry of %d bytes at the end of the file", len(data)%8)
+	}
+	return file
+}
+
+// sealSnapshot encrypts a snapshot with the active key of the keyring, if any,
+// prefixing it with a header recording the key ID.
+func sealSnapshot(snapshot []byte, keyring *encryption.Keyring) ([]byte, error) {
+	if keyring == nil {
+		return snapshot, nil
+	}
+	keyId := keyring.ActiveKeyId()
+	sealed, err := keyring.Encrypt(keyId, snapshot, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(encryption.EncodeHeader(keyId), sealed...), nil
+}
+
+// openSnapshot decrypts the content of an index file written by sealSnapshot.
+// Files without an encryption header are returned as they are.
+func openSnapshot(data []byte, keyring *encryption.Keyring) ([]byte, error) {
+	keyId, size, ok := encryption.DecodeHeader(data)
+	if !ok {
+		return data, nil
+	}
+	if keyring == nil || !keyring.HasKey(keyId) {
+		return nil, fmt.Errorf("encrypted with key %s which is not in the keyring", keyId)
+	}
+	return keyring.Decrypt(keyId, data[size:], nil)
 }
 
 func RestoreConsumerIndex(config *config.Config) (*ConsumerIndex, error) {
@@ -138,7 +224,7 @@ func RestoreConsumerIndex(config *config.Config) (*ConsumerIndex, error) {
 			return nil, err
 		}
 	}
-	indexes, err := restoreIndexesFromFile(lastIndexFile)
+	indexes, err := restoreIndexesFromFile(lastIndexFile, config.Keyring())
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +235,7 @@ func RestoreConsumerIndex(config *config.Config) (*ConsumerIndex, error) {
 		mu:      &sync.RWMutex{},
 		config:  config,
 	}
-	go c.schedulePersist()
+	c.schedulePersist()
 	return c, nil
 }
 
@@ -172,13 +258,28 @@ func (ci *ConsumerIndex) WriteIndex(consumerId, index int) {
 	ci.indexes[consumerId] = index
 }
 
+// AdvanceIndex updates the index for a given consumer ID when index is past its current one,
+// so a consumer never moves backwards. It reports whether the index was updated.
+func (ci *ConsumerIndex) AdvanceIndex(consumerId, index int) bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if current, exists := ci.indexes[consumerId]; exists && current >= index {
+		return false
+	}
+	ci.indexes[consumerId] = index
+	return true
+}
+
 // ReadIndex retrieves the index for a given consumer ID.
-// It uses a read lock to ensure thread safety while accessing the index.
-// If the consumer ID does not exist in the index, it initializes it to -1 and returns -1.
-// The consumer of this function should increment the index and read record at that index.
+// If the consumer ID does not exist in the index, it registers the consumer at -1 and returns -1,
+// under the write lock. The consumer of this function should increment the index and read record at that index.
 func (ci *ConsumerIndex) ReadIndex(consumerId int) int {
-	ci.mu.RLock()
-	defer ci.mu.RUnlock()
+	if index, exists := ci.LookupIndex(consumerId); exists {
+		return index
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
 
 	index, exists := ci.indexes[consumerId]
 	if !exists {
@@ -188,15 +289,38 @@ func (ci *ConsumerIndex) ReadIndex(consumerId int) int {
 	return index
 }
 
+// LookupIndex returns the index of a consumer and whether the consumer is known, without registering it.
+func (ci *ConsumerIndex) LookupIndex(consumerId int) (int, bool) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	index, exists := ci.indexes[consumerId]
+	if !exists {
+		return -1, false
+	}
+	return index, true
+}
+
+// Offsets returns a copy of the last message ID delivered to every consumer.
+func (ci *ConsumerIndex) Offsets() map[int]int {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	return maps.Clone(ci.indexes)
+}
+
 // Sync synchronizes the consumer index with the underlying storage.
-// It creates a snapshot of the current consumer index and writes it to the index file.
+// It creates a snapshot of the current consumer index and writes it to the index file,
+// encrypted when a keyring is configured.
 // It uses a write lock to ensure thread safety while accessing the index.
 // If an error occurs during the write operation, it returns the error.
 // Otherwise, it updates the writer to point to the new index file.
 // The old index file is not deleted, but it can be managed separately if needed.
 // This function is typically called periodically to ensure that the consumer index is up to date.
 func (ci *ConsumerIndex) Sync() error {
-	snapshot := ci.CreateSnapshot()
+	snapshot, err := sealSnapshot(ci.CreateSnapshot(), ci.config.Keyring())
+	if err != nil {
+		return err
+	}
 	newWriter, err := createIndexFile(ci.config)
 	if err != nil {
 		return err
@@ -232,8 +356,38 @@ func (ci *ConsumerIndex) CreateSnapshot() []byte {
 	return buf
 }
 
+// Close stops the periodic persistence and persists the offsets one last time.
 func (ci *ConsumerIndex) Close() error {
-	return ci.Persist()
+	if ci.stopPersist != nil {
+		ci.stopPersist()
+	}
+	if err := ci.Persist(); err != nil {
+		return err
+	}
+	return ci.writer.Close()
+}
+
+// SnapshotTo writes the current offsets to a new offsets file in the metadata directory of target,
+// from which RestoreConsumerIndex restores them, and returns the number of consumers.
+func (ci *ConsumerIndex) SnapshotTo(target *config.Config) (int, error) {
+	snapshot := ci.CreateSnapshot()
+	consumers := len(snapshot) / 8
+	sealed, err := sealSnapshot(snapshot, ci.config.Keyring())
+	if err != nil {
+		return 0, err
+	}
+	indexFile, err := createIndexFile(target)
+	if err != nil {
+		return 0, err
+	}
+	_, err = indexFile.Write(sealed)
+	if err == nil {
+		err = indexFile.Sync()
+	}
+	if closeErr := indexFile.Close(); err == nil {
+		err = closeErr
+	}
+	return consumers, err
 }
 
 func removeOldIndexFiles(config *config.Config, currentIndexFile string) error {
@@ -247,32 +401,46 @@ func removeOldIndexFiles(config *config.Config, currentIndexFile string) error {
 	})
 
 	for _, entry := range consumerIndexes {
-		dir, _ := os.Getwd()
-
-		fmt.Printf("Current working dir: %s\n", dir)
 		err := os.Remove(fmt.Sprintf("%s/%s", config.MetadataPath, entry.Name()))
 		if err != nil {
 			return err
 		}
+		config.Logger().Debug("removed old consumer offsets file", "file", entry.Name())
 	}
 	return nil
 }
 
+// Persist writes the offsets to a new index file and removes the older ones.
 func (ci *ConsumerIndex) Persist() error {
-	snapshot := ci.CreateSnapshot()
-	indexFile, err := createIndexFile(ci.config)
+	start := time.Now()
+	err := ci.persist()
+	ci.config.Metrics().Persisted(time.Since(start), err)
+	if err == nil {
+		ci.config.Logger().Debug("persisted consumer offsets", "duration", time.Since(start))
+	}
+	return err
+}
+
+func (ci *ConsumerIndex) persist() error {
+	snapshot, err := sealSnapshot(ci.CreateSnapshot(), ci.config.Keyring())
 	if err != nil {
 		return err
 	}
-	_, err = indexFile.Write(snapshot)
-	err = indexFile.Sync()
+	indexFile, err := createIndexFile(ci.config)
 	if err != nil {
 		return err
 	}
-	ci.writer = indexFile
-	err = removeOldIndexFiles(ci.config, indexFile.Name())
-	if err != nil {
+	if _, err = indexFile.Write(snapshot); err != nil {
+		indexFile.Close()
 		return err
 	}
-	return err
+	if err = indexFile.Sync(); err != nil {
+		indexFile.Close()
+		return err
+	}
+	if err := ci.writer.Close(); err != nil {
+		ci.config.Logger().Warn("failed to close the previous consumer offsets file", "file", ci.writer.Name(), "error", err)
+	}
+	ci.writer = indexFile
+	return removeOldIndexFiles(ci.config, indexFile.Name())
 }
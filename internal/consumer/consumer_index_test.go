@@ -2,6 +2,8 @@ package consumer
 
 import (
 	"ashishkujoy/queue/internal/config"
+	"ashishkujoy/queue/internal/encryption"
+	"bytes"
 	"os"
 	"testing"
 	"time"
@@ -37,6 +39,42 @@ func TestReadAndWriteIndex(t *testing.T) {
 	assert.Equal(t, -1, index.ReadIndex(4))
 }
 
+func TestLookupIndexDoesNotRegisterUnknownConsumers(t *testing.T) {
+	metadataDir, err := CreateMetadataDir("Lookup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(metadataDir)
+
+	cfg := config.NewConfig("/tmp", metadataDir, 1234, time.Second*100)
+	index, err := NewConsumerIndex(cfg)
+	assert.NoError(t, err)
+	index.WriteIndex(1, 10)
+
+	offset, exists := index.LookupIndex(1)
+	assert.True(t, exists)
+	assert.Equal(t, 10, offset)
+	offset, exists = index.LookupIndex(2)
+	assert.False(t, exists)
+	assert.Equal(t, -1, offset)
+	assert.Equal(t, map[int]int{1: 10}, index.Offsets())
+}
+
+func TestAdvanceIndexNeverMovesBackwards(t *testing.T) {
+	metadataDir, err := CreateMetadataDir("TestAdvanceIndexNeverMovesBackwards")
+	assert.NoError(t, err)
+	defer os.RemoveAll(metadataDir)
+
+	cfg := config.NewConfig("/tmp", metadataDir, 1234, time.Second*100)
+	index, err := NewConsumerIndex(cfg)
+	assert.NoError(t, err)
+
+	assert.True(t, index.AdvanceIndex(1, 10))
+	assert.False(t, index.AdvanceIndex(1, 5))
+	assert.False(t, index.AdvanceIndex(1, 10))
+	assert.Equal(t, 10, index.ReadIndex(1))
+	assert.True(t, index.AdvanceIndex(1, 12))
+	assert.Equal(t, 12, index.ReadIndex(1))
+}
+
 func TestReadFromARestoredConsumerIndex(t *testing.T) {
 	metadataDir, err := CreateMetadataDir("TestReadFromARestoredConsumerIndex")
 	assert.NoError(t, err)
@@ -49,6 +87,7 @@ func TestReadFromARestoredConsumerIndex(t *testing.T) {
 	index.WriteIndex(11, 10)
 	index.WriteIndex(12, 20)
 	index.WriteIndex(13, 30)
+	index.WriteIndex(15, -1)
 
 	assert.NoError(t, index.Close())
 
@@ -59,6 +98,7 @@ func TestReadFromARestoredConsumerIndex(t *testing.T) {
 	assert.Equal(t, 20, restoredIndex.ReadIndex(12))
 	assert.Equal(t, 30, restoredIndex.ReadIndex(13))
 	assert.Equal(t, -1, restoredIndex.ReadIndex(14))
+	assert.Equal(t, -1, restoredIndex.ReadIndex(15))
 }
 
 func TestRestoreIndexUsesTheLatestSnapshot(t *testing.T) {
@@ -93,3 +133,73 @@ func TestRestoreIndexUsesTheLatestSnapshot(t *testing.T) {
 	assert.Equal(t, 30, restoredIndex.ReadIndex(13))
 	assert.Equal(t, 1300, restoredIndex.ReadIndex(14))
 }
+
+func TestRestoreEncryptedConsumerIndex(t *testing.T) {
+	metadataDir, err := CreateMetadataDir("TestRestoreEncryptedConsumerIndex")
+	assert.NoError(t, err)
+	defer os.RemoveAll(metadataDir)
+
+	keyring := encryption.NewKeyring()
+	assert.NoError(t, keyring.Add("k1", bytes.Repeat([]byte{1}, 32)))
+	cfg := config.NewConfig("/tmp", metadataDir, 1234, time.Second*1000).WithKeyring(keyring)
+	index, err := NewConsumerIndex(cfg)
+	assert.NoError(t, err)
+
+	index.WriteIndex(11, 10)
+	assert.NoError(t, index.Close())
+
+	_, err = RestoreConsumerIndex(config.NewConfig("/tmp", metadataDir, 1234, time.Second*1000))
+	assert.Error(t, err)
+
+	restoredIndex, err := RestoreConsumerIndex(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, restoredIndex.ReadIndex(11))
+}
+
+func TestCloseStopsPeriodicPersistence(t *testing.T) {
+	metadataDir, err := CreateMetadataDir("TestCloseStopsPeriodicPersistence")
+	assert.NoError(t, err)
+	defer os.RemoveAll(metadataDir)
+
+	cfg := config.NewConfig("/tmp", metadataDir, 1234, time.Millisecond*10)
+	index, err := RestoreConsumerIndex(cfg)
+	assert.NoError(t, err)
+	index.WriteIndex(11, 10)
+	assert.NoError(t, index.Close())
+
+	before, err := os.ReadDir(metadataDir)
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 50)
+	after, err := os.ReadDir(metadataDir)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	restoredIndex, err := RestoreConsumerIndex(cfg)
+	assert.NoError(t, err)
+	defer restoredIndex.Close()
+	assert.Equal(t, 10, restoredIndex.ReadIndex(11))
+}
+
+func TestReadOffsetsFilesNewestFirst(t *testing.T) {
+	metadataDir, err := CreateMetadataDir("ReadOffsetsFiles")
+	assert.NoError(t, err)
+	defer os.RemoveAll(metadataDir)
+	cfg := config.NewConfig("/tmp", metadataDir, 1234, time.Second*100)
+
+	index, err := NewConsumerIndex(cfg)
+	assert.NoError(t, err)
+	index.WriteIndex(1, 10)
+	stale := append(index.CreateSnapshot(), 1, 2)
+	index.WriteIndex(2, 20)
+	assert.NoError(t, index.Close())
+	assert.NoError(t, os.WriteFile(metadataDir+"/consumer_index_1", stale, 0644))
+
+	files, err := ReadOffsetsFiles(cfg)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.NoError(t, files[0].Err)
+	assert.Equal(t, map[int]int{1: 10, 2: 20}, files[0].Offsets)
+	assert.Equal(t, metadataDir+"/consumer_index_1", files[1].Path)
+	assert.ErrorContains(t, files[1].Err, "partial entry")
+	assert.Equal(t, map[int]int{1: 10}, files[1].Offsets)
+}
@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeysEnvVar is the environment variable keys are read from when no keyfile is configured.
+// It holds the same content as a keyfile, entries may also be separated by commas.
+const KeysEnvVar = "QUEUE_ENCRYPTION_KEYS"
+
+// Keyring holds the AES keys used to encrypt data at rest, by key ID.
+// New files are encrypted with the active key, which is the key added last.
+// Older keys are kept so files written before a key rotation stay readable.
+type Keyring struct {
+	keys     map[string]cipher.AEAD
+	activeId string
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]cipher.AEAD)}
+}
+
+// Add adds an AES-128, AES-192 or AES-256 key to the keyring and makes it the active key.
+func (k *Keyring) Add(keyId string, key []byte) error {
+	if keyId == "" || len(keyId) > 255 || strings.ContainsAny(keyId, " \t,") {
+		return fmt.Errorf("invalid key id %q", keyId)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("key %s: %w", keyId, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("key %s: %w", keyId, err)
+	}
+	k.keys[keyId] = aead
+	k.activeId = keyId
+	return nil
+}
+
+// ActiveKeyId returns the ID of the key new files are encrypted with.
+func (k *Keyring) ActiveKeyId() string {
+	return k.activeId
+}
+
+// HasKey reports whether the keyring holds the key with the given ID.
+func (k *Keyring) HasKey(keyId string) bool {
+	_, ok := k.keys[keyId]
+	return ok
+}
+
+// Encrypt seals the plaintext with the given key using AES-GCM.
+// The additional data is authenticated but not stored, the same value must be passed to Decrypt.
+// The returned ciphertext is prefixed with its random nonce.
+func (k *Keyring) Encrypt(keyId string, plaintext, additionalData []byte) ([]byte, error) {
+	aead, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", keyId)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt with the same key and additional data.
+func (k *Keyring) Decrypt(keyId string, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", keyId)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], additionalData)
+}
+
+// LoadKeyring loads the keys from the given keyfile, or from the KeysEnvVar
+// environment variable when keyFile is empty.
+// It returns a nil keyring when no keys are configured, meaning data is stored unencrypted.
+//
+// Each entry is a key ID followed by the base64 encoded key, separated by whitespace.
+// Entries are separated by newlines (or commas), lines starting with # are ignored.
+// The last entry is the active key, so a key is rotated by appending a new entry.
+func LoadKeyring(keyFile string) (*Keyring, error) {
+	var content string
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		content = string(data)
+	} else {
+		content = os.Getenv(KeysEnvVar)
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil, nil
+	}
+	return ParseKeys(content)
+}
+
+// ParseKeys parses keys in the keyfile format described by LoadKeyring.
+func ParseKeys(content string) (*Keyring, error) {
+	keyring := NewKeyring()
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(content, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid key entry %q, expected <key-id> <base64-key>", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", fields[0], err)
+		}
+		if err := keyring.Add(fields[0], key); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if keyring.activeId == "" {
+		return nil, fmt.Errorf("no encryption keys found")
+	}
+	return keyring, nil
+}
+
+// headerMagic starts the header of every file encrypted at rest.
+const headerMagic = "QENC"
+
+// EncodeHeader returns the header identifying a file encrypted with the given key.
+func EncodeHeader(keyId string) []byte {
+	header := make([]byte, 0, len(headerMagic)+1+len(keyId))
+	header = append(header, headerMagic...)
+	header = append(header, byte(len(keyId)))
+	return append(header, keyId...)
+}
+
+// DecodeHeader returns the key ID recorded in an encryption header at the start of data,
+// along with the size of the header. ok is false when data does not start with a header,
+// i.e. the file is not encrypted.
+func DecodeHeader(data []byte) (keyId string, size int, ok bool) {
+	if len(data) < len(headerMagic)+1 || !bytes.HasPrefix(data, []byte(headerMagic)) {
+		return "", 0, false
+	}
+	idLen := int(data[len(headerMagic)])
+	size = len(headerMagic) + 1 + idLen
+	if len(data) < size {
+		return "", 0, false
+	}
+	return string(data[len(headerMagic)+1 : size]), size, true
+}
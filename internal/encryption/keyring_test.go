@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncryptAndDecrypt(t *testing.T) {
+	keyring, err := ParseKeys("k1 " + key(1))
+	assert.NoError(t, err)
+
+	ciphertext, err := keyring.Encrypt("k1", []byte("Hello World"), []byte("offset"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "Hello World")
+
+	plaintext, err := keyring.Decrypt("k1", ciphertext, []byte("offset"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello World"), plaintext)
+
+	_, err = keyring.Decrypt("k1", ciphertext, []byte("another offset"))
+	assert.Error(t, err)
+}
+
+func TestLastKeyIsActive(t *testing.T) {
+	keyring, err := ParseKeys("# rotated on 2026-10-01\nk1 " + key(1) + "\nk2 " + key(2) + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", keyring.ActiveKeyId())
+	assert.True(t, keyring.HasKey("k1"))
+
+	ciphertext, err := keyring.Encrypt("k1", []byte("Hello World"), nil)
+	assert.NoError(t, err)
+	_, err = keyring.Decrypt("k2", ciphertext, nil)
+	assert.Error(t, err)
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	t.Setenv(KeysEnvVar, "k1 "+key(1)+",k2 "+key(2))
+	keyring, err := LoadKeyring("")
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", keyring.ActiveKeyId())
+
+	t.Setenv(KeysEnvVar, "")
+	keyring, err = LoadKeyring("")
+	assert.NoError(t, err)
+	assert.Nil(t, keyring)
+}
+
+func TestLoadKeyringFromFile(t *testing.T) {
+	keyFile := os.TempDir() + "/TestLoadKeyringFromFile"
+	defer os.Remove(keyFile)
+	assert.NoError(t, os.WriteFile(keyFile, []byte("k1 "+key(1)+"\n"), 0600))
+
+	keyring, err := LoadKeyring(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", keyring.ActiveKeyId())
+}
+
+func TestRejectInvalidKeys(t *testing.T) {
+	_, err := ParseKeys("k1 " + base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+
+	_, err = ParseKeys("k1")
+	assert.Error(t, err)
+}
+
+func TestHeader(t *testing.T) {
+	header := EncodeHeader("k1")
+	keyId, size, ok := DecodeHeader(append(header, "payload"...))
+	assert.True(t, ok)
+	assert.Equal(t, "k1", keyId)
+	assert.Equal(t, len(header), size)
+
+	_, _, ok = DecodeHeader([]byte("payload"))
+	assert.False(t, ok)
+}
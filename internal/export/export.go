@@ -0,0 +1,177 @@
+// Package export encodes messages into the portable formats queues are exported to and imported from.
+//
+// JSON Lines holds one object per message, with the key and message base64 encoded:
+//
+//	{"id":3,"key":"dXNlci0x","headers":{"traceparent":"..."},"message":"aGVsbG8="}
+//
+// The binary format starts with the magic "QEXP" and a version byte, followed by every message
+// prefixed with its length as 4 big endian bytes, laid out as in a segment: the 8 byte ID, the
+// key length, the key, the headers when there are any, and the message.
+package export
+
+import (
+	"ashishkujoy/queue/internal/storage"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Format is the encoding of an export.
+type Format string
+
+const (
+	FormatJSONL  Format = "jsonl"
+	FormatBinary Format = "binary"
+)
+
+const (
+	binaryMagic   = "QEXP"
+	binaryVersion = 1
+	// maxBinaryRecordSize bounds the records a binary reader accepts, guarding against corrupt length prefixes.
+	maxBinaryRecordSize = 1 << 30
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case FormatJSONL, FormatBinary:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unknown export format %q, expected jsonl or binary", name)
+}
+
+// Writer encodes messages into an export. Flush must be called once every message is written.
+type Writer interface {
+	Write(record storage.Record) error
+	Flush() error
+}
+
+// Reader decodes the messages of an export, Read returns io.EOF after the last one.
+type Reader interface {
+	Read() (storage.Record, error)
+}
+
+// jsonRecord is a message in JSON Lines, encoding/json base64 encodes the byte slices.
+type jsonRecord struct {
+	Id      int               `json:"id"`
+	Key     []byte            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Message []byte            `json:"message"`
+}
+
+// NewWriter returns a writer encoding messages in the given format to w.
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	buffered := bufio.NewWriter(w)
+	switch format {
+	case FormatJSONL:
+		return &jsonWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case FormatBinary:
+		if _, err := buffered.WriteString(binaryMagic); err != nil {
+			return nil, err
+		}
+		if err := buffered.WriteByte(binaryVersion); err != nil {
+			return nil, err
+		}
+		return &binaryWriter{buffered: buffered}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type jsonWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (w *jsonWriter) Write(record storage.Record) error {
+	return w.encoder.Encode(jsonRecord{Id: record.Id, Key: record.Key, Headers: record.Headers, Message: record.Data})
+}
+
+func (w *jsonWriter) Flush() error {
+	return w.buffered.Flush()
+}
+
+type binaryWriter struct {
+	buffered *bufio.Writer
+}
+
+func (w *binaryWriter) Write(record storage.Record) error {
+	data := record.Encode()
+	if _, err := w.buffered.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data)))); err != nil {
+		return err
+	}
+	_, err := w.buffered.Write(data)
+	return err
+}
+
+func (w *binaryWriter) Flush() error {
+	return w.buffered.Flush()
+}
+
+// NewReader returns a reader decoding the export read from r, whose format is told apart by its first bytes.
+func NewReader(r io.Reader) (Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(binaryMagic))
+	if err != nil || string(magic) != binaryMagic {
+		return &jsonReader{decoder: json.NewDecoder(buffered)}, nil
+	}
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		return nil, fmt.Errorf("export header: %w", err)
+	}
+	if version := header[len(binaryMagic)]; version != binaryVersion {
+		return nil, fmt.Errorf("unsupported export format version %d", version)
+	}
+	return &binaryReader{buffered: buffered}, nil
+}
+
+type jsonReader struct {
+	decoder *json.Decoder
+	line    int
+}
+
+func (r *jsonReader) Read() (storage.Record, error) {
+	var record jsonRecord
+	r.line++
+	if err := r.decoder.Decode(&record); err != nil {
+		if err == io.EOF {
+			return storage.Record{}, io.EOF
+		}
+		return storage.Record{}, fmt.Errorf("message %d of the export: %w", r.line, err)
+	}
+	return storage.Record{Id: record.Id, Key: record.Key, Headers: record.Headers, Data: record.Message}, nil
+}
+
+type binaryReader struct {
+	buffered *bufio.Reader
+	read     int
+}
+
+func (r *binaryReader) Read() (storage.Record, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r.buffered, size[:]); err != nil {
+		if err == io.EOF {
+			return storage.Record{}, io.EOF
+		}
+		return storage.Record{}, r.wrap(io.ErrUnexpectedEOF)
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length > maxBinaryRecordSize {
+		return storage.Record{}, r.wrap(fmt.Errorf("length prefix of %d bytes", length))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.buffered, data); err != nil {
+		return storage.Record{}, r.wrap(io.ErrUnexpectedEOF)
+	}
+	record := storage.Record{}
+	if err := record.Decode(data); err != nil {
+		return storage.Record{}, r.wrap(err)
+	}
+	r.read++
+	return record, nil
+}
+
+func (r *binaryReader) wrap(err error) error {
+	return fmt.Errorf("message %d of the export: %w", r.read+1, err)
+}
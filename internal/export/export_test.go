@@ -0,0 +1,84 @@
+package export
+
+import (
+	"ashishkujoy/queue/internal/storage"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var exportedRecords = []storage.Record{
+	{Id: 3, Data: []byte("one")},
+	{Id: 4, Key: []byte("user-1"), Headers: map[string]string{"traceparent": "00-abc"}, Data: []byte{0, 1, 0xff}},
+	{Id: 9, Key: []byte("user-1"), Data: []byte{}},
+}
+
+func readAll(t *testing.T, r io.Reader) []storage.Record {
+	reader, err := NewReader(r)
+	assert.NoError(t, err)
+	var records []storage.Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records
+		}
+		assert.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestRoundTripEveryFormat(t *testing.T) {
+	for _, format := range []Format{FormatJSONL, FormatBinary} {
+		var exported bytes.Buffer
+		writer, err := NewWriter(&exported, format)
+		assert.NoError(t, err)
+		for _, record := range exportedRecords {
+			assert.NoError(t, writer.Write(record))
+		}
+		assert.NoError(t, writer.Flush())
+
+		records := readAll(t, &exported)
+		assert.Len(t, records, len(exportedRecords), format)
+		for i, record := range records {
+			assert.Equal(t, exportedRecords[i].Id, record.Id, format)
+			assert.Equal(t, exportedRecords[i].Headers, record.Headers, format)
+			assert.Equal(t, string(exportedRecords[i].Key), string(record.Key), format)
+			assert.Equal(t, string(exportedRecords[i].Data), string(record.Data), format)
+		}
+	}
+}
+
+func TestJSONLinesArePortable(t *testing.T) {
+	var exported bytes.Buffer
+	writer, _ := NewWriter(&exported, FormatJSONL)
+	assert.NoError(t, writer.Write(exportedRecords[1]))
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, `{"id":4,"key":"dXNlci0x","headers":{"traceparent":"00-abc"},"message":"AAH/"}`+"\n", exported.String())
+
+	assert.Empty(t, readAll(t, bytes.NewReader(nil)))
+}
+
+func TestReadersReportCorruptExports(t *testing.T) {
+	reader, err := NewReader(bytes.NewReader([]byte("{\"id\":1}\n{\"id\":")))
+	assert.NoError(t, err)
+	_, err = reader.Read()
+	assert.NoError(t, err)
+	_, err = reader.Read()
+	assert.ErrorContains(t, err, "message 2 of the export")
+
+	var exported bytes.Buffer
+	writer, _ := NewWriter(&exported, FormatBinary)
+	assert.NoError(t, writer.Write(exportedRecords[0]))
+	assert.NoError(t, writer.Flush())
+	reader, err = NewReader(bytes.NewReader(exported.Bytes()[:exported.Len()-1]))
+	assert.NoError(t, err)
+	_, err = reader.Read()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = NewReader(bytes.NewReader([]byte("QEXP\x07")))
+	assert.ErrorContains(t, err, "unsupported export format version 7")
+	_, err = ParseFormat("csv")
+	assert.Error(t, err)
+}
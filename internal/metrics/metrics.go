@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes the name of every metric.
+const Namespace = "queue"
+
+// Metrics records what the server does as Prometheus metrics.
+// Every method is a no-op on a nil Metrics, so instrumented code does not check whether metrics are enabled.
+type Metrics struct {
+	registry         *prometheus.Registry
+	enqueued         prometheus.Counter
+	enqueueDuration  prometheus.Histogram
+	delivered        prometheus.Counter
+	deliveryDuration prometheus.Histogram
+	appendedBytes    prometheus.Counter
+	fsyncDuration    prometheus.Histogram
+	fsyncErrors      prometheus.Counter
+	persistDuration  prometheus.Histogram
+	persistErrors    prometheus.Counter
+}
+
+// New returns metrics registered on a registry of their own, along with the Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		enqueued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Name: "enqueued_messages_total",
+			Help: "Messages enqueued by producers.",
+		}),
+		enqueueDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace, Name: "enqueue_duration_seconds",
+			Help:    "Time taken to store an enqueued message, replication included.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		delivered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Name: "delivered_messages_total",
+			Help: "Messages sent to consumers.",
+		}),
+		deliveryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace, Name: "delivery_duration_seconds",
+			Help:    "Time taken to read a message and send it to a consumer.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		appendedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Name: "appended_bytes_total",
+			Help: "Bytes appended to segments, framing and compression included.",
+		}),
+		fsyncDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace, Name: "fsync_duration_seconds",
+			Help:    "Time taken to sync a segment to disk.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		fsyncErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Name: "fsync_errors_total",
+			Help: "Segment syncs that failed.",
+		}),
+		persistDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace, Name: "consumer_index_persist_duration_seconds",
+			Help:    "Time taken to persist the consumer offsets.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		persistErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Name: "consumer_index_persist_errors_total",
+			Help: "Consumer offset persists that failed.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.enqueued, m.enqueueDuration,
+		m.delivered, m.deliveryDuration,
+		m.appendedBytes,
+		m.fsyncDuration, m.fsyncErrors,
+		m.persistDuration, m.persistErrors,
+	)
+	return m
+}
+
+// Register adds a collector reporting state read at scrape time, such as sizes and lags.
+func (m *Metrics) Register(collector prometheus.Collector) error {
+	if m == nil {
+		return nil
+	}
+	return m.registry.Register(collector)
+}
+
+// Unregister removes a collector added with Register.
+func (m *Metrics) Unregister(collector prometheus.Collector) {
+	if m == nil {
+		return
+	}
+	m.registry.Unregister(collector)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Enqueued records a message stored for a producer in duration.
+func (m *Metrics) Enqueued(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.enqueued.Inc()
+	m.enqueueDuration.Observe(duration.Seconds())
+}
+
+// Delivered records a message sent to a consumer in duration.
+func (m *Metrics) Delivered(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.delivered.Inc()
+	m.deliveryDuration.Observe(duration.Seconds())
+}
+
+// Appended records bytes written to a segment.
+func (m *Metrics) Appended(bytes int) {
+	if m == nil {
+		return
+	}
+	m.appendedBytes.Add(float64(bytes))
+}
+
+// Fsynced records a segment sync that took duration and failed with err, if not nil.
+func (m *Metrics) Fsynced(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.fsyncDuration.Observe(duration.Seconds())
+	if err != nil {
+		m.fsyncErrors.Inc()
+	}
+}
+
+// Persisted records a persist of the consumer offsets that took duration and failed with err, if not nil.
+func (m *Metrics) Persisted(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.persistDuration.Observe(duration.Seconds())
+	if err != nil {
+		m.persistErrors.Inc()
+	}
+}
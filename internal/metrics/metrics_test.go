@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *Metrics
+	m.Enqueued(time.Millisecond)
+	m.Delivered(time.Millisecond)
+	m.Appended(10)
+	m.Fsynced(time.Millisecond, errors.New("disk full"))
+	m.Persisted(time.Millisecond, nil)
+	assert.NoError(t, m.Register(prometheus.NewGauge(prometheus.GaugeOpts{Name: "unused"})))
+}
+
+func TestMetricsCountEventsAndErrors(t *testing.T) {
+	m := New()
+	m.Enqueued(time.Millisecond)
+	m.Enqueued(2 * time.Millisecond)
+	m.Delivered(time.Millisecond)
+	m.Appended(100)
+	m.Appended(28)
+	m.Fsynced(time.Millisecond, nil)
+	m.Fsynced(time.Millisecond, errors.New("disk full"))
+	m.Persisted(time.Millisecond, errors.New("disk full"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.enqueued))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.delivered))
+	assert.Equal(t, 128.0, testutil.ToFloat64(m.appendedBytes))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.fsyncErrors))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.persistErrors))
+}
+
+func TestHandlerServesRegisteredCollectors(t *testing.T) {
+	m := New()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: Namespace, Name: "segments", Help: "Segments."})
+	gauge.Set(3)
+	assert.NoError(t, m.Register(gauge))
+	m.Enqueued(time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	assert.Contains(t, string(body), "queue_segments 3")
+	assert.Contains(t, string(body), "queue_enqueued_messages_total 1")
+	assert.Contains(t, string(body), "queue_enqueue_duration_seconds_count 1")
+	assert.Contains(t, string(body), "go_goroutines")
+}
@@ -0,0 +1,194 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/cluster"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"errors"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer serves the AdminService of a queue server.
+type adminServer struct {
+	netinternal.UnimplementedAdminServiceServer
+	qs *QueueServer
+}
+
+func (a *adminServer) DescribeQueue(context.Context, *netinternal.DescribeQueueRequest) (*netinternal.QueueDescription, error) {
+	description := a.qs.queueService.Queue().Describe()
+	message := &netinternal.QueueDescription{
+		Name:       a.qs.config.QueueName(),
+		HeadId:     int64(description.HeadId),
+		TailId:     int64(description.TailId),
+		NextId:     uint64(description.NextId),
+		Messages:   uint64(description.Messages),
+		TotalBytes: uint64(description.SizeInBytes),
+	}
+	for _, segment := range description.Segments {
+		message.Segments = append(message.Segments, &netinternal.SegmentDescription{
+			Id:          uint64(segment.Id),
+			SizeInBytes: uint64(segment.SizeInBytes),
+			Messages:    uint64(segment.Messages),
+			FirstId:     int64(segment.FirstId),
+			LastId:      int64(segment.LastId),
+			Local:       segment.Local,
+			Active:      segment.Active,
+		})
+	}
+	return message, nil
+}
+
+func (a *adminServer) ListConsumers(context.Context, *netinternal.ListConsumersRequest) (*netinternal.ConsumerList, error) {
+	online := a.qs.onlineConsumerIds()
+	list := &netinternal.ConsumerList{}
+	for _, consumer := range a.qs.queueService.Consumers() {
+		list.Consumers = append(list.Consumers, consumerToProto(consumer, online))
+	}
+	return list, nil
+}
+
+// ResetConsumer moves a known consumer, through the Raft log in clustered mode. Its open
+// streams continue from the new position, which may replay messages already delivered.
+func (a *adminServer) ResetConsumer(ctx context.Context, req *netinternal.ResetConsumerRequest) (*netinternal.ConsumerDescription, error) {
+	if address, ok := a.qs.consumerLeaderAddress(); !ok {
+		return nil, notLeader(address, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+	}
+	if !a.qs.queueService.HasConsumer(int(req.ConsumerId)) {
+		return nil, status.Errorf(codes.NotFound, "unknown consumer %d", req.ConsumerId)
+	}
+	queue := a.qs.queueService.Queue()
+	messageId := int(req.MessageId)
+	switch req.Position {
+	case netinternal.ResetConsumerRequest_EARLIEST:
+		if messageId = queue.Describe().HeadId; messageId < 0 {
+			messageId = queue.NextId()
+		}
+	case netinternal.ResetConsumerRequest_LATEST:
+		messageId = queue.NextId()
+	default:
+		if messageId > queue.NextId() {
+			return nil, status.Errorf(codes.InvalidArgument, "message %d is past the next message id %d", messageId, queue.NextId())
+		}
+	}
+	err := a.qs.resetConsumer(req.ConsumerId, messageId)
+	if errors.Is(err, cluster.ErrNotLeader) {
+		address, _ := a.qs.consumerLeaderAddress()
+		return nil, notLeader(address, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset consumer %d: %v", req.ConsumerId, err)
+	}
+	a.qs.config.Logger().Info("reset consumer", "consumer", req.ConsumerId, "message", messageId, "client", clientIdentity(ctx))
+	consumer := queueinternal.ConsumerState{
+		Id:     int(req.ConsumerId),
+		Offset: a.qs.queueService.ConsumerOffset(int(req.ConsumerId)),
+	}
+	consumer.Lag = max(queue.NextId()-consumer.Offset-1, 0)
+	return consumerToProto(consumer, a.qs.onlineConsumerIds()), nil
+}
+
+// PurgeQueue drops every message of a standalone server or a replication leader.
+// Followers of a leader keep their copy of the purged messages. Cluster members and
+// followers reject purges, which would make them diverge from the rest of the cluster.
+func (a *adminServer) PurgeQueue(ctx context.Context, _ *netinternal.PurgeQueueRequest) (*netinternal.PurgeQueueResponse, error) {
+	if a.qs.node != nil || a.qs.follower != nil {
+		return nil, status.Error(codes.FailedPrecondition, "purging is not supported by cluster members and followers")
+	}
+	dropped, err := a.qs.queueService.Purge()
+	if err != nil {
+		a.qs.config.Logger().Error("failed to purge", "client", clientIdentity(ctx), "error", err)
+		return nil, status.Errorf(codes.Internal, "failed to purge: %v", err)
+	}
+	a.qs.consumerProgress.notify()
+	a.qs.config.Logger().Info("purged queue", "dropped", dropped, "client", clientIdentity(ctx))
+	return &netinternal.PurgeQueueResponse{Dropped: uint64(dropped), NextId: uint64(a.qs.queueService.Queue().NextId())}, nil
+}
+
+// Snapshot writes a copy of the queue and the consumer offsets to a directory of the server,
+// without stopping it. Followers and cluster members snapshot their own copy of the queue.
+func (a *adminServer) Snapshot(ctx context.Context, req *netinternal.SnapshotRequest) (*netinternal.SnapshotResponse, error) {
+	if req.Directory == "" {
+		return nil, status.Error(codes.InvalidArgument, "a snapshot directory is required")
+	}
+	snapshot, err := a.qs.queueService.Snapshot(req.Directory)
+	if errors.Is(err, queueinternal.ErrSnapshotDirNotEmpty) {
+		return nil, status.Errorf(codes.AlreadyExists, "%s: %v", req.Directory, err)
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, status.Errorf(codes.FailedPrecondition, "the %s storage backend cannot be snapshotted", a.qs.config.StorageBackend())
+	}
+	if err != nil {
+		a.qs.config.Logger().Error("failed to snapshot", "directory", req.Directory, "client", clientIdentity(ctx), "error", err)
+		return nil, status.Errorf(codes.Internal, "failed to snapshot: %v", err)
+	}
+	a.qs.config.Logger().Info("snapshotted queue", "directory", req.Directory, "segments", snapshot.Segments,
+		"size", snapshot.SizeInBytes, "next_id", snapshot.NextId, "client", clientIdentity(ctx))
+	return &netinternal.SnapshotResponse{
+		Directory:   req.Directory,
+		Segments:    uint64(snapshot.Segments),
+		SizeInBytes: uint64(snapshot.SizeInBytes),
+		NextId:      uint64(snapshot.NextId),
+		Consumers:   uint64(snapshot.Consumers),
+	}, nil
+}
+
+// resetConsumer moves a consumer and repositions the streams it has open,
+// which deliver from the new position right away.
+func (qs *QueueServer) resetConsumer(consumerId uint64, messageId int) error {
+	qs.mu.RLock()
+	var consumers []*OnlineConsumer
+	for _, consumer := range qs.onlineConsumer {
+		if consumer.id == consumerId {
+			consumers = append(consumers, consumer)
+		}
+	}
+	qs.mu.RUnlock()
+	for _, consumer := range consumers {
+		consumer.mu.Lock()
+	}
+	defer func() {
+		for _, consumer := range consumers {
+			consumer.mu.Unlock()
+		}
+		qs.consumerProgress.notify()
+		qs.broadcastInBackground()
+	}()
+
+	if qs.node != nil {
+		if err := qs.node.ResetConsumer(int(consumerId), messageId); err != nil {
+			return err
+		}
+	} else {
+		qs.queueService.ResetConsumer(int(consumerId), messageId)
+	}
+	for _, consumer := range consumers {
+		consumer.iterator = qs.queueService.NewConsumerIterator(int(consumerId))
+	}
+	return nil
+}
+
+// onlineConsumerIds returns the IDs of the consumers observing the queue.
+func (qs *QueueServer) onlineConsumerIds() []uint64 {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	ids := make([]uint64, 0, len(qs.onlineConsumer))
+	for _, consumer := range qs.onlineConsumer {
+		ids = append(ids, consumer.id)
+	}
+	return ids
+}
+
+func consumerToProto(consumer queueinternal.ConsumerState, online []uint64) *netinternal.ConsumerDescription {
+	return &netinternal.ConsumerDescription{
+		ConsumerId: uint64(consumer.Id),
+		Offset:     int64(consumer.Offset),
+		Lag:        uint64(consumer.Lag),
+		Online:     slices.Contains(online, uint64(consumer.Id)),
+	}
+}
@@ -0,0 +1,139 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/config"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startAdmin serves a queue server and returns a queue client and an admin client connected to it.
+func startAdmin(t *testing.T, name string) (*QueueServer, netinternal.QueueServiceClient, netinternal.AdminServiceClient) {
+	server, err := NewQueueServer(newTestConfig(t, name))
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return server, netinternal.NewQueueServiceClient(conn), netinternal.NewAdminServiceClient(conn)
+}
+
+func TestDescribeQueueAndListConsumers(t *testing.T) {
+	server, client, admin := startAdmin(t, "ServerTestAdminDescribe")
+	for _, message := range []string{"one", "two", "three"} {
+		assert.NoError(t, enqueueMessage(client, message))
+	}
+	consumeAndLeave(t, server, client, 1, "one", "two", "three")
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 2})
+	assert.NoError(t, err)
+	received, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(received.Message))
+
+	description, err := admin.DescribeQueue(context.Background(), &netinternal.DescribeQueueRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), description.HeadId)
+	assert.Equal(t, int64(2), description.TailId)
+	assert.Equal(t, uint64(3), description.NextId)
+	assert.Equal(t, uint64(3), description.Messages)
+	assert.NotEmpty(t, description.Segments)
+	assert.True(t, description.Segments[len(description.Segments)-1].Active)
+
+	assert.Eventually(t, func() bool {
+		list, err := admin.ListConsumers(context.Background(), &netinternal.ListConsumersRequest{})
+		return err == nil && len(list.Consumers) == 2 &&
+			list.Consumers[0].Offset == 2 && list.Consumers[0].Lag == 0 && !list.Consumers[0].Online &&
+			list.Consumers[1].Offset == 2 && list.Consumers[1].Online
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestResetConsumerReplaysMessagesToItsStream(t *testing.T) {
+	_, client, admin := startAdmin(t, "ServerTestAdminReset")
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1})
+	assert.NoError(t, err)
+	for _, message := range []string{"one", "two", "three"} {
+		assert.NoError(t, enqueueMessage(client, message))
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(received.Message))
+	}
+
+	consumer, err := admin.ResetConsumer(context.Background(), &netinternal.ResetConsumerRequest{ConsumerId: 1, MessageId: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), consumer.Offset)
+	assert.Equal(t, uint64(2), consumer.Lag)
+	for _, message := range []string{"two", "three"} {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(received.Message))
+	}
+
+	consumer, err = admin.ResetConsumer(context.Background(), &netinternal.ResetConsumerRequest{ConsumerId: 1, Position: netinternal.ResetConsumerRequest_LATEST})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), consumer.Offset)
+	_, err = admin.ResetConsumer(context.Background(), &netinternal.ResetConsumerRequest{ConsumerId: 1, MessageId: 10})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = admin.ResetConsumer(context.Background(), &netinternal.ResetConsumerRequest{ConsumerId: 3, Position: netinternal.ResetConsumerRequest_LATEST})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	consumers, err := admin.ListConsumers(context.Background(), &netinternal.ListConsumersRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, consumers.Consumers, 1)
+}
+
+func TestPurgeQueueDropsEveryMessage(t *testing.T) {
+	server, client, admin := startAdmin(t, "ServerTestAdminPurge")
+	for _, message := range []string{"one", "two"} {
+		assert.NoError(t, enqueueMessage(client, message))
+	}
+	consumeAndLeave(t, server, client, 1, "one")
+
+	response, err := admin.PurgeQueue(context.Background(), &netinternal.PurgeQueueRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), response.Dropped)
+	assert.Equal(t, uint64(2), response.NextId)
+
+	assert.NoError(t, enqueueMessage(client, "three"))
+	consumeAndLeave(t, server, client, 1, "three")
+	description, err := admin.DescribeQueue(context.Background(), &netinternal.DescribeQueueRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), description.HeadId)
+	assert.Equal(t, uint64(1), description.Messages)
+}
+
+func TestSnapshotIsRestoredByANewServer(t *testing.T) {
+	server, client, admin := startAdmin(t, "ServerTestAdminSnapshot")
+	assert.NoError(t, enqueueMessage(client, "one"))
+	consumeAndLeave(t, server, client, 1, "one")
+	assert.NoError(t, enqueueMessage(client, "two"))
+
+	dir := filepath.Join(t.TempDir(), "snapshot")
+	snapshot, err := admin.Snapshot(context.Background(), &netinternal.SnapshotRequest{Directory: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), snapshot.NextId)
+	assert.Equal(t, uint64(1), snapshot.Consumers)
+	assert.NoError(t, enqueueMessage(client, "three"))
+	_, err = admin.Snapshot(context.Background(), &netinternal.SnapshotRequest{Directory: dir})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	cfg := config.NewConfig(filepath.Join(dir, queueinternal.SnapshotSegmentsDir), filepath.Join(dir, queueinternal.SnapshotMetadataDir), 1024, time.Hour)
+	restored, restoredClient := startServer(t, cfg)
+	assert.Equal(t, 0, restored.queueService.ConsumerOffset(1))
+	fetched, err := restoredClient.Fetch(context.Background(), &netinternal.FetchRequest{MaxMessages: 10})
+	assert.NoError(t, err)
+	assert.Len(t, fetched.Messages, 2)
+	assert.Equal(t, uint64(2), fetched.NextId)
+}
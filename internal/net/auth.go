@@ -0,0 +1,151 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/auth"
+	"ashishkujoy/queue/internal/config"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// methodPermissions is the permission every RPC requires, RPCs missing from it require Admin.
+var methodPermissions = map[string]auth.Permission{
+	netinternal.QueueService_Enqueue_FullMethodName:      auth.Produce,
+	netinternal.QueueService_ObserveQueue_FullMethodName: auth.Consume,
+	netinternal.QueueService_Fetch_FullMethodName:        auth.Consume,
+}
+
+// isHealthCheck reports whether method belongs to the health service, which orchestrators probe without credentials.
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/"+healthgrpc.Health_ServiceDesc.ServiceName+"/")
+}
+
+// consumerRequest is a request made on behalf of a consumer group.
+type consumerRequest interface {
+	GetConsumerId() uint64
+}
+
+// authorizer authenticates every RPC and checks it against the ACL.
+// A Consume RPC is checked once its request is received, since the request names the consumer group.
+type authorizer struct {
+	authenticator auth.Authenticator
+	acl           *auth.ACL
+	queue         string
+	logger        *slog.Logger
+}
+
+// newAuthorizer returns the authorizer of the configured policy, nil when authentication is disabled.
+// Clients authenticate with an API token, or with their certificate when mutual TLS is enabled.
+func newAuthorizer(cfg *config.Config) *authorizer {
+	policy := cfg.AuthPolicy()
+	if policy == nil {
+		return nil
+	}
+	authenticators := auth.Chain{auth.NewTokenAuthenticator(policy.Tokens)}
+	if cfg.TLSClientCAFile() != "" {
+		authenticators = append(authenticators, auth.CertificateAuthenticator{})
+	}
+	return &authorizer{authenticator: authenticators, acl: policy.ACL(), queue: cfg.QueueName(), logger: cfg.Logger()}
+}
+
+func (a *authorizer) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.unary),
+		grpc.ChainStreamInterceptor(a.stream),
+	}
+}
+
+func permissionOf(method string) auth.Permission {
+	if permission, ok := methodPermissions[method]; ok {
+		return permission
+	}
+	return auth.Admin
+}
+
+func (a *authorizer) authenticate(ctx context.Context, method string) (context.Context, string, error) {
+	principal, err := a.authenticator.Authenticate(ctx)
+	if err != nil {
+		auth.AuditDenied(a.logger, "", method, permissionOf(method), a.queue, "", err.Error())
+		return ctx, "", status.Error(codes.Unauthenticated, "missing or invalid credentials")
+	}
+	return auth.WithPrincipal(ctx, principal), principal, nil
+}
+
+func (a *authorizer) authorize(principal string, method string, request interface{}) error {
+	permission := permissionOf(method)
+	consumerGroup := ""
+	if consumer, ok := request.(consumerRequest); ok {
+		consumerGroup = strconv.FormatUint(consumer.GetConsumerId(), 10)
+	}
+	if a.acl.Allowed(principal, permission, a.queue, consumerGroup) {
+		return nil
+	}
+	auth.AuditDenied(a.logger, principal, method, permission, a.queue, consumerGroup, "no acl rule grants it")
+	return status.Errorf(codes.PermissionDenied, "%s is not allowed to %s", principal, permission)
+}
+
+func (a *authorizer) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if isHealthCheck(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	ctx, principal, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.authorize(principal, info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authorizer) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if isHealthCheck(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	ctx, principal, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	stream := &authorizedStream{ServerStream: ss, ctx: ctx, authorizer: a, principal: principal, method: info.FullMethod}
+	if permissionOf(info.FullMethod) != auth.Consume {
+		if err := a.authorize(principal, info.FullMethod, nil); err != nil {
+			return err
+		}
+		stream.authorized = true
+	}
+	return handler(srv, stream)
+}
+
+// authorizedStream carries the principal of a stream and authorizes its first request when needed.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	authorizer *authorizer
+	principal  string
+	method     string
+	authorized bool
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if !s.authorized {
+		if err := s.authorizer.authorize(s.principal, s.method, m); err != nil {
+			return err
+		}
+		s.authorized = true
+	}
+	return nil
+}
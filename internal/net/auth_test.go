@@ -0,0 +1,104 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/auth"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testPolicy = &auth.Policy{
+	Tokens: []auth.TokenPrincipal{
+		{Token: "producer-token", Principal: "producer"},
+		{Token: "consumer-token", Principal: "consumer"},
+	},
+	Rules: []auth.Rule{
+		{Principal: "producer", Permissions: []auth.Permission{auth.Produce}, Queues: []string{"billing"}},
+		{Principal: "consumer", Permissions: []auth.Permission{auth.Consume}, ConsumerGroups: []string{"1"}},
+		{Principal: "client", Permissions: []auth.Permission{auth.Produce}},
+	},
+}
+
+func tokenContext(token string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	return metadata.AppendToOutgoingContext(ctx, auth.TokenMetadataKey, "Bearer "+token), cancel
+}
+
+func enqueueAs(client netinternal.QueueServiceClient, token string) error {
+	ctx, cancel := tokenContext(token)
+	defer cancel()
+	_, err := client.Enqueue(ctx, &netinternal.EnqueueRequest{Message: []byte("message")})
+	return err
+}
+
+// observeAs starts observing the queue and returns the error of the first receive.
+func observeAs(client netinternal.QueueServiceClient, token string, consumerId uint64) error {
+	ctx, cancel := tokenContext(token)
+	defer cancel()
+	stream, err := client.ObserveQueue(ctx, &netinternal.ObserveQueueRequest{ConsumerId: consumerId})
+	if err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+func TestServerAuthorizesRequestsWithTokens(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestAuth").WithQueueName("billing").WithAuthPolicy(testPolicy)
+	_, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueAs(client, "producer-token"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(enqueueAs(client, "")))
+	assert.Equal(t, codes.Unauthenticated, status.Code(enqueueAs(client, "guess")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(enqueueAs(client, "consumer-token")))
+
+	assert.NoError(t, observeAs(client, "consumer-token", 1))
+	assert.Equal(t, codes.PermissionDenied, status.Code(observeAs(client, "consumer-token", 2)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(observeAs(client, "producer-token", 1)))
+}
+
+func TestServerAuthenticatesClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", 2)
+	clientCertFile, clientKeyFile := ca.issue(t, dir, "client", 3)
+	cfg := newTestConfig(t, "ServerTestAuthCertificate").
+		WithTLS(certFile, keyFile).
+		WithTLSClientCA(ca.writeCert(t, dir)).
+		WithAuthPolicy(testPolicy)
+	address := serveTLS(t, cfg)
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	assert.NoError(t, err)
+	client := dialTLS(t, address, ca, &clientCert)
+	assert.NoError(t, enqueueWith(client))
+	assert.Equal(t, codes.PermissionDenied, status.Code(observeAs(client, "", 1)))
+}
+
+func TestServerRequiresAdminForReplication(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestAuthAdmin").WithAuthPolicy(testPolicy)
+	server, err := NewQueueServer(cfg)
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := tokenContext("producer-token")
+	defer cancel()
+	_, err = netinternal.NewReplicationServiceClient(conn).Status(ctx, &netinternal.ReplicationStatusRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,87 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// progressSignal wakes the enqueues blocked on a full backlog whenever a consumer receives messages.
+type progressSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newProgressSignal() *progressSignal {
+	return &progressSignal{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed on the next notify.
+func (s *progressSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *progressSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+}
+
+// admitToBacklog applies the backlog policy to an enqueue made while the slowest consumer
+// lags the configured maximum. The limit is soft, concurrent enqueues may each be admitted
+// by the same check.
+func (qs *QueueServer) admitToBacklog(ctx context.Context) error {
+	limit := qs.config.MaxBacklog()
+	if limit == 0 {
+		return nil
+	}
+	switch qs.config.BacklogPolicy() {
+	case config.BacklogBlock:
+		for {
+			progress := qs.consumerProgress.wait()
+			if qs.queueService.Backlog() < limit {
+				return nil
+			}
+			select {
+			case <-progress:
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-qs.closing:
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
+		}
+	case config.BacklogSkipOldest:
+		if qs.queueService.Backlog() >= limit {
+			qs.skipOldest(limit - 1)
+		}
+		return nil
+	default:
+		if backlog := qs.queueService.Backlog(); backlog >= limit {
+			return status.Errorf(codes.ResourceExhausted, "backlog full, the slowest consumer lags %d messages", backlog)
+		}
+		return nil
+	}
+}
+
+// skipOldest makes the consumers lagging more than limit messages skip their oldest messages.
+func (qs *QueueServer) skipOldest(limit int) {
+	for consumerId, offset := range qs.queueService.SkipBacklog(limit) {
+		qs.replicateOffset(uint64(consumerId), offset)
+	}
+}
+
+// catchUpSkipped moves the iterator of an online consumer past the messages skipped from its backlog.
+func (qs *QueueServer) catchUpSkipped(consumer *OnlineConsumer) {
+	if qs.config.MaxBacklog() == 0 || qs.config.BacklogPolicy() != config.BacklogSkipOldest {
+		return
+	}
+	if qs.queueService.ConsumerOffset(int(consumer.id)) >= consumer.iterator.NextId() {
+		consumer.iterator = qs.queueService.NewConsumerIterator(int(consumer.id))
+	}
+}
@@ -0,0 +1,166 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/config"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// consumeAndLeave receives the given messages as the consumer, then disconnects it,
+// leaving its offset at the last message received.
+func consumeAndLeave(t *testing.T, server *QueueServer, client netinternal.QueueServiceClient, consumerId uint64, messages ...string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.ObserveQueue(ctx, &netinternal.ObserveQueueRequest{ConsumerId: consumerId})
+	assert.NoError(t, err)
+	for _, message := range messages {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(received.Message))
+	}
+	cancel()
+	assert.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		return len(server.onlineConsumer) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestEnqueueLargerThanTheMaximumMessageSizeIsRejected(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestMaxMessageSize").WithMaxMessageSize(8)
+	_, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueMessage(client, "small"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(enqueueMessage(client, "too large")))
+	_, err := client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Key: []byte("key"), Message: []byte("values")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestFullBacklogRejectsEnqueues(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestBacklogReject").WithMaxBacklog(2, config.BacklogReject)
+	server, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueMessage(client, "one"))
+	consumeAndLeave(t, server, client, 1, "one")
+	assert.NoError(t, enqueueMessage(client, "two"))
+	assert.NoError(t, enqueueMessage(client, "three"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(enqueueMessage(client, "four")))
+
+	consumeAndLeave(t, server, client, 1, "two")
+	assert.NoError(t, enqueueMessage(client, "four"))
+}
+
+func TestFullBacklogBlocksEnqueuesUntilConsumersCatchUp(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestBacklogBlock").WithMaxBacklog(1, config.BacklogBlock)
+	server, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueMessage(client, "one"))
+	consumeAndLeave(t, server, client, 1, "one")
+	assert.NoError(t, enqueueMessage(client, "two"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.Enqueue(ctx, &netinternal.EnqueueRequest{Message: []byte("three")})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	enqueued := make(chan error, 1)
+	go func() { enqueued <- enqueueMessage(client, "three") }()
+	select {
+	case err := <-enqueued:
+		t.Fatalf("enqueue returned while the backlog was full: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1})
+	assert.NoError(t, err)
+	received, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(received.Message))
+	select {
+	case err := <-enqueued:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueue still blocked after the consumer caught up")
+	}
+	received, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(received.Message))
+}
+
+func TestFullBacklogSkipsTheOldestMessagesOfLaggingConsumers(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestBacklogSkipOldest").WithMaxBacklog(2, config.BacklogSkipOldest)
+	server, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueMessage(client, "one"))
+	consumeAndLeave(t, server, client, 1, "one")
+	for _, message := range []string{"two", "three", "four", "five"} {
+		assert.NoError(t, enqueueMessage(client, message))
+	}
+
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1})
+	assert.NoError(t, err)
+	for _, message := range []string{"four", "five"} {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(received.Message))
+	}
+}
+
+// Run with -race: every delivery looks up the offset of its consumer while the others ack.
+func TestSkipOldestWithParallelConsumers(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestBacklogParallel").WithMaxBacklog(5, config.BacklogSkipOldest)
+	server, client := startServer(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const consumers = 4
+	var wg sync.WaitGroup
+	for consumerId := uint64(1); consumerId <= consumers; consumerId++ {
+		stream, err := client.ObserveQueue(ctx, &netinternal.ObserveQueueRequest{ConsumerId: consumerId})
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lastId := int64(-1)
+			for {
+				received, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				assert.Greater(t, int64(received.Id), lastId)
+				lastId = int64(received.Id)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			server.queueService.ConsumerOffset(consumers + 1)
+			server.queueService.ConsumerOffsets()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		assert.NoError(t, enqueueMessage(client, fmt.Sprintf("message %d", i)))
+	}
+	assert.Eventually(t, func() bool {
+		offsets := server.queueService.ConsumerOffsets()
+		for consumerId := 1; consumerId <= consumers; consumerId++ {
+			if offsets[consumerId] != 199 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+	assert.Len(t, server.queueService.ConsumerOffsets(), consumers)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package netinternal
+
+// freeDiskSpace is not supported on this platform, the disk is never reported full.
+func freeDiskSpace(_ string) (uint64, bool) {
+	return 0, false
+}
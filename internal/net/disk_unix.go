@@ -0,0 +1,14 @@
+//go:build unix
+
+package netinternal
+
+import "syscall"
+
+// freeDiskSpace returns the bytes available to the server on the filesystem holding dir.
+func freeDiskSpace(dir string) (uint64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true
+}
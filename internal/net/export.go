@@ -0,0 +1,200 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/cluster"
+	"ashishkujoy/queue/internal/export"
+	"ashishkujoy/queue/internal/storage"
+	netinternal "ashishkujoy/queue/proto"
+	"bufio"
+	"errors"
+	"io"
+	"syscall"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// exportChunkSize is the size of the chunks an export is streamed in.
+	exportChunkSize = 64 * 1024
+	// importBatchSize is the number of messages imported under their IDs appended with a single write.
+	importBatchSize = 256
+)
+
+// ExportQueue streams the messages of the range encoded in the requested format. It reads the
+// local copy of the queue, so it also serves followers, and leaves out messages enqueued meanwhile.
+func (a *adminServer) ExportQueue(req *netinternal.ExportQueueRequest, stream grpc.ServerStreamingServer[netinternal.ExportChunk]) error {
+	format := export.FormatJSONL
+	if req.Format == netinternal.ExportQueueRequest_BINARY {
+		format = export.FormatBinary
+	}
+	chunks := bufio.NewWriterSize(chunkWriter{stream: stream}, exportChunkSize)
+	writer, err := export.NewWriter(chunks, format)
+	if err != nil {
+		return err
+	}
+	exported, err := a.qs.queueService.Queue().Export(int(req.FromId), int(req.ToId), writer.Write)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = chunks.Flush()
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		a.qs.config.Logger().Error("failed to export", "exported", exported, "error", err)
+		return status.Errorf(codes.Internal, "export failed after %d messages", exported)
+	}
+	a.qs.config.Logger().Info("exported messages", "from", req.FromId, "to", req.ToId, "exported", exported)
+	return nil
+}
+
+// chunkWriter sends everything written to it as export chunks.
+type chunkWriter struct {
+	stream grpc.ServerStreamingServer[netinternal.ExportChunk]
+}
+
+func (w chunkWriter) Write(data []byte) (int, error) {
+	if err := w.stream.Send(&netinternal.ExportChunk{Data: data}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// ImportQueue appends the messages of the export streamed by the client, enqueueing them as new
+// messages, or under their exported IDs with preserveIds. Preserved IDs are appended like replicated
+// messages, which Raft clusters do not support. The messages are delivered to consumers as they are imported.
+func (a *adminServer) ImportQueue(stream grpc.ClientStreamingServer[netinternal.ImportChunk, netinternal.ImportQueueResponse]) error {
+	if address, ok := a.qs.leaderAddress(); !ok {
+		return notLeader(address, stream.SetTrailer)
+	}
+	chunks := &chunkReader{stream: stream}
+	first, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	preserveIds := first != nil && first.PreserveIds
+	if first != nil {
+		chunks.pending = first.Data
+	}
+	if preserveIds && a.qs.node != nil {
+		return status.Error(codes.FailedPrecondition, "a cluster member cannot import messages under their IDs")
+	}
+	imported, err := a.qs.importMessages(stream, chunks, preserveIds)
+	if chunks.err != nil {
+		return chunks.err
+	}
+	if err != nil {
+		a.qs.config.Logger().Warn("import failed", "imported", imported, "error", err)
+		if st, ok := status.FromError(err); ok {
+			return status.Errorf(st.Code(), "import failed after %d messages: %s", imported, st.Message())
+		}
+		return status.Errorf(codes.InvalidArgument, "import failed after %d messages: %v", imported, err)
+	}
+	a.qs.config.Logger().Info("imported messages", "imported", imported, "preserve_ids", preserveIds)
+	return stream.SendAndClose(&netinternal.ImportQueueResponse{
+		Imported: uint64(imported),
+		NextId:   uint64(a.qs.queueService.Queue().NextId()),
+	})
+}
+
+// chunkReader reads the data of the import chunks as they are received.
+// err holds the error receiving a chunk failed with, which ends the import.
+type chunkReader struct {
+	stream  grpc.ClientStreamingServer[netinternal.ImportChunk, netinternal.ImportQueueResponse]
+	pending []byte
+	err     error
+}
+
+func (r *chunkReader) Read(data []byte) (int, error) {
+	for len(r.pending) == 0 {
+		chunk, err := r.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.pending = chunk.Data
+	}
+	n := copy(data, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// importMessages decodes the export read from chunks and appends its messages,
+// returning how many were imported. Errors returned as a status are reported as they are,
+// any other one is a problem with the export.
+func (qs *QueueServer) importMessages(stream grpc.ClientStreamingServer[netinternal.ImportChunk, netinternal.ImportQueueResponse], chunks io.Reader, preserveIds bool) (int, error) {
+	reader, err := export.NewReader(chunks)
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	var batch []storage.Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, err
+		}
+		if size := len(record.Key) + storage.HeadersSize(record.Headers) + len(record.Data); size > qs.config.MaxMessageSize() {
+			return imported, status.Errorf(codes.InvalidArgument, "message %d of %d bytes exceeds the maximum of %d", record.Id, size, qs.config.MaxMessageSize())
+		}
+		if !preserveIds {
+			if _, err := qs.enqueue(stream.Context(), record.Key, record.Headers, record.Data); err != nil {
+				return imported, qs.importError(err)
+			}
+			imported++
+			continue
+		}
+		batch = append(batch, record)
+		if len(batch) == importBatchSize {
+			if err := qs.appendImported(batch); err != nil {
+				return imported, err
+			}
+			imported += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := qs.appendImported(batch); err != nil {
+		return imported, err
+	}
+	return imported + len(batch), nil
+}
+
+// appendImported appends messages under their own IDs, which must follow the newest message.
+func (qs *QueueServer) appendImported(records []storage.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if nextId := qs.queueService.Queue().NextId(); records[0].Id < nextId {
+		return status.Errorf(codes.FailedPrecondition, "message %d precedes the next id %d of the queue", records[0].Id, nextId)
+	}
+	if err := qs.queueService.Queue().AppendReplicated(records); err != nil {
+		return qs.importError(err)
+	}
+	qs.leader.Notify()
+	qs.broadcastInBackground()
+	return nil
+}
+
+// importError converts an error appending imported messages like Enqueue does.
+func (qs *QueueServer) importError(err error) error {
+	switch {
+	case errors.Is(err, cluster.ErrNotLeader):
+		return status.Error(codes.Unavailable, "leadership lost during the import")
+	case errors.Is(err, storage.ErrMessageTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, syscall.ENOSPC):
+		qs.markDiskFull()
+		return status.Error(codes.Unavailable, errDiskFull.Error())
+	}
+	return status.Errorf(codes.Internal, "failed to append: %v", err)
+}
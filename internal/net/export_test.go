@@ -0,0 +1,94 @@
+package netinternal
+
+import (
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportQueue returns the data streamed by ExportQueue for the request.
+func exportQueue(t *testing.T, admin netinternal.AdminServiceClient, req *netinternal.ExportQueueRequest) []byte {
+	stream, err := admin.ExportQueue(context.Background(), req)
+	assert.NoError(t, err)
+	var data []byte
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return data
+		}
+		assert.NoError(t, err)
+		data = append(data, chunk.Data...)
+	}
+}
+
+// importQueue streams data to ImportQueue in chunks of three bytes.
+func importQueue(admin netinternal.AdminServiceClient, data []byte, preserveIds bool) (*netinternal.ImportQueueResponse, error) {
+	stream, err := admin.ImportQueue(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		n := min(3, len(data))
+		if err := stream.Send(&netinternal.ImportChunk{PreserveIds: preserveIds, Data: data[:n]}); err != nil {
+			break
+		}
+		data = data[n:]
+	}
+	return stream.CloseAndRecv()
+}
+
+func TestExportAndImportWithPreservedIds(t *testing.T) {
+	_, client, admin := startAdmin(t, "ServerTestExportSource")
+	for _, message := range []string{"one", "two", "three", "four"} {
+		assert.NoError(t, enqueueMessage(client, message))
+	}
+	for _, format := range []netinternal.ExportQueueRequest_Format{netinternal.ExportQueueRequest_JSONL, netinternal.ExportQueueRequest_BINARY} {
+		data := exportQueue(t, admin, &netinternal.ExportQueueRequest{FromId: 1, ToId: 3, Format: format})
+
+		_, targetClient, target := startAdmin(t, "ServerTestExportTarget"+format.String())
+		imported, err := importQueue(target, data, true)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), imported.Imported)
+		assert.Equal(t, uint64(3), imported.NextId)
+
+		fetched, err := targetClient.Fetch(context.Background(), &netinternal.FetchRequest{FromId: 0, MaxMessages: 10})
+		assert.NoError(t, err)
+		assert.Len(t, fetched.Messages, 2)
+		assert.Equal(t, uint64(1), fetched.Messages[0].Id)
+		assert.Equal(t, "two", string(fetched.Messages[0].Message))
+		assert.Equal(t, uint64(2), fetched.Messages[1].Id)
+		assert.Equal(t, "three", string(fetched.Messages[1].Message))
+
+		_, err = importQueue(target, data, true)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	}
+}
+
+func TestImportEnqueuesMessagesUnderNewIds(t *testing.T) {
+	_, client, admin := startAdmin(t, "ServerTestImportSource")
+	for _, message := range []string{"one", "two"} {
+		assert.NoError(t, enqueueMessage(client, message))
+	}
+	data := exportQueue(t, admin, &netinternal.ExportQueueRequest{})
+
+	_, targetClient, target := startAdmin(t, "ServerTestImportTarget")
+	assert.NoError(t, enqueueMessage(targetClient, "zero"))
+	imported, err := importQueue(target, data, false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), imported.Imported)
+	assert.Equal(t, uint64(3), imported.NextId)
+
+	fetched, err := targetClient.Fetch(context.Background(), &netinternal.FetchRequest{FromId: 0, MaxMessages: 10})
+	assert.NoError(t, err)
+	assert.Len(t, fetched.Messages, 3)
+	assert.Equal(t, "one", string(fetched.Messages[1].Message))
+	assert.Equal(t, "two", string(fetched.Messages[2].Message))
+
+	_, err = importQueue(target, []byte("{not json\n"), false)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,154 @@
+package netinternal
+
+import (
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval is how often the free space of the data disk is checked.
+const healthCheckInterval = 5 * time.Second
+
+// healthServices are the services the health service reports on, the empty name standing for the whole server.
+var healthServices = []string{"", netinternal.QueueService_ServiceDesc.ServiceName}
+
+var (
+	errShuttingDown = errors.New("shutting down")
+	errDiskFull     = errors.New("data disk is full")
+)
+
+// healthServer is the standard grpc.health.v1 service. Its Watch streams end once the server
+// shuts down, after reporting NOT_SERVING, so they do not hold up a graceful stop.
+type healthServer struct {
+	*health.Server
+	closing <-chan struct{}
+}
+
+func (h *healthServer) Watch(req *healthgrpc.HealthCheckRequest, stream healthgrpc.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return h.Server.Watch(req, watchStream{Health_WatchServer: stream, ctx: ctx})
+}
+
+// watchStream is a Watch stream whose context is also cancelled when the server shuts down.
+type watchStream struct {
+	healthgrpc.Health_WatchServer
+	ctx context.Context
+}
+
+func (s watchStream) Context() context.Context {
+	return s.ctx
+}
+
+// Ready returns nil when the server serves RPCs, and why it does not otherwise.
+func (qs *QueueServer) Ready() error {
+	select {
+	case <-qs.closing:
+		return errShuttingDown
+	default:
+	}
+	if qs.diskFull.Load() {
+		return errDiskFull
+	}
+	return nil
+}
+
+// checkHealth checks the free space of the data disk and reports the serving status to the health service.
+func (qs *QueueServer) checkHealth() {
+	qs.diskFull.Store(qs.isDiskFull())
+	qs.reportHealth()
+}
+
+func (qs *QueueServer) reportHealth() {
+	status := healthgrpc.HealthCheckResponse_SERVING
+	if err := qs.Ready(); err != nil {
+		status = healthgrpc.HealthCheckResponse_NOT_SERVING
+	}
+	for _, service := range healthServices {
+		qs.health.SetServingStatus(service, status)
+	}
+}
+
+// markDiskFull reports the server not serving after a write ran out of disk space,
+// until a later check finds enough free space again.
+func (qs *QueueServer) markDiskFull() {
+	if !qs.diskFull.Swap(true) {
+		qs.config.Logger().Error("data disk is full, reporting not serving")
+	}
+	qs.reportHealth()
+}
+
+// isDiskFull reports whether the disk holding the segments or the metadata has less than MinFreeDiskSpace free.
+func (qs *QueueServer) isDiskFull() bool {
+	minFree := qs.config.MinFreeDiskSpace()
+	if minFree == 0 {
+		return false
+	}
+	for _, dir := range []string{qs.config.SegmentsRoot(), qs.config.MetadataPath} {
+		if free, ok := freeDiskSpace(dir); ok && free < uint64(minFree) {
+			return true
+		}
+	}
+	return false
+}
+
+// monitorHealth checks the health every healthCheckInterval until the server shuts down.
+func (qs *QueueServer) monitorHealth() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			qs.checkHealth()
+		case <-qs.closing:
+			return
+		}
+	}
+}
+
+// Probes answers the liveness and readiness probes of an orchestrator over HTTP.
+// It is alive as soon as it serves, and ready once it is handed the server with
+// Serving, after the queue and the consumer offsets were restored, while that server is ready.
+type Probes struct {
+	server atomic.Pointer[QueueServer]
+}
+
+// Serving marks the queue restored, the readiness of the server is reported from then on.
+func (p *Probes) Serving(qs *QueueServer) {
+	p.server.Store(qs)
+}
+
+// Register serves the liveness probe at /healthz and the readiness probe at /readyz.
+func (p *Probes) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", p.ready)
+}
+
+func (p *Probes) ready(w http.ResponseWriter, _ *http.Request) {
+	server := p.server.Load()
+	if server == nil {
+		http.Error(w, "restoring the queue", http.StatusServiceUnavailable)
+		return
+	}
+	if err := server.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
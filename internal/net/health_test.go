@@ -0,0 +1,92 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startHealthClient serves a queue server and returns a health client connected to it.
+func startHealthClient(t *testing.T, cfg *config.Config) (*QueueServer, healthgrpc.HealthClient) {
+	server, err := NewQueueServer(cfg)
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return server, healthgrpc.NewHealthClient(conn)
+}
+
+func probe(probes *Probes, path string) int {
+	recorder := httptest.NewRecorder()
+	mux := http.NewServeMux()
+	probes.Register(mux)
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Code
+}
+
+func TestHealthReportsServingUntilShutdown(t *testing.T) {
+	server, client := startHealthClient(t, newTestConfig(t, "ServerTestHealth"))
+
+	for _, service := range healthServices {
+		response, err := client.Check(context.Background(), &healthgrpc.HealthCheckRequest{Service: service})
+		assert.NoError(t, err)
+		assert.Equal(t, healthgrpc.HealthCheckResponse_SERVING, response.Status)
+	}
+	watch, err := client.Watch(context.Background(), &healthgrpc.HealthCheckRequest{})
+	assert.NoError(t, err)
+	response, err := watch.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthgrpc.HealthCheckResponse_SERVING, response.Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, server.Shutdown(ctx), "open Watch streams must not hold up the shutdown")
+	assert.ErrorIs(t, server.Ready(), errShuttingDown)
+}
+
+func TestHealthReportsNotServingWhenTheDiskIsFull(t *testing.T) {
+	server, client := startHealthClient(t, newTestConfig(t, "ServerTestHealthDiskFull").WithMinFreeDiskSpace(math.MaxInt))
+
+	response, err := client.Check(context.Background(), &healthgrpc.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthgrpc.HealthCheckResponse_NOT_SERVING, response.Status)
+	assert.ErrorIs(t, server.Ready(), errDiskFull)
+}
+
+func TestHealthChecksNeedNoCredentials(t *testing.T) {
+	_, client := startHealthClient(t, newTestConfig(t, "ServerTestHealthAuth").WithAuthPolicy(testPolicy))
+
+	response, err := client.Check(context.Background(), &healthgrpc.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthgrpc.HealthCheckResponse_SERVING, response.Status)
+}
+
+func TestProbesReportReadinessOnceTheQueueIsRestored(t *testing.T) {
+	probes := &Probes{}
+	assert.Equal(t, http.StatusOK, probe(probes, "/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(probes, "/readyz"))
+
+	server, err := NewQueueServer(newTestConfig(t, "ServerTestProbes"))
+	assert.NoError(t, err)
+	probes.Serving(server)
+	assert.Equal(t, http.StatusOK, probe(probes, "/readyz"))
+
+	assert.NoError(t, server.Shutdown(context.Background()))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(probes, "/readyz"))
+	assert.Equal(t, http.StatusOK, probe(probes, "/healthz"))
+}
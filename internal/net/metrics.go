@@ -0,0 +1,63 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/metrics"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	segmentsDesc = prometheus.NewDesc(metrics.Namespace+"_segments",
+		"Segments of the queue, the active one included.", nil, nil)
+	activeSegmentSizeDesc = prometheus.NewDesc(metrics.Namespace+"_active_segment_size_bytes",
+		"Size of the segment messages are appended to.", nil, nil)
+	indexEntriesDesc = prometheus.NewDesc(metrics.Namespace+"_index_entries",
+		"Messages in the index.", nil, nil)
+	indexSizeDesc = prometheus.NewDesc(metrics.Namespace+"_index_size_bytes",
+		"Size of the index file.", nil, nil)
+	nextMessageIdDesc = prometheus.NewDesc(metrics.Namespace+"_next_message_id",
+		"ID the next enqueued message will be assigned.", nil, nil)
+	consumerLagDesc = prometheus.NewDesc(metrics.Namespace+"_consumer_lag_messages",
+		"Messages a consumer has not received yet.", []string{"consumer"}, nil)
+	onlineConsumersDesc = prometheus.NewDesc(metrics.Namespace+"_online_consumers",
+		"ObserveQueue streams open.", nil, nil)
+	followerLagDesc = prometheus.NewDesc(metrics.Namespace+"_follower_lag_messages",
+		"Messages this follower is behind its leader.", nil, nil)
+)
+
+// serverCollector reports the state of a queue server, read at scrape time.
+type serverCollector struct {
+	qs *QueueServer
+}
+
+func (c serverCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		segmentsDesc, activeSegmentSizeDesc, indexEntriesDesc, indexSizeDesc, nextMessageIdDesc,
+		consumerLagDesc, onlineConsumersDesc, followerLagDesc,
+	} {
+		descs <- desc
+	}
+}
+
+func (c serverCollector) Collect(values chan<- prometheus.Metric) {
+	stats := c.qs.queueService.Queue().Stats()
+	gauge := func(desc *prometheus.Desc, value int, labels ...string) {
+		values <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), labels...)
+	}
+	gauge(segmentsDesc, stats.Count)
+	gauge(activeSegmentSizeDesc, stats.ActiveSizeInBytes)
+	gauge(indexEntriesDesc, stats.IndexEntries)
+	gauge(indexSizeDesc, stats.IndexSizeInBytes)
+	gauge(nextMessageIdDesc, stats.NextId)
+	for consumerId, offset := range c.qs.queueService.ConsumerOffsets() {
+		gauge(consumerLagDesc, max(stats.NextId-offset-1, 0), strconv.Itoa(consumerId))
+	}
+	c.qs.mu.RLock()
+	online := len(c.qs.onlineConsumer)
+	c.qs.mu.RUnlock()
+	gauge(onlineConsumersDesc, online)
+	if lag, ok := c.qs.FollowerLag(); ok {
+		gauge(followerLagDesc, lag)
+	}
+}
@@ -0,0 +1,60 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/metrics"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(m *metrics.Metrics) string {
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	return string(body)
+}
+
+func TestMetricsReportQueueAndConsumerState(t *testing.T) {
+	m := metrics.New()
+	cfg := newTestConfig(t, "ServerTestMetrics").WithMetrics(m)
+	server, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueMessage(client, "one"))
+	consumeAndLeave(t, server, client, 1, "one")
+	assert.NoError(t, enqueueMessage(client, "two"))
+	assert.NoError(t, enqueueMessage(client, "three"))
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 2})
+	assert.NoError(t, err)
+	for _, message := range []string{"one", "two", "three"} {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(received.Message))
+	}
+
+	expected := []string{
+		"queue_enqueued_messages_total 3",
+		"queue_delivered_messages_total 4",
+		"queue_segments 1",
+		"queue_index_entries 3",
+		"queue_next_message_id 3",
+		`queue_consumer_lag_messages{consumer="1"} 2`,
+		`queue_consumer_lag_messages{consumer="2"} 0`,
+		"queue_online_consumers 1",
+	}
+	assert.Eventually(t, func() bool {
+		body := scrape(m)
+		for _, line := range expected {
+			if !strings.Contains(body, line+"\n") {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotContains(t, scrape(m), "queue_appended_bytes_total 0\n")
+}
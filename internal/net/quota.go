@@ -0,0 +1,133 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/auth"
+	"ashishkujoy/queue/internal/quota"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// clientIdentity returns the identity quotas are tracked under: the authenticated principal,
+// or the client's IP address when authentication is disabled.
+func clientIdentity(ctx context.Context) string {
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		return principal
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// quotaExceeded returns the ResourceExhausted error of a request over quota,
+// carrying how long to wait before retrying as a RetryInfo detail.
+func quotaExceeded(retryAfter time.Duration) error {
+	st := status.Newf(codes.ResourceExhausted, "quota exceeded, retry after %s", retryAfter.Round(time.Millisecond))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// retryDelivery serves the consumer again once its quota allows it, unless the server shuts down first.
+func (qs *QueueServer) retryDelivery(consumer *OnlineConsumer, delay time.Duration) {
+	if !consumer.retrying.CompareAndSwap(false, true) {
+		return
+	}
+	qs.broadcasts.Add(1)
+	go func() {
+		defer qs.broadcasts.Done()
+		select {
+		case <-time.After(delay):
+		case <-qs.closing:
+			consumer.retrying.Store(false)
+			return
+		}
+		consumer.retrying.Store(false)
+		if err := qs.serveMessages(consumer); err != nil {
+			qs.removeConsumers([]uint64{consumer.id})
+		}
+	}()
+}
+
+func (a *adminServer) GetQuotas(context.Context, *netinternal.GetQuotasRequest) (*netinternal.Quotas, error) {
+	return quotasToProto(a.qs.quotas.Quotas()), nil
+}
+
+func (a *adminServer) SetQuotas(_ context.Context, req *netinternal.Quotas) (*netinternal.Quotas, error) {
+	quotas := quotasFromProto(req)
+	for _, limits := range []quota.Limits{quotas.Produce, quotas.Consume, quotas.Queue} {
+		if limits.MessagesPerSecond < 0 || limits.BytesPerSecond < 0 {
+			return nil, status.Error(codes.InvalidArgument, "quotas must not be negative")
+		}
+	}
+	for client, override := range quotas.Clients {
+		if client == "" {
+			return nil, status.Error(codes.InvalidArgument, "a client quota needs a client")
+		}
+		for _, limits := range []quota.Limits{override.Produce, override.Consume} {
+			if limits.MessagesPerSecond < 0 || limits.BytesPerSecond < 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "quotas of %s must not be negative", client)
+			}
+		}
+	}
+	a.qs.quotas.Set(quotas)
+	return quotasToProto(quotas), nil
+}
+
+func limitsToProto(limits quota.Limits) *netinternal.QuotaLimits {
+	return &netinternal.QuotaLimits{MessagesPerSecond: limits.MessagesPerSecond, BytesPerSecond: limits.BytesPerSecond}
+}
+
+func limitsFromProto(limits *netinternal.QuotaLimits) quota.Limits {
+	return quota.Limits{MessagesPerSecond: limits.GetMessagesPerSecond(), BytesPerSecond: limits.GetBytesPerSecond()}
+}
+
+func quotasToProto(quotas quota.Quotas) *netinternal.Quotas {
+	message := &netinternal.Quotas{
+		Produce: limitsToProto(quotas.Produce),
+		Consume: limitsToProto(quotas.Consume),
+		Queue:   limitsToProto(quotas.Queue),
+	}
+	for client, override := range quotas.Clients {
+		message.Clients = append(message.Clients, &netinternal.ClientQuota{
+			Client:  client,
+			Produce: limitsToProto(override.Produce),
+			Consume: limitsToProto(override.Consume),
+		})
+	}
+	sort.Slice(message.Clients, func(i, j int) bool {
+		return message.Clients[i].Client < message.Clients[j].Client
+	})
+	return message
+}
+
+func quotasFromProto(message *netinternal.Quotas) quota.Quotas {
+	quotas := quota.Quotas{
+		Produce: limitsFromProto(message.GetProduce()),
+		Consume: limitsFromProto(message.GetConsume()),
+		Queue:   limitsFromProto(message.GetQueue()),
+	}
+	if len(message.GetClients()) != 0 {
+		quotas.Clients = make(map[string]quota.ClientQuotas, len(message.Clients))
+	}
+	for _, override := range message.GetClients() {
+		quotas.Clients[override.Client] = quota.ClientQuotas{
+			Produce: limitsFromProto(override.GetProduce()),
+			Consume: limitsFromProto(override.GetConsume()),
+		}
+	}
+	return quotas
+}
@@ -0,0 +1,96 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/quota"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func enqueueMessage(client netinternal.QueueServiceClient, message string) error {
+	_, err := client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: []byte(message)})
+	return err
+}
+
+func TestEnqueueOverQuotaIsRejectedWithARetryHint(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestProduceQuota").WithQuotas(quota.Quotas{Produce: quota.Limits{MessagesPerSecond: 2}})
+	_, client := startServer(t, cfg)
+
+	assert.NoError(t, enqueueMessage(client, "one"))
+	assert.NoError(t, enqueueMessage(client, "two"))
+	err := enqueueMessage(client, "three")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if assert.NotNil(t, retryInfo) {
+		assert.True(t, retryInfo.RetryDelay.AsDuration() > 0)
+		assert.True(t, retryInfo.RetryDelay.AsDuration() <= 500*time.Millisecond)
+	}
+}
+
+func TestQuotasChangeThroughTheAdminService(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestAdminQuota").WithQuotas(quota.Quotas{Produce: quota.Limits{MessagesPerSecond: 1}})
+	server, err := NewQueueServer(cfg)
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client, admin := netinternal.NewQueueServiceClient(conn), netinternal.NewAdminServiceClient(conn)
+
+	assert.NoError(t, enqueueMessage(client, "one"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(enqueueMessage(client, "two")))
+
+	quotas, err := admin.SetQuotas(context.Background(), &netinternal.Quotas{
+		Produce: &netinternal.QuotaLimits{MessagesPerSecond: 1},
+		Clients: []*netinternal.ClientQuota{{Client: "127.0.0.1", Produce: &netinternal.QuotaLimits{MessagesPerSecond: 100}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", quotas.Clients[0].Client)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, enqueueMessage(client, "more"))
+	}
+
+	current, err := admin.GetQuotas(context.Background(), &netinternal.GetQuotasRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(100), current.Clients[0].Produce.MessagesPerSecond)
+
+	_, err = admin.SetQuotas(context.Background(), &netinternal.Quotas{Queue: &netinternal.QuotaLimits{BytesPerSecond: -1}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestDeliveryIsPacedByTheConsumeQuota(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestConsumeQuota").WithQuotas(quota.Quotas{Consume: quota.Limits{MessagesPerSecond: 10}})
+	_, client := startServer(t, cfg)
+	for i := 0; i < 15; i++ {
+		assert.NoError(t, enqueueMessage(client, "message"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.ObserveQueue(ctx, &netinternal.ObserveQueueRequest{ConsumerId: 1})
+	assert.NoError(t, err)
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		_, err := stream.Recv()
+		assert.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, "received 15 messages in %s", time.Since(start))
+}
@@ -0,0 +1,146 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/cluster"
+	"ashishkujoy/queue/internal/replication"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// LeaderMetadataKey is the trailer carrying the address of the leader when a server
+// rejects a call because it does not lead, so clients can retry against the leader.
+const LeaderMetadataKey = "queue-leader"
+
+// setupReplication makes the server a member of a Raft cluster when cluster peers are configured,
+// a follower when a leader is configured, and a leader otherwise.
+// A leader serves the ReplicationService followers connect to.
+func (qs *QueueServer) setupReplication() error {
+	acks := qs.config.ReplicationAcks()
+	if acks != replication.AcksLeader && acks != replication.AcksQuorum {
+		return fmt.Errorf("unknown acks mode %q, expected %s or %s", acks, replication.AcksLeader, replication.AcksQuorum)
+	}
+	nodeId := qs.config.NodeId()
+	if nodeId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("a node id is required to replicate: %w", err)
+		}
+		nodeId = hostname
+	}
+
+	queue := qs.queueService.Queue()
+	switch {
+	case len(qs.config.ClusterPeers()) != 0:
+		if qs.config.ReplicationLeader() != "" {
+			return fmt.Errorf("a cluster member cannot follow a replication leader")
+		}
+		if qs.config.AuthPolicy() != nil && qs.config.TLSCertFile() == "" {
+			return fmt.Errorf("a cluster with an auth policy needs mutual TLS, plain Raft traffic would bypass it")
+		}
+		transportTLS, err := raftTransportTLS(qs.config)
+		if err != nil {
+			return err
+		}
+		node, err := cluster.NewNode(qs.config.WithNodeId(nodeId), qs.queueService, transportTLS, func() {
+			qs.broadcastInBackground()
+		})
+		if err != nil {
+			return err
+		}
+		qs.node = node
+	case qs.config.ReplicationLeader() != "":
+		qs.follower = replication.NewFollower(nodeId, qs.config.ReplicationLeader(), queue, func() {
+			qs.broadcastInBackground()
+		}).WithLogger(qs.config.Logger())
+		creds, err := replicationCredentials(qs.config)
+		if err != nil {
+			return err
+		}
+		if creds != nil {
+			qs.follower.WithTransportCredentials(creds)
+		}
+	default:
+		qs.leader = replication.NewLeader(queue, qs.config.ReplicationFactor())
+		netinternal.RegisterReplicationServiceServer(qs.gpServer, qs.leader)
+	}
+	return nil
+}
+
+// enqueue appends a message, through the Raft log in clustered mode.
+func (qs *QueueServer) enqueue(ctx context.Context, key []byte, headers map[string]string, data []byte) (int, error) {
+	if qs.node != nil {
+		return qs.node.Enqueue(key, headers, data)
+	}
+	messageId, err := qs.queueService.EnqueueWithHeaders(ctx, key, headers, data)
+	if err != nil {
+		return 0, err
+	}
+	qs.leader.Notify()
+	qs.broadcastInBackground()
+	return messageId, nil
+}
+
+// leaderAddress returns the client address of the leader and false when this server does not accept enqueues.
+func (qs *QueueServer) leaderAddress() (string, bool) {
+	switch {
+	case qs.follower != nil:
+		return qs.follower.LeaderAddress(), false
+	case qs.node != nil && !qs.node.IsLeader():
+		return qs.node.LeaderClientAddress(), false
+	}
+	return "", true
+}
+
+// consumerLeaderAddress is leaderAddress for consumers. In clustered mode consumers are served
+// by the leader, which commits their offsets to the Raft log, elsewhere any server serves them.
+func (qs *QueueServer) consumerLeaderAddress() (string, bool) {
+	if qs.node != nil && !qs.node.IsLeader() {
+		return qs.node.LeaderClientAddress(), false
+	}
+	return "", true
+}
+
+// notLeader returns the error rejecting a call on a server that does not lead,
+// passing the leader's address to setTrailer for the client.
+func notLeader(address string, setTrailer func(metadata.MD)) error {
+	if address == "" {
+		return status.Error(codes.Unavailable, "no leader elected yet")
+	}
+	setTrailer(metadata.Pairs(LeaderMetadataKey, address))
+	return status.Errorf(codes.FailedPrecondition, "not the leader, retry against %s", address)
+}
+
+// waitForReplicas waits for a quorum of replicas to store the message in quorum acks mode.
+// A Raft cluster only acknowledges messages committed by a quorum already.
+func (qs *QueueServer) waitForReplicas(ctx context.Context, messageId int) error {
+	if qs.leader == nil || qs.config.ReplicationAcks() != replication.AcksQuorum {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, qs.config.ReplicationTimeout())
+	defer cancel()
+	return qs.leader.WaitForReplicas(ctx, messageId)
+}
+
+// replicateOffset commits the last message delivered to a consumer to the Raft log in clustered mode.
+func (qs *QueueServer) replicateOffset(consumerId uint64, messageId int) {
+	if qs.node == nil || messageId < 0 {
+		return
+	}
+	if err := qs.node.Ack(int(consumerId), messageId); err != nil {
+		qs.config.Logger().Error("failed to replicate consumer offset", "consumer", consumerId, "message", messageId, "error", err)
+	}
+}
+
+// FollowerLag returns how many messages a follower is behind its leader, and false on a leader.
+func (qs *QueueServer) FollowerLag() (int, bool) {
+	if qs.follower == nil {
+		return 0, false
+	}
+	return qs.follower.Lag(), true
+}
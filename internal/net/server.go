@@ -2,57 +2,200 @@ package netinternal
 
 import (
 	"ashishkujoy/queue/internal"
+	"ashishkujoy/queue/internal/cluster"
 	"ashishkujoy/queue/internal/config"
 	queueinternal "ashishkujoy/queue/internal/queue"
+	"ashishkujoy/queue/internal/quota"
+	"ashishkujoy/queue/internal/replication"
+	"ashishkujoy/queue/internal/storage"
+	"ashishkujoy/queue/internal/tracing"
 	netinternal "ashishkujoy/queue/proto"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// maxRequestOverhead is the room left for the fields of a request around a message of the maximum size.
+const maxRequestOverhead = 1024
+
+// maxFetchBytes bounds the encoded size of a Fetch response, keeping it below the 4 MiB gRPC clients
+// receive by default with room for its NextId. A Fetch returns at least one message even when larger.
+const maxFetchBytes = 4<<20 - 16
+
 type MessageOutputStream = grpc.ServerStreamingServer[netinternal.QueueMessage]
+
+// OnlineConsumer is a consumer connected through ObserveQueue.
+// Its iterator tracks the next message to send, so delivery reads segments
+// sequentially instead of looking every message up in the index.
+// mu serialises deliveries, a stream must not be written to concurrently.
 type OnlineConsumer struct {
-	id     uint64
-	stream MessageOutputStream
+	id       uint64
+	stream   MessageOutputStream
+	iterator storage.Iterator
+	mu       sync.Mutex
+	// client is the identity the consumer's delivery quota is tracked under.
+	client string
+	// retrying is set while a delivery paused by the quota is scheduled.
+	retrying atomic.Bool
+	// limit is the number of messages the stream ends after, 0 for none, and delivered
+	// how many it has been sent. done is closed once the limit is reached.
+	limit     int
+	delivered int
+	done      chan struct{}
 }
 type QueueServer struct {
 	netinternal.UnimplementedQueueServiceServer
 	queueService   *queueinternal.QueueService
-	port           string
 	gpServer       *grpc.Server
 	onlineConsumer []*OnlineConsumer
 	mu             *sync.RWMutex
+	config         *config.Config
+	// leader is set when the server is a replication leader, follower when it replicates a leader.
+	leader   *replication.Leader
+	follower *replication.Follower
+	// node is set when the server is a member of a Raft cluster.
+	node *cluster.Node
+	// quotas limits the rate of enqueues and deliveries per client.
+	quotas *quota.Manager
+	// tracer starts the spans of enqueues and deliveries.
+	tracer trace.Tracer
+	// health reports the serving status, which diskFull turns NOT_SERVING.
+	health   *healthServer
+	diskFull atomic.Bool
+	// consumerProgress is notified when consumers receive messages, shrinking the backlog.
+	consumerProgress *progressSignal
+	// closing is closed when the server starts shutting down, ending the ObserveQueue streams.
+	closing      chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
+	// broadcasts tracks the deliveries running in the background, replicating tracks the follower's replication loop.
+	broadcasts  sync.WaitGroup
+	replicating sync.WaitGroup
 }
 
-func NewQueueServer(config *config.Config, port string) (*QueueServer, error) {
+// NewQueueServer creates a server for the queue stored as configured, accepting clients on config.ListenAddress.
+func NewQueueServer(config *config.Config) (*QueueServer, error) {
+	options := []grpc.ServerOption{grpc.MaxRecvMsgSize(config.MaxMessageSize() + maxRequestOverhead)}
+	creds, err := serverCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		options = append(options, grpc.Creds(creds))
+	}
+	if authorizer := newAuthorizer(config); authorizer != nil {
+		options = append(options, authorizer.serverOptions()...)
+	}
 	service, err := queueinternal.NewQueueService(config)
 	if err != nil {
 		return nil, err
 	}
 
-	gpServer := grpc.NewServer()
+	gpServer := grpc.NewServer(options...)
+	closing := make(chan struct{})
 	server := &QueueServer{
-		port:           port,
-		queueService:   service,
-		gpServer:       gpServer,
-		onlineConsumer: make([]*OnlineConsumer, 0),
-		mu:             &sync.RWMutex{},
+		queueService:     service,
+		gpServer:         gpServer,
+		onlineConsumer:   make([]*OnlineConsumer, 0),
+		mu:               &sync.RWMutex{},
+		config:           config,
+		closing:          closing,
+		quotas:           quota.NewManager(config.Quotas()),
+		consumerProgress: newProgressSignal(),
+		tracer:           config.TracerProvider().Tracer(tracing.InstrumentationName),
+		health:           &healthServer{Server: health.NewServer(), closing: closing},
+	}
+	if err := server.setupReplication(); err != nil {
+		service.Close()
+		return nil, err
+	}
+	if err := config.Metrics().Register(serverCollector{qs: server}); err != nil {
+		service.Close()
+		return nil, err
 	}
 	netinternal.RegisterQueueServiceServer(gpServer, server)
+	netinternal.RegisterAdminServiceServer(gpServer, &adminServer{qs: server})
+	healthgrpc.RegisterHealthServer(gpServer, server.health)
+	server.checkHealth()
+	go server.monitorHealth()
 	return server, nil
 }
 
-func (qs *QueueServer) Enqueue(_ context.Context, req *netinternal.EnqueueRequest) (*netinternal.EnqueueRequestResponse, error) {
-	if err := qs.queueService.Enqueue(req.Message); err != nil {
+// Enqueue stores a message in a span continuing the trace the producer sent in the request
+// headers or, failing that, in the RPC metadata. The trace context of the span is stored in
+// the headers of the message, so its deliveries join the trace.
+func (qs *QueueServer) Enqueue(ctx context.Context, req *netinternal.EnqueueRequest) (*netinternal.EnqueueRequestResponse, error) {
+	ctx = tracing.Extract(tracing.ExtractIncoming(ctx), req.Headers)
+	ctx, span := qs.tracer.Start(ctx, "QueueServer.Enqueue", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", qs.config.QueueName())))
+	defer span.End()
+	response, err := qs.serveEnqueue(ctx, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	return response, err
+}
+
+func (qs *QueueServer) serveEnqueue(ctx context.Context, req *netinternal.EnqueueRequest) (*netinternal.EnqueueRequestResponse, error) {
+	start := time.Now()
+	if address, ok := qs.leaderAddress(); !ok {
+		return nil, notLeader(address, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+	}
+	if size := len(req.Key) + storage.HeadersSize(req.Headers) + len(req.Message); size > qs.config.MaxMessageSize() {
+		return nil, status.Errorf(codes.InvalidArgument, "message of %d bytes exceeds the maximum of %d", size, qs.config.MaxMessageSize())
+	}
+	if retryAfter, ok := qs.quotas.AllowProduce(clientIdentity(ctx), len(req.Key)+len(req.Message)); !ok {
+		return nil, quotaExceeded(retryAfter)
+	}
+	if err := qs.admitToBacklog(ctx); err != nil {
+		return nil, err
+	}
+	headers := maps.Clone(req.Headers)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	tracing.Inject(ctx, headers)
+	messageId, err := qs.enqueue(ctx, req.Key, headers, req.Message)
+	if errors.Is(err, cluster.ErrNotLeader) {
+		address, _ := qs.leaderAddress()
+		return nil, notLeader(address, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+	}
+	if errors.Is(err, storage.ErrMessageTooLarge) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		qs.markDiskFull()
+		return nil, status.Error(codes.Unavailable, errDiskFull.Error())
+	}
+	if err != nil {
+		qs.config.Logger().Error("failed to enqueue", "client", clientIdentity(ctx), "error", err)
 		return nil, status.Errorf(codes.Internal, "failed to enqueue")
 	}
-	go qs.broadcastMessage()
-	return &netinternal.EnqueueRequestResponse{Success: true}, nil
+	if err := qs.waitForReplicas(ctx, messageId); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "message stored by the leader but not by a quorum: %v", err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("messaging.message.id", messageId))
+	qs.config.Metrics().Enqueued(time.Since(start))
+	qs.config.Logger().Debug("enqueued message", "message", messageId, "client", clientIdentity(ctx))
+	return &netinternal.EnqueueRequestResponse{Success: true, Id: uint64(messageId)}, nil
 }
 
 func removeClosedConsumers(closedChannels []uint64, consumers []*OnlineConsumer) []*OnlineConsumer {
@@ -61,60 +204,236 @@ func removeClosedConsumers(closedChannels []uint64, consumers []*OnlineConsumer)
 	})
 }
 
+// broadcastInBackground delivers new messages to the online consumers without blocking the caller.
+func (qs *QueueServer) broadcastInBackground() {
+	qs.broadcasts.Add(1)
+	go func() {
+		defer qs.broadcasts.Done()
+		qs.broadcastMessage()
+	}()
+}
+
 func (qs *QueueServer) broadcastMessage() {
 	var closedChannels []uint64
 	mu := sync.Mutex{}
 	wg := sync.WaitGroup{}
-	for _, consumer := range qs.onlineConsumer {
+	qs.mu.RLock()
+	consumers := qs.onlineConsumer
+	qs.mu.RUnlock()
+	for _, consumer := range consumers {
 		wg.Add(1)
 		go func(consumer *OnlineConsumer) {
 			defer wg.Done()
 			if err := qs.serveMessages(consumer); err != nil {
 				mu.Lock()
 				defer mu.Unlock()
-				qs.queueService.RevertDequeue(int(consumer.id))
 				closedChannels = append(closedChannels, consumer.id)
 			}
 		}(consumer)
 	}
 	wg.Wait()
+	qs.removeConsumers(closedChannels)
+}
+
+func (qs *QueueServer) removeConsumers(closedChannels []uint64) {
+	if len(closedChannels) == 0 {
+		return
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
 	qs.onlineConsumer = removeClosedConsumers(closedChannels, qs.onlineConsumer)
 }
 
 func (qs *QueueServer) ObserveQueue(req *netinternal.ObserveQueueRequest, stream grpc.ServerStreamingServer[netinternal.QueueMessage]) error {
-	consumer := &OnlineConsumer{id: req.ConsumerId, stream: stream}
+	if address, ok := qs.consumerLeaderAddress(); !ok {
+		return notLeader(address, stream.SetTrailer)
+	}
+	consumer := &OnlineConsumer{
+		id:       req.ConsumerId,
+		stream:   stream,
+		iterator: qs.queueService.NewConsumerIterator(int(req.ConsumerId)),
+		client:   clientIdentity(stream.Context()),
+		limit:    int(req.MaxMessages),
+		done:     make(chan struct{}),
+	}
+	logger := qs.config.Logger().With("consumer", req.ConsumerId, "client", consumer.client)
+	logger.Info("consumer connected")
+	defer logger.Info("consumer disconnected")
 	_ = qs.serveMessages(consumer)
+	qs.mu.Lock()
 	qs.onlineConsumer = append(qs.onlineConsumer, consumer)
-	<-stream.Context().Done()
+	qs.mu.Unlock()
+	select {
+	case <-stream.Context().Done():
+	case <-consumer.done:
+	case <-qs.closing:
+		// Deliver what was enqueued up to the shutdown before ending the stream.
+		_ = qs.serveMessages(consumer)
+	}
 	closedConsumers := []uint64{req.ConsumerId}
-	qs.onlineConsumer = removeClosedConsumers(closedConsumers, qs.onlineConsumer)
+	qs.removeConsumers(closedConsumers)
 	return nil
 }
 
+// serveMessages sends the consumer every message it has not received yet.
+// A message is acknowledged for the consumer only once it has been sent,
+// so a failed send leaves the consumer's position untouched.
 func (qs *QueueServer) serveMessages(consumer *OnlineConsumer) error {
-	for {
-		msg, err := qs.queueService.Dequeue(int(consumer.id))
-		if err != nil {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	lastId := -1
+	defer func() {
+		if lastId >= 0 {
+			qs.consumerProgress.notify()
+		}
+		qs.replicateOffset(consumer.id, lastId)
+	}()
+	for consumer.limit == 0 || consumer.delivered < consumer.limit {
+		qs.catchUpSkipped(consumer)
+		if delay := qs.quotas.ConsumeDelay(consumer.client); delay > 0 {
+			qs.retryDelivery(consumer, delay)
 			break
 		}
-		err = consumer.stream.Send(&netinternal.QueueMessage{Message: msg})
+		start := time.Now()
+		record, err := consumer.iterator.Next()
 		if err != nil {
+			break
+		}
+		if err := qs.deliver(consumer, record); err != nil {
+			qs.config.Logger().Debug("failed to deliver message", "consumer", consumer.id, "message", record.Id, "error", err)
 			return err
 		}
+		qs.config.Metrics().Delivered(time.Since(start))
+		qs.quotas.ChargeConsume(consumer.client, len(record.Key)+len(record.Data))
+		qs.queueService.Ack(int(consumer.id), record.Id)
+		lastId = record.Id
+		consumer.delivered++
+		if consumer.delivered == consumer.limit {
+			close(consumer.done)
+		}
 	}
 
 	return nil
 }
 
+// deliver sends a message to the consumer in a span joining the trace stored in the headers of the message.
+func (qs *QueueServer) deliver(consumer *OnlineConsumer, record storage.Record) error {
+	ctx := tracing.Extract(consumer.stream.Context(), record.Headers)
+	_, span := qs.tracer.Start(ctx, "QueueServer.Deliver", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", qs.config.QueueName()),
+			attribute.Int("messaging.message.id", record.Id),
+			attribute.Int64("messaging.consumer.id", int64(consumer.id)),
+		))
+	defer span.End()
+	err := consumer.stream.Send(recordToProto(record))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return err
+}
+
+// Fetch reads messages from the given ID on, without moving any consumer. It reads the
+// local copy of the queue, so a follower may return fewer messages than its leader.
+func (qs *QueueServer) Fetch(ctx context.Context, req *netinternal.FetchRequest) (*netinternal.FetchResponse, error) {
+	client := clientIdentity(ctx)
+	iterator := qs.queueService.Queue().NewIterator(int(req.FromId))
+	response := &netinternal.FetchResponse{}
+	size := 0
+	for req.MaxMessages == 0 || uint64(len(response.Messages)) < req.MaxMessages {
+		if delay := qs.quotas.ConsumeDelay(client); delay > 0 {
+			if len(response.Messages) == 0 {
+				return nil, quotaExceeded(delay)
+			}
+			break
+		}
+		record, err := iterator.Next()
+		if err == storage.ErrNoMoreMessages {
+			break
+		}
+		if err != nil {
+			qs.config.Logger().Error("failed to fetch", "client", client, "message", iterator.NextId(), "error", err)
+			return nil, status.Errorf(codes.Internal, "failed to read message %d", iterator.NextId())
+		}
+		message := recordToProto(record)
+		messageSize := proto.Size(&netinternal.FetchResponse{Messages: []*netinternal.QueueMessage{message}})
+		if len(response.Messages) > 0 && size+messageSize > maxFetchBytes {
+			response.NextId = uint64(record.Id)
+			return response, nil
+		}
+		qs.quotas.ChargeConsume(client, len(record.Key)+len(record.Data))
+		response.Messages = append(response.Messages, message)
+		size += messageSize
+	}
+	response.NextId = uint64(iterator.NextId())
+	return response, nil
+}
+
+func recordToProto(record storage.Record) *netinternal.QueueMessage {
+	return &netinternal.QueueMessage{Id: uint64(record.Id), Message: record.Data, Key: record.Key, Headers: record.Headers}
+}
+
+// Run serves RPCs on the configured listen address until the server is shut down.
 func (qs *QueueServer) Run() error {
-	listener, err := net.Listen("tcp", ":50051")
+	listener, err := net.Listen("tcp", qs.config.ListenAddress())
 	if err != nil {
-		fmt.Printf("Error Creating listener %v\n", err)
-		return err
+		return fmt.Errorf("failed to listen on %s: %w", qs.config.ListenAddress(), err)
 	}
-	fmt.Println("Created Listener")
-	if err := qs.gpServer.Serve(listener); err != nil {
-		return nil
+	qs.config.Logger().Info("serving", "address", listener.Addr().String())
+	return qs.Serve(listener)
+}
+
+// Serve serves RPCs on the listener, replicating the leader's log meanwhile on a follower.
+// It returns once the server is shut down.
+func (qs *QueueServer) Serve(listener net.Listener) error {
+	if qs.follower != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		qs.replicating.Add(1)
+		go func() {
+			defer qs.replicating.Done()
+			qs.follower.Run(ctx)
+		}()
+		defer cancel()
 	}
-	return nil
+	return qs.gpServer.Serve(listener)
+}
+
+// Shutdown stops the server gracefully. It reports NOT_SERVING to health checks, stops
+// accepting RPCs, delivers the messages enqueued so far to the open ObserveQueue streams
+// and ends them, waits for the running RPCs to finish, then syncs the segments to disk and persists the consumer offsets.
+// When ctx is done before the RPCs finish, they are cancelled and the data is still synced.
+// Calling Shutdown again returns the result of the first call.
+func (qs *QueueServer) Shutdown(ctx context.Context) error {
+	qs.shutdownOnce.Do(func() {
+		qs.shutdownErr = qs.shutdown(ctx)
+	})
+	return qs.shutdownErr
+}
+
+func (qs *QueueServer) shutdown(ctx context.Context) error {
+	qs.health.Shutdown()
+	close(qs.closing)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		qs.gpServer.GracefulStop()
+	}()
+	var errs []error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		qs.gpServer.Stop()
+		<-stopped
+		errs = append(errs, fmt.Errorf("in-flight RPCs cancelled: %w", ctx.Err()))
+	}
+
+	qs.replicating.Wait()
+	if qs.node != nil {
+		errs = append(errs, qs.node.Shutdown())
+	}
+	qs.broadcasts.Wait()
+	qs.config.Metrics().Unregister(serverCollector{qs: qs})
+	errs = append(errs, qs.queueService.Close())
+	return errors.Join(errs...)
 }
@@ -0,0 +1,208 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/config"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	"ashishkujoy/queue/internal/tracing"
+	netinternal "ashishkujoy/queue/proto"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func createTempDir(suffix string) string {
+	dir := os.TempDir() + "/" + suffix
+	os.MkdirAll(dir, 0755)
+	return dir
+}
+
+func removeTempDir(suffix string) {
+	dir := os.TempDir() + "/" + suffix
+	os.RemoveAll(dir)
+}
+
+func newTestConfig(t *testing.T, name string) *config.Config {
+	t.Cleanup(func() {
+		removeTempDir(name)
+		removeTempDir(name + "Metadata")
+	})
+	return config.NewConfig(createTempDir(name), createTempDir(name+"Metadata"), 1024, time.Hour)
+}
+
+// startServer serves a queue server on a random localhost port and returns a client connected to it.
+func startServer(t *testing.T, cfg *config.Config) (*QueueServer, netinternal.QueueServiceClient) {
+	server, err := NewQueueServer(cfg)
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return server, netinternal.NewQueueServiceClient(conn)
+}
+
+func TestShutdownDrainsConsumersAndPersistsOffsets(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestShutdown")
+	server, client := startServer(t, cfg)
+
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1})
+	assert.NoError(t, err)
+	for _, message := range []string{"one", "two"} {
+		_, err := client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: []byte(message)})
+		assert.NoError(t, err)
+	}
+	for _, message := range []string{"one", "two"} {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(message), received.Message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, server.Shutdown(ctx))
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+	_, err = client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: []byte("three")})
+	assert.Error(t, err)
+	assert.NoError(t, server.Shutdown(ctx))
+
+	service, err := queueinternal.NewQueueService(cfg)
+	assert.NoError(t, err)
+	defer service.Close()
+	assert.Equal(t, 2, service.Queue().NextId())
+	assert.Equal(t, 1, service.ConsumerOffsets()[1])
+}
+
+func TestEnqueueAndDeliveryJoinTheProducerTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	cfg := newTestConfig(t, "ServerTestTracing").WithTracerProvider(provider)
+	_, client := startServer(t, cfg)
+
+	ctx, producerSpan := provider.Tracer("producer").Start(context.Background(), "produce")
+	_, err := client.Enqueue(tracing.InjectOutgoing(ctx), &netinternal.EnqueueRequest{Message: []byte("one")})
+	assert.NoError(t, err)
+	headers := map[string]string{"tenant": "acme"}
+	tracing.Inject(ctx, headers)
+	_, err = client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: []byte("two"), Headers: headers})
+	assert.NoError(t, err)
+	producerSpan.End()
+
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1})
+	assert.NoError(t, err)
+	for _, message := range []string{"one", "two"} {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(received.Message))
+		assert.Contains(t, received.Headers["traceparent"], producerSpan.SpanContext().TraceID().String())
+	}
+
+	spansNamed := func(name string) []sdktrace.ReadOnlySpan {
+		var spans []sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+	assert.Eventually(t, func() bool { return len(spansNamed("QueueServer.Deliver")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	enqueueSpans := spansNamed("QueueServer.Enqueue")
+	assert.Len(t, enqueueSpans, 2)
+	for i, span := range enqueueSpans {
+		assert.Equal(t, producerSpan.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, span.SpanContext().SpanID(), spansNamed("Queue.Enqueue")[i].Parent().SpanID())
+		assert.Equal(t, span.SpanContext().SpanID(), spansNamed("QueueServer.Deliver")[i].Parent().SpanID())
+	}
+}
+
+func TestObserveQueueEndsAfterMaxMessages(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestMaxMessages")
+	server, client := startServer(t, cfg)
+	for _, message := range []string{"one", "two", "three"} {
+		response, err := client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: []byte(message)})
+		assert.NoError(t, err)
+		assert.True(t, response.Success)
+	}
+
+	stream, err := client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1, MaxMessages: 2})
+	assert.NoError(t, err)
+	for _, id := range []uint64{0, 1} {
+		received, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, id, received.Id)
+	}
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1, server.queueService.ConsumerOffset(1))
+
+	stream, err = client.ObserveQueue(context.Background(), &netinternal.ObserveQueueRequest{ConsumerId: 1, MaxMessages: 1})
+	assert.NoError(t, err)
+	received, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(received.Message))
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFetchReadsWithoutMovingConsumers(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestFetch")
+	server, client := startServer(t, cfg)
+	for _, message := range []string{"one", "two", "three"} {
+		_, err := client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: []byte(message), Key: []byte("key-" + message)})
+		assert.NoError(t, err)
+	}
+
+	response, err := client.Fetch(context.Background(), &netinternal.FetchRequest{FromId: 1, MaxMessages: 1})
+	assert.NoError(t, err)
+	assert.Len(t, response.Messages, 1)
+	assert.Equal(t, uint64(1), response.Messages[0].Id)
+	assert.Equal(t, "two", string(response.Messages[0].Message))
+	assert.Equal(t, "key-two", string(response.Messages[0].Key))
+	assert.Equal(t, uint64(2), response.NextId)
+
+	response, err = client.Fetch(context.Background(), &netinternal.FetchRequest{FromId: response.NextId})
+	assert.NoError(t, err)
+	assert.Len(t, response.Messages, 1)
+	assert.Equal(t, "three", string(response.Messages[0].Message))
+	assert.Equal(t, uint64(3), response.NextId)
+
+	response, err = client.Fetch(context.Background(), &netinternal.FetchRequest{FromId: response.NextId})
+	assert.NoError(t, err)
+	assert.Empty(t, response.Messages)
+	assert.Equal(t, uint64(3), response.NextId)
+	assert.Equal(t, -1, server.queueService.ConsumerOffset(1))
+}
+
+func TestFetchResponsesFitTheDefaultReceiveLimitOfClients(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestFetchSize")
+	_, client := startServer(t, cfg)
+	message := bytes.Repeat([]byte("m"), 1<<20)
+	for range 5 {
+		_, err := client.Enqueue(context.Background(), &netinternal.EnqueueRequest{Message: message})
+		assert.NoError(t, err)
+	}
+
+	response, err := client.Fetch(context.Background(), &netinternal.FetchRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, response.Messages, 3)
+	assert.Equal(t, uint64(3), response.NextId)
+
+	response, err = client.Fetch(context.Background(), &netinternal.FetchRequest{FromId: response.NextId})
+	assert.NoError(t, err)
+	assert.Len(t, response.Messages, 2)
+	assert.Equal(t, uint64(5), response.NextId)
+}
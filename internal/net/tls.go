@@ -0,0 +1,187 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/cluster"
+	"ashishkujoy/queue/internal/config"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// reloadCheckInterval is how often a handshake checks whether the certificate files changed.
+var reloadCheckInterval = time.Second
+
+// certificateReloader provides the server certificate, and the CAs client certificates are
+// verified against, loading them again when their files change. Certificates are rotated by
+// replacing the files, new connections use the new certificate while open ones are unaffected.
+type certificateReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	logger       *slog.Logger
+
+	mu          sync.Mutex
+	checked     time.Time
+	modTimes    []time.Time
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+func newCertificateReloader(certFile string, keyFile string, clientCAFile string, logger *slog.Logger) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, logger: logger}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certificateReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// load reads the files, keeping the previous certificate when any of them is invalid.
+func (r *certificateReloader) load() error {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		clientCAs, err = loadCertPool(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+	r.certificate, r.clientCAs, r.modTimes = &certificate, clientCAs, modTimes
+	return nil
+}
+
+// changed reports whether any file was modified since it was loaded.
+func (r *certificateReloader) changed() bool {
+	for i, file := range r.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the certificate and client CAs, reloading them first when their files changed.
+func (r *certificateReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= reloadCheckInterval {
+		r.checked = time.Now()
+		if r.changed() {
+			if err := r.load(); err != nil {
+				r.logger.Warn("keeping the current TLS certificate", "cert_file", r.certFile, "error", err)
+			} else {
+				r.logger.Info("reloaded the TLS certificate", "cert_file", r.certFile)
+			}
+		}
+	}
+	return r.certificate, r.clientCAs
+}
+
+// configForClient is the tls.Config.GetConfigForClient of the server.
+func (r *certificateReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	certificate, clientCAs := r.current()
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*certificate},
+		NextProtos:   []string{"h2"},
+	}
+	if clientCAs != nil {
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// serverCredentials returns the transport credentials of the server, nil when TLS is not configured.
+func serverCredentials(cfg *config.Config) (credentials.TransportCredentials, error) {
+	if cfg.TLSCertFile() == "" {
+		return nil, nil
+	}
+	reloader, err := newCertificateReloader(cfg.TLSCertFile(), cfg.TLSKeyFile(), cfg.TLSClientCAFile(), cfg.Logger())
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: reloader.configForClient,
+	}), nil
+}
+
+// replicationCredentials returns the credentials a follower dials its leader with, nil when TLS is not configured.
+func replicationCredentials(cfg *config.Config) (credentials.TransportCredentials, error) {
+	if cfg.TLSCertFile() == "" {
+		return nil, nil
+	}
+	reloader, err := newCertificateReloader(cfg.TLSCertFile(), cfg.TLSKeyFile(), cfg.TLSClientCAFile(), cfg.Logger())
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(reloader.peerConfig()), nil
+}
+
+// raftTransportTLS returns the mutual TLS the members of a Raft cluster talk to each other over,
+// nil when TLS is not configured. Validate requires the client CAs along with TLS in a cluster.
+func raftTransportTLS(cfg *config.Config) (*cluster.TransportTLS, error) {
+	if cfg.TLSCertFile() == "" {
+		return nil, nil
+	}
+	reloader, err := newCertificateReloader(cfg.TLSCertFile(), cfg.TLSKeyFile(), cfg.TLSClientCAFile(), cfg.Logger())
+	if err != nil {
+		return nil, err
+	}
+	return &cluster.TransportTLS{
+		Server: &tls.Config{MinVersion: tls.VersionTLS12, GetConfigForClient: reloader.configForClient},
+		Client: reloader.peerConfig(),
+	}, nil
+}
+
+// peerConfig is the config a member dials another member of the deployment with. Members share
+// their CA, so the peer is verified against the client CAs and this member presents its own
+// certificate for mutual TLS.
+func (r *certificateReloader) peerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    r.clientCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			certificate, _ := r.current()
+			return certificate, nil
+		},
+	}
+}
@@ -0,0 +1,252 @@
+package netinternal
+
+import (
+	"ashishkujoy/queue/internal/cluster"
+	"ashishkujoy/queue/internal/config"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "queue test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{certificate: certificate, key: key, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for name signed by the CA and its key to dir, returning their paths.
+func (ca *testCA) issue(t *testing.T, dir string, name string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func (ca *testCA) writeCert(t *testing.T, dir string) string {
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, ca.certPEM, 0600))
+	return caFile
+}
+
+// dialTLS connects to the server at address, trusting the CA and presenting clientCert when not nil.
+func dialTLS(t *testing.T, address string, ca *testCA, clientCert *tls.Certificate) netinternal.QueueServiceClient {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return netinternal.NewQueueServiceClient(conn)
+}
+
+func serveTLS(t *testing.T, cfg *config.Config) string {
+	server, err := NewQueueServer(cfg)
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return listener.Addr().String()
+}
+
+func enqueueWith(client netinternal.QueueServiceClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := client.Enqueue(ctx, &netinternal.EnqueueRequest{Message: []byte("secret")})
+	return err
+}
+
+func TestServerAcceptsTLSClientsOnly(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", 2)
+	address := serveTLS(t, newTestConfig(t, "ServerTestTLS").WithTLS(certFile, keyFile))
+
+	assert.NoError(t, enqueueWith(dialTLS(t, address, ca, nil)))
+
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Error(t, enqueueWith(netinternal.NewQueueServiceClient(conn)))
+}
+
+func TestServerRequiresClientCertificatesWithMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", 2)
+	clientCertFile, clientKeyFile := ca.issue(t, dir, "client", 3)
+	cfg := newTestConfig(t, "ServerTestMutualTLS").WithTLS(certFile, keyFile).WithTLSClientCA(ca.writeCert(t, dir))
+	address := serveTLS(t, cfg)
+
+	assert.Error(t, enqueueWith(dialTLS(t, address, ca, nil)))
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	assert.NoError(t, err)
+	assert.NoError(t, enqueueWith(dialTLS(t, address, ca, &clientCert)))
+
+	otherCA := newTestCA(t)
+	otherCertFile, otherKeyFile := otherCA.issue(t, t.TempDir(), "client", 4)
+	otherCert, err := tls.LoadX509KeyPair(otherCertFile, otherKeyFile)
+	assert.NoError(t, err)
+	assert.Error(t, enqueueWith(dialTLS(t, address, ca, &otherCert)))
+}
+
+func TestCertificateReloadsWhenItsFilesChange(t *testing.T) {
+	previousInterval := reloadCheckInterval
+	reloadCheckInterval = 0
+	defer func() { reloadCheckInterval = previousInterval }()
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", 2)
+	reloader, err := newCertificateReloader(certFile, keyFile, "", slog.Default())
+	assert.NoError(t, err)
+	serial := func() int64 {
+		certificate, _ := reloader.current()
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		assert.NoError(t, err)
+		return leaf.SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(2), serial())
+
+	ca.issue(t, dir, "server", 5)
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	assert.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, int64(5), serial())
+
+	// A broken certificate file is ignored until it is fixed.
+	assert.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0600))
+	evenLater := later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, evenLater, evenLater))
+	assert.Equal(t, int64(5), serial())
+}
+
+func TestClusterMembersTalkRaftOverMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := ca.writeCert(t, dir)
+	var peers []config.ClusterPeer
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		listener.Close()
+		peers = append(peers, config.ClusterPeer{
+			Id:            fmt.Sprintf("node-%d", i),
+			RaftAddress:   listener.Addr().String(),
+			ClientAddress: fmt.Sprintf("client-%d:50051", i),
+		})
+	}
+	nodes := make([]*cluster.Node, len(peers))
+	services := make([]*queueinternal.QueueService, len(peers))
+	for i, peer := range peers {
+		certFile, keyFile := ca.issue(t, dir, peer.Id, int64(i+2))
+		cfg := newTestConfig(t, fmt.Sprintf("ServerTestRaftTLS%d", i)).
+			WithTLS(certFile, keyFile).
+			WithTLSClientCA(caFile).
+			WithNodeId(peer.Id).
+			WithClusterPeers(peers)
+		transportTLS, err := raftTransportTLS(cfg)
+		assert.NoError(t, err)
+		services[i], err = queueinternal.NewQueueService(cfg)
+		assert.NoError(t, err)
+		nodes[i], err = cluster.NewNode(cfg, services[i], transportTLS, nil)
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			nodes[i].Shutdown()
+			services[i].Close()
+		})
+	}
+
+	var leader *cluster.Node
+	assert.Eventually(t, func() bool {
+		for _, node := range nodes {
+			if node.IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err := leader.Enqueue(nil, nil, []byte("secret"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return services[0].Queue().NextId() == services[1].Queue().NextId()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	conn, err := tls.Dial("tcp", peers[0].RaftAddress, &tls.Config{RootCAs: roots})
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err, "a member without a client certificate must be rejected")
+}
+
+func TestClusterWithAnAuthPolicyRequiresTLS(t *testing.T) {
+	cfg := newTestConfig(t, "ServerTestRaftAuth").
+		WithAuthPolicy(testPolicy).
+		WithNodeId("node-0").
+		WithClusterPeers([]config.ClusterPeer{{Id: "node-0", RaftAddress: "127.0.0.1:0", ClientAddress: "client-0:50051"}})
+	_, err := NewQueueServer(cfg)
+	assert.ErrorContains(t, err, "needs mutual TLS")
+}
@@ -3,13 +3,30 @@ package queueinternal
 import (
 	"ashishkujoy/queue/internal/config"
 	"ashishkujoy/queue/internal/storage"
+	"ashishkujoy/queue/internal/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Queue stores messages in the LogStorage backend chosen by config.StorageBackend.
 type Queue struct {
+	log storage.LogStorage
+	// segments and index are set for the segments backend, whose segments the queue describes.
 	segments *storage.Segments
+	index    *storage.Index
+	tracer   trace.Tracer
 }
 
 func NewQueue(cfg *config.Config) (*Queue, error) {
+	if cfg.StorageBackend() != config.StorageSegments {
+		return openQueue(cfg)
+	}
 	index, err := storage.NewIndex(cfg)
 	if err != nil {
 		return nil, err
@@ -18,10 +35,13 @@ func NewQueue(cfg *config.Config) (*Queue, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Queue{segments: segments}, nil
+	return newSegmentsQueue(cfg, segments, index), nil
 }
 
 func RestoreQueue(cfg *config.Config) (*Queue, error) {
+	if cfg.StorageBackend() != config.StorageSegments {
+		return openQueue(cfg)
+	}
 	index, err := storage.RestoreIndex(cfg)
 	if err != nil {
 		return nil, err
@@ -30,17 +50,221 @@ func RestoreQueue(cfg *config.Config) (*Queue, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Queue{segments: segments}, nil
+	return newSegmentsQueue(cfg, segments, index), nil
+}
+
+func newSegmentsQueue(cfg *config.Config, segments *storage.Segments, index *storage.Index) *Queue {
+	return &Queue{log: segments, segments: segments, index: index, tracer: cfg.TracerProvider().Tracer(tracing.InstrumentationName)}
+}
+
+// openQueue opens a queue on the memory or kv backend, which store new and restored queues alike.
+func openQueue(cfg *config.Config) (*Queue, error) {
+	var log storage.LogStorage
+	switch backend := cfg.StorageBackend(); backend {
+	case config.StorageMemory:
+		log = storage.NewMemoryLog(cfg)
+	case config.StorageKV:
+		kvLog, err := storage.OpenKVLog(cfg)
+		if err != nil {
+			return nil, err
+		}
+		log = kvLog
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+	return &Queue{log: log, tracer: cfg.TracerProvider().Tracer(tracing.InstrumentationName)}, nil
 }
 
 func (q *Queue) Enqueue(data []byte) (int, error) {
-	return q.segments.Append(data)
+	return q.EnqueueWithKey(nil, data)
+}
+
+// EnqueueWithKey appends a message tagged with a key, see Segments.AppendWithKey.
+func (q *Queue) EnqueueWithKey(key []byte, data []byte) (int, error) {
+	messageIds, err := q.log.AppendRecords([]storage.Record{{Key: key, Data: data}})
+	if err != nil {
+		return 0, err
+	}
+	return messageIds[0], nil
+}
+
+// EnqueueWithHeaders appends a message tagged with a key and stored along with headers,
+// recording the append as a span of the trace of ctx.
+func (q *Queue) EnqueueWithHeaders(ctx context.Context, key []byte, headers map[string]string, data []byte) (int, error) {
+	_, span := q.tracer.Start(ctx, "Queue.Enqueue", trace.WithAttributes(attribute.Int("messaging.message.body.size", len(data))))
+	defer span.End()
+	messageIds, err := q.log.AppendRecords([]storage.Record{{Key: key, Headers: headers, Data: data}})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int("messaging.message.id", messageIds[0]))
+	return messageIds[0], nil
+}
+
+// EnqueueBatch appends all the messages with a single write and returns their IDs.
+func (q *Queue) EnqueueBatch(data [][]byte) ([]int, error) {
+	records := make([]storage.Record, len(data))
+	for i := range data {
+		records[i] = storage.Record{Data: data[i]}
+	}
+	return q.log.AppendRecords(records)
 }
 
 func (q *Queue) Dequeue(id int) ([]byte, error) {
-	return q.segments.Read(id)
+	return q.log.Read(id)
+}
+
+// NewIterator returns an iterator streaming messages starting at the message with ID fromId.
+func (q *Queue) NewIterator(fromId int) storage.Iterator {
+	return q.log.NewIterator(fromId)
+}
+
+// NextId returns the ID the next enqueued message will be assigned.
+func (q *Queue) NextId() int {
+	return q.log.NextId()
+}
+
+// AppendReplicated appends records replicated from a leader under their original IDs.
+func (q *Queue) AppendReplicated(records []storage.Record) error {
+	return q.log.AppendReplicated(records)
+}
+
+// Sync flushes the messages enqueued so far to disk.
+func (q *Queue) Sync() error {
+	return q.log.Flush()
+}
+
+// Compact drops the messages superseded by a newer message with the same key
+// and returns how many were dropped. Backends that cannot be compacted drop none.
+func (q *Queue) Compact() (int, error) {
+	if compactor, ok := q.log.(storage.Compactor); ok {
+		return compactor.Compact()
+	}
+	return 0, nil
+}
+
+// Truncate drops messages with IDs below beforeId and returns how many were dropped, see LogStorage.Truncate.
+func (q *Queue) Truncate(beforeId int) (int, error) {
+	return q.log.Truncate(beforeId)
+}
+
+// Purge drops every message of the queue and returns how many were dropped, see Segments.Purge.
+func (q *Queue) Purge() (int, error) {
+	return q.log.Truncate(math.MaxInt)
 }
 
+// Export calls fn with every message stored from fromId up to, but excluding, toId, in ID order,
+// and returns how many there were. A toId of 0 or less exports up to the newest message when
+// the export starts, so messages enqueued meanwhile are left out.
+func (q *Queue) Export(fromId, toId int, fn func(storage.Record) error) (int, error) {
+	if toId <= 0 {
+		toId = q.NextId()
+	}
+	exported := 0
+	iterator := q.NewIterator(fromId)
+	for {
+		record, err := iterator.Next()
+		if err == storage.ErrNoMoreMessages || (err == nil && record.Id >= toId) {
+			return exported, nil
+		}
+		if err != nil {
+			return exported, err
+		}
+		if err := fn(record); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+}
+
+// Snapshot writes a copy of the queue as it is at the time of the call to the directories of target,
+// which RestoreQueue restores, see Segments.Snapshot. Backends that cannot be copied, such as the
+// memory backend, return an error wrapping errors.ErrUnsupported.
+func (q *Queue) Snapshot(target *config.Config) (storage.SnapshotInfo, error) {
+	snapshotter, err := q.snapshotter()
+	if err != nil {
+		return storage.SnapshotInfo{}, err
+	}
+	return snapshotter.Snapshot(target)
+}
+
+func (q *Queue) snapshotter() (storage.Snapshotter, error) {
+	if snapshotter, ok := q.log.(storage.Snapshotter); ok {
+		return snapshotter, nil
+	}
+	return nil, fmt.Errorf("snapshot of a %T: %w", q.log, errors.ErrUnsupported)
+}
+
+// Description lists the messages a queue holds and where they are stored.
+type Description struct {
+	// HeadId is the ID of the oldest message stored and TailId of the newest, both -1 when the queue is empty.
+	HeadId int
+	TailId int
+	// NextId is the ID the next enqueued message will be assigned.
+	NextId int
+	// Messages is the number of messages stored, SizeInBytes the space they take on local disk.
+	Messages    int
+	SizeInBytes int
+	// Segments is only set for the segments backend.
+	Segments []storage.SegmentInfo
+}
+
+// Describe returns the range of the messages of the queue and its segments.
+func (q *Queue) Describe() Description {
+	if q.segments == nil {
+		return Description{
+			HeadId:      q.log.Head(),
+			TailId:      q.log.Tail(),
+			NextId:      q.log.NextId(),
+			Messages:    q.log.Len(),
+			SizeInBytes: q.log.SizeInBytes(),
+		}
+	}
+	description := Description{HeadId: -1, TailId: -1, NextId: q.segments.NextId(), Segments: q.segments.Describe()}
+	for _, segment := range description.Segments {
+		description.SizeInBytes += segment.SizeInBytes
+		if segment.Messages == 0 {
+			continue
+		}
+		if description.Messages == 0 {
+			description.HeadId = segment.FirstId
+		}
+		description.Messages += segment.Messages
+		description.TailId = segment.LastId
+	}
+	return description
+}
+
+// Stats describes the storage of a queue.
+type Stats struct {
+	storage.SegmentStats
+	// IndexEntries is the number of messages in the index, IndexSizeInBytes the size of its file.
+	IndexEntries     int
+	IndexSizeInBytes int
+	// NextId is the ID the next enqueued message will be assigned.
+	NextId int
+}
+
+// Stats returns the sizes of the segments and the index. Other backends only report
+// the number of messages, as IndexEntries.
+func (q *Queue) Stats() Stats {
+	if q.segments == nil {
+		return Stats{IndexEntries: q.log.Len(), NextId: q.log.NextId()}
+	}
+	return Stats{
+		SegmentStats:     q.segments.Stats(),
+		IndexEntries:     q.index.Len(),
+		IndexSizeInBytes: q.index.SizeInBytes(),
+		NextId:           q.segments.NextId(),
+	}
+}
+
+// Close syncs the messages to disk and closes the backend.
 func (q *Queue) Close() error {
-	return q.segments.Close()
+	if q.index == nil {
+		return q.log.Close()
+	}
+	return errors.Join(q.log.Close(), q.index.Close())
 }
@@ -3,19 +3,39 @@ package queueinternal
 import (
 	"ashishkujoy/queue/internal/config"
 	"ashishkujoy/queue/internal/consumer"
+	"ashishkujoy/queue/internal/storage"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
 )
 
+// Directories of a snapshot holding the segments and the metadata, the message index and consumer offsets.
+const (
+	SnapshotSegmentsDir = "segments"
+	SnapshotMetadataDir = "metadata"
+)
+
+// ErrSnapshotDirNotEmpty is returned when a snapshot is written to a directory holding files already.
+var ErrSnapshotDirNotEmpty = errors.New("snapshot directory is not empty")
+
 type QueueService struct {
 	queue         *Queue
 	consumerIndex *consumer.ConsumerIndex
 }
 
-func NewQueueService(config *config.Config) (*QueueService, error) {
-	queue, err := RestoreQueue(config)
+func NewQueueService(cfg *config.Config) (*QueueService, error) {
+	queue, err := RestoreQueue(cfg)
 	if err != nil {
 		return nil, err
 	}
-	consumerIndex, err := consumer.RestoreConsumerIndex(config)
+	restoreConsumerIndex := consumer.RestoreConsumerIndex
+	if cfg.StorageBackend() == config.StorageMemory {
+		// The messages of the memory backend are gone, offsets restored would point past the new ones.
+		restoreConsumerIndex = consumer.NewConsumerIndex
+	}
+	consumerIndex, err := restoreConsumerIndex(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -31,6 +51,23 @@ func (qs *QueueService) Enqueue(data []byte) error {
 	return err
 }
 
+// EnqueueWithKey enqueues a message of a compacted queue, where only the newest message of each key is kept.
+// It returns the ID assigned to the message.
+func (qs *QueueService) EnqueueWithKey(key []byte, data []byte) (int, error) {
+	return qs.queue.EnqueueWithKey(key, data)
+}
+
+// EnqueueWithHeaders enqueues a message tagged with a key and stored along with headers,
+// tracing the append under ctx. It returns the ID assigned to the message.
+func (qs *QueueService) EnqueueWithHeaders(ctx context.Context, key []byte, headers map[string]string, data []byte) (int, error) {
+	return qs.queue.EnqueueWithHeaders(ctx, key, headers, data)
+}
+
+// Queue returns the queue the service serves.
+func (qs *QueueService) Queue() *Queue {
+	return qs.queue
+}
+
 func (qs *QueueService) Dequeue(consumerId int) ([]byte, error) {
 	index := qs.consumerIndex.ReadIndex(consumerId)
 	data, err := qs.queue.Dequeue(index + 1)
@@ -41,15 +78,152 @@ func (qs *QueueService) Dequeue(consumerId int) ([]byte, error) {
 	return data, nil
 }
 
+// NewConsumerIterator returns an iterator positioned right after the last message
+// delivered to the consumer. Messages read from it are not marked as delivered,
+// callers report successful delivery with Ack.
+func (qs *QueueService) NewConsumerIterator(consumerId int) storage.Iterator {
+	index := qs.consumerIndex.ReadIndex(consumerId)
+	return qs.queue.NewIterator(index + 1)
+}
+
+// Ack records that the message with the given ID has been delivered to the consumer.
+// Acknowledging a message older than the consumer's offset leaves the offset as it is.
+func (qs *QueueService) Ack(consumerId, messageId int) {
+	qs.consumerIndex.AdvanceIndex(consumerId, messageId)
+}
+
+// ConsumerOffset returns the last message ID delivered to the consumer, -1 when it has received none.
+// An unknown consumer is not registered.
+func (qs *QueueService) ConsumerOffset(consumerId int) int {
+	offset, _ := qs.consumerIndex.LookupIndex(consumerId)
+	return offset
+}
+
+// HasConsumer reports whether the consumer has observed the queue or been positioned.
+func (qs *QueueService) HasConsumer(consumerId int) bool {
+	_, exists := qs.consumerIndex.LookupIndex(consumerId)
+	return exists
+}
+
+// ConsumerOffsets returns the last message ID delivered to every consumer.
+func (qs *QueueService) ConsumerOffsets() map[int]int {
+	return qs.consumerIndex.Offsets()
+}
+
+// Backlog returns how many messages the slowest consumer has not received yet,
+// counted from its offset to the newest message. A queue without consumers has no backlog.
+func (qs *QueueService) Backlog() int {
+	nextId := qs.queue.NextId()
+	backlog := 0
+	for _, offset := range qs.consumerIndex.Offsets() {
+		backlog = max(backlog, nextId-offset-1)
+	}
+	return backlog
+}
+
+// SkipBacklog moves the offset of every consumer lagging more than limit messages forward,
+// skipping its oldest messages so it lags limit messages. It returns the new offsets of the moved consumers.
+// The skipped messages are not removed from the queue.
+func (qs *QueueService) SkipBacklog(limit int) map[int]int {
+	offset := qs.queue.NextId() - limit - 1
+	moved := make(map[int]int)
+	for consumerId := range qs.consumerIndex.Offsets() {
+		if qs.consumerIndex.AdvanceIndex(consumerId, offset) {
+			moved[consumerId] = offset
+		}
+	}
+	return moved
+}
+
+// ConsumerState is the position of a consumer in the queue.
+type ConsumerState struct {
+	Id int
+	// Offset is the last message ID delivered to the consumer, -1 when it has received none.
+	Offset int
+	// Lag is the number of messages enqueued after Offset.
+	Lag int
+}
+
+// Consumers returns the position of every known consumer, ordered by ID.
+func (qs *QueueService) Consumers() []ConsumerState {
+	nextId := qs.queue.NextId()
+	var consumers []ConsumerState
+	for consumerId, offset := range qs.consumerIndex.Offsets() {
+		consumers = append(consumers, ConsumerState{Id: consumerId, Offset: offset, Lag: max(nextId-offset-1, 0)})
+	}
+	slices.SortFunc(consumers, func(a, b ConsumerState) int { return a.Id - b.Id })
+	return consumers
+}
+
+// ResetConsumer moves the consumer so the next message delivered to it is the first
+// message with an ID of at least messageId, backwards as well as forwards.
+func (qs *QueueService) ResetConsumer(consumerId, messageId int) {
+	qs.consumerIndex.WriteIndex(consumerId, messageId-1)
+}
+
+// Purge drops every message of the queue and moves every consumer past them.
+// It returns the number of messages dropped.
+// A message enqueued concurrently is either dropped or delivered.
+func (qs *QueueService) Purge() (int, error) {
+	offset := qs.queue.NextId() - 1
+	dropped, err := qs.queue.Purge()
+	if err != nil {
+		return dropped, err
+	}
+	for consumerId := range qs.consumerIndex.Offsets() {
+		qs.consumerIndex.AdvanceIndex(consumerId, offset)
+	}
+	return dropped, nil
+}
+
+// Snapshot describes a snapshot written by QueueService.Snapshot.
+type Snapshot struct {
+	storage.SnapshotInfo
+	// Consumers is the number of consumers whose offsets the snapshot holds.
+	Consumers int
+}
+
+// Snapshot writes a point-in-time copy of the queue and the consumer offsets to dir, which is created
+// when missing and must be empty otherwise. A server started with the segments and metadata directories
+// of dir, and the same keyring, restores the queue as it was when the snapshot was taken.
+// The offsets are copied first, so they never point past the messages of the snapshot;
+// a consumer acknowledging messages meanwhile receives them again from the snapshot.
+func (qs *QueueService) Snapshot(dir string) (Snapshot, error) {
+	if _, err := qs.queue.snapshotter(); err != nil {
+		return Snapshot{}, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Snapshot{}, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(entries) != 0 {
+		return Snapshot{}, ErrSnapshotDirNotEmpty
+	}
+	target := config.NewConfig(filepath.Join(dir, SnapshotSegmentsDir), filepath.Join(dir, SnapshotMetadataDir), 0, 0)
+	if err := os.MkdirAll(target.MetadataPath, 0755); err != nil {
+		return Snapshot{}, err
+	}
+	consumers, err := qs.consumerIndex.SnapshotTo(target)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	info, err := qs.queue.Snapshot(target)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{SnapshotInfo: info, Consumers: consumers}, nil
+}
+
 func (qs *QueueService) RevertDequeue(consumerId int) {
 	index := qs.consumerIndex.ReadIndex(consumerId)
 	qs.consumerIndex.WriteIndex(consumerId, index-1)
 }
 
+// Close syncs the queue to disk and persists the consumer offsets.
+// The offsets are persisted even when closing the queue fails.
 func (qs *QueueService) Close() error {
-	err := qs.queue.Close()
-	if err != nil {
-		return err
-	}
-	return qs.consumerIndex.Close()
+	return errors.Join(qs.queue.Close(), qs.consumerIndex.Close())
 }
@@ -3,6 +3,7 @@ package queueinternal
 import (
 	"ashishkujoy/queue/internal/config"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -74,3 +75,101 @@ func TestDequeueFromARestoredQueue(t *testing.T) {
 	data, _ = queueService.Dequeue(1)
 	assert.Equal(t, []byte("Hello World 3"), data)
 }
+
+func TestBacklogOfTheSlowestConsumer(t *testing.T) {
+	segmentPath := createTempDir("testBacklog/segments")
+	metaDataPath := createTempDir("testBacklog/metadata")
+	defer os.RemoveAll(segmentPath)
+	defer os.RemoveAll(metaDataPath)
+	cfg := config.NewConfig(segmentPath, metaDataPath, 1024, time.Second)
+
+	queueService, err := NewQueueService(cfg)
+	assert.NoError(t, err)
+	defer queueService.Close()
+
+	for _, message := range []string{"one", "two", "three", "four"} {
+		assert.NoError(t, queueService.Enqueue([]byte(message)))
+	}
+	assert.Equal(t, 0, queueService.Backlog())
+
+	queueService.NewConsumerIterator(1)
+	queueService.Ack(2, 2)
+	assert.Equal(t, 4, queueService.Backlog())
+
+	assert.Equal(t, map[int]int{1: 1}, queueService.SkipBacklog(2))
+	assert.Equal(t, 2, queueService.Backlog())
+	record, err := queueService.NewConsumerIterator(1).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, record.Id)
+
+	queueService.Ack(2, 0)
+	assert.Equal(t, 2, queueService.ConsumerOffset(2))
+}
+
+func TestResetAndPurgeMoveConsumers(t *testing.T) {
+	segmentPath := createTempDir("testResetConsumer/segments")
+	metaDataPath := createTempDir("testResetConsumer/metadata")
+	defer os.RemoveAll(segmentPath)
+	defer os.RemoveAll(metaDataPath)
+	cfg := config.NewConfig(segmentPath, metaDataPath, 1024, time.Second)
+
+	queueService, err := NewQueueService(cfg)
+	assert.NoError(t, err)
+	defer queueService.Close()
+	for _, message := range []string{"one", "two", "three", "four"} {
+		assert.NoError(t, queueService.Enqueue([]byte(message)))
+	}
+	queueService.Ack(2, 3)
+	queueService.Ack(1, 1)
+	assert.Equal(t, []ConsumerState{{Id: 1, Offset: 1, Lag: 2}, {Id: 2, Offset: 3, Lag: 0}}, queueService.Consumers())
+
+	queueService.ResetConsumer(2, 1)
+	record, err := queueService.NewConsumerIterator(2).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, record.Id)
+
+	dropped, err := queueService.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, dropped)
+	assert.Equal(t, []ConsumerState{{Id: 1, Offset: 3, Lag: 0}, {Id: 2, Offset: 3, Lag: 0}}, queueService.Consumers())
+	description := queueService.Queue().Describe()
+	assert.Equal(t, 0, description.Messages)
+	assert.Equal(t, -1, description.HeadId)
+	assert.Equal(t, -1, description.TailId)
+	assert.Equal(t, 4, description.NextId)
+}
+
+func TestSnapshotRestoresMessagesAndConsumerOffsets(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second)
+	assert.NoError(t, os.MkdirAll(cfg.SegmentsRoot(), 0755))
+	assert.NoError(t, os.MkdirAll(cfg.MetadataPath, 0755))
+
+	queueService, err := NewQueueService(cfg)
+	assert.NoError(t, err)
+	defer queueService.Close()
+	for _, message := range []string{"one", "two", "three", "four"} {
+		assert.NoError(t, queueService.Enqueue([]byte(message)))
+	}
+	queueService.Ack(1, 1)
+	queueService.Ack(2, 3)
+
+	snapshotDir := filepath.Join(dir, "snapshot")
+	snapshot, err := queueService.Snapshot(snapshotDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, snapshot.NextId)
+	assert.Equal(t, 2, snapshot.Consumers)
+	assert.NoError(t, queueService.Enqueue([]byte("five")))
+	queueService.Ack(1, 4)
+	_, err = queueService.Snapshot(snapshotDir)
+	assert.ErrorIs(t, err, ErrSnapshotDirNotEmpty)
+
+	restored, err := NewQueueService(config.NewConfig(filepath.Join(snapshotDir, SnapshotSegmentsDir), filepath.Join(snapshotDir, SnapshotMetadataDir), 64, time.Second))
+	assert.NoError(t, err)
+	defer restored.Close()
+	assert.Equal(t, []ConsumerState{{Id: 1, Offset: 1, Lag: 2}, {Id: 2, Offset: 3, Lag: 0}}, restored.Consumers())
+	data, err := restored.Dequeue(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("three"), data)
+	assert.Equal(t, 4, restored.Queue().NextId())
+}
@@ -2,7 +2,11 @@ package queueinternal
 
 import (
 	"ashishkujoy/queue/internal/config"
+	"ashishkujoy/queue/internal/storage"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -39,3 +43,115 @@ func TestEnqueueSingleElement(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, data, []byte("First Message"))
 }
+
+func TestDescribeSumsUpTheSegments(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestDescribeQueue"),
+		createTempDir("metadataDescribeQueue"),
+		64,
+		time.Second)
+	defer removeTempDir("TestDescribeQueue")
+	defer removeTempDir("metadataDescribeQueue")
+	queue, err := NewQueue(cfg)
+	assert.NoError(t, err)
+	defer queue.Close()
+	for _, message := range []string{"first message", "second message", "third message"} {
+		_, err := queue.Enqueue([]byte(message))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, queue.Sync())
+
+	description := queue.Describe()
+	assert.Equal(t, 0, description.HeadId)
+	assert.Equal(t, 2, description.TailId)
+	assert.Equal(t, 3, description.NextId)
+	assert.Equal(t, 3, description.Messages)
+	assert.Greater(t, len(description.Segments), 1)
+	size := 0
+	for _, segment := range description.Segments {
+		size += segment.SizeInBytes
+	}
+	assert.Equal(t, size, description.SizeInBytes)
+}
+
+func TestExportAMessageRange(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestExportQueue"),
+		createTempDir("metadataExportQueue"),
+		64,
+		time.Second)
+	defer removeTempDir("TestExportQueue")
+	defer removeTempDir("metadataExportQueue")
+	queue, err := NewQueue(cfg)
+	assert.NoError(t, err)
+	defer queue.Close()
+	for i := 0; i < 5; i++ {
+		_, err := queue.Enqueue([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+
+	var exported []string
+	collect := func(record storage.Record) error {
+		exported = append(exported, string(record.Data))
+		return nil
+	}
+	count, err := queue.Export(1, 3, collect)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"message 1", "message 2"}, exported)
+
+	exported = nil
+	count, err = queue.Export(3, 0, collect)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"message 3", "message 4"}, exported)
+}
+
+func TestQueueOnTheMemoryAndKVBackends(t *testing.T) {
+	for _, backend := range []string{config.StorageMemory, config.StorageKV} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second).
+				WithStorageBackend(backend)
+			queue, err := NewQueue(cfg)
+			assert.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				_, err := queue.Enqueue([]byte(fmt.Sprintf("message %d", i)))
+				assert.NoError(t, err)
+			}
+			messageIds, err := queue.EnqueueBatch([][]byte{[]byte("message 3"), []byte("message 4")})
+			assert.NoError(t, err)
+			assert.Equal(t, []int{3, 4}, messageIds)
+
+			description := queue.Describe()
+			assert.Equal(t, 0, description.HeadId)
+			assert.Equal(t, 4, description.TailId)
+			assert.Equal(t, 5, description.Messages)
+			assert.Empty(t, description.Segments)
+			assert.Equal(t, 5, queue.Stats().IndexEntries)
+			compacted, err := queue.Compact()
+			assert.NoError(t, err)
+			assert.Equal(t, 0, compacted)
+			dropped, err := queue.Purge()
+			assert.NoError(t, err)
+			assert.Equal(t, 5, dropped)
+			_, err = queue.Enqueue([]byte("message 5"))
+			assert.NoError(t, err)
+			assert.NoError(t, queue.Close())
+
+			queue, err = RestoreQueue(cfg)
+			assert.NoError(t, err)
+			defer queue.Close()
+			if backend == config.StorageMemory {
+				assert.Equal(t, 0, queue.NextId())
+				_, err = queue.Snapshot(cfg)
+				assert.ErrorIs(t, err, errors.ErrUnsupported)
+				return
+			}
+			assert.Equal(t, 6, queue.NextId())
+			data, err := queue.Dequeue(5)
+			assert.NoError(t, err)
+			assert.Equal(t, "message 5", string(data))
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package quota
+
+import (
+	"math"
+	"time"
+)
+
+// bucket is a token bucket refilled at rate tokens per second, holding at most one second of tokens.
+// A request larger than the bucket is allowed once the bucket is full and leaves it in debt,
+// so a single large message delays the following ones instead of never passing.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	return &bucket{rate: rate, tokens: rate, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.rate, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// wait returns how long until the bucket holds enough tokens for n, zero when it already does.
+func (b *bucket) wait(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	needed := math.Min(n, b.rate)
+	if b.tokens >= needed {
+		return 0
+	}
+	return time.Duration((needed - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take(n float64) {
+	b.tokens -= n
+}
@@ -0,0 +1,161 @@
+// Package quota limits the rate at which clients enqueue and receive messages.
+package quota
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits caps a flow of messages. A zero rate is unlimited.
+type Limits struct {
+	MessagesPerSecond float64
+	BytesPerSecond    float64
+}
+
+// IsUnlimited reports whether the limits let any flow through.
+func (l Limits) IsUnlimited() bool {
+	return l.MessagesPerSecond <= 0 && l.BytesPerSecond <= 0
+}
+
+// ClientQuotas overrides the default limits of one client.
+type ClientQuotas struct {
+	Produce Limits
+	Consume Limits
+}
+
+// Quotas are the limits a server enforces.
+type Quotas struct {
+	// Produce and Consume are the limits of every client without an override in Clients.
+	Produce Limits
+	Consume Limits
+	// Queue caps the messages enqueued by all clients together.
+	Queue Limits
+	// Clients overrides the default limits per client identity.
+	Clients map[string]ClientQuotas
+}
+
+func (q *Quotas) produceLimits(client string) Limits {
+	if override, ok := q.Clients[client]; ok {
+		return override.Produce
+	}
+	return q.Produce
+}
+
+func (q *Quotas) consumeLimits(client string) Limits {
+	if override, ok := q.Clients[client]; ok {
+		return override.Consume
+	}
+	return q.Consume
+}
+
+// flow is the pair of buckets enforcing a Limits.
+type flow struct {
+	messages *bucket
+	bytes    *bucket
+}
+
+func newFlow(limits Limits, now time.Time) *flow {
+	f := &flow{}
+	if limits.MessagesPerSecond > 0 {
+		f.messages = newBucket(limits.MessagesPerSecond, now)
+	}
+	if limits.BytesPerSecond > 0 {
+		f.bytes = newBucket(limits.BytesPerSecond, now)
+	}
+	return f
+}
+
+func (f *flow) wait(messages int, bytes int, now time.Time) time.Duration {
+	var wait time.Duration
+	if f.messages != nil {
+		wait = max(wait, f.messages.wait(float64(messages), now))
+	}
+	if f.bytes != nil {
+		wait = max(wait, f.bytes.wait(float64(bytes), now))
+	}
+	return wait
+}
+
+func (f *flow) take(messages int, bytes int) {
+	if f.messages != nil {
+		f.messages.take(float64(messages))
+	}
+	if f.bytes != nil {
+		f.bytes.take(float64(bytes))
+	}
+}
+
+// Manager enforces quotas per client identity and per queue.
+// Its quotas can be replaced at runtime, which resets every bucket.
+type Manager struct {
+	mu      sync.Mutex
+	quotas  Quotas
+	queue   *flow
+	produce map[string]*flow
+	consume map[string]*flow
+	now     func() time.Time
+}
+
+func NewManager(quotas Quotas) *Manager {
+	m := &Manager{now: time.Now}
+	m.Set(quotas)
+	return m
+}
+
+// Quotas returns the quotas currently enforced.
+func (m *Manager) Quotas() Quotas {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.quotas
+}
+
+// Set replaces the quotas, starting every client with full buckets.
+func (m *Manager) Set(quotas Quotas) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas = quotas
+	m.queue = newFlow(quotas.Queue, m.now())
+	m.produce = make(map[string]*flow)
+	m.consume = make(map[string]*flow)
+}
+
+// AllowProduce takes a message of the given size from the quotas of the client and the queue.
+// When either is exhausted nothing is taken and it returns how long to wait before retrying.
+func (m *Manager) AllowProduce(client string, bytes int) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	clientFlow := m.flowOf(m.produce, client, m.quotas.produceLimits(client), now)
+	wait := max(clientFlow.wait(1, bytes, now), m.queue.wait(1, bytes, now))
+	if wait > 0 {
+		return wait, false
+	}
+	clientFlow.take(1, bytes)
+	m.queue.take(1, bytes)
+	return 0, true
+}
+
+// ConsumeDelay returns how long delivery to the client must pause, zero when a message may be delivered now.
+// The size of a message is only known once it is read, so delivering it is charged afterwards with ChargeConsume.
+func (m *Manager) ConsumeDelay(client string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	return m.flowOf(m.consume, client, m.quotas.consumeLimits(client), now).wait(1, 0, now)
+}
+
+// ChargeConsume takes a delivered message of the given size from the quota of the client.
+func (m *Manager) ChargeConsume(client string, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flowOf(m.consume, client, m.quotas.consumeLimits(client), m.now()).take(1, bytes)
+}
+
+func (m *Manager) flowOf(flows map[string]*flow, client string, limits Limits, now time.Time) *flow {
+	f, ok := flows[client]
+	if !ok {
+		f = newFlow(limits, now)
+		flows[client] = f
+	}
+	return f
+}
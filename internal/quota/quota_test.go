@@ -0,0 +1,86 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestManager returns a manager whose clock only moves when the returned function is called.
+func newTestManager(quotas Quotas) (*Manager, func(time.Duration)) {
+	now := time.Unix(1000, 0)
+	m := &Manager{now: func() time.Time { return now }}
+	m.Set(quotas)
+	return m, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAllowProduceEnforcesMessagesPerSecond(t *testing.T) {
+	m, advance := newTestManager(Quotas{Produce: Limits{MessagesPerSecond: 2}})
+
+	for i := 0; i < 2; i++ {
+		_, ok := m.AllowProduce("producer", 10)
+		assert.True(t, ok)
+	}
+	wait, ok := m.AllowProduce("producer", 10)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	_, ok = m.AllowProduce("other", 10)
+	assert.True(t, ok, "every client has its own quota")
+
+	advance(wait)
+	_, ok = m.AllowProduce("producer", 10)
+	assert.True(t, ok)
+}
+
+func TestAllowProduceEnforcesBytesPerSecondAndTheQueue(t *testing.T) {
+	m, advance := newTestManager(Quotas{Produce: Limits{BytesPerSecond: 100}, Queue: Limits{MessagesPerSecond: 3}})
+
+	_, ok := m.AllowProduce("producer", 250)
+	assert.True(t, ok, "a message larger than the bucket passes once it is full")
+	wait, ok := m.AllowProduce("producer", 10)
+	assert.False(t, ok)
+	assert.Equal(t, 1600*time.Millisecond, wait)
+
+	_, ok = m.AllowProduce("second", 10)
+	assert.True(t, ok)
+	_, ok = m.AllowProduce("third", 10)
+	assert.True(t, ok)
+	_, ok = m.AllowProduce("fourth", 10)
+	assert.False(t, ok, "the queue quota is shared by every client")
+
+	advance(2 * time.Second)
+	_, ok = m.AllowProduce("producer", 10)
+	assert.True(t, ok)
+}
+
+func TestClientOverridesAndRuntimeChanges(t *testing.T) {
+	m, _ := newTestManager(Quotas{
+		Produce: Limits{MessagesPerSecond: 1},
+		Clients: map[string]ClientQuotas{"batch": {Produce: Limits{MessagesPerSecond: 5}}},
+	})
+	for i := 0; i < 5; i++ {
+		_, ok := m.AllowProduce("batch", 1)
+		assert.True(t, ok)
+	}
+	_, ok := m.AllowProduce("batch", 1)
+	assert.False(t, ok)
+
+	m.Set(Quotas{})
+	for i := 0; i < 100; i++ {
+		_, ok := m.AllowProduce("batch", 1)
+		assert.True(t, ok)
+	}
+	assert.True(t, m.Quotas().Produce.IsUnlimited())
+}
+
+func TestConsumeDelayAfterCharges(t *testing.T) {
+	m, advance := newTestManager(Quotas{Consume: Limits{MessagesPerSecond: 10, BytesPerSecond: 1000}})
+
+	assert.Zero(t, m.ConsumeDelay("consumer"))
+	m.ChargeConsume("consumer", 1500)
+	assert.Equal(t, 500*time.Millisecond, m.ConsumeDelay("consumer"))
+	advance(500 * time.Millisecond)
+	assert.Zero(t, m.ConsumeDelay("consumer"))
+}
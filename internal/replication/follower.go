@@ -0,0 +1,141 @@
+package replication
+
+import (
+	"ashishkujoy/queue/internal/storage"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// retryInterval is how long a follower waits before reconnecting to its leader.
+const retryInterval = time.Second
+
+// ReplicaLog is the log a follower appends the records replicated from its leader to.
+type ReplicaLog interface {
+	AppendReplicated(records []storage.Record) error
+	NextId() int
+}
+
+// Follower replicates the log of a leader asynchronously, storing its records under the same message IDs.
+// A follower resumes from the next ID missing from its own log, so it picks up where it left off after a restart.
+type Follower struct {
+	id            string
+	leaderAddress string
+	log           ReplicaLog
+	// onAppend is called after every batch of records appended to the log.
+	onAppend     func()
+	leaderNextId atomic.Int64
+	connected    atomic.Bool
+	creds        credentials.TransportCredentials
+	logger       *slog.Logger
+}
+
+// NewFollower creates a follower identified by id, replicating the leader listening on leaderAddress into log.
+func NewFollower(id string, leaderAddress string, log ReplicaLog, onAppend func()) *Follower {
+	return &Follower{
+		id:            id,
+		leaderAddress: leaderAddress,
+		log:           log,
+		onAppend:      onAppend,
+		creds:         insecure.NewCredentials(),
+		logger:        slog.Default(),
+	}
+}
+
+// WithTransportCredentials makes the follower connect to a leader serving TLS.
+func (f *Follower) WithTransportCredentials(creds credentials.TransportCredentials) *Follower {
+	f.creds = creds
+	return f
+}
+
+// WithLogger makes the follower log to logger instead of slog.Default.
+func (f *Follower) WithLogger(logger *slog.Logger) *Follower {
+	f.logger = logger
+	return f
+}
+
+// Run replicates the leader's log until ctx is done, reconnecting after every failure.
+func (f *Follower) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := f.replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		f.logger.Warn("replication failed, retrying", "leader", f.leaderAddress, "retry_in", retryInterval, "error", err)
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (f *Follower) replicate(ctx context.Context) error {
+	// A batch holds up to the leader's maximum message size on top of its own size, whatever the leader allows is accepted.
+	conn, err := grpc.NewClient(f.leaderAddress,
+		grpc.WithTransportCredentials(f.creds),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stream, err := netinternal.NewReplicationServiceClient(conn).Replicate(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f.ack(stream); err != nil {
+		return err
+	}
+	f.connected.Store(true)
+	defer f.connected.Store(false)
+
+	for {
+		batch, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		f.leaderNextId.Store(int64(batch.LeaderNextId))
+		if len(batch.Records) == 0 {
+			continue
+		}
+		records := make([]storage.Record, len(batch.Records))
+		for i, record := range batch.Records {
+			records[i] = storage.Record{Id: int(record.Id), Key: record.Key, Headers: record.Headers, Data: record.Message}
+		}
+		if err := f.log.AppendReplicated(records); err != nil {
+			return err
+		}
+		if f.onAppend != nil {
+			f.onAppend()
+		}
+		if err := f.ack(stream); err != nil {
+			return err
+		}
+	}
+}
+
+// ack tells the leader which message the follower needs next.
+func (f *Follower) ack(stream netinternal.ReplicationService_ReplicateClient) error {
+	return stream.Send(&netinternal.ReplicateRequest{FollowerId: f.id, NextId: uint64(f.log.NextId())})
+}
+
+// Lag returns how many messages the follower is behind its leader, as of the last batch received.
+func (f *Follower) Lag() int {
+	return max(int(f.leaderNextId.Load())-f.log.NextId(), 0)
+}
+
+// Connected reports whether the follower is currently streaming from its leader.
+func (f *Follower) Connected() bool {
+	return f.connected.Load()
+}
+
+// LeaderAddress returns the address of the leader the follower replicates.
+func (f *Follower) LeaderAddress() string {
+	return f.leaderAddress
+}
@@ -0,0 +1,241 @@
+package replication
+
+import (
+	"ashishkujoy/queue/internal/storage"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Acks modes of Enqueue.
+const (
+	// AcksLeader acknowledges a message once the leader has stored it.
+	AcksLeader = "leader"
+	// AcksQuorum acknowledges a message once a majority of the replicas have stored it.
+	AcksQuorum = "quorum"
+)
+
+const (
+	maxBatchRecords   = 512
+	maxBatchBytes     = 1024 * 1024
+	heartbeatInterval = time.Second
+)
+
+// Log is the log a leader replicates to its followers.
+type Log interface {
+	NewIterator(fromId int) storage.Iterator
+	NextId() int
+}
+
+type followerState struct {
+	nextId    int
+	connected bool
+}
+
+// Leader streams the records of its log to followers and tracks how far each follower got.
+// Followers connect to the leader through the ReplicationService, so a leader does not
+// need to know its followers in advance.
+type Leader struct {
+	netinternal.UnimplementedReplicationServiceServer
+	log               Log
+	replicationFactor int
+	mu                sync.Mutex
+	followers         map[string]*followerState
+	// appended fires when records are appended to the log, acked when a follower acknowledges records.
+	appended *signal
+	acked    *signal
+}
+
+// NewLeader creates a leader replicating the log.
+// replicationFactor is the number of copies of the log, the leader's included,
+// a quorum being a majority of them.
+func NewLeader(log Log, replicationFactor int) *Leader {
+	return &Leader{
+		log:               log,
+		replicationFactor: max(replicationFactor, 1),
+		followers:         make(map[string]*followerState),
+		appended:          newSignal(),
+		acked:             newSignal(),
+	}
+}
+
+// Notify wakes up the replication streams after records have been appended to the log.
+func (l *Leader) Notify() {
+	l.appended.fire()
+}
+
+// Replicate streams the log to a follower, starting at the ID requested in the
+// follower's first message. Every following message acknowledges records.
+func (l *Leader) Replicate(stream netinternal.ReplicationService_ReplicateServer) error {
+	request, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if request.FollowerId == "" {
+		return status.Error(codes.InvalidArgument, "follower id is required")
+	}
+	follower := l.connect(request.FollowerId, int(request.NextId))
+	defer l.disconnect(follower)
+
+	acks := make(chan error, 1)
+	go func() {
+		acks <- l.receiveAcks(stream, follower)
+	}()
+
+	iterator := l.log.NewIterator(int(request.NextId))
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		appended := l.appended.wait()
+		batch, err := readBatch(iterator)
+		if err != nil {
+			return err
+		}
+		if len(batch.Records) == 0 {
+			select {
+			case <-appended:
+				continue
+			case <-heartbeat.C:
+			case err := <-acks:
+				return err
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			}
+		}
+		batch.LeaderNextId = uint64(l.log.NextId())
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// readBatch reads the records available from the iterator, up to the batch limits.
+func readBatch(iterator storage.Iterator) (*netinternal.ReplicationBatch, error) {
+	batch := &netinternal.ReplicationBatch{}
+	size := 0
+	for len(batch.Records) < maxBatchRecords && size < maxBatchBytes {
+		record, err := iterator.Next()
+		if err == storage.ErrNoMoreMessages {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch.Records = append(batch.Records, &netinternal.ReplicatedRecord{
+			Id:      uint64(record.Id),
+			Key:     record.Key,
+			Message: record.Data,
+			Headers: record.Headers,
+		})
+		size += len(record.Key) + storage.HeadersSize(record.Headers) + len(record.Data)
+	}
+	return batch, nil
+}
+
+func (l *Leader) receiveAcks(stream netinternal.ReplicationService_ReplicateServer, follower *followerState) error {
+	for {
+		request, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		l.mu.Lock()
+		follower.nextId = int(request.NextId)
+		l.mu.Unlock()
+		l.acked.fire()
+	}
+}
+
+// connect registers a replication stream of the follower, replacing any previous stream of the same follower.
+func (l *Leader) connect(followerId string, nextId int) *followerState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	follower := &followerState{nextId: nextId, connected: true}
+	l.followers[followerId] = follower
+	return follower
+}
+
+func (l *Leader) disconnect(follower *followerState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	follower.connected = false
+}
+
+// WaitForReplicas blocks until a quorum of the replicas, the leader included,
+// stores the message with the given ID, or until ctx is done.
+func (l *Leader) WaitForReplicas(ctx context.Context, messageId int) error {
+	needed := l.replicationFactor / 2
+	for {
+		acked := l.acked.wait()
+		if l.replicas(messageId) >= needed {
+			return nil
+		}
+		select {
+		case <-acked:
+		case <-ctx.Done():
+			return fmt.Errorf("message %d stored by %d of %d followers needed: %w", messageId, l.replicas(messageId), needed, ctx.Err())
+		}
+	}
+}
+
+// replicas returns the number of followers that acknowledged the message with the given ID.
+func (l *Leader) replicas(messageId int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, follower := range l.followers {
+		if follower.nextId > messageId {
+			count++
+		}
+	}
+	return count
+}
+
+// Status reports the progress of every follower that ever connected to the leader.
+func (l *Leader) Status(context.Context, *netinternal.ReplicationStatusRequest) (*netinternal.ReplicationStatus, error) {
+	nextId := l.log.NextId()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	replicationStatus := &netinternal.ReplicationStatus{NextId: uint64(nextId)}
+	for followerId, follower := range l.followers {
+		replicationStatus.Followers = append(replicationStatus.Followers, &netinternal.FollowerStatus{
+			FollowerId: followerId,
+			NextId:     uint64(follower.nextId),
+			Lag:        uint64(max(nextId-follower.nextId, 0)),
+			Connected:  follower.connected,
+		})
+	}
+	sort.Slice(replicationStatus.Followers, func(i, j int) bool {
+		return replicationStatus.Followers[i].FollowerId < replicationStatus.Followers[j].FollowerId
+	})
+	return replicationStatus, nil
+}
+
+// signal wakes up every goroutine waiting on it each time it fires.
+type signal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newSignal() *signal {
+	return &signal{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed the next time the signal fires.
+func (s *signal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *signal) fire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+}
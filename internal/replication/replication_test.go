@@ -0,0 +1,159 @@
+package replication
+
+import (
+	"ashishkujoy/queue/internal/config"
+	queueinternal "ashishkujoy/queue/internal/queue"
+	netinternal "ashishkujoy/queue/proto"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func createTempDir(suffix string) string {
+	dir := os.TempDir() + "/" + suffix
+	os.MkdirAll(dir, 0755)
+	return dir
+}
+
+func removeTempDir(suffix string) {
+	dir := os.TempDir() + "/" + suffix
+	os.RemoveAll(dir)
+}
+
+func newTestQueue(t *testing.T, name string) (*queueinternal.Queue, *config.Config) {
+	cfg := config.NewConfig(createTempDir(name), createTempDir(name+"Metadata"), 100, time.Second)
+	t.Cleanup(func() {
+		removeTempDir(name)
+		removeTempDir(name + "Metadata")
+	})
+	queue, err := queueinternal.NewQueue(cfg)
+	assert.NoError(t, err)
+	return queue, cfg
+}
+
+// startLeader serves the leader's ReplicationService on a random localhost port.
+func startLeader(t *testing.T, leader *Leader) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	netinternal.RegisterReplicationServiceServer(server, leader)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func startFollower(t *testing.T, follower *Follower) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follower.Run(ctx)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func enqueue(t *testing.T, queue *queueinternal.Queue, leader *Leader, messages ...string) []int {
+	var messageIds []int
+	for _, message := range messages {
+		messageId, err := queue.EnqueueWithKey([]byte("key-"+message), []byte(message))
+		assert.NoError(t, err)
+		messageIds = append(messageIds, messageId)
+	}
+	leader.Notify()
+	return messageIds
+}
+
+func TestFollowersReplicateTheLeaderLog(t *testing.T) {
+	leaderQueue, _ := newTestQueue(t, "ReplicationTestLeader")
+	defer leaderQueue.Close()
+	leader := NewLeader(leaderQueue, 3)
+	address := startLeader(t, leader)
+
+	var followers []*Follower
+	var followerQueues []*queueinternal.Queue
+	for i := 0; i < 2; i++ {
+		queue, _ := newTestQueue(t, fmt.Sprintf("ReplicationTestFollower%d", i))
+		defer queue.Close()
+		follower := NewFollower(fmt.Sprintf("follower-%d", i), address, queue, nil)
+		startFollower(t, follower)
+		followers = append(followers, follower)
+		followerQueues = append(followerQueues, queue)
+	}
+
+	messageIds := enqueue(t, leaderQueue, leader, "one", "two", "three", "four", "five")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, leader.WaitForReplicas(ctx, messageIds[len(messageIds)-1]))
+
+	for i, queue := range followerQueues {
+		assert.Eventually(t, func() bool { return queue.NextId() == leaderQueue.NextId() }, 5*time.Second, 10*time.Millisecond)
+		iterator := queue.NewIterator(0)
+		for j, messageId := range messageIds {
+			record, err := iterator.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, messageId, record.Id)
+			assert.Equal(t, []byte("key-"+[]string{"one", "two", "three", "four", "five"}[j]), record.Key)
+		}
+		assert.Eventually(t, func() bool { return followers[i].Connected() && followers[i].Lag() == 0 }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	replicationStatus, err := leader.Status(context.Background(), &netinternal.ReplicationStatusRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, replicationStatus.Followers, 2)
+	for _, follower := range replicationStatus.Followers {
+		assert.Equal(t, uint64(leaderQueue.NextId()), follower.NextId)
+		assert.Equal(t, uint64(0), follower.Lag)
+	}
+}
+
+func TestFollowerResumesAfterRestart(t *testing.T) {
+	leaderQueue, _ := newTestQueue(t, "ReplicationTestResumeLeader")
+	defer leaderQueue.Close()
+	leader := NewLeader(leaderQueue, 2)
+	address := startLeader(t, leader)
+
+	followerQueue, followerConfig := newTestQueue(t, "ReplicationTestResumeFollower")
+	stop := startFollower(t, NewFollower("follower", address, followerQueue, nil))
+	first := enqueue(t, leaderQueue, leader, "one", "two")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, leader.WaitForReplicas(ctx, first[1]))
+	stop()
+	assert.NoError(t, followerQueue.Close())
+
+	second := enqueue(t, leaderQueue, leader, "three")
+	followerQueue, err := queueinternal.RestoreQueue(followerConfig)
+	assert.NoError(t, err)
+	defer followerQueue.Close()
+	startFollower(t, NewFollower("follower", address, followerQueue, nil))
+	assert.NoError(t, leader.WaitForReplicas(ctx, second[0]))
+
+	data, err := followerQueue.Dequeue(second[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("three"), data)
+}
+
+func TestWaitForReplicasTimesOutWithoutQuorum(t *testing.T) {
+	leaderQueue, _ := newTestQueue(t, "ReplicationTestNoQuorum")
+	defer leaderQueue.Close()
+	leader := NewLeader(leaderQueue, 3)
+	messageIds := enqueue(t, leaderQueue, leader, "one")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, leader.WaitForReplicas(ctx, messageIds[0]), context.DeadlineExceeded)
+
+	single := NewLeader(leaderQueue, 1)
+	assert.NoError(t, single.WaitForReplicas(context.Background(), messageIds[0]))
+}
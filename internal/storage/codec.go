@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies the compression applied to the batches of a segment.
+// The codec is recorded in the segment header, so every segment is decoded
+// with the codec it was written with, whatever the current configuration says.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecGzip
+	CodecSnappy
+	CodecZstd
+)
+
+var codecNames = map[Codec]string{
+	CodecNone:   "none",
+	CodecGzip:   "gzip",
+	CodecSnappy: "snappy",
+	CodecZstd:   "zstd",
+}
+
+// ParseCodec returns the codec with the given name. An empty name means no compression.
+func ParseCodec(name string) (Codec, error) {
+	if name == "" {
+		return CodecNone, nil
+	}
+	for codec, codecName := range codecNames {
+		if codecName == name {
+			return codec, nil
+		}
+	}
+	return CodecNone, fmt.Errorf("unknown compression codec %q", name)
+}
+
+func (c Codec) String() string {
+	if name, ok := codecNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func (c Codec) compress(data []byte) ([]byte, error) {
+	switch c {
+	case CodecNone:
+		return data, nil
+	case CodecGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecSnappy:
+		return snappy.Encode(nil, data), nil
+	case CodecZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %s", c)
+}
+
+func (c Codec) decompress(data []byte) ([]byte, error) {
+	switch c {
+	case CodecNone:
+		return data, nil
+	case CodecGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case CodecSnappy:
+		return snappy.Decode(nil, data)
+	case CodecZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("unknown compression codec %s", c)
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"type":"order.created","amount":1250}`), 50)
+	for _, name := range []string{"none", "gzip", "snappy", "zstd"} {
+		codec, err := ParseCodec(name)
+		assert.NoError(t, err)
+		assert.Equal(t, name, codec.String())
+
+		compressed, err := codec.compress(data)
+		assert.NoError(t, err)
+		if codec != CodecNone {
+			assert.Less(t, len(compressed), len(data))
+		}
+
+		decompressed, err := codec.decompress(compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decompressed)
+	}
+}
+
+func TestParseUnknownCodec(t *testing.T) {
+	codec, err := ParseCodec("")
+	assert.NoError(t, err)
+	assert.Equal(t, CodecNone, codec)
+
+	_, err = ParseCodec("lz4")
+	assert.Error(t, err)
+}
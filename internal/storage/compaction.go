@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// compactor runs Segments.Compact periodically in the background.
+type compactor struct {
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// startCompactor starts compacting the closed segments every CompactionInterval,
+// unless compaction is disabled.
+func (s *Segments) startCompactor() {
+	interval := s.config.CompactionInterval()
+	if interval <= 0 {
+		return
+	}
+	s.compactor = &compactor{done: make(chan struct{}), stopped: make(chan struct{})}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(s.compactor.stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				dropped, err := s.Compact()
+				if err != nil {
+					s.config.Logger().Error("failed to compact segments", "error", err)
+					continue
+				}
+				s.config.Logger().Debug("compacted segments", "dropped", dropped)
+			case <-s.compactor.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the compactor and waits for a running compaction to finish.
+func (c *compactor) stop() {
+	if c == nil {
+		return
+	}
+	close(c.done)
+	<-c.stopped
+}
+
+// Compact rewrites the closed segments, dropping every record superseded by a newer record
+// with the same key. Records without a key are always kept. A tombstone, a keyed record
+// with empty data, is kept as the newest record of its key so consumers catching up still
+// see the deletion, while all the older values of the key are dropped.
+// Message IDs are preserved, the index is updated to the new location of the records kept
+// and forgets the ones dropped. Segments offloaded to an archive are left as they are.
+// It returns the number of records dropped.
+func (s *Segments) Compact() (int, error) {
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+	if t := s.tiered; t != nil {
+		t.archiving.Lock()
+		defer t.archiving.Unlock()
+	}
+	s.mu.RLock()
+	segments := append(slices.Clone(s.closedSegments), s.active)
+	s.mu.RUnlock()
+
+	latest := make(map[string]int)
+	for _, segment := range segments {
+		err := segment.forEachRecord(func(_ int, record Record) error {
+			if record.Key != nil {
+				latest[string(record.Key)] = record.Id
+			}
+			return nil
+		})
+		if err != nil && err != errSegmentEvicted {
+			return 0, err
+		}
+	}
+
+	keep := func(record Record) bool {
+		return record.Key == nil || latest[string(record.Key)] == record.Id
+	}
+	removed := 0
+	for _, segment := range segments[:len(segments)-1] {
+		dropped, err := s.compactSegment(segment, keep)
+		if err != nil {
+			return removed, fmt.Errorf("compact segment %d: %w", segment.id, err)
+		}
+		if dropped > 0 {
+			s.config.Logger().Debug("compacted segment", "segment", segment.id, "dropped", dropped)
+		}
+		removed += dropped
+	}
+	return removed, nil
+}
+
+// compactSegment compacts a closed segment. An archived segment is uploaded again
+// once compacted, so its archived copy agrees with the index. When that upload fails
+// the segment is marked not archived, and uploaded again by the next archiving.
+// It must be called with t.archiving held.
+func (s *Segments) compactSegment(segment *Segment, keep func(Record) bool) (int, error) {
+	t := s.tiered
+	if t == nil {
+		return segment.compact(keep, s.index.Update)
+	}
+	t.mu.Lock()
+	skip := !segment.isLoaded() || slices.Contains(t.fetched, segment)
+	archived := t.archived[segment.id]
+	t.mu.Unlock()
+	if skip {
+		return 0, nil
+	}
+	dropped, err := segment.compact(keep, s.index.Update)
+	if err != nil || dropped == 0 || !archived {
+		return dropped, err
+	}
+	if err := t.archive.Upload(segment.id, segmentFilePath(s.config, segment.id)); err != nil {
+		t.mu.Lock()
+		t.archived[segment.id] = false
+		t.mu.Unlock()
+		return dropped, fmt.Errorf("upload compacted segment %d: %w", segment.id, err)
+	}
+	return dropped, nil
+}
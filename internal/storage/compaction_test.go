@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactKeepsNewestRecordOfEachKey(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("CompactionTestNewest"),
+		createTempDir("CompactionTestNewestMetadata"),
+		30,
+		time.Second,
+	)
+	defer removeTempDir("CompactionTestNewest")
+	defer removeTempDir("CompactionTestNewestMetadata")
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	messages := []struct{ key, data string }{
+		{"a", "a1"}, {"b", "b1"}, {"", "plain"}, {"a", "a2"}, {"c", "c1"}, {"b", ""}, {"a", "a3"}, {"d", "d1"},
+	}
+	var messageIds []int
+	for _, message := range messages {
+		var key []byte
+		if message.key != "" {
+			key = []byte(message.key)
+		}
+		messageId, err := segments.AppendWithKey(key, []byte(message.data))
+		assert.NoError(t, err)
+		messageIds = append(messageIds, messageId)
+	}
+
+	removed, err := segments.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	for _, dropped := range []int{0, 1, 3} {
+		_, err := segments.Read(messageIds[dropped])
+		assert.Error(t, err)
+	}
+	data, err := segments.Read(messageIds[2])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("plain"), data)
+
+	iterator := segments.NewIterator(messageIds[0])
+	var kept []Record
+	for {
+		record, err := iterator.Next()
+		if err != nil {
+			assert.Equal(t, ErrNoMoreMessages, err)
+			break
+		}
+		kept = append(kept, record)
+	}
+	assert.Equal(t, []Record{
+		{Id: messageIds[2], Data: []byte("plain")},
+		{Id: messageIds[4], Key: []byte("c"), Data: []byte("c1")},
+		{Id: messageIds[5], Key: []byte("b"), Data: []byte{}},
+		{Id: messageIds[6], Key: []byte("a"), Data: []byte("a3")},
+		{Id: messageIds[7], Key: []byte("d"), Data: []byte("d1")},
+	}, kept)
+	assert.True(t, kept[2].IsTombstone())
+
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+
+	restored, err := RestoreIndex(cfg)
+	assert.NoError(t, err)
+	defer restored.Close()
+	_, ok := restored.GetOffset(messageIds[0])
+	assert.False(t, ok)
+	assert.Equal(t, len(messageIds), restored.NextElementId())
+
+	diff, err := VerifyIndex(cfg)
+	assert.NoError(t, err)
+	assert.True(t, diff.Matches(), diff.String())
+}
+
+func TestCompactLeavesUnkeyedSegmentsUntouched(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("CompactionTestUnkeyed"),
+		createTempDir("CompactionTestUnkeyedMetadata"),
+		20,
+		time.Second,
+	)
+	defer removeTempDir("CompactionTestUnkeyed")
+	defer removeTempDir("CompactionTestUnkeyedMetadata")
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+
+	for _, message := range []string{"one", "two", "three"} {
+		_, err := segments.Append([]byte(message))
+		assert.NoError(t, err)
+	}
+	generation := segments.closedSegments[0].currentGeneration()
+
+	removed, err := segments.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, generation, segments.closedSegments[0].currentGeneration())
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate blocks without growing the file,
+// so the file size keeps reflecting the data actually written.
+const fallocKeepSize = 0x1
+
+// preallocate reserves size bytes of disk space for the file using fallocate(2).
+// Filesystems that do not support fallocate are tolerated, preallocation is only an optimisation.
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
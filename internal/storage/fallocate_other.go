@@ -0,0 +1,10 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// preallocate is a no-op on platforms without fallocate(2).
+func preallocate(_ *os.File, _ int64) error {
+	return nil
+}
@@ -3,6 +3,9 @@ package storage
 import (
 	"ashishkujoy/queue/internal/config"
 	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
 	"sync"
 )
 
@@ -12,8 +15,10 @@ type MessageEntry struct {
 	elementId int
 }
 
+const messageEntrySize = 24
+
 func (m *MessageEntry) Encode() []byte {
-	data := make([]byte, 24)
+	data := make([]byte, messageEntrySize)
 	offset := 0
 	binary.BigEndian.PutUint64(data[offset:offset+8], uint64(m.segmentId))
 	offset += 8
@@ -36,20 +41,59 @@ func NewMessageEntry(segmentId int, offset int) MessageEntry {
 	return MessageEntry{segmentId: segmentId, offset: offset}
 }
 
+// removedOffset marks the entry of a message dropped from its segment by compaction.
+const removedOffset = -1
+
+// removedEntry returns the entry recording that the message with the given ID no longer exists.
+func removedEntry(elementId int) MessageEntry {
+	return MessageEntry{segmentId: -1, offset: removedOffset, elementId: elementId}
+}
+
+func (m *MessageEntry) isRemoved() bool {
+	return m.offset == removedOffset
+}
+
+// MessageId returns the ID of the message the entry locates.
+func (m *MessageEntry) MessageId() int {
+	return m.elementId
+}
+
+// SegmentId returns the ID of the segment holding the message.
+func (m *MessageEntry) SegmentId() int {
+	return m.segmentId
+}
+
+// Offset returns the offset of the batch holding the message in its segment.
+func (m *MessageEntry) Offset() int {
+	return m.offset
+}
+
+// Removed reports whether the entry records that the message was dropped, by compaction or a purge.
+func (m *MessageEntry) Removed() bool {
+	return m.isRemoved()
+}
+
 type Index struct {
-	entries   map[int]MessageEntry
+	// entries holds the entries sorted by message ID, so IDs are found by binary search.
+	// Messages dropped by compaction keep a removed entry until the next purge or truncation.
+	entries []MessageEntry
+	// removed counts the removed entries in entries.
+	removed int
+	// ranges holds the range of the IDs of the messages in every segment holding any,
+	// kept up to date as entries are appended, removed and purged.
+	ranges    map[int]idRange
 	elementId int
 	store     *Store
 	mu        *sync.Mutex
 }
 
 func NewIndex(cfg *config.Config) (*Index, error) {
-	store, err := NewStore(cfg.IndexFilePath())
+	store, err := NewEncryptedStore(cfg.IndexFilePath(), cfg.Keyring())
 	if err != nil {
 		return nil, err
 	}
 	return &Index{
-		entries:   make(map[int]MessageEntry),
+		ranges:    make(map[int]idRange),
 		store:     store,
 		elementId: 0,
 		mu:        &sync.Mutex{},
@@ -57,7 +101,7 @@ func NewIndex(cfg *config.Config) (*Index, error) {
 }
 
 func RestoreIndex(cfg *config.Config) (*Index, error) {
-	store, err := NewStore(cfg.IndexFilePath())
+	store, err := NewEncryptedStore(cfg.IndexFilePath(), cfg.Keyring())
 	if err != nil {
 		return nil, err
 	}
@@ -65,12 +109,14 @@ func RestoreIndex(cfg *config.Config) (*Index, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Index{
-		entries:   entries,
+	index := &Index{
+		entries:   sortedEntries(entries),
 		store:     store,
 		elementId: elementId,
 		mu:        &sync.Mutex{},
-	}, nil
+	}
+	index.rebuildRanges()
+	return index, nil
 }
 
 func restoreEntries(store *Store) (map[int]MessageEntry, int, error) {
@@ -81,21 +127,36 @@ func restoreEntries(store *Store) (map[int]MessageEntry, int, error) {
 	}
 	var elementId = -1
 	for _, entry := range entriesBytes {
+		if len(entry) != messageEntrySize {
+			return nil, 0, fmt.Errorf("corrupt index entry of %d bytes", len(entry))
+		}
 		messageEntry := MessageEntry{}
 		messageEntry.Decode(entry)
-		entries[messageEntry.elementId] = messageEntry
-		elementId = messageEntry.elementId
+		if messageEntry.isRemoved() {
+			delete(entries, messageEntry.elementId)
+		} else {
+			entries[messageEntry.elementId] = messageEntry
+		}
+		elementId = max(elementId, messageEntry.elementId)
 	}
 	return entries, elementId + 1, nil
 }
 
+// sortedEntries returns the entries sorted by message ID.
+func sortedEntries(entries map[int]MessageEntry) []MessageEntry {
+	sorted := slices.Collect(maps.Values(entries))
+	slices.SortFunc(sorted, func(a, b MessageEntry) int { return a.elementId - b.elementId })
+	return sorted
+}
+
 func (i *Index) Append(messageEntry MessageEntry) (int, error) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
 	currentElementId := i.elementId
 	messageEntry.elementId = currentElementId
-	i.entries[currentElementId] = messageEntry
+	i.entries = append(i.entries, messageEntry)
+	i.addToRange(messageEntry)
 	i.elementId++
 	_, err := i.store.Append(messageEntry.Encode())
 	if err != nil {
@@ -104,9 +165,222 @@ func (i *Index) Append(messageEntry MessageEntry) (int, error) {
 	return currentElementId, nil
 }
 
+// AppendBatch appends the entries with consecutive IDs, writing them to the index file at once.
+// It returns the ID assigned to each entry.
+func (i *Index) AppendBatch(messageEntries []MessageEntry) ([]int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	elementIds := make([]int, len(messageEntries))
+	for j := range messageEntries {
+		messageEntries[j].elementId = i.elementId + j
+		elementIds[j] = i.elementId + j
+	}
+	if err := i.appendEntries(messageEntries); err != nil {
+		return nil, err
+	}
+	return elementIds, nil
+}
+
+// AppendReplicated appends entries that already carry their ID, e.g. messages replicated from a leader.
+// IDs must be increasing and not below NextElementId, IDs skipped are treated as removed.
+func (i *Index) AppendReplicated(messageEntries []MessageEntry) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	nextId := i.elementId
+	for _, messageEntry := range messageEntries {
+		if messageEntry.elementId < nextId {
+			return fmt.Errorf("message id %d already in the index, next id is %d", messageEntry.elementId, nextId)
+		}
+		nextId = messageEntry.elementId + 1
+	}
+	return i.appendEntries(messageEntries)
+}
+
+// appendEntries must be called with i.mu held.
+func (i *Index) appendEntries(messageEntries []MessageEntry) error {
+	encoded := make([][]byte, len(messageEntries))
+	for j, messageEntry := range messageEntries {
+		i.entries = append(i.entries, messageEntry)
+		i.addToRange(messageEntry)
+		encoded[j] = messageEntry.Encode()
+		i.elementId = messageEntry.elementId + 1
+	}
+	_, err := i.store.AppendBatch(encoded)
+	return err
+}
+
+// Update records a new location for entries that are already in the index,
+// e.g. after the segment holding them has been rewritten.
+// Removed entries drop their message from the index.
+// Later entries for an ID take precedence when the index is restored.
+func (i *Index) Update(messageEntries []MessageEntry) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	encoded := make([][]byte, len(messageEntries))
+	shrunk := make(map[int]bool)
+	for j, messageEntry := range messageEntries {
+		if k, ok := i.search(messageEntry.elementId); ok {
+			if i.entries[k].isRemoved() != messageEntry.isRemoved() {
+				if messageEntry.isRemoved() {
+					i.removed++
+					shrunk[i.entries[k].segmentId] = true
+				} else {
+					i.removed--
+				}
+			}
+			i.entries[k] = messageEntry
+		}
+		encoded[j] = messageEntry.Encode()
+	}
+	for segmentId := range shrunk {
+		i.shrinkRange(segmentId)
+	}
+	_, err := i.store.AppendBatch(encoded)
+	return err
+}
+
+// NextElementId returns the ID that the next appended entry will be assigned.
+func (i *Index) NextElementId() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.elementId
+}
+
+// search returns the position in entries of the entry for elementId, or where it would be inserted.
+// It must be called with i.mu held.
+func (i *Index) search(elementId int) (int, bool) {
+	return slices.BinarySearchFunc(i.entries, elementId, func(entry MessageEntry, id int) int {
+		return entry.elementId - id
+	})
+}
+
 func (i *Index) GetOffset(elementId int) (MessageEntry, bool) {
-	v, ok := i.entries[elementId]
-	return v, ok
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	k, ok := i.search(elementId)
+	if !ok || i.entries[k].isRemoved() {
+		return MessageEntry{}, false
+	}
+	return i.entries[k], true
+}
+
+// GetOffsetFrom returns the entry of the first message with an ID of at least elementId,
+// skipping the messages removed by compaction.
+func (i *Index) GetOffsetFrom(elementId int) (MessageEntry, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	k, _ := i.search(elementId)
+	for ; k < len(i.entries); k++ {
+		if !i.entries[k].isRemoved() {
+			return i.entries[k], true
+		}
+	}
+	return MessageEntry{}, false
+}
+
+// RemoveAll drops every message from the index and returns how many it dropped.
+// The next ID is kept, the entries recording the removals carrying the newest ID when the index is restored.
+func (i *Index) RemoveAll() (int, error) {
+	return i.removeWhere(func(MessageEntry) bool { return true })
+}
+
+// removeSegments drops the messages stored in the segments from the index and returns how many it dropped.
+func (i *Index) removeSegments(segmentIds []int) (int, error) {
+	return i.removeWhere(func(entry MessageEntry) bool { return slices.Contains(segmentIds, entry.segmentId) })
+}
+
+// removeWhere drops the messages whose entry matches from the index, recording their removal in the index file.
+// The removed entries, these and the ones left by compaction, are dropped from entries.
+func (i *Index) removeWhere(matches func(MessageEntry) bool) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var encoded [][]byte
+	i.entries = slices.DeleteFunc(i.entries, func(entry MessageEntry) bool {
+		if entry.isRemoved() {
+			return true
+		}
+		if !matches(entry) {
+			return false
+		}
+		removed := removedEntry(entry.elementId)
+		encoded = append(encoded, removed.Encode())
+		return true
+	})
+	i.removed = 0
+	i.rebuildRanges()
+	_, err := i.store.AppendBatch(encoded)
+	return len(encoded), err
+}
+
+// idRange is the range of message IDs stored in a segment.
+type idRange struct {
+	first, last, count int
+}
+
+// segmentRanges returns the range of the IDs of the messages in every segment holding any.
+func (i *Index) segmentRanges() map[int]idRange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return maps.Clone(i.ranges)
+}
+
+// addToRange records a message appended to its segment. It must be called with i.mu held.
+func (i *Index) addToRange(entry MessageEntry) {
+	r, ok := i.ranges[entry.segmentId]
+	if !ok {
+		r.first = entry.elementId
+	}
+	r.last = entry.elementId
+	r.count++
+	i.ranges[entry.segmentId] = r
+}
+
+// shrinkRange recomputes the range of a segment some messages of which were removed,
+// scanning only the entries within its previous range. It must be called with i.mu held.
+func (i *Index) shrinkRange(segmentId int) {
+	previous, ok := i.ranges[segmentId]
+	if !ok {
+		return
+	}
+	delete(i.ranges, segmentId)
+	from, _ := i.search(previous.first)
+	to, found := i.search(previous.last)
+	if found {
+		to++
+	}
+	for _, entry := range i.entries[from:to] {
+		if entry.segmentId == segmentId && !entry.isRemoved() {
+			i.addToRange(entry)
+		}
+	}
+}
+
+// rebuildRanges recomputes the ranges of every segment from entries. It must be called with i.mu held.
+func (i *Index) rebuildRanges() {
+	i.ranges = make(map[int]idRange)
+	for _, entry := range i.entries {
+		if !entry.isRemoved() {
+			i.addToRange(entry)
+		}
+	}
+}
+
+// Len returns the number of messages in the index, the ones removed by compaction excluded.
+func (i *Index) Len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.entries) - i.removed
+}
+
+// SizeInBytes returns the size of the index file.
+func (i *Index) SizeInBytes() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.store.Size()
 }
 
 func (i *Index) Close() error {
@@ -3,7 +3,9 @@ package storage
 import (
 	"ashishkujoy/queue/internal/config"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestCreateNewIndex(t *testing.T) {
@@ -56,6 +58,74 @@ func TestReadFromAIndex(t *testing.T) {
 	assert.Equal(t, MessageEntry{segmentId: 1, offset: 10, elementId: i2}, offset2)
 }
 
+func TestGetOffsetFromSkipsRemovedMessages(t *testing.T) {
+	cfg := config.NewConfig(
+		"",
+		createTempDir("TestGetOffsetFromSkipsRemovedMessages"),
+		1000,
+		0,
+	)
+	defer removeTempDir("TestGetOffsetFromSkipsRemovedMessages")
+	index, _ := NewIndex(cfg)
+
+	for offset := 0; offset < 5; offset++ {
+		_, _ = index.Append(MessageEntry{segmentId: 0, offset: offset})
+	}
+	assert.NoError(t, index.AppendReplicated([]MessageEntry{{segmentId: 1, offset: 0, elementId: 8}}))
+	assert.NoError(t, index.Update([]MessageEntry{removedEntry(1), removedEntry(2)}))
+
+	entry, ok := index.GetOffsetFrom(1)
+	assert.True(t, ok)
+	assert.Equal(t, 3, entry.MessageId())
+	entry, ok = index.GetOffsetFrom(5)
+	assert.True(t, ok)
+	assert.Equal(t, 8, entry.MessageId())
+	_, ok = index.GetOffsetFrom(9)
+	assert.False(t, ok)
+	_, ok = index.GetOffset(2)
+	assert.False(t, ok)
+	assert.Equal(t, 4, index.Len())
+
+	dropped, err := index.removeSegments([]int{0})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dropped)
+	assert.Equal(t, 1, index.Len())
+	entry, ok = index.GetOffsetFrom(0)
+	assert.True(t, ok)
+	assert.Equal(t, 8, entry.MessageId())
+}
+
+func TestIndexKeepsTheRangeOfEverySegment(t *testing.T) {
+	cfg := config.NewConfig(
+		"",
+		createTempDir("TestIndexKeepsTheRangeOfEverySegment"),
+		1000,
+		0,
+	)
+	defer removeTempDir("TestIndexKeepsTheRangeOfEverySegment")
+	index, _ := NewIndex(cfg)
+
+	for segmentId := 0; segmentId < 3; segmentId++ {
+		for offset := 0; offset < 3; offset++ {
+			_, _ = index.Append(MessageEntry{segmentId: segmentId, offset: offset})
+		}
+	}
+	assert.Equal(t, map[int]idRange{0: {0, 2, 3}, 1: {3, 5, 3}, 2: {6, 8, 3}}, index.segmentRanges())
+
+	assert.NoError(t, index.Update([]MessageEntry{removedEntry(3), removedEntry(5), removedEntry(6), removedEntry(7), removedEntry(8)}))
+	assert.Equal(t, map[int]idRange{0: {0, 2, 3}, 1: {4, 4, 1}}, index.segmentRanges())
+
+	_, err := index.removeSegments([]int{0})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]idRange{1: {4, 4, 1}}, index.segmentRanges())
+	assert.NoError(t, index.Close())
+
+	restored, err := RestoreIndex(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]idRange{1: {4, 4, 1}}, restored.segmentRanges())
+	assert.NoError(t, restored.Close())
+}
+
 func TestReadFromARestoredIndex(t *testing.T) {
 	cfg := config.NewConfig(
 		"",
@@ -78,3 +148,75 @@ func TestReadFromARestoredIndex(t *testing.T) {
 	assert.Equal(t, MessageEntry{segmentId: 0, offset: 0, elementId: i1}, offset1)
 	assert.Equal(t, MessageEntry{segmentId: 1, offset: 10, elementId: i2}, offset2)
 }
+
+func TestRebuildIndexFromSegments(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestRebuildIndexFromSegments/segments"),
+		createTempDir("TestRebuildIndexFromSegments/metadata"),
+		10,
+		time.Second,
+	)
+	defer removeTempDir("TestRebuildIndexFromSegments")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	messageId1, _ := segments.Append([]byte("Hello Segments"))
+	messageId2, _ := segments.Append([]byte("Another Hello Segments"))
+	messageId3, _ := segments.Append([]byte("Yet Another Hello Segments"))
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+	assert.NoError(t, os.Remove(cfg.IndexFilePath()))
+
+	diff, err := RebuildIndex(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, diff.Entries)
+	assert.False(t, diff.Matches())
+
+	index, err = RestoreIndex(cfg)
+	assert.NoError(t, err)
+	restoredSegments, err := RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+
+	data1, _ := restoredSegments.Read(messageId1)
+	data2, _ := restoredSegments.Read(messageId2)
+	data3, _ := restoredSegments.Read(messageId3)
+	assert.Equal(t, []byte("Hello Segments"), data1)
+	assert.Equal(t, []byte("Another Hello Segments"), data2)
+	assert.Equal(t, []byte("Yet Another Hello Segments"), data3)
+
+	messageId4, err := restoredSegments.Append([]byte("After Rebuild"))
+	assert.NoError(t, err)
+	assert.Equal(t, messageId3+1, messageId4)
+}
+
+func TestVerifyIndexReportsDifferences(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestVerifyIndexReportsDifferences/segments"),
+		createTempDir("TestVerifyIndexReportsDifferences/metadata"),
+		1000,
+		time.Second,
+	)
+	defer removeTempDir("TestVerifyIndexReportsDifferences")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	_, _ = segments.Append([]byte("Hello Segments"))
+	_, _ = segments.Append([]byte("Another Hello Segments"))
+	assert.NoError(t, segments.Close())
+
+	diff, err := VerifyIndex(cfg)
+	assert.NoError(t, err)
+	assert.True(t, diff.Matches())
+
+	_, _ = index.Append(MessageEntry{segmentId: 7, offset: 0})
+	assert.NoError(t, index.Close())
+
+	diff, err = VerifyIndex(cfg)
+	assert.NoError(t, err)
+	assert.False(t, diff.Matches())
+	assert.Equal(t, []int{2}, diff.Unknown)
+	assert.Empty(t, diff.Missing)
+	assert.Empty(t, diff.Mismatched)
+}
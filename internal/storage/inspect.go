@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SegmentCheck reports what CheckSegment found in a segment file.
+type SegmentCheck struct {
+	Id      int
+	Version byte
+	Codec   Codec
+	// KeyId is the ID of the key the segment is encrypted with, empty when it is not encrypted.
+	KeyId       string
+	SizeInBytes int
+	// ValidSize is the size of the file up to the end of the last batch that could be read.
+	ValidSize int
+	Batches   int
+	Records   int
+	// FirstId and LastId are the IDs of the first and last records read, -1 when there is none.
+	FirstId int
+	LastId  int
+	// Err is the problem the check stopped at, nil when the whole file was read.
+	Err error
+	// TornTail is set when Err is a batch cut short by the end of the file, or a tail of zero bytes,
+	// as left by a crash in the middle of a write. Truncating the file to ValidSize drops it.
+	TornTail bool
+}
+
+// IndexCheck reports what CheckIndex found in the index file.
+type IndexCheck struct {
+	SizeInBytes int
+	ValidSize   int
+	// Entries counts the entries read, Removed those of them recording a dropped message.
+	Entries int
+	Removed int
+	// NextId is the ID the index would assign to the next message.
+	NextId   int
+	Err      error
+	TornTail bool
+}
+
+// SegmentIds returns the IDs of the segment files stored locally under SegmentsRoot, in order.
+func SegmentIds(cfg *config.Config) ([]int, error) {
+	return getSegmentIds(cfg.SegmentsRoot())
+}
+
+// CheckSegment reads every batch of a local segment file without modifying it, checking that
+// entries are framed within the file, batches match their CRC32C, decrypt and decompress, and
+// record IDs increase. fn, when not nil, is called with every record along with the offset of its batch.
+// The returned error is set when the file cannot be opened or fn fails, problems in the
+// file itself are reported in the check.
+// It must not be called while a queue is open on the same configuration.
+func CheckSegment(cfg *config.Config, segmentId int, fn func(offset int, record Record) error) (*SegmentCheck, error) {
+	filePath := segmentFilePath(cfg, segmentId)
+	check := &SegmentCheck{Id: segmentId, FirstId: -1, LastId: -1}
+	store, err := restoreStoreForCheck(filePath, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		check.Err, check.TornTail = errors.New("empty file"), true
+		return check, nil
+	}
+	defer store.Close()
+	check.SizeInBytes, check.KeyId = store.Size(), store.KeyId()
+
+	header, batchesOffset, err := readSegmentHeader(store)
+	if err != nil {
+		check.ValidSize = store.firstOffset
+		check.Err = fmt.Errorf("segment header: %w", err)
+		check.TornTail = isTorn(err) || store.isZeroFrom(store.firstOffset)
+		return check, nil
+	}
+	check.Version, check.Codec = header.version, header.codec
+
+	segment := &Segment{id: segmentId, codec: header.codec}
+	var fnErr error
+	check.ValidSize, check.Err, check.TornTail = walkEntries(store, batchesOffset, func(offset int, entry []byte) error {
+		records, err := segment.decodeBatch(entry)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if record.Id <= check.LastId {
+				return fmt.Errorf("message %d follows message %d", record.Id, check.LastId)
+			}
+			check.LastId = record.Id
+			if check.FirstId < 0 {
+				check.FirstId = record.Id
+			}
+		}
+		check.Batches++
+		check.Records += len(records)
+		if fn == nil {
+			return nil
+		}
+		for _, record := range records {
+			if fnErr = fn(offset, record); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return nil, fnErr
+	}
+	return check, nil
+}
+
+// CheckIndex reads every entry of the index file without modifying it. fn, when not nil,
+// is called with every entry along with its offset in the file.
+// It must not be called while a queue is open on the same configuration.
+func CheckIndex(cfg *config.Config, fn func(offset int, entry MessageEntry) error) (*IndexCheck, error) {
+	check := &IndexCheck{}
+	store, err := restoreStoreForCheck(cfg.IndexFilePath(), cfg)
+	if err != nil || store == nil {
+		return check, err
+	}
+	defer store.Close()
+	check.SizeInBytes = store.Size()
+
+	var fnErr error
+	check.ValidSize, check.Err, check.TornTail = walkEntries(store, store.firstOffset, func(offset int, data []byte) error {
+		if len(data) != messageEntrySize {
+			return fmt.Errorf("index entry of %d bytes", len(data))
+		}
+		entry := MessageEntry{}
+		entry.Decode(data)
+		check.Entries++
+		if entry.isRemoved() {
+			check.Removed++
+		}
+		check.NextId = max(check.NextId, entry.elementId+1)
+		if fn == nil {
+			return nil
+		}
+		fnErr = fn(offset, entry)
+		return fnErr
+	})
+	if fnErr != nil {
+		return nil, fnErr
+	}
+	return check, nil
+}
+
+// TruncateSegment cuts the segment file down to sizeInBytes, dropping a torn tail found by CheckSegment.
+func TruncateSegment(cfg *config.Config, segmentId int, sizeInBytes int) error {
+	return os.Truncate(segmentFilePath(cfg, segmentId), int64(sizeInBytes))
+}
+
+// TruncateIndex cuts the index file down to sizeInBytes, dropping a torn tail found by CheckIndex.
+func TruncateIndex(cfg *config.Config, sizeInBytes int) error {
+	return os.Truncate(cfg.IndexFilePath(), int64(sizeInBytes))
+}
+
+// restoreStoreForCheck opens the store at filePath read only. It returns a nil store for an
+// empty file, which opening would initialise with an encryption header.
+func restoreStoreForCheck(filePath string, cfg *config.Config) (*Store, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	store, err := RestoreEncryptedStore(filePath, cfg.Keyring())
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CloseWriter(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// walkEntries calls fn with every entry of the store from offset on. It returns the offset
+// the entries read end at, along with the problem it stopped at and whether that is a torn tail.
+func walkEntries(store *Store, offset int, fn func(offset int, entry []byte) error) (validSize int, problem error, torn bool) {
+	for offset < store.Size() {
+		entry, next, err := store.readEntry(offset)
+		if err == nil {
+			err = fn(offset, entry)
+		}
+		if err != nil {
+			return offset, fmt.Errorf("entry at offset %d: %w", offset, err), isTorn(err) || store.isZeroFrom(offset)
+		}
+		offset = next
+	}
+	return offset, nil, false
+}
+
+func isTorn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isZeroFrom reports whether every byte of the file from offset on is zero.
+func (s *Store) isZeroFrom(offset int) bool {
+	buf := make([]byte, 64*1024)
+	reader := io.NewSectionReader(s.reader, int64(offset), int64(s.offset-offset))
+	for {
+		n, err := reader.Read(buf)
+		if len(bytes.Trim(buf[:n], "\x00")) != 0 {
+			return false
+		}
+		if err != nil {
+			return err == io.EOF
+		}
+	}
+}
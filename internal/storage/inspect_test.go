@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSegmentsToInspect(t *testing.T, name string) *config.Config {
+	cfg := config.NewConfig(createTempDir(name+"/segments"), createTempDir(name+"/metadata"), 1000, time.Second)
+	t.Cleanup(func() { removeTempDir(name) })
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+	return cfg
+}
+
+func TestCheckSegmentReadsEveryRecord(t *testing.T) {
+	cfg := writeSegmentsToInspect(t, "TestCheckSegmentReadsEveryRecord")
+	segmentIds, err := SegmentIds(cfg)
+	assert.NoError(t, err)
+	assert.Len(t, segmentIds, 1)
+
+	var messages []string
+	check, err := CheckSegment(cfg, segmentIds[0], func(offset int, record Record) error {
+		assert.Greater(t, offset, 0)
+		messages = append(messages, string(record.Data))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, check.Err)
+	assert.Equal(t, []string{"message 0", "message 1", "message 2"}, messages)
+	assert.Equal(t, 3, check.Records)
+	assert.Equal(t, 0, check.FirstId)
+	assert.Equal(t, 2, check.LastId)
+	assert.Equal(t, check.SizeInBytes, check.ValidSize)
+
+	indexCheck, err := CheckIndex(cfg, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, indexCheck.Err)
+	assert.Equal(t, 3, indexCheck.Entries)
+	assert.Equal(t, 3, indexCheck.NextId)
+}
+
+func TestCheckSegmentFindsAndTruncatesATornTail(t *testing.T) {
+	cfg := writeSegmentsToInspect(t, "TestCheckSegmentFindsATornTail")
+	segmentIds, _ := SegmentIds(cfg)
+	check, _ := CheckSegment(cfg, segmentIds[0], nil)
+	validSize := check.SizeInBytes
+
+	file, err := os.OpenFile(segmentFilePath(cfg, segmentIds[0]), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 100, 1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	check, err = CheckSegment(cfg, segmentIds[0], nil)
+	assert.NoError(t, err)
+	assert.Error(t, check.Err)
+	assert.True(t, check.TornTail)
+	assert.Equal(t, validSize, check.ValidSize)
+	assert.Equal(t, 3, check.Records)
+
+	assert.NoError(t, TruncateSegment(cfg, segmentIds[0], check.ValidSize))
+	check, err = CheckSegment(cfg, segmentIds[0], nil)
+	assert.NoError(t, err)
+	assert.NoError(t, check.Err)
+}
+
+func TestCheckSegmentReportsCorruptBatches(t *testing.T) {
+	cfg := writeSegmentsToInspect(t, "TestCheckSegmentReportsCorruptBatches")
+	segmentIds, _ := SegmentIds(cfg)
+	check, _ := CheckSegment(cfg, segmentIds[0], nil)
+
+	file, err := os.OpenFile(segmentFilePath(cfg, segmentIds[0]), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 3, 1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	corrupt, err := CheckSegment(cfg, segmentIds[0], nil)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, corrupt.Err, fmt.Sprintf("entry at offset %d", check.SizeInBytes))
+	assert.False(t, corrupt.TornTail)
+	assert.Equal(t, check.SizeInBytes, corrupt.ValidSize)
+}
+
+func TestCheckSegmentDetectsACorruptPayload(t *testing.T) {
+	cfg := writeSegmentsToInspect(t, "TestCheckSegmentDetectsACorruptPayload")
+	segmentIds, _ := SegmentIds(cfg)
+	var batchOffsets []int
+	check, _ := CheckSegment(cfg, segmentIds[0], func(offset int, record Record) error {
+		batchOffsets = append(batchOffsets, offset)
+		return nil
+	})
+	assert.Equal(t, byte(segmentFormatVersion), check.Version)
+	assert.Equal(t, CodecNone, check.Codec)
+
+	filePath := segmentFilePath(cfg, segmentIds[0])
+	data, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	position := bytes.Index(data, []byte("message 1"))
+	assert.Greater(t, position, 0)
+	data[position] ^= 0xff
+	assert.NoError(t, os.WriteFile(filePath, data, 0644))
+
+	corrupt, err := CheckSegment(cfg, segmentIds[0], nil)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, corrupt.Err, ErrBatchChecksum)
+	assert.ErrorContains(t, corrupt.Err, fmt.Sprintf("entry at offset %d", batchOffsets[1]))
+	assert.False(t, corrupt.TornTail)
+	assert.Equal(t, batchOffsets[1], corrupt.ValidSize)
+	assert.Equal(t, 1, corrupt.Records)
+}
+
+func TestCheckIndexFindsATornTail(t *testing.T) {
+	cfg := writeSegmentsToInspect(t, "TestCheckIndexFindsATornTail")
+	file, err := os.OpenFile(cfg.IndexFilePath(), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = file.Write(make([]byte, 10))
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	var messageIds []int
+	check, err := CheckIndex(cfg, func(offset int, entry MessageEntry) error {
+		messageIds = append(messageIds, entry.MessageId())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, check.TornTail)
+	assert.Equal(t, []int{0, 1, 2}, messageIds)
+	assert.Equal(t, check.SizeInBytes-10, check.ValidSize)
+}
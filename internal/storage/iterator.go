@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNoMoreMessages is returned by Iterator.Next once the iterator has caught up
+// with the head of the queue. The iterator stays usable, calling Next again
+// returns messages appended in the meantime.
+var ErrNoMoreMessages = errors.New("no more messages")
+
+// errSegmentRewritten reports that an offset belongs to a segment file that has since been rewritten.
+var errSegmentRewritten = errors.New("segment rewritten")
+
+// errSegmentEvicted reports that a segment has been offloaded to the archive and must be fetched back first.
+var errSegmentEvicted = errors.New("segment evicted")
+
+// Iterator streams the records of a log in message ID order.
+// An iterator is not safe for concurrent use.
+type Iterator interface {
+	// Next returns the next record, or ErrNoMoreMessages when there is none yet.
+	Next() (Record, error)
+	// NextId returns the ID of the message the iterator will return next.
+	NextId() int
+}
+
+// segmentIterator streams records out of the segments in message ID order.
+// It looks up the location of its first message in the index and from there on
+// reads the segment files sequentially, moving on to the next segment when one is exhausted.
+type segmentIterator struct {
+	segments *Segments
+	nextId   int
+	segment  *Segment
+	offset   int
+	// generation is the generation of segment that offset belongs to.
+	generation int
+	// pending holds the records of the last batch read that have not been returned yet.
+	pending []Record
+}
+
+func (it *segmentIterator) Next() (Record, error) {
+	if it.segment == nil {
+		if err := it.seek(); err != nil {
+			return Record{}, err
+		}
+	}
+	for {
+		for len(it.pending) > 0 {
+			record := it.pending[0]
+			it.pending = it.pending[1:]
+			if record.Id >= it.nextId {
+				it.nextId = record.Id + 1
+				return record, nil
+			}
+		}
+		records, nextOffset, err := it.segment.readBatchOfGeneration(it.offset, it.generation)
+		if err == errSegmentRewritten {
+			if err := it.seek(); err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		if err == errSegmentEvicted {
+			if err := it.segments.fetchSegment(it.segment); err != nil {
+				return Record{}, err
+			}
+			// A segment restored straight from the archive only learns where
+			// its batches start once fetched.
+			if it.offset == 0 {
+				it.offset = it.segment.firstBatchOffset()
+			}
+			continue
+		}
+		if err == io.EOF {
+			segment, ok := it.segments.nextSegment(it.segment.id)
+			if !ok {
+				return Record{}, ErrNoMoreMessages
+			}
+			it.segment = segment
+			it.offset = segment.firstBatchOffset()
+			it.generation = segment.currentGeneration()
+			continue
+		}
+		if err != nil {
+			return Record{}, err
+		}
+		it.offset = nextOffset
+		it.pending = records
+	}
+}
+
+// seek positions the iterator on the segment and offset of the next message.
+// The generation of the segment is read before the location of the message,
+// so a segment rewritten concurrently is detected by the next read.
+func (it *segmentIterator) seek() error {
+	it.segment = nil
+	it.pending = nil
+	entry, ok := it.segments.index.GetOffsetFrom(it.nextId)
+	for {
+		if !ok {
+			return ErrNoMoreMessages
+		}
+		segment, err := it.segments.findSegment(entry.segmentId)
+		if err != nil {
+			return err
+		}
+		generation := segment.currentGeneration()
+		latest, found := it.segments.index.GetOffsetFrom(it.nextId)
+		if found && latest.segmentId == segment.id {
+			it.segment = segment
+			it.offset = latest.offset
+			it.generation = generation
+			return nil
+		}
+		entry, ok = latest, found
+	}
+}
+
+func (it *segmentIterator) NextId() int {
+	return it.nextId
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIteratorStreamsAcrossSegments(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestIteratorStreamsAcrossSegments"),
+		createTempDir("TestIteratorStreamsAcrossSegmentsMetadata"),
+		20,
+		time.Second,
+	)
+	defer removeTempDir("TestIteratorStreamsAcrossSegments")
+	defer removeTempDir("TestIteratorStreamsAcrossSegmentsMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	messages := []string{"Hello Segments", "Another Hello Segments", "Yet Another Hello Segments", "Last One"}
+	for _, message := range messages {
+		_, err := segments.Append([]byte(message))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, len(segments.closedSegments))
+
+	iterator := segments.NewIterator(1)
+	for id, message := range messages[1:] {
+		record, err := iterator.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, Record{Id: id + 1, Data: []byte(message)}, record)
+	}
+	_, err = iterator.Next()
+	assert.ErrorIs(t, err, ErrNoMoreMessages)
+}
+
+func TestIteratorResumesAfterNewAppends(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestIteratorResumesAfterNewAppends"),
+		createTempDir("TestIteratorResumesAfterNewAppendsMetadata"),
+		1000,
+		time.Second,
+	)
+	defer removeTempDir("TestIteratorResumesAfterNewAppends")
+	defer removeTempDir("TestIteratorResumesAfterNewAppendsMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	iterator := segments.NewIterator(0)
+	_, err = iterator.Next()
+	assert.ErrorIs(t, err, ErrNoMoreMessages)
+
+	messageId, _ := segments.Append([]byte("Hello Segments"))
+	record, err := iterator.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Id: messageId, Data: []byte("Hello Segments")}, record)
+
+	_, err = iterator.Next()
+	assert.ErrorIs(t, err, ErrNoMoreMessages)
+
+	messageId, _ = segments.Append([]byte("Another Hello Segments"))
+	record, err = iterator.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Id: messageId, Data: []byte("Another Hello Segments")}, record)
+	assert.Equal(t, messageId+1, iterator.NextId())
+}
+
+func TestIteratorReadsWhileTheSegmentRollsOver(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestIteratorReadsWhileTheSegmentRollsOver"),
+		createTempDir("TestIteratorReadsWhileTheSegmentRollsOverMetadata"),
+		200,
+		time.Second,
+	)
+	defer removeTempDir("TestIteratorReadsWhileTheSegmentRollsOver")
+	defer removeTempDir("TestIteratorReadsWhileTheSegmentRollsOverMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	_, err = segments.Append([]byte("message 0"))
+	assert.NoError(t, err)
+
+	// Readers keep reading the newest message, which sits in the active segment when it rolls over.
+	const count = 300
+	var newest atomic.Int64
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			iterator := segments.NewIterator(0)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				messageId := int(newest.Load())
+				data, err := segments.Read(messageId)
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("message %d", messageId), string(data))
+				if _, err := iterator.Next(); err != nil && err != ErrNoMoreMessages {
+					assert.NoError(t, err)
+				}
+			}
+		}()
+	}
+	for i := 1; i < count; i++ {
+		messageId, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+		newest.Store(int64(messageId))
+	}
+	close(done)
+	readers.Wait()
+	assert.Greater(t, len(segments.closedSegments), 5)
+}
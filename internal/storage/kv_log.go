@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// KVLogFileName is the name of the database file KVLog stores messages in, under the segments directory.
+const KVLogFileName = "log.db"
+
+// kvIteratorBatchSize is the number of records an iterator reads from the database at once.
+const kvIteratorBatchSize = 64
+
+var (
+	messagesBucket = []byte("messages")
+	metaBucket     = []byte("meta")
+	nextIdKey      = []byte("nextId")
+)
+
+// KVLog stores the messages of a queue in an embedded key-value database, keyed by message ID.
+// Every append is committed, and synced to disk, as one transaction.
+type KVLog struct {
+	config *config.Config
+	db     *bolt.DB
+	// mu serialises appends and truncations with the next ID and the number of messages.
+	mu     sync.RWMutex
+	nextId int
+	count  int
+}
+
+// OpenKVLog opens the database of the queue under the segments directory of cfg, creating it when missing.
+func OpenKVLog(cfg *config.Config) (*KVLog, error) {
+	if err := os.MkdirAll(cfg.SegmentsRoot(), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(cfg.SegmentsRoot(), KVLogFileName), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", KVLogFileName, err)
+	}
+	l := &KVLog{config: cfg, db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		messages, err := tx.CreateBucketIfNotExists(messagesBucket)
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if value := meta.Get(nextIdKey); value != nil {
+			l.nextId = int(binary.BigEndian.Uint64(value))
+		}
+		l.count = messages.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	return l, nil
+}
+
+// AppendRecords appends the records under consecutive IDs from NextId in one transaction.
+func (l *KVLog) AppendRecords(records []Record) ([]int, error) {
+	for _, record := range records {
+		if err := checkMessageSize(l.config, record); err != nil {
+			return nil, err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records = slices.Clone(records)
+	messageIds := make([]int, len(records))
+	for i := range records {
+		records[i].Id = l.nextId + i
+		messageIds[i] = records[i].Id
+	}
+	if err := l.put(records); err != nil {
+		return nil, err
+	}
+	return messageIds, nil
+}
+
+// AppendReplicated appends the records under the IDs they carry in one transaction.
+func (l *KVLog) AppendReplicated(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := checkReplicatedIds(records, l.nextId); err != nil {
+		return err
+	}
+	return l.put(records)
+}
+
+// put stores the records and moves the next ID past the last of them. It must be called with l.mu held.
+func (l *KVLog) put(records []Record) error {
+	size := 0
+	nextId := l.nextId
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(messagesBucket)
+		for _, record := range records {
+			encoded := record.Encode()
+			if err := messages.Put(kvKey(record.Id), encoded); err != nil {
+				return err
+			}
+			size += len(encoded)
+			nextId = record.Id + 1
+		}
+		return tx.Bucket(metaBucket).Put(nextIdKey, kvKey(nextId))
+	})
+	if err != nil {
+		return err
+	}
+	l.nextId = nextId
+	l.count += len(records)
+	l.config.Metrics().Appended(size)
+	return nil
+}
+
+// Read returns the data of the message with the given ID.
+func (l *KVLog) Read(messageId int) ([]byte, error) {
+	var record Record
+	err := l.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(messagesBucket).Get(kvKey(messageId))
+		if value == nil {
+			return fmt.Errorf("unknown message id: %d", messageId)
+		}
+		return record.Decode(slices.Clone(value))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record.Data, nil
+}
+
+// NewIterator returns an iterator streaming the records from the message with ID fromId on.
+func (l *KVLog) NewIterator(fromId int) Iterator {
+	return &kvIterator{log: l, nextId: fromId}
+}
+
+// Truncate drops the messages with IDs below beforeId in one transaction and returns how many it dropped.
+func (l *KVLog) Truncate(beforeId int) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dropped := 0
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(messagesBucket).Cursor()
+		for key, _ := cursor.First(); key != nil && kvId(key) < beforeId; key, _ = cursor.First() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			dropped++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	l.count -= dropped
+	return dropped, nil
+}
+
+// Head returns the ID of the oldest message stored, -1 when there is none.
+func (l *KVLog) Head() int {
+	return l.edge(func(cursor *bolt.Cursor) []byte { key, _ := cursor.First(); return key })
+}
+
+// Tail returns the ID of the newest message stored, -1 when there is none.
+func (l *KVLog) Tail() int {
+	return l.edge(func(cursor *bolt.Cursor) []byte { key, _ := cursor.Last(); return key })
+}
+
+// edge returns the ID of the key the cursor is moved to, -1 when there is none.
+func (l *KVLog) edge(move func(*bolt.Cursor) []byte) int {
+	id := -1
+	_ = l.db.View(func(tx *bolt.Tx) error {
+		if key := move(tx.Bucket(messagesBucket).Cursor()); key != nil {
+			id = kvId(key)
+		}
+		return nil
+	})
+	return id
+}
+
+// NextId returns the ID the next appended message will be assigned.
+func (l *KVLog) NextId() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.nextId
+}
+
+// Len returns the number of messages stored.
+func (l *KVLog) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.count
+}
+
+// SizeInBytes returns the size of the database file.
+func (l *KVLog) SizeInBytes() int {
+	size := 0
+	_ = l.db.View(func(tx *bolt.Tx) error {
+		size = int(tx.Size())
+		return nil
+	})
+	return size
+}
+
+// Flush syncs the database file, which every committed append already is.
+func (l *KVLog) Flush() error {
+	return l.db.Sync()
+}
+
+func (l *KVLog) Close() error {
+	return l.db.Close()
+}
+
+// Snapshot writes the database as it is at the time of the call to the segments directory of target,
+// where OpenKVLog opens it. Appends go on while the database is copied.
+func (l *KVLog) Snapshot(target *config.Config) (SnapshotInfo, error) {
+	if err := os.MkdirAll(target.SegmentsRoot(), 0755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	var info SnapshotInfo
+	err := l.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(metaBucket).Get(nextIdKey); value != nil {
+			info.NextId = int(binary.BigEndian.Uint64(value))
+		}
+		file, err := os.OpenFile(filepath.Join(target.SegmentsRoot(), KVLogFileName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		written, err := tx.WriteTo(file)
+		if err == nil {
+			err = file.Sync()
+		}
+		info.SizeInBytes = int(written)
+		return errors.Join(err, file.Close())
+	})
+	if err != nil {
+		return info, err
+	}
+	return info, syncDir(target.SegmentsRoot())
+}
+
+// kvKey encodes a message ID as a big-endian key, so keys sort in ID order.
+func kvKey(messageId int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(messageId))
+}
+
+func kvId(key []byte) int {
+	return int(binary.BigEndian.Uint64(key))
+}
+
+// kvIterator streams the records of a KVLog, reading them in batches of kvIteratorBatchSize.
+type kvIterator struct {
+	log     *KVLog
+	nextId  int
+	pending []Record
+}
+
+func (it *kvIterator) Next() (Record, error) {
+	if len(it.pending) == 0 {
+		if err := it.read(); err != nil {
+			return Record{}, err
+		}
+		if len(it.pending) == 0 {
+			return Record{}, ErrNoMoreMessages
+		}
+	}
+	record := it.pending[0]
+	it.pending = it.pending[1:]
+	it.nextId = record.Id + 1
+	return record, nil
+}
+
+// read reads the next batch of records from nextId on.
+func (it *kvIterator) read() error {
+	return it.log.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(messagesBucket).Cursor()
+		for key, value := cursor.Seek(kvKey(max(it.nextId, 0))); key != nil && len(it.pending) < kvIteratorBatchSize; key, value = cursor.Next() {
+			var record Record
+			if err := record.Decode(slices.Clone(value)); err != nil {
+				return fmt.Errorf("decode message %d: %w", kvId(key), err)
+			}
+			it.pending = append(it.pending, record)
+		}
+		return nil
+	})
+}
+
+func (it *kvIterator) NextId() int {
+	return it.nextId
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKVLogIsRestoredWhenReopened(t *testing.T) {
+	cfg := config.NewConfig(filepath.Join(t.TempDir(), "segments"), t.TempDir(), 64, time.Second)
+	log, err := OpenKVLog(cfg)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.AppendRecords([]Record{{Data: []byte(fmt.Sprintf("message %d", i))}})
+		assert.NoError(t, err)
+	}
+	_, err = log.Truncate(3)
+	assert.NoError(t, err)
+	_, err = log.AppendRecords([]Record{{Data: []byte("message 3")}})
+	assert.NoError(t, err)
+	assert.NoError(t, log.Close())
+
+	log, err = OpenKVLog(cfg)
+	assert.NoError(t, err)
+	defer log.Close()
+	assert.Equal(t, 4, log.NextId())
+	assert.Equal(t, 1, log.Len())
+	data, err := log.Read(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "message 3", string(data))
+}
+
+func TestKVLogSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second)
+	log, err := OpenKVLog(cfg)
+	assert.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 5; i++ {
+		_, err := log.AppendRecords([]Record{{Data: []byte(fmt.Sprintf("message %d", i))}})
+		assert.NoError(t, err)
+	}
+
+	target := config.NewConfig(filepath.Join(dir, "snapshot", "segments"), filepath.Join(dir, "snapshot", "metadata"), 64, time.Second)
+	info, err := log.Snapshot(target)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, info.NextId)
+	assert.Greater(t, info.SizeInBytes, 0)
+	_, err = log.AppendRecords([]Record{{Data: []byte("after the snapshot")}})
+	assert.NoError(t, err)
+	_, err = log.Snapshot(target)
+	assert.Error(t, err)
+
+	restored, err := OpenKVLog(target)
+	assert.NoError(t, err)
+	defer restored.Close()
+	assert.Equal(t, 5, restored.NextId())
+	assert.Equal(t, 4, restored.Tail())
+}
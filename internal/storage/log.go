@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+)
+
+// LogStorage stores the messages of a queue under increasing message IDs.
+// Segments is the default implementation, MemoryLog and KVLog the others, chosen by config.StorageBackend.
+// Implementations are safe for concurrent use.
+type LogStorage interface {
+	// AppendRecords appends the records under consecutive IDs from NextId, ignoring the IDs they carry,
+	// and returns the IDs assigned to them in order.
+	AppendRecords(records []Record) ([]int, error)
+	// AppendReplicated appends records under the IDs they carry, which must be increasing and not below NextId.
+	AppendReplicated(records []Record) error
+	// Read returns the data of the message with the given ID.
+	Read(messageId int) ([]byte, error)
+	// NewIterator returns an iterator streaming the records from the message with ID fromId on.
+	NewIterator(fromId int) Iterator
+	// Truncate drops messages with IDs below beforeId and returns how many it dropped.
+	// Every message is dropped when beforeId is at least NextId, IDs keep increasing from NextId.
+	Truncate(beforeId int) (int, error)
+	// Head returns the ID of the oldest message stored and Tail of the newest, both -1 when there is none.
+	Head() int
+	Tail() int
+	// NextId returns the ID the next appended message will be assigned.
+	NextId() int
+	// Len returns the number of messages stored, SizeInBytes the space they take.
+	Len() int
+	SizeInBytes() int
+	// Flush persists the messages appended so far.
+	Flush() error
+	Close() error
+}
+
+// Compactor is implemented by the logs that can be compacted by message key.
+type Compactor interface {
+	// Compact drops the messages superseded by a newer message with the same key and returns how many it dropped.
+	Compact() (int, error)
+}
+
+// Snapshotter is implemented by the logs that can be copied while they are appended to.
+type Snapshotter interface {
+	// Snapshot writes the log as it is at the time of the call to the directories of target,
+	// from which the same backend restores it.
+	Snapshot(target *config.Config) (SnapshotInfo, error)
+}
+
+// checkMessageSize rejects a record whose key, headers and data exceed the maximum message size,
+// before anything is written.
+func checkMessageSize(cfg *config.Config, record Record) error {
+	if size := len(record.Key) + HeadersSize(record.Headers) + len(record.Data); size > cfg.MaxMessageSize() {
+		return fmt.Errorf("%w: %d bytes, the maximum is %d", ErrMessageTooLarge, size, cfg.MaxMessageSize())
+	}
+	return nil
+}
+
+// checkReplicatedIds rejects records whose IDs are not increasing from nextId.
+func checkReplicatedIds(records []Record, nextId int) error {
+	for _, record := range records {
+		if record.Id < nextId {
+			return fmt.Errorf("message id %d out of order, next id is %d", record.Id, nextId)
+		}
+		nextId = record.Id + 1
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newLogs returns a log of every backend, with segments of 64 bytes under dir.
+func newLogs(t *testing.T, dir string) map[string]LogStorage {
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second)
+	assert.NoError(t, os.MkdirAll(cfg.SegmentsRoot(), 0755))
+	assert.NoError(t, os.MkdirAll(cfg.MetadataPath, 0755))
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	kvLog, err := OpenKVLog(cfg.WithSegmentsRoot(filepath.Join(dir, "kv")))
+	assert.NoError(t, err)
+	t.Cleanup(func() { index.Close() })
+	return map[string]LogStorage{"segments": segments, "memory": NewMemoryLog(cfg), "kv": kvLog}
+}
+
+func TestLogStorageBackendsBehaveAlike(t *testing.T) {
+	for name, log := range newLogs(t, t.TempDir()) {
+		t.Run(name, func(t *testing.T) {
+			defer log.Close()
+			assert.Equal(t, -1, log.Head())
+			assert.Equal(t, -1, log.Tail())
+			for i := 0; i < 10; i++ {
+				messageIds, err := log.AppendRecords([]Record{{Id: 42, Data: []byte(fmt.Sprintf("message %d", i))}})
+				assert.NoError(t, err)
+				assert.Equal(t, []int{i}, messageIds)
+			}
+			assert.NoError(t, log.AppendReplicated([]Record{{Id: 12, Key: []byte("key"), Headers: map[string]string{"h": "v"}, Data: []byte("replicated")}}))
+			assert.Error(t, log.AppendReplicated([]Record{{Id: 11, Data: []byte("out of order")}}))
+			_, err := log.AppendRecords([]Record{{Data: make([]byte, config.DefaultMaxMessageSize+1)}})
+			assert.ErrorIs(t, err, ErrMessageTooLarge)
+			assert.NoError(t, log.Flush())
+
+			assert.Equal(t, 13, log.NextId())
+			assert.Equal(t, 11, log.Len())
+			assert.Equal(t, 0, log.Head())
+			assert.Equal(t, 12, log.Tail())
+			assert.Greater(t, log.SizeInBytes(), 0)
+			data, err := log.Read(3)
+			assert.NoError(t, err)
+			assert.Equal(t, "message 3", string(data))
+			_, err = log.Read(11)
+			assert.Error(t, err)
+
+			iterator := log.NewIterator(9)
+			record, err := iterator.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, Record{Id: 9, Data: []byte("message 9")}, record)
+			record, err = iterator.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, Record{Id: 12, Key: []byte("key"), Headers: map[string]string{"h": "v"}, Data: []byte("replicated")}, record)
+			_, err = iterator.Next()
+			assert.ErrorIs(t, err, ErrNoMoreMessages)
+			assert.Equal(t, 13, iterator.NextId())
+
+			dropped, err := log.Truncate(5)
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, dropped, 5)
+			assert.Equal(t, 11-dropped, log.Len())
+			assert.Equal(t, dropped, log.Head())
+			_, err = log.Read(5)
+			assert.NoError(t, err)
+
+			_, err = log.Truncate(log.NextId())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, log.Len())
+			assert.Equal(t, -1, log.Head())
+			messageIds, err := log.AppendRecords([]Record{{Data: []byte("after truncation")}})
+			assert.NoError(t, err)
+			assert.Equal(t, []int{13}, messageIds)
+			record, err = iterator.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, 13, record.Id)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// MemoryLog keeps the messages of a queue in memory, for tests and ephemeral queues
+// whose messages need not survive a restart.
+type MemoryLog struct {
+	config *config.Config
+	mu     sync.RWMutex
+	// records are sorted by ID, there may be gaps between the IDs of replicated records.
+	records []Record
+	nextId  int
+	size    int
+}
+
+// NewMemoryLog returns an empty MemoryLog.
+func NewMemoryLog(cfg *config.Config) *MemoryLog {
+	return &MemoryLog{config: cfg}
+}
+
+// AppendRecords appends copies of the records under consecutive IDs from NextId.
+func (l *MemoryLog) AppendRecords(records []Record) ([]int, error) {
+	for _, record := range records {
+		if err := checkMessageSize(l.config, record); err != nil {
+			return nil, err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	messageIds := make([]int, len(records))
+	for i, record := range records {
+		record.Id = l.nextId
+		messageIds[i] = record.Id
+		l.append(record)
+	}
+	return messageIds, nil
+}
+
+// AppendReplicated appends copies of the records under the IDs they carry.
+func (l *MemoryLog) AppendReplicated(records []Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := checkReplicatedIds(records, l.nextId); err != nil {
+		return err
+	}
+	for _, record := range records {
+		l.append(record)
+	}
+	return nil
+}
+
+// append must be called with l.mu held.
+func (l *MemoryLog) append(record Record) {
+	record.Key = slices.Clone(record.Key)
+	record.Headers = maps.Clone(record.Headers)
+	record.Data = slices.Clone(record.Data)
+	l.records = append(l.records, record)
+	l.nextId = record.Id + 1
+	l.size += record.size()
+	l.config.Metrics().Appended(record.size())
+}
+
+// Read returns the data of the message with the given ID, which must not be modified.
+func (l *MemoryLog) Read(messageId int) ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := l.search(messageId)
+	if i == len(l.records) || l.records[i].Id != messageId {
+		return nil, fmt.Errorf("unknown message id: %d", messageId)
+	}
+	return l.records[i].Data, nil
+}
+
+// search returns the position of the first record with an ID of at least messageId.
+// It must be called with l.mu held.
+func (l *MemoryLog) search(messageId int) int {
+	return sort.Search(len(l.records), func(i int) bool { return l.records[i].Id >= messageId })
+}
+
+// NewIterator returns an iterator streaming the records from the message with ID fromId on.
+func (l *MemoryLog) NewIterator(fromId int) Iterator {
+	return &memoryIterator{log: l, nextId: fromId}
+}
+
+// Truncate drops the messages with IDs below beforeId and returns how many it dropped.
+func (l *MemoryLog) Truncate(beforeId int) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := l.search(beforeId)
+	for _, record := range l.records[:dropped] {
+		l.size -= record.size()
+	}
+	l.records = slices.Clone(l.records[dropped:])
+	return dropped, nil
+}
+
+// Head returns the ID of the oldest message stored, -1 when there is none.
+func (l *MemoryLog) Head() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.records) == 0 {
+		return -1
+	}
+	return l.records[0].Id
+}
+
+// Tail returns the ID of the newest message stored, -1 when there is none.
+func (l *MemoryLog) Tail() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.records) == 0 {
+		return -1
+	}
+	return l.records[len(l.records)-1].Id
+}
+
+// NextId returns the ID the next appended message will be assigned.
+func (l *MemoryLog) NextId() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.nextId
+}
+
+// Len returns the number of messages stored.
+func (l *MemoryLog) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.records)
+}
+
+// SizeInBytes returns the size of the records stored, laid out as in a segment.
+func (l *MemoryLog) SizeInBytes() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.size
+}
+
+// Flush does nothing, the messages of a MemoryLog are never persisted.
+func (l *MemoryLog) Flush() error {
+	return nil
+}
+
+// Close drops every message.
+func (l *MemoryLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = nil
+	l.size = 0
+	return nil
+}
+
+// memoryIterator streams the records of a MemoryLog, looking up the next one on every call.
+type memoryIterator struct {
+	log    *MemoryLog
+	nextId int
+}
+
+func (it *memoryIterator) Next() (Record, error) {
+	it.log.mu.RLock()
+	defer it.log.mu.RUnlock()
+	i := it.log.search(it.nextId)
+	if i == len(it.log.records) {
+		return Record{}, ErrNoMoreMessages
+	}
+	record := it.log.records[i]
+	it.nextId = record.Id + 1
+	return record, nil
+}
+
+func (it *memoryIterator) NextId() int {
+	return it.nextId
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLogStoresCopiesOfTheRecords(t *testing.T) {
+	log := NewMemoryLog(config.DefaultConfig())
+	data := []byte("message")
+	headers := map[string]string{"h": "v"}
+	_, err := log.AppendRecords([]Record{{Headers: headers, Data: data}})
+	assert.NoError(t, err)
+	data[0] = 'M'
+	headers["h"] = "changed"
+
+	record, err := log.NewIterator(0).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Id: 0, Headers: map[string]string{"h": "v"}, Data: []byte("message")}, record)
+	assert.Equal(t, record.size(), log.SizeInBytes())
+	_, err = log.Truncate(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, log.SizeInBytes())
+}
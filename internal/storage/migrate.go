@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// MigrateBaselineSegments rewrites the segments written before segment files had a header,
+// which hold bare message payloads, into the current segment format with the configured codec
+// and keyring, then rebuilds the index. Such segments can only be read once migrated.
+//
+// Their messages are numbered from 0 in the order of the segments and of the payloads within
+// them, which is the order the index assigned IDs in at the time. Headerless segments must
+// therefore all come before the first segment with a header. Segments that already have a header
+// are left untouched, so migrating twice does nothing. It returns the number of segments
+// rewritten and of messages they hold, and must not be called while a queue is open on cfg.
+func MigrateBaselineSegments(cfg *config.Config) (int, int, error) {
+	codec, err := ParseCodec(cfg.Compression())
+	if err != nil {
+		return 0, 0, err
+	}
+	segmentIds, err := getSegmentIds(cfg.SegmentsRoot())
+	if err != nil {
+		return 0, 0, err
+	}
+	segments, nextId := 0, 0
+	for i, segmentId := range segmentIds {
+		payloads, headerless, err := readBaselineSegment(cfg, segmentId)
+		if err != nil {
+			return segments, nextId, fmt.Errorf("segment %d: %w", segmentId, err)
+		}
+		if !headerless {
+			continue
+		}
+		if i != segments {
+			return segments, nextId, fmt.Errorf("segment %d has no header but follows segment %d which has one", segmentId, segmentIds[i-1])
+		}
+		records := make([]Record, len(payloads))
+		for j, payload := range payloads {
+			records[j] = Record{Id: nextId, Data: payload}
+			nextId++
+		}
+		if err := rewriteSegment(cfg, codec, segmentId, records); err != nil {
+			return segments, nextId, fmt.Errorf("segment %d: %w", segmentId, err)
+		}
+		segments++
+	}
+	if segments == 0 {
+		return 0, 0, nil
+	}
+	if err := syncDir(cfg.SegmentsRoot()); err != nil {
+		return segments, nextId, err
+	}
+	_, err = RebuildIndex(cfg)
+	return segments, nextId, err
+}
+
+// readBaselineSegment returns the payloads of a segment when it has no header, an empty file
+// counting as a headerless segment without payloads. Reading stops at a partially written tail.
+func readBaselineSegment(cfg *config.Config, segmentId int) ([][]byte, bool, error) {
+	store, err := RestoreEncryptedStore(segmentFilePath(cfg, segmentId), cfg.Keyring())
+	if err != nil {
+		return nil, false, err
+	}
+	defer store.Close()
+	_, _, err = readSegmentHeader(store)
+	if err == nil || (!errors.Is(err, ErrNoSegmentHeader) && err != io.EOF) {
+		return nil, false, err
+	}
+	payloads, err := store.readAllEntries()
+	return payloads, true, err
+}
+
+// rewriteSegment replaces the file of the segment with one holding the records, in batches
+// compressed with the codec.
+func rewriteSegment(cfg *config.Config, codec Codec, segmentId int, records []Record) error {
+	filePath := segmentFilePath(cfg, segmentId)
+	tmpPath := filePath + ".migrate"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	store, err := NewEncryptedStore(tmpPath, cfg.Keyring())
+	if err != nil {
+		return err
+	}
+	_, err = writeBatches(store, codec, segmentId, records)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeBaselineSegment writes a segment as servers did before segments had a header,
+// every entry being the bare payload of a message.
+func writeBaselineSegment(t *testing.T, cfg *config.Config, segmentId int, payloads ...string) {
+	store, err := NewStore(segmentFilePath(cfg, segmentId))
+	assert.NoError(t, err)
+	for _, payload := range payloads {
+		_, err := store.Append([]byte(payload))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Close())
+}
+
+func TestMigrateBaselineSegments(t *testing.T) {
+	name := "TestMigrateBaselineSegments"
+	cfg := config.NewConfig(createTempDir(name+"/segments"), createTempDir(name+"/metadata"), 1000, time.Second)
+	t.Cleanup(func() { removeTempDir(name) })
+	writeBaselineSegment(t, cfg, 0, "message 0", "message 1")
+	writeBaselineSegment(t, cfg, 1, "message 2")
+	writeBaselineSegment(t, cfg, 2)
+
+	_, err := RestoreSegment(0, cfg)
+	assert.ErrorIs(t, err, ErrNoSegmentHeader)
+
+	segments, messages, err := MigrateBaselineSegments(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, segments)
+	assert.Equal(t, 3, messages)
+
+	index, err := RestoreIndex(cfg)
+	assert.NoError(t, err)
+	restored, err := RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		data, err := restored.Read(i)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("message %d", i), string(data))
+	}
+	messageId, err := restored.Append([]byte("message 3"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, messageId)
+	assert.NoError(t, restored.Close())
+	assert.NoError(t, index.Close())
+
+	segments, messages, err = MigrateBaselineSegments(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, segments)
+	assert.Equal(t, 0, messages)
+}
+
+func TestMigrateRejectsHeaderlessSegmentsAfterMigratedOnes(t *testing.T) {
+	cfg := writeSegmentsToInspect(t, "TestMigrateRejectsHeaderlessSegments")
+	segmentIds, err := SegmentIds(cfg)
+	assert.NoError(t, err)
+	writeBaselineSegment(t, cfg, segmentIds[len(segmentIds)-1]+1, "late")
+
+	_, _, err = MigrateBaselineSegments(cfg)
+	assert.ErrorContains(t, err, "has no header but follows segment")
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported on this platform, stores fall back to ReadAt.
+func mmapFile(_ *os.File, _ int) ([]byte, error) {
+	return nil, errors.New("mmap not supported")
+}
+
+func munmapFile(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Purge drops every message of the queue and returns how many it dropped.
+// Every segment is removed, from local disk and from the archive, and appends
+// continue in a new empty segment. Message IDs keep increasing from NextId,
+// so consumer positions stay valid. Iterators positioned on the dropped
+// messages move on to the messages appended after the purge.
+func (s *Segments) Purge() (int, error) {
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+	if t := s.tiered; t != nil {
+		t.archiving.Lock()
+		defer t.archiving.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.tiered; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+	return s.purgeLocked()
+}
+
+// purgeLocked must be called with s.maintenance, s.tiered.archiving, s.mu and s.tiered.mu held.
+func (s *Segments) purgeLocked() (int, error) {
+	active, err := NewSegment(s.id+1, s.config)
+	if err != nil {
+		return 0, err
+	}
+	purged := append(s.closedSegments, s.active)
+	s.id++
+	s.active = active
+	s.closedSegments = make([]*Segment, 0)
+
+	dropped, err := s.index.RemoveAll()
+	if err != nil {
+		return 0, err
+	}
+	for _, segment := range purged {
+		if err := segment.remove(); err != nil {
+			return dropped, fmt.Errorf("remove segment %d: %w", segment.id, err)
+		}
+		if err := s.tiered.forget(segment); err != nil {
+			return dropped, err
+		}
+	}
+	s.config.Logger().Info("purged queue", "dropped", dropped, "next_segment", active.id)
+	return dropped, nil
+}
+
+// Truncate drops the messages with IDs below beforeId and returns how many it dropped.
+// Messages are dropped a whole closed segment at a time, so the segment holding message
+// beforeId-1 is kept along with the messages before beforeId it holds. When beforeId is
+// at least NextId, the queue is purged, see Purge.
+func (s *Segments) Truncate(beforeId int) (int, error) {
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+	if t := s.tiered; t != nil {
+		t.archiving.Lock()
+		defer t.archiving.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.tiered; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+	if beforeId >= s.index.NextElementId() {
+		return s.purgeLocked()
+	}
+
+	ranges := s.index.segmentRanges()
+	count := 0
+	for _, segment := range s.closedSegments {
+		if r, ok := ranges[segment.id]; ok && r.last >= beforeId {
+			break
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	truncated := s.closedSegments[:count]
+	s.closedSegments = slices.Clone(s.closedSegments[count:])
+	segmentIds := make([]int, len(truncated))
+	for i, segment := range truncated {
+		segmentIds[i] = segment.id
+	}
+	dropped, err := s.index.removeSegments(segmentIds)
+	if err != nil {
+		return 0, err
+	}
+	for _, segment := range truncated {
+		if err := segment.remove(); err != nil {
+			return dropped, fmt.Errorf("remove segment %d: %w", segment.id, err)
+		}
+		if err := s.tiered.forget(segment); err != nil {
+			return dropped, err
+		}
+	}
+	s.config.Logger().Info("truncated queue", "dropped", dropped, "segments", len(truncated), "before", beforeId)
+	return dropped, nil
+}
+
+// forget deletes a removed segment from the archive. It must be called with t.mu held.
+func (t *tieredStorage) forget(segment *Segment) error {
+	if t == nil {
+		return nil
+	}
+	if t.archived[segment.id] {
+		if err := t.archive.Delete(segment.id); err != nil {
+			return fmt.Errorf("delete archived segment %d: %w", segment.id, err)
+		}
+		delete(t.archived, segment.id)
+	}
+	if i := slices.Index(t.fetched, segment); i >= 0 {
+		t.fetched = slices.Delete(t.fetched, i, i+1)
+	}
+	return nil
+}
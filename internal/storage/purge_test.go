@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/archive"
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeDropsEveryMessageAndKeepsTheNextId(t *testing.T) {
+	cfg := config.NewConfig(createTempDir("SegmentTestPurge"), createTempDir("metadataPurge"), 64, time.Second)
+	defer removeTempDir("SegmentTestPurge")
+	defer removeTempDir("metadataPurge")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+	assert.Greater(t, segments.Stats().Count, 1)
+	iterator := segments.NewIterator(0)
+	_, err = iterator.Next()
+	assert.NoError(t, err)
+
+	dropped, err := segments.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, dropped)
+	assert.Equal(t, 10, segments.NextId())
+	assert.Equal(t, 0, index.Len())
+	_, err = segments.Read(3)
+	assert.Error(t, err)
+	_, err = iterator.Next()
+	assert.ErrorIs(t, err, ErrNoMoreMessages)
+
+	messageId, err := segments.Append([]byte("after purge"))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, messageId)
+	record, err := iterator.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Id: 10, Data: []byte("after purge")}, record)
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+
+	index, err = RestoreIndex(cfg)
+	assert.NoError(t, err)
+	defer index.Close()
+	segments, err = RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	assert.Equal(t, 11, segments.NextId())
+	record, err = segments.NewIterator(0).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, record.Id)
+}
+
+func TestTruncateDropsWholeClosedSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second)
+	assert.NoError(t, os.MkdirAll(cfg.SegmentsRoot(), 0755))
+	assert.NoError(t, os.MkdirAll(cfg.MetadataPath, 0755))
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+	infos := segments.Describe()
+	assert.Greater(t, len(infos), 2)
+
+	dropped, err := segments.Truncate(infos[1].LastId)
+	assert.NoError(t, err)
+	assert.Equal(t, infos[0].Messages, dropped)
+	assert.Equal(t, infos[1].FirstId, segments.Head())
+	assert.Equal(t, len(infos)-1, segments.Stats().Count)
+	segmentIds, err := getSegmentIds(cfg.SegmentsRoot())
+	assert.NoError(t, err)
+	assert.NotContains(t, segmentIds, infos[0].Id)
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+
+	index, err = RestoreIndex(cfg)
+	assert.NoError(t, err)
+	defer index.Close()
+	segments, err = RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	assert.Equal(t, 10, segments.NextId())
+	assert.Equal(t, 10-dropped, segments.Len())
+	record, err := segments.NewIterator(0).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, infos[1].FirstId, record.Id)
+}
+
+func TestPurgeDeletesArchivedSegments(t *testing.T) {
+	dir := t.TempDir()
+	segmentArchive, err := archive.NewDirectoryArchive(filepath.Join(dir, "archive"))
+	assert.NoError(t, err)
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second).
+		WithArchive(segmentArchive).
+		WithLocalSegmentsRetained(1)
+	assert.NoError(t, os.MkdirAll(cfg.SegmentsRoot(), 0755))
+	assert.NoError(t, os.MkdirAll(cfg.MetadataPath, 0755))
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	for i := 0; i < 10; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+	segments.tiered.wait()
+	assert.NoError(t, segments.ArchiveClosedSegments())
+	archived, _ := segmentArchive.List()
+	assert.NotEmpty(t, archived)
+
+	_, err = segments.Purge()
+	assert.NoError(t, err)
+	archived, _ = segmentArchive.List()
+	assert.Empty(t, archived)
+	segmentIds, err := getSegmentIds(cfg.SegmentsRoot())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{segments.active.id}, segmentIds)
+}
+
+func TestDescribeListsTheIdRangeOfEverySegment(t *testing.T) {
+	cfg := config.NewConfig(createTempDir("SegmentTestDescribe"), createTempDir("metadataDescribe"), 64, time.Second)
+	defer removeTempDir("SegmentTestDescribe")
+	defer removeTempDir("metadataDescribe")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	for i := 0; i < 6; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+
+	infos := segments.Describe()
+	assert.Equal(t, segments.Stats().Count, len(infos))
+	nextId := 0
+	for _, info := range infos[:len(infos)-1] {
+		assert.Equal(t, nextId, info.FirstId)
+		assert.Equal(t, info.FirstId+info.Messages-1, info.LastId)
+		assert.True(t, info.Local)
+		assert.False(t, info.Active)
+		assert.Greater(t, info.SizeInBytes, 0)
+		nextId = info.LastId + 1
+	}
+	active := infos[len(infos)-1]
+	assert.True(t, active.Active)
+	assert.Equal(t, 6, nextId+active.Messages)
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// IndexDiff describes how the index regenerated from the segment files
+// differs from the index found on disk.
+type IndexDiff struct {
+	// Entries is the number of entries recovered from the segment files.
+	Entries int
+	// Missing holds the IDs found in segments but absent from the index on disk.
+	Missing []int
+	// Mismatched holds the IDs the index on disk points to the wrong location for.
+	Mismatched []int
+	// Unknown holds the IDs present in the index on disk but in no segment.
+	Unknown []int
+	// IndexErr is set when the index on disk could not be read at all.
+	IndexErr error
+}
+
+// Matches reports whether the index on disk agrees with the segment files.
+func (d *IndexDiff) Matches() bool {
+	return d.IndexErr == nil && len(d.Missing) == 0 && len(d.Mismatched) == 0 && len(d.Unknown) == 0
+}
+
+func (d *IndexDiff) String() string {
+	if d.IndexErr != nil {
+		return fmt.Sprintf("%d entries recovered from segments, index on disk unreadable: %v", d.Entries, d.IndexErr)
+	}
+	return fmt.Sprintf(
+		"%d entries recovered from segments, %d missing, %d mismatched, %d unknown",
+		d.Entries, len(d.Missing), len(d.Mismatched), len(d.Unknown),
+	)
+}
+
+// VerifyIndex regenerates the index from the segment files under SegmentsRoot
+// and compares it against the index file on disk, without modifying anything.
+func VerifyIndex(cfg *config.Config) (*IndexDiff, error) {
+	_, diff, err := scanIndex(cfg)
+	return diff, err
+}
+
+// RebuildIndex regenerates the index purely from the segment files under SegmentsRoot,
+// scanning segments in order, and replaces the index file on disk with the result.
+// The returned diff reports how the replaced index differed from the segments.
+// It must not be called while a queue is open on the same configuration.
+func RebuildIndex(cfg *config.Config) (*IndexDiff, error) {
+	entries, diff, err := scanIndex(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeIndexFile(cfg, entries); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// scanIndex reads every segment in order and returns the entries it found,
+// along with their difference from the index on disk.
+func scanIndex(cfg *config.Config) ([]MessageEntry, *IndexDiff, error) {
+	entries, err := scanSegmentEntries(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff := &IndexDiff{Entries: len(entries)}
+	existing, err := readIndexFile(cfg)
+	if err != nil {
+		diff.IndexErr = err
+		existing = make(map[int]MessageEntry)
+	}
+	seen := make(map[int]bool, len(entries))
+	for _, entry := range entries {
+		seen[entry.elementId] = true
+		old, ok := existing[entry.elementId]
+		if !ok {
+			diff.Missing = append(diff.Missing, entry.elementId)
+		} else if old != entry {
+			diff.Mismatched = append(diff.Mismatched, entry.elementId)
+		}
+	}
+	for elementId := range existing {
+		if !seen[elementId] {
+			diff.Unknown = append(diff.Unknown, elementId)
+		}
+	}
+	slices.Sort(diff.Unknown)
+	return entries, diff, nil
+}
+
+// scanSegmentEntries reads the local segments, along with the segments only present
+// in the archive when one is configured, which are downloaded to a temporary directory.
+func scanSegmentEntries(cfg *config.Config) ([]MessageEntry, error) {
+	segmentIds, err := getSegmentIds(cfg.SegmentsRoot())
+	if err != nil {
+		return nil, err
+	}
+	var archivedIds []int
+	if cfg.Archive() != nil {
+		if archivedIds, err = cfg.Archive().List(); err != nil {
+			return nil, err
+		}
+	}
+	tmpDir, err := os.MkdirTemp("", "queue-rebuild-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var entries []MessageEntry
+	for _, segmentId := range slices.Compact(slices.Sorted(slices.Values(append(segmentIds, archivedIds...)))) {
+		filePath := segmentFilePath(cfg, segmentId)
+		if !slices.Contains(segmentIds, segmentId) {
+			filePath = filepath.Join(tmpDir, fmt.Sprintf("segment-%d", segmentId))
+			if err := cfg.Archive().Download(segmentId, filePath); err != nil {
+				return nil, err
+			}
+		}
+		segment, err := restoreSegmentFile(segmentId, filePath, cfg)
+		if err != nil {
+			return nil, err
+		}
+		err = segment.forEachRecord(func(offset int, record Record) error {
+			entries = append(entries, MessageEntry{segmentId: segmentId, offset: offset, elementId: record.Id})
+			return nil
+		})
+		closeErr := segment.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, closeErr
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b MessageEntry) int {
+		return a.elementId - b.elementId
+	})
+	return entries, nil
+}
+
+func readIndexFile(cfg *config.Config) (map[int]MessageEntry, error) {
+	if _, err := os.Stat(cfg.IndexFilePath()); err != nil {
+		return nil, err
+	}
+	store, err := RestoreEncryptedStore(cfg.IndexFilePath(), cfg.Keyring())
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	entries, _, err := restoreEntries(store)
+	return entries, err
+}
+
+// writeIndexFile writes the entries to a temporary file and renames it over
+// the index file, so a crash midway never leaves a half written index behind.
+func writeIndexFile(cfg *config.Config, entries []MessageEntry) error {
+	filePath := cfg.IndexFilePath()
+	tmpPath := filePath + ".rebuild"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	store, err := NewEncryptedStore(tmpPath, cfg.Keyring())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := store.Append(entry.Encode()); err != nil {
+			store.Close()
+			return err
+		}
+	}
+	if err := store.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
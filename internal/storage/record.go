@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"slices"
+)
+
+// recordHeaderSize is the size of the message ID and key length preceding the key and data of a record.
+const recordHeaderSize = 12
+
+// recordHasHeaders is set in the key length of a record followed by headers after its key.
+// Records without headers are laid out as before headers existed.
+const recordHasHeaders = 1 << 31
+
+// Record is a single message as it is laid out inside a segment.
+// Every record carries the message id it was assigned, which makes
+// segment files self-describing: the index can be rebuilt from them alone.
+// Records of compacted queues carry a key, only the newest record of each key is kept.
+// Headers are metadata stored along with the message, such as its trace context.
+type Record struct {
+	Id      int
+	Key     []byte
+	Headers map[string]string
+	Data    []byte
+}
+
+// IsTombstone reports whether the record deletes its key, which is marked by an empty payload.
+func (r *Record) IsTombstone() bool {
+	return len(r.Key) != 0 && len(r.Data) == 0
+}
+
+func (r *Record) size() int {
+	size := recordHeaderSize + len(r.Key) + len(r.Data)
+	if len(r.Headers) != 0 {
+		size += 4 + HeadersSize(r.Headers)
+	}
+	return size
+}
+
+// HeadersSize returns the number of bytes headers take in a record.
+func HeadersSize(headers map[string]string) int {
+	size := 0
+	for name, value := range headers {
+		size += 8 + len(name) + len(value)
+	}
+	return size
+}
+
+// Encode lays the record out as its ID, key length, key and data.
+// A record with headers has recordHasHeaders set in its key length and the headers,
+// prefixed with their length, between its key and data.
+func (r *Record) Encode() []byte {
+	data := make([]byte, recordHeaderSize, r.size())
+	binary.BigEndian.PutUint64(data[:8], uint64(r.Id))
+	keyLength := uint32(len(r.Key))
+	if len(r.Headers) != 0 {
+		keyLength |= recordHasHeaders
+	}
+	binary.BigEndian.PutUint32(data[8:recordHeaderSize], keyLength)
+	data = append(data, r.Key...)
+	if len(r.Headers) != 0 {
+		data = binary.BigEndian.AppendUint32(data, uint32(HeadersSize(r.Headers)))
+		data = appendHeaders(data, r.Headers)
+	}
+	return append(data, r.Data...)
+}
+
+func (r *Record) Decode(data []byte) error {
+	if len(data) < recordHeaderSize {
+		return fmt.Errorf("record too short: %d bytes", len(data))
+	}
+	r.Id = int(binary.BigEndian.Uint64(data[:8]))
+	keyLength := binary.BigEndian.Uint32(data[8:recordHeaderSize])
+	keySize := int(keyLength &^ recordHasHeaders)
+	if len(data) < recordHeaderSize+keySize {
+		return fmt.Errorf("record key truncated")
+	}
+	r.Key = nil
+	if keySize != 0 {
+		r.Key = data[recordHeaderSize : recordHeaderSize+keySize]
+	}
+	data = data[recordHeaderSize+keySize:]
+	r.Headers = nil
+	if keyLength&recordHasHeaders != 0 {
+		if len(data) < 4 || len(data) < 4+int(binary.BigEndian.Uint32(data)) {
+			return fmt.Errorf("record headers truncated")
+		}
+		headersSize := int(binary.BigEndian.Uint32(data))
+		headers, err := decodeHeaders(data[4 : 4+headersSize])
+		if err != nil {
+			return err
+		}
+		r.Headers = headers
+		data = data[4+headersSize:]
+	}
+	r.Data = data
+	return nil
+}
+
+// appendHeaders appends every header as its name length, name, value length and value, sorted by name.
+func appendHeaders(data []byte, headers map[string]string) []byte {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(name)))
+		data = append(data, name...)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(headers[name])))
+		data = append(data, headers[name]...)
+	}
+	return data
+}
+
+func decodeHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(data) > 0 {
+		var fields [2]string
+		for i := range fields {
+			if len(data) < 4 || len(data) < 4+int(binary.BigEndian.Uint32(data)) {
+				return nil, fmt.Errorf("record headers truncated")
+			}
+			size := int(binary.BigEndian.Uint32(data))
+			fields[i] = string(data[4 : 4+size])
+			data = data[4+size:]
+		}
+		headers[fields[0]] = fields[1]
+	}
+	return headers, nil
+}
+
+// encodeBatch lays the records out one after the other, each prefixed with its length.
+// A batch is the unit that gets compressed and written to a segment.
+func encodeBatch(records []Record) []byte {
+	size := 0
+	for _, record := range records {
+		size += 4 + record.size()
+	}
+	data := make([]byte, 0, size)
+	for _, record := range records {
+		data = binary.BigEndian.AppendUint32(data, uint32(record.size()))
+		data = append(data, record.Encode()...)
+	}
+	return data
+}
+
+// batchChecksumSize is the size of the CRC32C (Castagnoli) preceding every batch of a segment.
+const batchChecksumSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBatchChecksum is returned when a batch does not match the checksum it was written with.
+var ErrBatchChecksum = errors.New("batch checksum mismatch")
+
+// sealBatch prefixes a batch, as written to a segment, with its checksum.
+func sealBatch(batch []byte) []byte {
+	sealed := binary.BigEndian.AppendUint32(make([]byte, 0, batchChecksumSize+len(batch)), crc32.Checksum(batch, castagnoli))
+	return append(sealed, batch...)
+}
+
+// openBatch verifies and strips the checksum sealBatch prefixed a batch with.
+func openBatch(data []byte) ([]byte, error) {
+	if len(data) < batchChecksumSize {
+		return nil, fmt.Errorf("truncated batch")
+	}
+	batch := data[batchChecksumSize:]
+	if expected, actual := binary.BigEndian.Uint32(data), crc32.Checksum(batch, castagnoli); expected != actual {
+		return nil, fmt.Errorf("%w: expected %08x, got %08x", ErrBatchChecksum, expected, actual)
+	}
+	return batch, nil
+}
+
+// decodeBatch decodes the records of a batch laid out by encodeBatch.
+func decodeBatch(data []byte) ([]Record, error) {
+	var records []Record
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated batch")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if len(data) < size {
+			return nil, fmt.Errorf("truncated batch")
+		}
+		record := Record{}
+		if err := record.Decode(data[:size]); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		data = data[size:]
+	}
+	return records, nil
+}
+
+// ErrNoSegmentHeader is returned for a segment file that does not start with a segment header,
+// such as one written before segments had a header, which queuectl migrate rewrites.
+var ErrNoSegmentHeader = errors.New("no segment header, segments written before segment headers need queuectl migrate")
+
+const (
+	segmentMagic         = "QSEG"
+	segmentFormatVersion = 1
+	segmentHeaderSize    = 6
+)
+
+// segmentHeader is the first entry of every segment file.
+// It records how the batches that follow it are encoded.
+// Version 1 segments hold records with their key and headers, in batches each preceded by its checksum.
+// Segments written before segment headers existed have none, see ErrNoSegmentHeader.
+type segmentHeader struct {
+	version byte
+	codec   Codec
+}
+
+func (h *segmentHeader) Encode() []byte {
+	data := make([]byte, segmentHeaderSize)
+	copy(data, segmentMagic)
+	data[4] = h.version
+	data[5] = byte(h.codec)
+	return data
+}
+
+func (h *segmentHeader) Decode(data []byte) error {
+	if len(data) < segmentHeaderSize || string(data[:4]) != segmentMagic {
+		return ErrNoSegmentHeader
+	}
+	h.version = data[4]
+	h.codec = Codec(data[5])
+	if h.version != segmentFormatVersion {
+		return fmt.Errorf("unsupported segment format version %d", h.version)
+	}
+	return nil
+}
@@ -2,66 +2,466 @@ package storage
 
 import (
 	"ashishkujoy/queue/internal/config"
+	"ashishkujoy/queue/internal/encryption"
 	"fmt"
+	"io"
+	"os"
 	"sync"
 )
 
+// recompressBatchSize is the amount of uncompressed record data grouped into
+// a single batch when a closed segment is recompressed.
+const recompressBatchSize = 64 * 1024
+
 // Segment represents a single segment in the queue.
 // It is responsible for storing messages and managing the
 // underlying storage.
 // Each segment has a unique ID and is associated with a store.
 // The segment is thread-safe and uses a mutex to synchronize access.
 // The segment can be closed, and it will flush any pending writes to the store.
+//
+// A segment file starts with a header recording the codec of the segment,
+// followed by batches of records. Each batch is compressed with that codec
+// and every message of a batch is indexed at the offset of the batch.
 type Segment struct {
-	id    int
-	store *Store
-	mu    *sync.RWMutex
+	id       int
+	filePath string
+	store    *Store
+	codec    Codec
+	keyring  *encryption.Keyring
+	mu       *sync.RWMutex
+	cache    *batchCache
+	// batchesOffset is the offset of the first batch, right after the segment header.
+	batchesOffset int
+	// generation is bumped every time the segment file is rewritten,
+	// which invalidates every offset handed out before.
+	generation int
+}
+
+// batchCache keeps the last batch decoded from a segment, so reading
+// the messages of a compressed batch one by one decompresses it only once.
+type batchCache struct {
+	mu      sync.Mutex
+	offset  int
+	records []Record
+}
+
+func (c *batchCache) get(offset int) ([]Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.records == nil || c.offset != offset {
+		return nil, false
+	}
+	return c.records, true
+}
+
+func (c *batchCache) put(offset int, records []Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset = offset
+	c.records = records
+}
+
+func (c *batchCache) clear() {
+	c.put(0, nil)
 }
 
 // NewSegment creates a new segment with the given ID and configuration.
+// The segment file is preallocated up to the configured maximum segment size.
+// A new segment compresses its batches with the configured codec,
+// an existing segment file keeps the codec recorded in its header.
 func NewSegment(id int, config *config.Config) (*Segment, error) {
-	filePath := fmt.Sprintf("%s/segment-%d", config.SegmentsRoot(), id)
-	store, err := NewStore(filePath)
+	codec, err := ParseCodec(config.Compression())
 	if err != nil {
 		return nil, err
 	}
+	filePath := segmentFilePath(config, id)
+	store, err := NewEncryptedStore(filePath, config.Keyring())
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Preallocate(config.MaxSegmentSizeInBytes()); err != nil {
+		store.Close()
+		return nil, err
+	}
+	if store.Size() == store.firstOffset {
+		header := segmentHeader{version: segmentFormatVersion, codec: codec}
+		if _, err := store.Append(header.Encode()); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
 
-	return &Segment{store: store, id: id, mu: &sync.RWMutex{}}, nil
+	return openSegment(id, filePath, store, config.Keyring())
 }
 
-// RestoreSegment restores a segment from the given ID and configuration.
+// RestoreSegment restores a closed segment from the given ID and configuration.
+// The restored segment is read only.
 func RestoreSegment(id int, config *config.Config) (*Segment, error) {
-	filePath := fmt.Sprintf("%s/segment-%d", config.SegmentsRoot(), id)
-	store, err := RestoreStore(filePath)
+	return restoreSegmentFile(id, segmentFilePath(config, id), config)
+}
+
+// restoreSegmentFile restores the closed segment with the given ID from the file at filePath.
+func restoreSegmentFile(id int, filePath string, config *config.Config) (*Segment, error) {
+	store, err := RestoreEncryptedStore(filePath, config.Keyring())
 	if err != nil {
 		return nil, err
 	}
+	if err := store.CloseWriter(); err != nil {
+		store.Close()
+		return nil, err
+	}
 
-	return &Segment{store: store, id: id, mu: &sync.RWMutex{}}, nil
+	return openSegment(id, filePath, store, config.Keyring())
 }
 
-// Append appends data to the segment.
+func segmentFilePath(config *config.Config, id int) string {
+	return fmt.Sprintf("%s/segment-%d", config.SegmentsRoot(), id)
+}
+
+// openSegment reads the segment header from the store and wraps it in a Segment.
+func openSegment(id int, filePath string, store *Store, keyring *encryption.Keyring) (*Segment, error) {
+	header, batchesOffset, err := readSegmentHeader(store)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("segment %d: %w", id, err)
+	}
+	return &Segment{
+		id:            id,
+		filePath:      filePath,
+		store:         store,
+		codec:         header.codec,
+		keyring:       keyring,
+		mu:            &sync.RWMutex{},
+		cache:         &batchCache{},
+		batchesOffset: batchesOffset,
+	}, nil
+}
+
+// readSegmentHeader reads the segment header and returns it along with the offset of the first batch.
+func readSegmentHeader(store *Store) (segmentHeader, int, error) {
+	header := segmentHeader{}
+	data, next, err := store.readEntry(store.firstOffset)
+	if err != nil {
+		return header, 0, err
+	}
+	return header, next, header.Decode(data)
+}
+
+// firstBatchOffset is the offset of the first batch, right after the header.
+func (s *Segment) firstBatchOffset() int {
+	return s.batchesOffset
+}
+
+// Append appends data to the segment as a record tagged with the given message ID.
 // It locks the segment for writing to ensure thread safety.
-// It returns the offset of the appended data or an error if the operation fails.
-func (s *Segment) Append(data []byte) (int, error) {
+// It returns the offset of the appended record or an error if the operation fails.
+func (s *Segment) Append(messageId int, data []byte) (int, error) {
+	offsets, err := s.AppendBatch([]int{messageId}, [][]byte{data})
+	if err != nil {
+		return 0, err
+	}
+	return offsets[0], nil
+}
+
+// AppendBatch appends the data as one batch of records tagged with the given message IDs,
+// compressed with the segment's codec and written with a single write.
+// It returns the offset of each appended record, which is the offset of the batch,
+// or an error if the operation fails.
+func (s *Segment) AppendBatch(messageIds []int, data [][]byte) ([]int, error) {
+	records := make([]Record, len(data))
+	for i := range data {
+		records[i] = Record{Id: messageIds[i], Data: data[i]}
+	}
+	return s.appendRecords(records)
+}
+
+// appendRecords appends the records as one batch and returns the offset of each of them.
+func (s *Segment) appendRecords(records []Record) ([]int, error) {
+	batch, err := s.codec.compress(encodeBatch(records))
+	if err != nil {
+		return nil, err
+	}
+	batch = sealBatch(batch)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.store.Append(data)
+	offset, err := s.store.Append(batch)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]int, len(records))
+	for i := range offsets {
+		offsets[i] = offset
+	}
+	return offsets, nil
 }
 
-// Read reads data from the segment at the given offset.
+// Read reads the data of the record appended at the given offset.
 // It locks the segment for reading to ensure thread safety.
 // It returns the data read from the segment or an error if the operation fails.
 func (s *Segment) Read(offset int) ([]byte, error) {
+	record, err := s.ReadRecord(offset)
+	if err != nil {
+		return nil, err
+	}
+	return record.Data, nil
+}
+
+// ReadRecord reads the record appended at the given offset, including its message ID.
+// When the offset holds a batch of several records, the first one is returned.
+func (s *Segment) ReadRecord(offset int) (Record, error) {
+	records, _, err := s.readBatchAt(offset)
+	if err != nil {
+		return Record{}, err
+	}
+	return records[0], nil
+}
+
+// ReadMessage reads the data of the message with the given ID from the batch at the given offset.
+func (s *Segment) ReadMessage(offset int, messageId int) ([]byte, error) {
+	records, _, err := s.readBatchAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Id == messageId {
+			return record.Data, nil
+		}
+	}
+	return nil, fmt.Errorf("message %d not found in segment %d at offset %d", messageId, s.id, offset)
+}
+
+// readBatchAt reads and decompresses the batch stored at the given offset
+// and returns its records along with the offset of the batch that follows it.
+// It returns io.EOF when offset is at the end of the data written so far.
+func (s *Segment) readBatchAt(offset int) ([]Record, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readBatch(offset)
+}
+
+// readBatchOfGeneration is readBatchAt for an offset obtained from the given generation of the segment.
+// It returns errSegmentRewritten when the segment has been rewritten since.
+func (s *Segment) readBatchOfGeneration(offset int, generation int) ([]Record, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.generation != generation {
+		return nil, offset, errSegmentRewritten
+	}
+	return s.readBatch(offset)
+}
+
+// currentGeneration returns the generation offsets into the segment currently belong to.
+func (s *Segment) currentGeneration() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// readBatch must be called with s.mu held.
+func (s *Segment) readBatch(offset int) ([]Record, int, error) {
+	if s.store == nil {
+		return nil, offset, errSegmentEvicted
+	}
+	if offset < s.firstBatchOffset() {
+		return nil, offset, fmt.Errorf("invalid offset %d in segment %d", offset, s.id)
+	}
+	if offset >= s.store.Size() {
+		return nil, offset, io.EOF
+	}
+	data, nextOffset, err := s.store.readEntry(offset)
+	if err != nil {
+		return nil, offset, err
+	}
+	if records, ok := s.cache.get(offset); ok {
+		return records, nextOffset, nil
+	}
+	records, err := s.decodeBatch(data)
+	if err != nil {
+		return nil, offset, err
+	}
+	s.cache.put(offset, records)
+	return records, nextOffset, nil
+}
+
+func (s *Segment) decodeBatch(data []byte) ([]Record, error) {
+	data, err := openBatch(data)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %w", s.id, err)
+	}
+	decompressed, err := s.codec.decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %w", s.id, err)
+	}
+	records, err := decodeBatch(decompressed)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty batch in segment %d", s.id)
+	}
+	return records, nil
+}
+
+// forEachRecord calls fn for every record in the segment, in the order they were written,
+// along with the offset of the batch holding the record.
+// It stops at the first batch that cannot be read, which is treated as the end of the segment.
+func (s *Segment) forEachRecord(fn func(offset int, record Record) error) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.store.Read(offset)
+	if s.store == nil {
+		return errSegmentEvicted
+	}
+	return s.store.forEachEntryFrom(s.firstBatchOffset(), func(offset int, entry []byte) error {
+		records, err := s.decodeBatch(entry)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := fn(offset, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recompress rewrites the closed segment into batches of up to recompressBatchSize bytes
+// compressed with the given codec, and atomically replaces the segment file with the result.
+// relocate is called with the new location of every message in the segment while
+// the segment is locked, so no reader can observe the new file with the old locations.
+func (s *Segment) recompress(codec Codec, relocate func([]MessageEntry) error) error {
+	return s.rewrite(codec, func(Record) bool { return true }, relocate)
+}
+
+// compact rewrites the closed segment keeping only the records for which keep returns true,
+// in the segment's current codec. relocate is called as for recompress, along with
+// a removed entry for every message dropped from the segment.
+// It returns the number of records dropped, the segment is left untouched when there is none.
+func (s *Segment) compact(keep func(Record) bool, relocate func([]MessageEntry) error) (int, error) {
+	dropped := 0
+	err := s.forEachRecord(func(_ int, record Record) error {
+		if !keep(record) {
+			dropped++
+		}
+		return nil
+	})
+	if err != nil || dropped == 0 {
+		return 0, err
+	}
+	s.mu.RLock()
+	codec := s.codec
+	s.mu.RUnlock()
+	if err := s.rewrite(codec, keep, relocate); err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// rewrite replaces the segment file with the records for which keep returns true,
+// grouped into batches compressed with the given codec.
+func (s *Segment) rewrite(codec Codec, keep func(Record) bool, relocate func([]MessageEntry) error) error {
+	var records []Record
+	var removed []MessageEntry
+	err := s.forEachRecord(func(_ int, record Record) error {
+		if keep(record) {
+			records = append(records, record)
+		} else {
+			removed = append(removed, removedEntry(record.Id))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.filePath + ".recompress"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	store, err := NewEncryptedStore(tmpPath, s.keyring)
+	if err != nil {
+		return err
+	}
+	entries, err := writeBatches(store, codec, s.id, records)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return err
+	}
+	store, err = RestoreEncryptedStore(s.filePath, s.keyring)
+	if err != nil {
+		return err
+	}
+	if err := store.CloseWriter(); err != nil {
+		store.Close()
+		return err
+	}
+	_, batchesOffset, err := readSegmentHeader(store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	if err := relocate(append(entries, removed...)); err != nil {
+		store.Close()
+		return err
+	}
+	previous := s.store
+	s.store = store
+	s.codec = codec
+	s.batchesOffset = batchesOffset
+	s.generation++
+	s.cache.clear()
+	return previous.Close()
+}
+
+// writeBatches writes a segment header for the codec followed by the records,
+// grouped into compressed batches. It returns the location of every record written.
+func writeBatches(store *Store, codec Codec, segmentId int, records []Record) ([]MessageEntry, error) {
+	header := segmentHeader{version: segmentFormatVersion, codec: codec}
+	if _, err := store.Append(header.Encode()); err != nil {
+		return nil, err
+	}
+	var entries []MessageEntry
+	for start := 0; start < len(records); {
+		end, size := start, 0
+		for end < len(records) && (end == start || size+records[end].size() <= recompressBatchSize) {
+			size += records[end].size()
+			end++
+		}
+		batch, err := codec.compress(encodeBatch(records[start:end]))
+		if err != nil {
+			return nil, err
+		}
+		offset, err := store.Append(sealBatch(batch))
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records[start:end] {
+			entries = append(entries, MessageEntry{segmentId: segmentId, offset: offset, elementId: record.Id})
+		}
+		start = end
+	}
+	return entries, nil
 }
 
 // isFull checks if the segment is full based on the maximum size in bytes.
 // It returns true if the segment is full, false otherwise.
 // It uses a threshold of 90% of the maximum size to determine if the segment is full.
+// A segment holding no batch yet is never full, so it always accepts at least one batch.
 func (s *Segment) isFull(maxSizeInBytes int) bool {
+	if s.store.Size() <= s.firstBatchOffset() {
+		return false
+	}
 	return float64(s.store.Size()) >= float64(maxSizeInBytes)*0.9
 }
 
@@ -70,10 +470,77 @@ func (s *Segment) isFull(maxSizeInBytes int) bool {
 func (s *Segment) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.store == nil {
+		return nil
+	}
 	return s.store.Close()
 }
 
-// Flush flushes any pending writes to the store.
+// isLoaded reports whether the segment file is available locally.
+func (s *Segment) isLoaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store != nil
+}
+
+// evict closes the closed segment and removes its file from local disk.
+// Reads of an evicted segment fail with errSegmentEvicted until it is loaded again.
+// Offsets into the segment stay valid, since the file is loaded back unchanged.
+func (s *Segment) evict() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		return nil
+	}
+	err := s.store.Close()
+	s.store = nil
+	s.cache.clear()
+	if removeErr := os.Remove(s.filePath); err == nil && !os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+	return err
+}
+
+// remove closes the segment and deletes its file. Offsets into the segment are invalidated,
+// so iterators reading it look their position up in the index again.
+func (s *Segment) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.cache.clear()
+	var err error
+	if s.store != nil {
+		err = s.store.Close()
+		s.store = nil
+	}
+	if removeErr := os.Remove(s.filePath); err == nil && !os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+	return err
+}
+
+// load makes the evicted segment readable again from a copy of its file at filePath.
+func (s *Segment) load(filePath string, config *config.Config) error {
+	loaded, err := restoreSegmentFile(s.id, filePath, config)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store != nil {
+		return loaded.store.Close()
+	}
+	s.filePath = filePath
+	s.store = loaded.store
+	s.codec = loaded.codec
+	s.batchesOffset = loaded.batchesOffset
+	return nil
+}
+
+// CloseWriter syncs and closes the write side of the segment, which then memory maps its file.
+// It holds the segment lock, so readers never see the store half way through the switch.
 func (s *Segment) CloseWriter() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.store.CloseWriter()
 }
@@ -13,28 +13,28 @@ import (
 
 func TestAppendToTheSegment(t *testing.T) {
 	cfg := config.NewConfig(os.TempDir(), "/tmp", 1024, time.Second*5)
-	defer os.Remove(fmt.Sprintf("%s/segment_10", os.TempDir()))
+	defer os.Remove(fmt.Sprintf("%s/segment-10", os.TempDir()))
 
 	segment, err := NewSegment(10, cfg)
 	assert.NoError(t, err)
 	defer segment.Close()
 
-	_, err = segment.Append([]byte("Hello World"))
+	_, err = segment.Append(0, []byte("Hello World"))
 	assert.NoError(t, err)
 }
 
 func TestReadFromTheSegment(t *testing.T) {
 	cfg := config.NewConfig(os.TempDir(), "/tmp", 1024, time.Second)
-	defer os.Remove(fmt.Sprintf("%s/segment_10", os.TempDir()))
+	defer os.Remove(fmt.Sprintf("%s/segment-10", os.TempDir()))
 
 	segment, err := NewSegment(10, cfg)
 	assert.NoError(t, err)
 	defer segment.Close()
 
-	offset1, err := segment.Append([]byte("Hello World"))
+	offset1, err := segment.Append(0, []byte("Hello World"))
 	assert.NoError(t, err)
 
-	offset2, err := segment.Append([]byte("Bye World"))
+	offset2, err := segment.Append(1, []byte("Bye World"))
 	assert.NoError(t, err)
 
 	data2, err := segment.Read(offset2)
@@ -48,7 +48,7 @@ func TestReadFromTheSegment(t *testing.T) {
 
 func TestReadNonExistingMessage(t *testing.T) {
 	cfg := config.NewConfig(os.TempDir(), "/tmp", 1024, time.Second)
-	defer os.Remove(fmt.Sprintf("%s/segment_12", os.TempDir()))
+	defer os.Remove(fmt.Sprintf("%s/segment-12", os.TempDir()))
 
 	segment, err := NewSegment(12, cfg)
 	assert.NoError(t, err)
@@ -60,14 +60,14 @@ func TestReadNonExistingMessage(t *testing.T) {
 
 func TestReadFromReloadedSegment(t *testing.T) {
 	cfg := config.NewConfig(os.TempDir(), "/tmp", 1024, time.Second)
-	defer os.Remove(fmt.Sprintf("%s/segment_13", os.TempDir()))
+	defer os.Remove(fmt.Sprintf("%s/segment-13", os.TempDir()))
 
 	segment, err := NewSegment(12, cfg)
 	assert.NoError(t, err)
 
-	offset1, _ := segment.Append([]byte("Hello world"))
-	offset2, _ := segment.Append([]byte("Hello earth"))
-	offset3, _ := segment.Append([]byte("Hello India"))
+	offset1, _ := segment.Append(0, []byte("Hello world"))
+	offset2, _ := segment.Append(1, []byte("Hello earth"))
+	offset3, _ := segment.Append(2, []byte("Hello India"))
 	segment.Close()
 
 	restoredSegment, err := NewSegment(12, cfg)
@@ -81,3 +81,52 @@ func TestReadFromReloadedSegment(t *testing.T) {
 	assert.Equal(t, []byte("Hello earth"), data2)
 	assert.Equal(t, []byte("Hello India"), data3)
 }
+
+func TestReadRecordCarriesMessageId(t *testing.T) {
+	cfg := config.NewConfig(os.TempDir(), "/tmp", 1024, time.Second)
+	defer os.Remove(fmt.Sprintf("%s/segment-14", os.TempDir()))
+
+	segment, err := NewSegment(14, cfg)
+	assert.NoError(t, err)
+	defer segment.Close()
+
+	offset, err := segment.Append(42, []byte("Hello World"))
+	assert.NoError(t, err)
+
+	record, err := segment.ReadRecord(offset)
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Id: 42, Data: []byte("Hello World")}, record)
+}
+
+func TestRecordWithKeyRoundTrip(t *testing.T) {
+	records := []Record{{Id: 1, Key: []byte("config"), Data: []byte("v1")}, {Id: 2, Data: []byte("plain")}}
+
+	decoded, err := decodeBatch(encodeBatch(records))
+	assert.NoError(t, err)
+	assert.Equal(t, records, decoded)
+}
+
+func TestRecordWithHeadersRoundTrip(t *testing.T) {
+	records := []Record{
+		{Id: 1, Key: []byte("config"), Headers: map[string]string{"traceparent": "00-01", "tenant": "acme"}, Data: []byte("v1")},
+		{Id: 2, Headers: map[string]string{"empty": ""}, Data: []byte("plain")},
+		{Id: 3, Data: []byte("no headers")},
+	}
+
+	decoded, err := decodeBatch(encodeBatch(records))
+	assert.NoError(t, err)
+	assert.Equal(t, records, decoded)
+}
+
+func TestRecordWithoutHeadersKeepsTheLayoutOfKeyedRecords(t *testing.T) {
+	record := Record{Id: 7, Key: []byte("k"), Data: []byte("v")}
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 1, 'k', 'v'}, record.Encode())
+}
+
+func TestDecodeRejectsTruncatedHeaders(t *testing.T) {
+	record := Record{Id: 1, Headers: map[string]string{"name": "value"}, Data: []byte("data")}
+	encoded := record.Encode()
+	headersEnd := recordHeaderSize + 4 + HeadersSize(record.Headers)
+
+	assert.Error(t, (&Record{}).Decode(encoded[:headersEnd-1]))
+}
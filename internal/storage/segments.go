@@ -2,14 +2,19 @@ package storage
 
 import (
 	"ashishkujoy/queue/internal/config"
+	"errors"
 	"fmt"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ErrMessageTooLarge is returned when a message is larger than the configured maximum message size.
+var ErrMessageTooLarge = errors.New("message too large")
+
 // Segments manages multiple segments.
 // It is responsible for appending data to the active segment,
 // reading data from segments, and rolling over to a new segment
@@ -20,49 +25,88 @@ type Segments struct {
 	id             int
 	index          *Index
 	closedSegments []*Segment
-	mu             *sync.Mutex
+	mu             *sync.RWMutex
+	// tiered is set when closed segments are offloaded to an archive.
+	tiered *tieredStorage
+	// compactor is set when the queue is compacted by key.
+	compactor *compactor
+	// maintenance serialises the compactions, purges and recompressions rewriting or removing closed segments.
+	maintenance sync.Mutex
+	// recompressing holds the IDs of the closed segments waiting to be recompressed after a rollover,
+	// they are archived only once rewritten. It is guarded by mu.
+	recompressing map[int]bool
+	// recompressions tracks the background recompressions started after a rollover.
+	recompressions sync.WaitGroup
 }
 
 // NewSegments creates a new Segments instance with the given configuration and index.
 func NewSegments(config *config.Config, index *Index) (*Segments, error) {
+	tiered, err := newTieredStorage(config)
+	if err != nil {
+		return nil, err
+	}
 	segment, err := NewSegment(0, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Segments{
+	segments := &Segments{
 		config:         config,
 		active:         segment,
 		index:          index,
 		closedSegments: make([]*Segment, 0),
-		mu:             &sync.Mutex{},
-	}, nil
+		mu:             &sync.RWMutex{},
+		tiered:         tiered,
+		recompressing:  make(map[int]bool),
+	}
+	segments.startCompactor()
+	return segments, nil
 }
 
 // RestoreSegments restores segments from the given configuration and index.
+// With an archive configured, segments only present in the archive are restored
+// as evicted and fetched back on first read, and closed segments not uploaded yet
+// are archived in the background.
 func RestoreSegments(c *config.Config, index *Index) (*Segments, error) {
 	segmentIds, err := getSegmentIds(c.SegmentsRoot())
 	if err != nil {
 		return nil, err
 	}
+	tiered, err := newTieredStorage(c)
+	if err != nil {
+		return nil, err
+	}
 
 	closedSegments, err2 := restoreSegmentsById(c, segmentIds)
 	if err2 != nil {
 		return nil, err2
 	}
+	if tiered != nil {
+		for _, segmentId := range tiered.archivedOnly(segmentIds) {
+			closedSegments = append(closedSegments, evictedSegment(segmentId, c))
+			segmentIds = append(segmentIds, segmentId)
+		}
+		slices.SortFunc(closedSegments, func(a, b *Segment) int { return a.id - b.id })
+		slices.Sort(segmentIds)
+	}
 
 	activeSegmentId, activeSegment, err := createActiveSegment(c, segmentIds, err)
 	if err != nil {
 		return nil, err
 	}
-	return &Segments{
+	segments := &Segments{
 		config:         c,
 		active:         activeSegment,
 		id:             activeSegmentId,
 		index:          index,
 		closedSegments: closedSegments,
-		mu:             &sync.Mutex{},
-	}, nil
+		mu:             &sync.RWMutex{},
+		tiered:         tiered,
+		recompressing:  make(map[int]bool),
+	}
+	segments.archiveInBackground()
+	segments.startCompactor()
+	return segments, nil
 }
 
 func createActiveSegment(c *config.Config, segmentIds []int, err error) (int, *Segment, error) {
@@ -102,7 +146,8 @@ func getSegmentIds(segmentsRoot string) ([]int, error) {
 		segmentIdStr := strings.TrimPrefix(entry.Name(), "segment-")
 		id, err := strconv.Atoi(segmentIdStr)
 		if err != nil {
-			return nil, err
+			// Leftover temporary files, e.g. of an interrupted recompression.
+			continue
 		}
 		segmentIds = append(segmentIds, id)
 	}
@@ -112,17 +157,106 @@ func getSegmentIds(segmentsRoot string) ([]int, error) {
 
 // Append appends data to the active segment.
 // If the active segment is full, it rolls over to a new segment.
+// The record written to the segment carries the message ID assigned by the index.
 func (s *Segments) Append(data []byte) (int, error) {
+	return s.AppendWithKey(nil, data)
+}
+
+// AppendWithKey appends data tagged with a key, which compaction uses to keep
+// only the newest message of each key. Empty data marks a tombstone deleting the key.
+func (s *Segments) AppendWithKey(key []byte, data []byte) (int, error) {
+	return s.AppendWithHeaders(key, nil, data)
+}
+
+// AppendWithHeaders appends data tagged with a key, see AppendWithKey, and stored along with headers.
+func (s *Segments) AppendWithHeaders(key []byte, headers map[string]string, data []byte) (int, error) {
+	messageIds, err := s.AppendRecords([]Record{{Key: key, Headers: headers, Data: data}})
+	if err != nil {
+		return 0, err
+	}
+	return messageIds[0], nil
+}
+
+// AppendBatch appends all the data to the active segment with a single write
+// and returns the message IDs assigned to them, in order.
+func (s *Segments) AppendBatch(data [][]byte) ([]int, error) {
+	records := make([]Record, len(data))
+	for i := range data {
+		records[i] = Record{Data: data[i]}
+	}
+	return s.AppendRecords(records)
+}
+
+// AppendRecords appends the records to the active segment as one batch, assigning them
+// consecutive message IDs from NextId, and returns those IDs in order. The IDs the records carry are ignored.
+// If the active segment is full, it rolls over to a new segment before writing the batch,
+// a batch is never split across segments.
+func (s *Segments) AppendRecords(records []Record) ([]int, error) {
+	for _, record := range records {
+		if err := checkMessageSize(s.config, record); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.active.isFull(s.config.MaxSegmentSizeInBytes()) {
 		if err := s.rollOverSegment(); err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
-	offset, err := s.active.Append(data)
+	firstId := s.index.NextElementId()
+	records = slices.Clone(records)
+	for i := range records {
+		records[i].Id = firstId + i
+	}
+	sizeBefore := s.active.store.Size()
+	offsets, err := s.active.appendRecords(records)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return s.index.Append(NewMessageEntry(s.active.id, offset))
+	s.config.Metrics().Appended(s.active.store.Size() - sizeBefore)
+	entries := make([]MessageEntry, len(offsets))
+	for i, offset := range offsets {
+		entries[i] = NewMessageEntry(s.active.id, offset)
+	}
+	return s.index.AppendBatch(entries)
+}
+
+// AppendReplicated appends records that already carry their message ID, as one batch.
+// It is used by followers to store the records of a leader under the same IDs.
+// IDs must be increasing and not below NextId. The sizes of the records were checked by the leader.
+func (s *Segments) AppendReplicated(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkReplicatedIds(records, s.index.NextElementId()); err != nil {
+		return err
+	}
+	if s.active.isFull(s.config.MaxSegmentSizeInBytes()) {
+		if err := s.rollOverSegment(); err != nil {
+			return err
+		}
+	}
+	sizeBefore := s.active.store.Size()
+	offsets, err := s.active.appendRecords(records)
+	if err != nil {
+		return err
+	}
+	s.config.Metrics().Appended(s.active.store.Size() - sizeBefore)
+	entries := make([]MessageEntry, len(offsets))
+	for i, offset := range offsets {
+		entries[i] = MessageEntry{segmentId: s.active.id, offset: offset, elementId: records[i].Id}
+	}
+	return s.index.AppendReplicated(entries)
+}
+
+// NextId returns the ID the next appended message will be assigned.
+func (s *Segments) NextId() int {
+	return s.index.NextElementId()
 }
 
 // Read reads data from the segment with the given message ID.
@@ -131,25 +265,139 @@ func (s *Segments) Append(data []byte) (int, error) {
 // If the segment is not found, it returns an error.
 // It returns the data read from the segment or an error if the operation fails.
 // It locks the segment for reading to ensure thread safety.
+// If the segment is rewritten between the index lookup and the read, the lookup is retried.
+// A segment offloaded to the archive is fetched back before reading.
 func (s *Segments) Read(messageId int) ([]byte, error) {
 	entry, ok := s.index.GetOffset(messageId)
-	if !ok {
-		return nil, fmt.Errorf("unknown message id: %d", messageId)
+	for {
+		if !ok {
+			return nil, fmt.Errorf("unknown message id: %d", messageId)
+		}
+		segment, err := s.findSegment(entry.segmentId)
+		if err != nil {
+			return nil, err
+		}
+		data, err := segment.ReadMessage(entry.offset, messageId)
+		if err == nil {
+			return data, nil
+		}
+		if err == errSegmentEvicted {
+			if err := s.fetchSegment(segment); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		latest, found := s.index.GetOffset(messageId)
+		if found && latest == entry {
+			return nil, err
+		}
+		entry, ok = latest, found
 	}
-	segment, err := s.findSegment(entry.segmentId)
-	if err != nil {
-		return nil, err
+}
+
+// Flush flushes any pending writes to the active segment and syncs it to disk.
+func (s *Segments) Flush() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	start := time.Now()
+	err := s.active.store.Flush()
+	s.config.Metrics().Fsynced(time.Since(start), err)
+	return err
+}
+
+// SegmentStats describes the segments of a queue.
+type SegmentStats struct {
+	// Count is the number of segments, the active one included.
+	Count int
+	// ActiveSizeInBytes is the size of the segment messages are appended to.
+	ActiveSizeInBytes int
+}
+
+// Stats returns the number of segments and the size of the active segment.
+func (s *Segments) Stats() SegmentStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return SegmentStats{Count: len(s.closedSegments) + 1, ActiveSizeInBytes: s.active.store.Size()}
+}
+
+// Head returns the ID of the oldest message stored, -1 when there is none.
+func (s *Segments) Head() int {
+	head := -1
+	for _, r := range s.index.segmentRanges() {
+		if head < 0 || r.first < head {
+			head = r.first
+		}
+	}
+	return head
+}
+
+// Tail returns the ID of the newest message stored, -1 when there is none.
+func (s *Segments) Tail() int {
+	tail := -1
+	for _, r := range s.index.segmentRanges() {
+		tail = max(tail, r.last)
+	}
+	return tail
+}
+
+// Len returns the number of messages stored, the ones removed by compaction excluded.
+func (s *Segments) Len() int {
+	return s.index.Len()
+}
+
+// SizeInBytes returns the size of the segment files on local disk.
+func (s *Segments) SizeInBytes() int {
+	size := 0
+	for _, segment := range s.Describe() {
+		size += segment.SizeInBytes
 	}
-	return segment.Read(entry.offset)
+	return size
 }
 
-// Flush flushes any pending writes to the active segment.
-func (s *Segments) Flush() {
-	s.active.store.Flush()
+// SegmentInfo describes a segment and the messages it holds.
+type SegmentInfo struct {
+	Id int
+	// SizeInBytes is the size of the segment file, zero for a segment only present in the archive.
+	SizeInBytes int
+	// Messages is the number of messages of the segment, FirstId and LastId the range of their IDs,
+	// both -1 for a segment without messages.
+	Messages int
+	FirstId  int
+	LastId   int
+	// Local is false for a segment offloaded to the archive, Active is true for the segment appended to.
+	Local  bool
+	Active bool
+}
+
+// Describe returns every segment, the oldest first.
+func (s *Segments) Describe() []SegmentInfo {
+	ranges := s.index.segmentRanges()
+	s.mu.RLock()
+	segments := append(slices.Clone(s.closedSegments), s.active)
+	s.mu.RUnlock()
+
+	infos := make([]SegmentInfo, len(segments))
+	for i, segment := range segments {
+		info := SegmentInfo{Id: segment.id, FirstId: -1, LastId: -1, Active: segment == segments[len(segments)-1]}
+		if r, ok := ranges[segment.id]; ok {
+			info.Messages, info.FirstId, info.LastId = r.count, r.first, r.last
+		}
+		segment.mu.RLock()
+		if segment.store != nil {
+			info.SizeInBytes = segment.store.Size()
+			info.Local = true
+		}
+		segment.mu.RUnlock()
+		infos[i] = info
+	}
+	return infos
 }
 
 // findSegment finds a segment by its ID.
 func (s *Segments) findSegment(segmentId int) (*Segment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.active.id == segmentId {
 		return s.active, nil
 	}
@@ -161,27 +409,105 @@ func (s *Segments) findSegment(segmentId int) (*Segment, error) {
 	return nil, fmt.Errorf("unknown segment %d", segmentId)
 }
 
+// nextSegment returns the segment that follows the segment with the given ID,
+// or false when the given segment is the active one.
+func (s *Segments) nextSegment(segmentId int) (*Segment, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, segment := range s.closedSegments {
+		if segment.id > segmentId {
+			return segment, true
+		}
+	}
+	if s.active.id > segmentId {
+		return s.active, true
+	}
+	return nil, false
+}
+
+// NewIterator returns an iterator that streams records starting at the message with ID fromId.
+// The iterator reads segments sequentially and only consults the index to find its starting point.
+func (s *Segments) NewIterator(fromId int) Iterator {
+	return &segmentIterator{segments: s, nextId: fromId}
+}
+
 // rollOverSegment rolls over to a new segment.
-// It closes the current active segment writer,
-// appends it to the closed segments list, and creates a new active segment.
+// It creates the next active segment, closes the current active segment writer
+// and appends it to the closed segments list. On error the current active segment
+// stays active. With RecompressOnRollover the closed segment is recompressed in the
+// background, so the writer is not held up by the rewrite.
+// It must be called with s.mu held.
 func (s *Segments) rollOverSegment() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.active.CloseWriter()
-	s.closedSegments = append(s.closedSegments, s.active)
-	s.id++
-	newActiveSegment, err := NewSegment(s.id, s.config)
+	newActiveSegment, err := NewSegment(s.id+1, s.config)
 	if err != nil {
 		return err
 	}
+	start := time.Now()
+	err = s.active.CloseWriter()
+	s.config.Metrics().Fsynced(time.Since(start), err)
+	if err != nil {
+		if removeErr := newActiveSegment.remove(); removeErr != nil {
+			s.config.Logger().Error("failed to remove unused segment", "segment", newActiveSegment.id, "error", removeErr)
+		}
+		return fmt.Errorf("close segment %d: %w", s.active.id, err)
+	}
+	closed := s.active
+	s.closedSegments = append(s.closedSegments, closed)
+	s.id = newActiveSegment.id
 	s.active = newActiveSegment
+	s.config.Logger().Debug("rolled over segment", "segment", closed.id, "next_segment", newActiveSegment.id)
+	if s.config.RecompressOnRollover() {
+		s.recompressInBackground(closed)
+		return nil
+	}
+	s.archiveInBackground()
 	return nil
 }
 
+// recompressInBackground recompresses a segment just closed by a rollover, then archives it.
+// The segment stays readable in its original codec meanwhile, and is kept so when
+// the recompression fails. It must be called with s.mu held.
+func (s *Segments) recompressInBackground(segment *Segment) {
+	s.recompressing[segment.id] = true
+	s.recompressions.Add(1)
+	go func() {
+		defer s.recompressions.Done()
+		s.maintenance.Lock()
+		// A purge may have removed the segment before its turn came.
+		if segment.isLoaded() {
+			if err := s.recompress(segment); err != nil {
+				s.config.Logger().Error("failed to recompress segment", "segment", segment.id, "error", err)
+			}
+		}
+		s.maintenance.Unlock()
+		s.mu.Lock()
+		delete(s.recompressing, segment.id)
+		s.mu.Unlock()
+		s.archiveInBackground()
+	}()
+}
+
+// recompress rewrites a closed segment into large batches compressed with the configured codec
+// and points the index at the new location of its messages.
+func (s *Segments) recompress(segment *Segment) error {
+	codec, err := ParseCodec(s.config.Compression())
+	if err != nil {
+		return err
+	}
+	if codec == CodecNone {
+		return nil
+	}
+	return segment.recompress(codec, s.index.Update)
+}
+
 // Close closes the active segment and all closed segments.
 // It flushes any pending writes to the store and releases resources.
+// It stops the compactor and waits for background recompressions and uploads to the archive to finish first.
 func (s *Segments) Close() error {
+	s.compactor.stop()
+	s.recompressions.Wait()
+	s.tiered.wait()
 	if err := s.active.Close(); err != nil {
 		return err
 	}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"ashishkujoy/queue/internal/config"
+	"bytes"
+	"fmt"
+	"log/slog"
 	"os"
 	"testing"
 	"time"
@@ -43,6 +46,59 @@ func TestAppend(t *testing.T) {
 	assert.Equal(t, []byte("Hello Segments"), data)
 }
 
+func TestAppendRejectsMessagesLargerThanTheMaximum(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("SegmentTestMaxMessageSize"),
+		createTempDir("metadata"),
+		1000,
+		time.Second,
+	).WithMaxMessageSize(8)
+	defer removeTempDir("SegmentTestMaxMessageSize")
+	defer removeTempDir("metadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	_, err = segments.AppendWithKey([]byte("key"), []byte("message"))
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+	_, err = segments.AppendBatch([][]byte{[]byte("small"), []byte("too large")})
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+	assert.Equal(t, 0, segments.NextId())
+
+	messageId, err := segments.AppendWithKey([]byte("key"), []byte("value"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messageId)
+
+	_, err = segments.AppendWithHeaders(nil, map[string]string{"a": "b"}, []byte("v"))
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+}
+
+func TestAppendWithHeadersIsReadBackByIterators(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("SegmentTestAppendWithHeaders"),
+		createTempDir("metadata"),
+		1000,
+		time.Second,
+	)
+	defer removeTempDir("SegmentTestAppendWithHeaders")
+	defer removeTempDir("metadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+
+	headers := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	messageId, err := segments.AppendWithHeaders([]byte("key"), headers, []byte("message"))
+	assert.NoError(t, err)
+
+	record, err := segments.NewIterator(messageId).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Id: messageId, Key: []byte("key"), Headers: headers, Data: []byte("message")}, record)
+	data, err := segments.Read(messageId)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("message"), data)
+}
+
 func TestAppendMultipleEntry(t *testing.T) {
 	cfg := config.NewConfig(
 		createTempDir("SegmentTestAppendMultipleEntry"),
@@ -71,6 +127,55 @@ func TestAppendMultipleEntry(t *testing.T) {
 	assert.Equal(t, []byte("Hello Segments"), data1)
 }
 
+func TestStatsCountSegmentsAndActiveSegmentSize(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("SegmentTestStats"),
+		createTempDir("metadata"),
+		40,
+		time.Second,
+	)
+	defer removeTempDir("SegmentTestStats")
+	defer removeTempDir("metadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, segments.Stats().Count)
+	headerSize := segments.Stats().ActiveSizeInBytes
+
+	_, err = segments.Append([]byte("Hello Segments, a message long enough to fill one"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, segments.Stats().Count)
+	assert.True(t, segments.Stats().ActiveSizeInBytes > 40)
+
+	_, err = segments.Append([]byte("Hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, segments.Stats().Count)
+	assert.True(t, segments.Stats().ActiveSizeInBytes > headerSize)
+	assert.Equal(t, 2, index.Len())
+	assert.True(t, index.SizeInBytes() > 0)
+}
+
+func TestRollOverIsLoggedWithTheSegmentId(t *testing.T) {
+	var output bytes.Buffer
+	cfg := config.NewConfig(
+		createTempDir("SegmentTestRollOverLog"),
+		createTempDir("metadata"),
+		40,
+		time.Second,
+	).WithLogger(slog.New(slog.NewTextHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer removeTempDir("SegmentTestRollOverLog")
+	defer removeTempDir("metadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	_, err = segments.Append([]byte("Hello Segments, a message long enough to fill one"))
+	assert.NoError(t, err)
+	_, err = segments.Append([]byte("Hello"))
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "msg=\"rolled over segment\" segment=0 next_segment=1")
+}
+
 func TestSegmentRollOver(t *testing.T) {
 	cfg := config.NewConfig(
 		createTempDir("TestSegmentRollOver1"),
@@ -141,3 +246,173 @@ func TestRestoreASegment(t *testing.T) {
 	assert.Equal(t, []byte("Hello Segments"), data1)
 	assert.Equal(t, []byte("Yet Another Hello Segments"), data3)
 }
+
+func TestAppendBatch(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestAppendBatch"),
+		createTempDir("TestAppendBatchMetadata"),
+		1000,
+		time.Second,
+	)
+	defer removeTempDir("TestAppendBatch")
+	defer removeTempDir("TestAppendBatchMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	messageId, _ := segments.Append([]byte("Hello Segments"))
+	messageIds, err := segments.AppendBatch([][]byte{[]byte("First in batch"), []byte("Second in batch")})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{messageId + 1, messageId + 2}, messageIds)
+
+	data1, _ := segments.Read(messageIds[0])
+	data2, _ := segments.Read(messageIds[1])
+	assert.Equal(t, []byte("First in batch"), data1)
+	assert.Equal(t, []byte("Second in batch"), data2)
+}
+
+func TestReadFromCompressedSegments(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestReadFromCompressedSegments"),
+		createTempDir("TestReadFromCompressedSegmentsMetadata"),
+		1000,
+		time.Second,
+	).WithCompression("zstd")
+	defer removeTempDir("TestReadFromCompressedSegments")
+	defer removeTempDir("TestReadFromCompressedSegmentsMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	assert.Equal(t, CodecZstd, segments.active.codec)
+
+	messageId, _ := segments.Append([]byte("Hello Segments"))
+	messageIds, err := segments.AppendBatch([][]byte{[]byte("First in batch"), []byte("Second in batch")})
+	assert.NoError(t, err)
+
+	data, _ := segments.Read(messageId)
+	assert.Equal(t, []byte("Hello Segments"), data)
+	data, _ = segments.Read(messageIds[1])
+	assert.Equal(t, []byte("Second in batch"), data)
+	data, _ = segments.Read(messageIds[0])
+	assert.Equal(t, []byte("First in batch"), data)
+}
+
+func TestRecompressSegmentOnRollOver(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestRecompressSegmentOnRollOver"),
+		createTempDir("TestRecompressSegmentOnRollOverMetadata"),
+		400,
+		time.Second,
+	).WithCompression("gzip").WithRecompressOnRollover(true)
+	defer removeTempDir("TestRecompressSegmentOnRollOver")
+	defer removeTempDir("TestRecompressSegmentOnRollOverMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	iterator := segments.NewIterator(0)
+	first, err := iterator.Next()
+	assert.ErrorIs(t, err, ErrNoMoreMessages)
+
+	var messages []string
+	for i := 0; len(segments.closedSegments) == 0; i++ {
+		message := fmt.Sprintf(`{"type":"order.created","sequence":%d}`, i)
+		_, err := segments.Append([]byte(message))
+		assert.NoError(t, err)
+		messages = append(messages, message)
+	}
+	segments.recompressions.Wait()
+	closed := segments.closedSegments[0]
+	assert.Less(t, closed.store.Size(), 400)
+
+	for id, message := range messages {
+		data, err := segments.Read(id)
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(data))
+	}
+	for id, message := range messages {
+		first, err = iterator.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, Record{Id: id, Data: []byte(message)}, first)
+	}
+
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+	diff, err := VerifyIndex(cfg)
+	assert.NoError(t, err)
+	assert.True(t, diff.Matches())
+
+	index, _ = RestoreIndex(cfg)
+	restored, err := RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+	data, err := restored.Read(0)
+	assert.NoError(t, err)
+	assert.Equal(t, messages[0], string(data))
+}
+
+func TestFailedRollOverKeepsTheActiveSegment(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestFailedRollOverKeepsTheActiveSegment"),
+		createTempDir("TestFailedRollOverKeepsTheActiveSegmentMetadata"),
+		100,
+		time.Second,
+	)
+	defer removeTempDir("TestFailedRollOverKeepsTheActiveSegment")
+	defer removeTempDir("TestFailedRollOverKeepsTheActiveSegmentMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+
+	// The next segment cannot be created while a directory stands in the way of its file.
+	assert.NoError(t, os.Mkdir(segmentFilePath(cfg, 1), 0755))
+	for err == nil {
+		_, err = segments.Append([]byte("Hello, World!"))
+	}
+	assert.Empty(t, segments.closedSegments)
+	assert.Equal(t, 0, segments.active.id)
+
+	assert.NoError(t, os.Remove(segmentFilePath(cfg, 1)))
+	_, err = segments.Append([]byte("Hello, World!"))
+	assert.NoError(t, err)
+	assert.Len(t, segments.closedSegments, 1)
+	assert.Equal(t, 1, segments.active.id)
+	data, err := segments.Read(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello, World!"), data)
+}
+
+func TestRestoreEncryptedSegments(t *testing.T) {
+	cfg := config.NewConfig(
+		createTempDir("TestRestoreEncryptedSegments"),
+		createTempDir("TestRestoreEncryptedSegmentsMetadata"),
+		10,
+		time.Second,
+	).WithKeyring(testKeyring(t, "k1")).WithCompression("snappy")
+	defer removeTempDir("TestRestoreEncryptedSegments")
+	defer removeTempDir("TestRestoreEncryptedSegmentsMetadata")
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	messageId1, _ := segments.Append([]byte("Hello Segments"))
+	messageId2, _ := segments.Append([]byte("Another Hello Segments"))
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+
+	cfg.WithKeyring(testKeyring(t, "k1", "k2"))
+	index, err = RestoreIndex(cfg)
+	assert.NoError(t, err)
+	restoreSegments, err := RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", restoreSegments.active.store.KeyId())
+
+	data1, _ := restoreSegments.Read(messageId1)
+	data2, _ := restoreSegments.Read(messageId2)
+	assert.Equal(t, []byte("Hello Segments"), data1)
+	assert.Equal(t, []byte("Another Hello Segments"), data2)
+
+	diff, err := VerifyIndex(cfg)
+	assert.NoError(t, err)
+	assert.True(t, diff.Matches())
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/config"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SnapshotInfo describes the copy of the queue written by Snapshot.
+type SnapshotInfo struct {
+	// Segments is the number of segment files of the snapshot, SizeInBytes their size along with the index.
+	Segments    int
+	SizeInBytes int
+	// NextId is the ID the next message appended to the snapshot will be assigned.
+	NextId int
+}
+
+// snapshotSource is a file copied into a snapshot up to size. It is opened while appends are
+// held, so the copy reads the same file even if it is recompressed or removed meanwhile.
+type snapshotSource struct {
+	file   *os.File
+	size   int
+	target string
+}
+
+// Snapshot writes the segments and the index, as they are at the time of the call, to the segments
+// directory and the index file of target, which must both be empty. RestoreSegments and RestoreIndex
+// restore that state from them, with the same keyring when the queue is encrypted.
+//
+// Appends are held while the active segment and the index are synced and the closed segments are hard
+// linked, or copied when target is on another file system. The active segment and the index are then
+// copied up to their size at that point while appends go on. Segments offloaded to an archive are
+// downloaded into the snapshot, compactions and purges wait for the snapshot to complete.
+func (s *Segments) Snapshot(target *config.Config) (SnapshotInfo, error) {
+	if err := os.MkdirAll(target.SegmentsRoot(), 0755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target.IndexFilePath()), 0755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+	if t := s.tiered; t != nil {
+		t.archiving.Lock()
+		defer t.archiving.Unlock()
+	}
+
+	info, sources, evicted, err := s.snapshotLocked(target)
+	for _, source := range sources {
+		defer source.file.Close()
+	}
+	if err != nil {
+		return info, err
+	}
+	for _, segmentId := range evicted {
+		filePath := segmentFilePath(target, segmentId)
+		if err := s.tiered.archive.Download(segmentId, filePath); err != nil {
+			return info, fmt.Errorf("download segment %d: %w", segmentId, err)
+		}
+		size, err := syncFile(filePath)
+		if err != nil {
+			return info, err
+		}
+		info.SizeInBytes += size
+	}
+	for _, source := range sources {
+		if err := copyPrefix(source); err != nil {
+			return info, fmt.Errorf("copy %s: %w", source.file.Name(), err)
+		}
+		info.SizeInBytes += source.size
+	}
+	if err := syncDir(target.SegmentsRoot()); err != nil {
+		return info, err
+	}
+	return info, syncDir(filepath.Dir(target.IndexFilePath()))
+}
+
+// snapshotLocked holds appends while it syncs and opens the active segment and the index,
+// and links the closed segments available locally into target. It returns the IDs of the
+// segments only present in the archive.
+func (s *Segments) snapshotLocked(target *config.Config) (SnapshotInfo, []snapshotSource, []int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.tiered; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+	info := SnapshotInfo{Segments: len(s.closedSegments) + 1, NextId: s.index.NextElementId()}
+	var evicted []int
+	for _, segment := range s.closedSegments {
+		segment.mu.RLock()
+		filePath, loaded := segment.filePath, segment.store != nil
+		segment.mu.RUnlock()
+		if !loaded {
+			evicted = append(evicted, segment.id)
+			continue
+		}
+		size, err := linkOrCopy(filePath, segmentFilePath(target, segment.id))
+		if err != nil {
+			return info, nil, nil, fmt.Errorf("link segment %d: %w", segment.id, err)
+		}
+		info.SizeInBytes += size
+	}
+	active, err := openSnapshotSource(s.active.store, s.active.filePath, segmentFilePath(target, s.active.id))
+	if err != nil {
+		return info, nil, nil, fmt.Errorf("sync the active segment: %w", err)
+	}
+	index, err := s.index.snapshotSource(s.config.IndexFilePath(), target.IndexFilePath())
+	if err != nil {
+		active.file.Close()
+		return info, nil, nil, fmt.Errorf("sync the index: %w", err)
+	}
+	return info, []snapshotSource{active, index}, evicted, nil
+}
+
+// snapshotSource syncs the index file and opens it to be copied to target.
+func (i *Index) snapshotSource(filePath string, target string) (snapshotSource, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return openSnapshotSource(i.store, filePath, target)
+}
+
+// openSnapshotSource syncs the store and opens its file at filePath to be copied to target.
+func openSnapshotSource(store *Store, filePath string, target string) (snapshotSource, error) {
+	if err := store.Flush(); err != nil {
+		return snapshotSource{}, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return snapshotSource{}, err
+	}
+	return snapshotSource{file: file, size: store.Size(), target: target}, nil
+}
+
+// linkOrCopy hard links the file at source to target, copying it when linking fails,
+// e.g. across file systems, and returns its size.
+func linkOrCopy(source, target string) (int, error) {
+	if err := os.Link(source, target); err == nil {
+		info, err := os.Stat(target)
+		if err != nil {
+			return 0, err
+		}
+		return int(info.Size()), nil
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return int(info.Size()), copyPrefix(snapshotSource{file: file, size: int(info.Size()), target: target})
+}
+
+// copyPrefix copies the first size bytes of the source file to a new file at its target and syncs it.
+func copyPrefix(source snapshotSource) error {
+	file, err := os.OpenFile(source.target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, io.NewSectionReader(source.file, 0, int64(source.size)))
+	if err == nil {
+		err = file.Sync()
+	}
+	return errors.Join(err, file.Close())
+}
+
+// syncFile syncs the file at filePath and returns its size.
+func syncFile(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return int(info.Size()), file.Sync()
+}
+
+// syncDir syncs a directory, persisting the files created in it.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
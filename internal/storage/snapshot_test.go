@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/archive"
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertRestoresMessages restores the segments of cfg and checks they hold messages 0 to count-1.
+func assertRestoresMessages(t *testing.T, cfg *config.Config, count int) {
+	index, err := RestoreIndex(cfg)
+	assert.NoError(t, err)
+	defer index.Close()
+	segments, err := RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	assert.Equal(t, count, segments.NextId())
+	iterator := segments.NewIterator(0)
+	for i := 0; i < count; i++ {
+		record, err := iterator.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, Record{Id: i, Data: []byte(fmt.Sprintf("message %d", i))}, record)
+	}
+	_, err = iterator.Next()
+	assert.ErrorIs(t, err, ErrNoMoreMessages)
+}
+
+func TestSnapshotRestoresTheStateAtSnapshotTime(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second)
+	assert.NoError(t, os.MkdirAll(cfg.SegmentsRoot(), 0755))
+	assert.NoError(t, os.MkdirAll(cfg.MetadataPath, 0755))
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	for i := 0; i < 7; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+	assert.Greater(t, segments.Stats().Count, 1)
+
+	target := config.NewConfig(filepath.Join(dir, "snapshot", "segments"), filepath.Join(dir, "snapshot", "metadata"), 64, time.Second)
+	info, err := segments.Snapshot(target)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, info.NextId)
+	assert.Equal(t, segments.Stats().Count, info.Segments)
+	assert.Greater(t, info.SizeInBytes, 0)
+
+	_, err = segments.Append([]byte("after the snapshot"))
+	assert.NoError(t, err)
+	_, err = segments.Purge()
+	assert.NoError(t, err)
+	assertRestoresMessages(t, target, 7)
+
+	_, err = segments.Snapshot(target)
+	assert.Error(t, err)
+}
+
+func TestSnapshotDownloadsArchivedSegments(t *testing.T) {
+	dir := t.TempDir()
+	segmentArchive, err := archive.NewDirectoryArchive(filepath.Join(dir, "archive"))
+	assert.NoError(t, err)
+	cfg := config.NewConfig(filepath.Join(dir, "segments"), filepath.Join(dir, "metadata"), 64, time.Second).
+		WithArchive(segmentArchive).
+		WithLocalSegmentsRetained(1)
+	assert.NoError(t, os.MkdirAll(cfg.SegmentsRoot(), 0755))
+	assert.NoError(t, os.MkdirAll(cfg.MetadataPath, 0755))
+	index, _ := NewIndex(cfg)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	defer segments.Close()
+	for i := 0; i < 10; i++ {
+		_, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+	}
+	segments.tiered.wait()
+	assert.NoError(t, segments.ArchiveClosedSegments())
+	assert.False(t, segments.closedSegments[0].isLoaded())
+
+	target := config.NewConfig(filepath.Join(dir, "snapshot", "segments"), filepath.Join(dir, "snapshot", "metadata"), 64, time.Second)
+	_, err = segments.Snapshot(target)
+	assert.NoError(t, err)
+	assertRestoresMessages(t, target, 10)
+}
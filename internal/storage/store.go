@@ -1,21 +1,100 @@
 package storage
 
 import (
+	"ashishkujoy/queue/internal/encryption"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
 )
 
+// Store is an append only file of length prefixed entries.
+//
+// A store can be encrypted at rest, in which case the file starts with an
+// unencrypted header entry recording the ID of the key, and every following
+// entry is sealed with AES-GCM using its offset as additional data.
 type Store struct {
-	reader *os.File
-	writer *os.File
-	offset int
+	reader       *os.File
+	writer       *fileWriter
+	offset       int
+	preallocated bool
+	// mapped holds the whole file mapped into memory once the store no longer
+	// accepts writes, so reads are served without syscalls.
+	mapped []byte
+	// keyring and keyId are set when the store is encrypted.
+	keyring *encryption.Keyring
+	keyId   string
+	// firstOffset is the offset of the first entry, right after the encryption header if any.
+	firstOffset int
 }
 
+// CloseWriter syncs and closes the write side of the store, leaving it readable.
+// Once the writer is closed the store's content can no longer change,
+// so the file is memory mapped and subsequent reads are served from the mapping.
 func (s *Store) CloseWriter() error {
-	return s.writer.Close()
+	if err := s.closeWriter(); err != nil {
+		return err
+	}
+	s.mapReader()
+	return nil
+}
+
+func (s *Store) closeWriter() error {
+	if s.writer == nil {
+		return nil
+	}
+	if s.preallocated {
+		if err := s.writer.truncate(s.offset); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	s.writer = nil
+	return nil
+}
+
+// mapReader memory maps the file for reading.
+// Mapping is an optimisation only, when it fails reads keep using ReadAt.
+func (s *Store) mapReader() {
+	if s.offset == 0 || s.mapped != nil {
+		return
+	}
+	mapped, err := mmapFile(s.reader, s.offset)
+	if err != nil {
+		return
+	}
+	s.mapped = mapped
 }
 
 func NewStore(filePath string) (*Store, error) {
+	return NewEncryptedStore(filePath, nil)
+}
+
+// NewEncryptedStore opens the store at filePath, encrypting it with the keyring.
+// A new file is encrypted with the keyring's active key. An existing file keeps
+// the key it was created with, and a file created unencrypted stays unencrypted.
+// A nil keyring only opens unencrypted files.
+func NewEncryptedStore(filePath string, keyring *encryption.Keyring) (*Store, error) {
+	return openStore(filePath, keyring)
+}
+
+// RestoreStore restores a store from a file at the given filePath.
+// It opens the file for reading only
+func RestoreStore(filePath string) (*Store, error) {
+	return RestoreEncryptedStore(filePath, nil)
+}
+
+// RestoreEncryptedStore is RestoreStore for a store that may be encrypted with a key of the keyring.
+func RestoreEncryptedStore(filePath string, keyring *encryption.Keyring) (*Store, error) {
+	return openStore(filePath, keyring)
+}
+
+func openStore(filePath string, keyring *encryption.Keyring) (*Store, error) {
 	writer, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
@@ -23,91 +102,203 @@ func NewStore(filePath string) (*Store, error) {
 
 	reader, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
 	if err != nil {
+		writer.Close()
 		return nil, err
 	}
 
 	stat, err := reader.Stat()
 	if err != nil {
+		writer.Close()
+		reader.Close()
 		return nil, err
 	}
 
-	return &Store{
-		reader: reader,
-		writer: writer,
-		offset: int(stat.Size()),
-	}, nil
+	store := &Store{
+		reader:  reader,
+		writer:  newFileWriter(writer),
+		offset:  int(stat.Size()),
+		keyring: keyring,
+	}
+	if err := store.initEncryption(filePath); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
 }
 
-// RestoreStore restores a store from a file at the given filePath.
-// It opens the file for reading only
-func RestoreStore(filePath string) (*Store, error) {
-	writer, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, err
+// initEncryption writes the encryption header to a new file,
+// or reads the key ID from the header of an existing file.
+func (s *Store) initEncryption(filePath string) error {
+	if s.offset == 0 {
+		if s.keyring == nil {
+			return nil
+		}
+		keyId := s.keyring.ActiveKeyId()
+		n, err := s.writer.writeRecords([][]byte{encryption.EncodeHeader(keyId)})
+		s.offset += n
+		if err != nil {
+			return err
+		}
+		s.keyId = keyId
+		s.firstOffset = s.offset
+		return nil
 	}
 
-	reader, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
+	data, next, err := s.readRaw(0)
 	if err != nil {
-		return nil, err
+		return nil
 	}
-	return &Store{
-		reader: reader,
-		writer: writer,
-	}, nil
+	keyId, size, ok := encryption.DecodeHeader(data)
+	if !ok || size != len(data) {
+		return nil
+	}
+	if s.keyring == nil || !s.keyring.HasKey(keyId) {
+		return fmt.Errorf("%s is encrypted with key %s which is not in the keyring", filePath, keyId)
+	}
+	s.keyId = keyId
+	s.firstOffset = next
+	return nil
 }
 
-func (s *Store) Append(data []byte) (int, error) {
-	currentOffset := s.offset
-	sizeBuff := make([]byte, 4)
+// KeyId returns the ID of the key the store is encrypted with, or an empty string when it is not encrypted.
+func (s *Store) KeyId() string {
+	return s.keyId
+}
 
-	binary.BigEndian.PutUint32(sizeBuff, uint32(len(data)))
-	n, err := s.writer.Write(sizeBuff)
-	if err != nil {
-		return 0, err
+// Preallocate reserves disk space for the store to grow up to sizeInBytes
+// without changing its size. The reserved space past the written data is released
+// when the writer is closed.
+func (s *Store) Preallocate(sizeInBytes int) error {
+	if sizeInBytes <= s.offset {
+		return nil
 	}
-	s.offset += n
+	if err := s.writer.preallocate(sizeInBytes); err != nil {
+		return err
+	}
+	s.preallocated = true
+	return nil
+}
 
-	n, err = s.writer.Write(data)
+// Append appends data to the store, prefixed with its length, in a single write.
+// It returns the offset at which the entry starts.
+func (s *Store) Append(data []byte) (int, error) {
+	offsets, err := s.AppendBatch([][]byte{data})
 	if err != nil {
 		return 0, err
 	}
+	return offsets[0], nil
+}
+
+// AppendBatch appends all the entries to the store in a single write.
+// It returns the offset at which each entry starts.
+func (s *Store) AppendBatch(entries [][]byte) ([]int, error) {
+	offsets := make([]int, len(entries))
+	stored := entries
+	if s.keyId != "" {
+		stored = make([][]byte, len(entries))
+	}
+	offset := s.offset
+	for i, entry := range entries {
+		offsets[i] = offset
+		if s.keyId != "" {
+			sealed, err := s.keyring.Encrypt(s.keyId, entry, offsetAdditionalData(offset))
+			if err != nil {
+				return nil, err
+			}
+			stored[i] = sealed
+		}
+		offset += 4 + len(stored[i])
+	}
+
+	n, err := s.writer.writeRecords(stored)
 	s.offset += n
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
 
-	return currentOffset, nil
+// offsetAdditionalData binds an encrypted entry to its offset,
+// so entries cannot be moved around in the file without being detected.
+func offsetAdditionalData(offset int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(offset))
 }
 
 func (s *Store) Read(offset int) ([]byte, error) {
+	data, _, err := s.readEntry(offset)
+	return data, err
+}
+
+// readEntry reads and, for an encrypted store, decrypts the entry at offset.
+// It returns the entry along with the offset of the entry that follows it.
+func (s *Store) readEntry(offset int) ([]byte, int, error) {
+	data, next, err := s.readRaw(offset)
+	if err != nil || s.keyId == "" {
+		return data, next, err
+	}
+	data, err = s.keyring.Decrypt(s.keyId, data, offsetAdditionalData(offset))
+	if err != nil {
+		return nil, offset, fmt.Errorf("decrypting entry at offset %d: %w", offset, err)
+	}
+	return data, next, nil
+}
+
+// readRaw reads the entry at offset as it is stored in the file.
+func (s *Store) readRaw(offset int) ([]byte, int, error) {
+	if s.mapped != nil {
+		return s.readMapped(offset)
+	}
 	sizeBuff := make([]byte, 4)
 	n, err := s.reader.ReadAt(sizeBuff, int64(offset))
 	if err != nil {
-		return nil, err
+		return nil, offset, err
 	}
-	offset += n
 
 	dataLen := binary.BigEndian.Uint32(sizeBuff)
 	data := make([]byte, dataLen)
 
-	_, err = s.reader.ReadAt(data, int64(offset))
+	_, err = s.reader.ReadAt(data, int64(offset+n))
 	if err != nil {
-		return nil, err
+		return nil, offset, err
 	}
 
-	return data, err
+	return data, offset + n + len(data), nil
+}
+
+// readMapped reads the entry at offset from the memory mapped file.
+// The entry is copied out, so it stays valid after the store is closed.
+func (s *Store) readMapped(offset int) ([]byte, int, error) {
+	if offset < 0 || offset+4 > len(s.mapped) {
+		return nil, offset, io.EOF
+	}
+	dataLen := int(binary.BigEndian.Uint32(s.mapped[offset:]))
+	start := offset + 4
+	if start+dataLen > len(s.mapped) {
+		return nil, offset, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, dataLen)
+	copy(data, s.mapped[start:start+dataLen])
+	return data, start + dataLen, nil
 }
 
 func (s *Store) Flush() error {
+	if s.writer == nil {
+		return nil
+	}
 	return s.writer.Sync()
 }
 
 func (s *Store) Close() error {
-	if err := s.Flush(); err != nil {
+	if err := s.closeWriter(); err != nil {
 		return err
 	}
-	if err := s.reader.Close(); err != nil {
-		return err
+	if s.mapped != nil {
+		if err := munmapFile(s.mapped); err != nil {
+			return err
+		}
+		s.mapped = nil
 	}
-
-	return s.writer.Close()
+	return s.reader.Close()
 }
 
 func (s *Store) Size() int {
@@ -116,14 +307,33 @@ func (s *Store) Size() int {
 
 func (s *Store) readAllEntries() ([][]byte, error) {
 	var entries [][]byte
-	offset := 0
+	err := s.forEachEntry(func(_ int, entry []byte) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// forEachEntry calls fn with every entry of the store and the offset it starts at.
+// Reading stops silently at the end of the file or at a partially written tail.
+// Any other read error, or an error returned by fn, stops the iteration and is returned.
+func (s *Store) forEachEntry(fn func(offset int, entry []byte) error) error {
+	return s.forEachEntryFrom(s.firstOffset, fn)
+}
+
+// forEachEntryFrom is forEachEntry starting at the entry at the given offset.
+func (s *Store) forEachEntryFrom(offset int, fn func(offset int, entry []byte) error) error {
 	for {
-		entry, err := s.Read(offset)
+		entry, next, err := s.readEntry(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
 		if err != nil {
-			break
+			return err
 		}
-		entries = append(entries, entry)
-		offset += len(entry) + 4
+		if err := fn(offset, entry); err != nil {
+			return err
+		}
+		offset = next
 	}
-	return entries, nil
 }
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"ashishkujoy/queue/internal/encryption"
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"testing"
@@ -90,3 +93,240 @@ func TestRestoreStore(t *testing.T) {
 	assert.Equal(t, data2, []byte("Another Hello World"))
 	assert.Equal(t, data1, []byte("Hello World"))
 }
+
+func TestAppendBatchAndRead(t *testing.T) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "TestAppendBatchAndRead")
+	defer os.Remove(filePath)
+	store, err := NewStore(filePath)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	offsets, err := store.AppendBatch([][]byte{[]byte("Hello World"), []byte("Another Hello World")})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 15}, offsets)
+
+	data1, err := store.Read(offsets[0])
+	assert.NoError(t, err)
+	data2, err := store.Read(offsets[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello World"), data1)
+	assert.Equal(t, []byte("Another Hello World"), data2)
+}
+
+func TestPreallocatedStoreKeepsItsSize(t *testing.T) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "TestPreallocatedStoreKeepsItsSize")
+	defer os.Remove(filePath)
+	store, err := NewStore(filePath)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Preallocate(1024*1024))
+
+	offset, err := store.Append([]byte("Hello World"))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	stat, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), stat.Size())
+
+	restoreStore, err := RestoreStore(filePath)
+	assert.NoError(t, err)
+	defer restoreStore.Close()
+	data, err := restoreStore.Read(offset)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello World"), data)
+}
+
+// appendWithTwoWrites appends an entry the way Store did before writes were framed
+// into a single buffer: one write for the length prefix and one for the payload.
+func appendWithTwoWrites(file *os.File, data []byte) error {
+	sizeBuff := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBuff, uint32(len(data)))
+	if _, err := file.Write(sizeBuff); err != nil {
+		return err
+	}
+	_, err := file.Write(data)
+	return err
+}
+
+func benchmarkPayload() []byte {
+	return []byte(`{"id":"8f2c1a9e","type":"order.created","amount":1250,"currency":"INR"}`)
+}
+
+func BenchmarkAppendWithTwoWrites(b *testing.B) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "BenchmarkAppendWithTwoWrites")
+	defer os.Remove(filePath)
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+	payload := benchmarkPayload()
+
+	b.SetBytes(int64(len(payload) + 4))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := appendWithTwoWrites(file, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreAppend(b *testing.B) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "BenchmarkStoreAppend")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+	store, err := NewStore(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	payload := benchmarkPayload()
+
+	b.SetBytes(int64(len(payload) + 4))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Append(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreAppendBatch(b *testing.B) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "BenchmarkStoreAppendBatch")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+	store, err := NewStore(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	payload := benchmarkPayload()
+	batch := make([][]byte, 64)
+	for i := range batch {
+		batch[i] = payload
+	}
+
+	b.SetBytes(int64(len(payload)+4) * int64(len(batch)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.AppendBatch(batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreallocatedStoreAppend(b *testing.B) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "BenchmarkPreallocatedStoreAppend")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+	store, err := NewStore(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	payload := benchmarkPayload()
+	if err := store.Preallocate((len(payload) + 4) * (b.N + 1)); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(payload) + 4))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Append(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadAfterCloseWriter(t *testing.T) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "TestReadAfterCloseWriter")
+	defer os.Remove(filePath)
+	store, err := NewStore(filePath)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	offset1, _ := store.Append([]byte("Hello World"))
+	offset2, _ := store.Append([]byte("Another Hello World"))
+	assert.NoError(t, store.CloseWriter())
+
+	data1, err := store.Read(offset1)
+	assert.NoError(t, err)
+	data2, err := store.Read(offset2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello World"), data1)
+	assert.Equal(t, []byte("Another Hello World"), data2)
+
+	_, err = store.Read(offset2 + 23)
+	assert.Error(t, err)
+}
+
+func testKeyring(t *testing.T, keys ...string) *encryption.Keyring {
+	keyring := encryption.NewKeyring()
+	for i, keyId := range keys {
+		assert.NoError(t, keyring.Add(keyId, bytes.Repeat([]byte{byte(i + 1)}, 32)))
+	}
+	return keyring
+}
+
+func TestEncryptedStore(t *testing.T) {
+	filePath := fmt.Sprintf("%s/%s", os.TempDir(), "TestEncryptedStore")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+	keyring := testKeyring(t, "k1")
+	store, err := NewEncryptedStore(filePath, keyring)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", store.KeyId())
+
+	offset1, _ := store.Append([]byte("Hello World"))
+	offset2, _ := store.Append([]byte("Another Hello World"))
+	assert.NoError(t, store.Close())
+
+	content, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "Hello World")
+
+	_, err = RestoreStore(filePath)
+	assert.Error(t, err)
+
+	restoreStore, err := RestoreEncryptedStore(filePath, keyring)
+	assert.NoError(t, err)
+	defer restoreStore.Close()
+	data1, err := restoreStore.Read(offset1)
+	assert.NoError(t, err)
+	data2, err := restoreStore.Read(offset2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello World"), data1)
+	assert.Equal(t, []byte("Another Hello World"), data2)
+
+	entries, err := restoreStore.readAllEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("Hello World"), []byte("Another Hello World")}, entries)
+}
+
+func TestStoresKeepTheirKeyAfterRotation(t *testing.T) {
+	oldPath := fmt.Sprintf("%s/%s", os.TempDir(), "TestStoresKeepTheirKeyAfterRotationOld")
+	newPath := fmt.Sprintf("%s/%s", os.TempDir(), "TestStoresKeepTheirKeyAfterRotationNew")
+	os.Remove(oldPath)
+	os.Remove(newPath)
+	defer os.Remove(oldPath)
+	defer os.Remove(newPath)
+
+	store, err := NewEncryptedStore(oldPath, testKeyring(t, "k1"))
+	assert.NoError(t, err)
+	offset, _ := store.Append([]byte("Hello World"))
+	assert.NoError(t, store.Close())
+
+	rotated := testKeyring(t, "k1", "k2")
+	oldStore, err := NewEncryptedStore(oldPath, rotated)
+	assert.NoError(t, err)
+	defer oldStore.Close()
+	assert.Equal(t, "k1", oldStore.KeyId())
+	data, err := oldStore.Read(offset)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello World"), data)
+
+	newStore, err := NewEncryptedStore(newPath, rotated)
+	assert.NoError(t, err)
+	defer newStore.Close()
+	assert.Equal(t, "k2", newStore.KeyId())
+}
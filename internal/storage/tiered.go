@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/archive"
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// tieredStorage offloads closed segments to an archive.
+// Closed segments are uploaded after rollover, and once uploaded all but the newest
+// LocalSegmentsRetained are removed from local disk. Reads of a removed segment fetch
+// it back from the archive into a cache directory holding up to ArchiveCacheSegments segments.
+type tieredStorage struct {
+	archive archive.SegmentArchive
+	config  *config.Config
+	// archiving serialises the changes to the archive and the evictions they allow: uploads,
+	// re-uploads of compacted segments, deletions by purges, and snapshots downloading from it.
+	// It is taken before Segments.mu and mu, and held across the network calls instead of them.
+	archiving sync.Mutex
+	// mu guards archived and fetched.
+	mu       sync.Mutex
+	archived map[int]bool
+	// fetched holds the segments loaded back from the archive, the least recently fetched first.
+	fetched []*Segment
+	// uploads tracks the background uploads started after a rollover.
+	uploads sync.WaitGroup
+}
+
+// newTieredStorage returns nil when no archive is configured.
+// The cache directory is emptied, cached copies do not outlive the process.
+func newTieredStorage(c *config.Config) (*tieredStorage, error) {
+	if c.Archive() == nil {
+		return nil, nil
+	}
+	if err := os.RemoveAll(c.ArchiveCacheDir()); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.ArchiveCacheDir(), 0755); err != nil {
+		return nil, err
+	}
+	archivedIds, err := c.Archive().List()
+	if err != nil {
+		return nil, fmt.Errorf("list archived segments: %w", err)
+	}
+	archived := make(map[int]bool, len(archivedIds))
+	for _, segmentId := range archivedIds {
+		archived[segmentId] = true
+	}
+	return &tieredStorage{archive: c.Archive(), config: c, archived: archived}, nil
+}
+
+// archivedOnly returns the IDs of the archived segments missing from local disk.
+func (t *tieredStorage) archivedOnly(localIds []int) []int {
+	var segmentIds []int
+	for segmentId := range t.archived {
+		if !slices.Contains(localIds, segmentId) {
+			segmentIds = append(segmentIds, segmentId)
+		}
+	}
+	slices.Sort(segmentIds)
+	return segmentIds
+}
+
+// evictedSegment returns a closed segment only present in the archive.
+func evictedSegment(id int, c *config.Config) *Segment {
+	return &Segment{
+		id:       id,
+		filePath: segmentFilePath(c, id),
+		keyring:  c.Keyring(),
+		mu:       &sync.RWMutex{},
+		cache:    &batchCache{},
+	}
+}
+
+// archiveInBackground uploads closed segments without holding up the writer that rolled over.
+func (s *Segments) archiveInBackground() {
+	if s.tiered == nil {
+		return
+	}
+	s.tiered.uploads.Add(1)
+	go func() {
+		defer s.tiered.uploads.Done()
+		if err := s.ArchiveClosedSegments(); err != nil {
+			s.config.Logger().Error("failed to archive closed segments", "error", err)
+		}
+	}()
+}
+
+// ArchiveClosedSegments uploads the closed segments not archived yet, then removes
+// from local disk the oldest archived segments beyond LocalSegmentsRetained.
+// Segments waiting to be recompressed are left for the archiving that follows their recompression.
+// Uploads run without holding t.mu, so reads fetching segments back are not held up by them.
+// It does nothing when no archive is configured.
+func (s *Segments) ArchiveClosedSegments() error {
+	t := s.tiered
+	if t == nil {
+		return nil
+	}
+	t.archiving.Lock()
+	defer t.archiving.Unlock()
+	s.mu.RLock()
+	closedSegments := slices.DeleteFunc(slices.Clone(s.closedSegments), func(segment *Segment) bool {
+		return s.recompressing[segment.id]
+	})
+	s.mu.RUnlock()
+
+	var local, pending []*Segment
+	t.mu.Lock()
+	for _, segment := range closedSegments {
+		if !segment.isLoaded() || slices.Contains(t.fetched, segment) {
+			continue
+		}
+		if !t.archived[segment.id] {
+			pending = append(pending, segment)
+		}
+		local = append(local, segment)
+	}
+	t.mu.Unlock()
+
+	for _, segment := range pending {
+		if err := t.archive.Upload(segment.id, segmentFilePath(s.config, segment.id)); err != nil {
+			return fmt.Errorf("upload segment %d: %w", segment.id, err)
+		}
+		t.mu.Lock()
+		t.archived[segment.id] = true
+		t.mu.Unlock()
+		s.config.Logger().Debug("archived segment", "segment", segment.id)
+	}
+
+	retained := s.config.LocalSegmentsRetained()
+	if retained <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(local) > retained {
+		if err := local[0].evict(); err != nil {
+			return fmt.Errorf("evict segment %d: %w", local[0].id, err)
+		}
+		s.config.Logger().Debug("removed archived segment from local disk", "segment", local[0].id)
+		local = local[1:]
+	}
+	return nil
+}
+
+// fetchSegment downloads an evicted segment from the archive into the cache directory
+// and loads it, evicting the least recently fetched segment when the cache is full.
+// The download runs without holding t.mu. When several readers fetch the same segment
+// at once the first download to complete is loaded, and a download of a segment purged
+// meanwhile is dropped.
+func (s *Segments) fetchSegment(segment *Segment) error {
+	t := s.tiered
+	if t == nil {
+		return fmt.Errorf("segment %d: %w", segment.id, errSegmentEvicted)
+	}
+	t.mu.Lock()
+	if i := slices.Index(t.fetched, segment); i >= 0 {
+		t.fetched = append(slices.Delete(t.fetched, i, i+1), segment)
+	}
+	loaded := segment.isLoaded()
+	t.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	generation := segment.currentGeneration()
+	download, err := os.CreateTemp(s.config.ArchiveCacheDir(), fmt.Sprintf("segment-%d-*.download", segment.id))
+	if err != nil {
+		return err
+	}
+	download.Close()
+	defer os.Remove(download.Name())
+	if err := t.archive.Download(segment.id, download.Name()); err != nil {
+		return fmt.Errorf("fetch segment %d: %w", segment.id, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if segment.isLoaded() || segment.currentGeneration() != generation {
+		return nil
+	}
+	filePath := filepath.Join(s.config.ArchiveCacheDir(), fmt.Sprintf("segment-%d", segment.id))
+	if err := os.Rename(download.Name(), filePath); err != nil {
+		return err
+	}
+	if err := segment.load(filePath, s.config); err != nil {
+		return err
+	}
+	t.fetched = append(t.fetched, segment)
+	s.config.Logger().Debug("fetched segment from the archive", "segment", segment.id)
+	for len(t.fetched) > s.config.ArchiveCacheSegments() {
+		if err := t.fetched[0].evict(); err != nil {
+			return err
+		}
+		t.fetched = t.fetched[1:]
+	}
+	return nil
+}
+
+// wait waits for the background uploads to finish.
+func (t *tieredStorage) wait() {
+	if t != nil {
+		t.uploads.Wait()
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"ashishkujoy/queue/internal/archive"
+	"ashishkujoy/queue/internal/config"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTieredConfig(t *testing.T, suffix string) (*config.Config, archive.SegmentArchive) {
+	segmentArchive, err := archive.NewDirectoryArchive(createTempDir(suffix + "Archive"))
+	assert.NoError(t, err)
+	cfg := config.NewConfig(
+		createTempDir(suffix),
+		createTempDir(suffix+"Metadata"),
+		20,
+		time.Second,
+	).WithArchive(segmentArchive).WithLocalSegmentsRetained(1).WithArchiveCacheSegments(1)
+	t.Cleanup(func() {
+		removeTempDir(suffix)
+		removeTempDir(suffix + "Metadata")
+		removeTempDir(suffix + "Archive")
+	})
+	return cfg, segmentArchive
+}
+
+func appendMessages(t *testing.T, segments *Segments, count int) []int {
+	var messageIds []int
+	for i := 0; i < count; i++ {
+		messageId, err := segments.Append([]byte(fmt.Sprintf("message %d", i)))
+		assert.NoError(t, err)
+		messageIds = append(messageIds, messageId)
+	}
+	return messageIds
+}
+
+func TestClosedSegmentsAreArchivedAndEvicted(t *testing.T) {
+	cfg, segmentArchive := newTieredConfig(t, "TieredTestArchive")
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+
+	messageIds := appendMessages(t, segments, 4)
+	segments.tiered.wait()
+	assert.NoError(t, segments.ArchiveClosedSegments())
+
+	archived, err := segmentArchive.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, archived)
+	local, err := getSegmentIds(cfg.SegmentsRoot())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, local)
+
+	for i, messageId := range messageIds {
+		data, err := segments.Read(messageId)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("message %d", i)), data)
+	}
+	cached, err := os.ReadDir(cfg.ArchiveCacheDir())
+	assert.NoError(t, err)
+	assert.Len(t, cached, 1)
+	assert.NoError(t, segments.Close())
+}
+
+func TestRestoreSegmentsFromArchive(t *testing.T) {
+	cfg, _ := newTieredConfig(t, "TieredTestRestore")
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	messageIds := appendMessages(t, segments, 4)
+	segments.tiered.wait()
+	assert.NoError(t, segments.ArchiveClosedSegments())
+	assert.NoError(t, segments.Close())
+	assert.NoError(t, index.Close())
+
+	index, err = RestoreIndex(cfg)
+	assert.NoError(t, err)
+	restored, err := RestoreSegments(cfg, index)
+	assert.NoError(t, err)
+
+	iterator := restored.NewIterator(messageIds[0])
+	for i := range messageIds {
+		record, err := iterator.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("message %d", i)), record.Data)
+	}
+	_, err = iterator.Next()
+	assert.Equal(t, ErrNoMoreMessages, err)
+	assert.NoError(t, restored.Close())
+	assert.NoError(t, index.Close())
+
+	diff, err := VerifyIndex(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, len(messageIds), diff.Entries)
+	assert.True(t, diff.Matches(), diff.String())
+}
+
+// blockingArchive holds every upload until release is closed.
+type blockingArchive struct {
+	archive.SegmentArchive
+	uploading chan struct{}
+	release   chan struct{}
+}
+
+func (a *blockingArchive) Upload(segmentId int, filePath string) error {
+	a.uploading <- struct{}{}
+	<-a.release
+	return a.SegmentArchive.Upload(segmentId, filePath)
+}
+
+// failingArchive fails every upload.
+type failingArchive struct {
+	archive.SegmentArchive
+}
+
+func (a *failingArchive) Upload(int, string) error {
+	return fmt.Errorf("archive unavailable")
+}
+
+func TestFetchSegmentWhileAnUploadIsInProgress(t *testing.T) {
+	cfg, segmentArchive := newTieredConfig(t, "TieredTestFetchDuringUpload")
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	messageIds := appendMessages(t, segments, 4)
+	segments.tiered.wait()
+	assert.NoError(t, segments.ArchiveClosedSegments())
+
+	blocking := &blockingArchive{SegmentArchive: segmentArchive, uploading: make(chan struct{}), release: make(chan struct{})}
+	segments.tiered.archive = blocking
+	appendMessages(t, segments, 1)
+	<-blocking.uploading
+
+	read := make(chan error)
+	go func() {
+		_, err := segments.Read(messageIds[0])
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fetch waited for the upload")
+	}
+	close(blocking.release)
+	assert.NoError(t, segments.Close())
+}
+
+func TestFailedUploadOfACompactedSegmentIsRetried(t *testing.T) {
+	cfg, segmentArchive := newTieredConfig(t, "TieredTestCompactUpload")
+	cfg.WithLocalSegmentsRetained(10)
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	segments, err := NewSegments(cfg, index)
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err := segments.AppendWithKey([]byte("k"), []byte(fmt.Sprintf("v%d", i)))
+		assert.NoError(t, err)
+	}
+	segments.tiered.wait()
+	assert.NoError(t, segments.ArchiveClosedSegments())
+	assert.True(t, segments.tiered.archived[0])
+
+	segments.tiered.archive = &failingArchive{SegmentArchive: segmentArchive}
+	_, err = segments.Compact()
+	assert.ErrorContains(t, err, "archive unavailable")
+	assert.False(t, segments.tiered.archived[0])
+
+	segments.tiered.archive = segmentArchive
+	assert.NoError(t, segments.ArchiveClosedSegments())
+	assert.True(t, segments.tiered.archived[0])
+	assert.NoError(t, segments.Close())
+}
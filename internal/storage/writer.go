@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// fileWriter frames records with their length prefix into a reusable scratch buffer
+// and hands the whole buffer to the file in a single write.
+// Appending a record, or a batch of records, therefore costs one write syscall.
+// It does not buffer across calls, so every record is visible to readers of the
+// file as soon as the call that wrote it returns, and Sync only has to fsync.
+// Records held in process memory between calls would be lost by a crash of the
+// process after being acknowledged, while records in the page cache are not.
+type fileWriter struct {
+	file *os.File
+	buf  []byte
+}
+
+func newFileWriter(file *os.File) *fileWriter {
+	return &fileWriter{file: file}
+}
+
+// writeRecords writes the records, each prefixed with its length, in a single write.
+// It returns the number of bytes written.
+func (w *fileWriter) writeRecords(records [][]byte) (int, error) {
+	size := 0
+	for _, record := range records {
+		size += 4 + len(record)
+	}
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	buf := w.buf[:size]
+	offset := 0
+	for _, record := range records {
+		binary.BigEndian.PutUint32(buf[offset:], uint32(len(record)))
+		offset += 4
+		offset += copy(buf[offset:], record)
+	}
+	return w.file.Write(buf)
+}
+
+// preallocate reserves size bytes of disk space for the file without changing its size.
+func (w *fileWriter) preallocate(size int) error {
+	return preallocate(w.file, int64(size))
+}
+
+// truncate drops everything past size, including space reserved by preallocate.
+func (w *fileWriter) truncate(size int) error {
+	return w.file.Truncate(int64(size))
+}
+
+func (w *fileWriter) Sync() error {
+	return w.file.Sync()
+}
+
+func (w *fileWriter) Close() error {
+	return w.file.Close()
+}
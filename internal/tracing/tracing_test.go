@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInjectAndExtractCarryTheSpanContextInHeaders(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	headers := map[string]string{"tenant": "acme"}
+	Inject(ctx, headers)
+	assert.Contains(t, headers, "traceparent")
+	assert.Equal(t, "acme", headers["tenant"])
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), headers))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+}
+
+func TestExtractKeepsTheContextWithoutTraceHeaders(t *testing.T) {
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(Extract(ctx, nil)))
+	assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(Extract(ctx, map[string]string{"tenant": "acme"})))
+}
+
+func TestTraceContextTravelsInRPCMetadata(t *testing.T) {
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	outgoing, _ := metadata.FromOutgoingContext(InjectOutgoing(ctx))
+	assert.NotEmpty(t, outgoing.Get("traceparent"))
+
+	incoming := metadata.NewIncomingContext(context.Background(), outgoing)
+	assert.Equal(t, span.SpanContext().TraceID(), trace.SpanContextFromContext(ExtractIncoming(incoming)).TraceID())
+	assert.False(t, trace.SpanContextFromContext(ExtractIncoming(context.Background())).IsValid())
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, err := NewProvider(context.Background(), ExporterNone, "", "queue")
+	assert.NoError(t, err)
+	assert.Nil(t, provider)
+
+	provider, err = NewProvider(context.Background(), ExporterStdout, "", "queue")
+	assert.NoError(t, err)
+	assert.NotNil(t, provider)
+	assert.NoError(t, Shutdown(context.Background(), provider))
+
+	_, err = NewProvider(context.Background(), "zipkin", "", "queue")
+	assert.ErrorContains(t, err, "unknown tracing exporter")
+	assert.NoError(t, Shutdown(context.Background(), noop.NewTracerProvider()))
+}
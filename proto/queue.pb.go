@@ -21,9 +21,114 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type ResetConsumerRequest_Position int32
+
+const (
+	// MESSAGE_ID resumes the consumer at messageId.
+	ResetConsumerRequest_MESSAGE_ID ResetConsumerRequest_Position = 0
+	// EARLIEST resumes the consumer at the oldest message stored.
+	ResetConsumerRequest_EARLIEST ResetConsumerRequest_Position = 1
+	// LATEST only delivers the messages enqueued from now on.
+	ResetConsumerRequest_LATEST ResetConsumerRequest_Position = 2
+)
+
+// Enum value maps for ResetConsumerRequest_Position.
+var (
+	ResetConsumerRequest_Position_name = map[int32]string{
+		0: "MESSAGE_ID",
+		1: "EARLIEST",
+		2: "LATEST",
+	}
+	ResetConsumerRequest_Position_value = map[string]int32{
+		"MESSAGE_ID": 0,
+		"EARLIEST":   1,
+		"LATEST":     2,
+	}
+)
+
+func (x ResetConsumerRequest_Position) Enum() *ResetConsumerRequest_Position {
+	p := new(ResetConsumerRequest_Position)
+	*p = x
+	return p
+}
+
+func (x ResetConsumerRequest_Position) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ResetConsumerRequest_Position) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_queue_proto_enumTypes[0].Descriptor()
+}
+
+func (ResetConsumerRequest_Position) Type() protoreflect.EnumType {
+	return &file_proto_queue_proto_enumTypes[0]
+}
+
+func (x ResetConsumerRequest_Position) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ResetConsumerRequest_Position.Descriptor instead.
+func (ResetConsumerRequest_Position) EnumDescriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{22, 0}
+}
+
+type ExportQueueRequest_Format int32
+
+const (
+	// JSONL exports a JSON object per message, with the key and message base64 encoded.
+	ExportQueueRequest_JSONL ExportQueueRequest_Format = 0
+	// BINARY exports the messages length prefixed, laid out as in a segment.
+	ExportQueueRequest_BINARY ExportQueueRequest_Format = 1
+)
+
+// Enum value maps for ExportQueueRequest_Format.
+var (
+	ExportQueueRequest_Format_name = map[int32]string{
+		0: "JSONL",
+		1: "BINARY",
+	}
+	ExportQueueRequest_Format_value = map[string]int32{
+		"JSONL":  0,
+		"BINARY": 1,
+	}
+)
+
+func (x ExportQueueRequest_Format) Enum() *ExportQueueRequest_Format {
+	p := new(ExportQueueRequest_Format)
+	*p = x
+	return p
+}
+
+func (x ExportQueueRequest_Format) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ExportQueueRequest_Format) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_queue_proto_enumTypes[1].Descriptor()
+}
+
+func (ExportQueueRequest_Format) Type() protoreflect.EnumType {
+	return &file_proto_queue_proto_enumTypes[1]
+}
+
+func (x ExportQueueRequest_Format) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ExportQueueRequest_Format.Descriptor instead.
+func (ExportQueueRequest_Format) EnumDescriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{25, 0}
+}
+
 type EnqueueRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Message       []byte                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Message []byte                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	// key identifies the message in a compacted queue, where only the newest message of each key is kept.
+	// A message with a key and an empty payload deletes the key.
+	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	// headers are stored along with the message and delivered with it, the server adds the trace context.
+	Headers       map[string]string `protobuf:"bytes,3,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -65,9 +170,25 @@ func (x *EnqueueRequest) GetMessage() []byte {
 	return nil
 }
 
+func (x *EnqueueRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *EnqueueRequest) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
 type EnqueueRequestResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Success bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	// id is the ID the message was stored under.
+	Id            uint64 `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -109,9 +230,20 @@ func (x *EnqueueRequestResponse) GetSuccess() bool {
 	return false
 }
 
+func (x *EnqueueRequestResponse) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
 type ObserveQueueRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ConsumerId    uint64                 `protobuf:"varint,1,opt,name=consumerId,proto3" json:"consumerId,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	ConsumerId uint64                 `protobuf:"varint,1,opt,name=consumerId,proto3" json:"consumerId,omitempty"`
+	// maxMessages ends the stream once that many messages have been delivered, 0 streams until cancelled.
+	// Messages are acknowledged as they are sent, so a limit enforced by the server keeps the consumer
+	// from skipping messages a client stopping on its own would have received without reading.
+	MaxMessages   uint64 `protobuf:"varint,2,opt,name=maxMessages,proto3" json:"maxMessages,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -153,9 +285,19 @@ func (x *ObserveQueueRequest) GetConsumerId() uint64 {
 	return 0
 }
 
+func (x *ObserveQueueRequest) GetMaxMessages() uint64 {
+	if x != nil {
+		return x.MaxMessages
+	}
+	return 0
+}
+
 type QueueMessage struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Message       []byte                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Key           []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Headers       map[string]string      `protobuf:"bytes,3,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Id            uint64                 `protobuf:"varint,4,opt,name=id,proto3" json:"id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -197,24 +339,1713 @@ func (x *QueueMessage) GetMessage() []byte {
 	return nil
 }
 
-var File_proto_queue_proto protoreflect.FileDescriptor
+func (x *QueueMessage) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
 
-const file_proto_queue_proto_rawDesc = "" +
-	"\n" +
-	"\x11proto/queue.proto\"*\n" +
-	"\x0eEnqueueRequest\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\fR\amessage\"2\n" +
-	"\x16EnqueueRequestResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"5\n" +
-	"\x13ObserveQueueRequest\x12\x1e\n" +
-	"\n" +
-	"consumerId\x18\x01 \x01(\x04R\n" +
-	"consumerId\"(\n" +
-	"\fQueueMessage\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\fR\amessage2z\n" +
-	"\fQueueService\x123\n" +
-	"\aEnqueue\x12\x0f.EnqueueRequest\x1a\x17.EnqueueRequestResponse\x125\n" +
-	"\fObserveQueue\x12\x14.ObserveQueueRequest\x1a\r.QueueMessage0\x01B#Z!ashishkujoy/queue/net;netinternalb\x06proto3"
+func (x *QueueMessage) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *QueueMessage) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+// FetchRequest reads messages without moving any consumer.
+type FetchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// fromId is the ID of the first message returned, or of the next one stored when it has been dropped.
+	FromId uint64 `protobuf:"varint,1,opt,name=fromId,proto3" json:"fromId,omitempty"`
+	// maxMessages bounds the number of messages returned. The server returns fewer when the response would
+	// exceed the 4MiB gRPC clients receive by default, 0 leaves the bound to the server alone.
+	MaxMessages   uint64 `protobuf:"varint,2,opt,name=maxMessages,proto3" json:"maxMessages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FetchRequest) Reset() {
+	*x = FetchRequest{}
+	mi := &file_proto_queue_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchRequest) ProtoMessage() {}
+
+func (x *FetchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchRequest.ProtoReflect.Descriptor instead.
+func (*FetchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FetchRequest) GetFromId() uint64 {
+	if x != nil {
+		return x.FromId
+	}
+	return 0
+}
+
+func (x *FetchRequest) GetMaxMessages() uint64 {
+	if x != nil {
+		return x.MaxMessages
+	}
+	return 0
+}
+
+type FetchResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Messages []*QueueMessage        `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	// nextId is the fromId to fetch the following messages with.
+	NextId        uint64 `protobuf:"varint,2,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FetchResponse) Reset() {
+	*x = FetchResponse{}
+	mi := &file_proto_queue_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchResponse) ProtoMessage() {}
+
+func (x *FetchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchResponse.ProtoReflect.Descriptor instead.
+func (*FetchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FetchResponse) GetMessages() []*QueueMessage {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+func (x *FetchResponse) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+// ReplicateRequest is sent by a follower when it opens a replication stream, and again
+// every time it has appended records, to acknowledge them.
+type ReplicateRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	FollowerId string                 `protobuf:"bytes,1,opt,name=followerId,proto3" json:"followerId,omitempty"`
+	// nextId is the ID of the next message the follower needs, every message before it is stored.
+	NextId        uint64 `protobuf:"varint,2,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicateRequest) Reset() {
+	*x = ReplicateRequest{}
+	mi := &file_proto_queue_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicateRequest) ProtoMessage() {}
+
+func (x *ReplicateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicateRequest.ProtoReflect.Descriptor instead.
+func (*ReplicateRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ReplicateRequest) GetFollowerId() string {
+	if x != nil {
+		return x.FollowerId
+	}
+	return ""
+}
+
+func (x *ReplicateRequest) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+type ReplicatedRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Key           []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Message       []byte                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	Headers       map[string]string      `protobuf:"bytes,4,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicatedRecord) Reset() {
+	*x = ReplicatedRecord{}
+	mi := &file_proto_queue_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicatedRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicatedRecord) ProtoMessage() {}
+
+func (x *ReplicatedRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicatedRecord.ProtoReflect.Descriptor instead.
+func (*ReplicatedRecord) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ReplicatedRecord) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ReplicatedRecord) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *ReplicatedRecord) GetMessage() []byte {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *ReplicatedRecord) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+// ReplicationBatch carries records to a follower. Batches without records are heartbeats.
+type ReplicationBatch struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Records []*ReplicatedRecord    `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	// leaderNextId is the ID of the next message the leader will append, used to compute follower lag.
+	LeaderNextId  uint64 `protobuf:"varint,2,opt,name=leaderNextId,proto3" json:"leaderNextId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationBatch) Reset() {
+	*x = ReplicationBatch{}
+	mi := &file_proto_queue_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationBatch) ProtoMessage() {}
+
+func (x *ReplicationBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationBatch.ProtoReflect.Descriptor instead.
+func (*ReplicationBatch) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ReplicationBatch) GetRecords() []*ReplicatedRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *ReplicationBatch) GetLeaderNextId() uint64 {
+	if x != nil {
+		return x.LeaderNextId
+	}
+	return 0
+}
+
+type ReplicationStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationStatusRequest) Reset() {
+	*x = ReplicationStatusRequest{}
+	mi := &file_proto_queue_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationStatusRequest) ProtoMessage() {}
+
+func (x *ReplicationStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationStatusRequest.ProtoReflect.Descriptor instead.
+func (*ReplicationStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{9}
+}
+
+type FollowerStatus struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	FollowerId string                 `protobuf:"bytes,1,opt,name=followerId,proto3" json:"followerId,omitempty"`
+	NextId     uint64                 `protobuf:"varint,2,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	// lag is the number of messages the follower has not acknowledged yet.
+	Lag           uint64 `protobuf:"varint,3,opt,name=lag,proto3" json:"lag,omitempty"`
+	Connected     bool   `protobuf:"varint,4,opt,name=connected,proto3" json:"connected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FollowerStatus) Reset() {
+	*x = FollowerStatus{}
+	mi := &file_proto_queue_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FollowerStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FollowerStatus) ProtoMessage() {}
+
+func (x *FollowerStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FollowerStatus.ProtoReflect.Descriptor instead.
+func (*FollowerStatus) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *FollowerStatus) GetFollowerId() string {
+	if x != nil {
+		return x.FollowerId
+	}
+	return ""
+}
+
+func (x *FollowerStatus) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+func (x *FollowerStatus) GetLag() uint64 {
+	if x != nil {
+		return x.Lag
+	}
+	return 0
+}
+
+func (x *FollowerStatus) GetConnected() bool {
+	if x != nil {
+		return x.Connected
+	}
+	return false
+}
+
+type ReplicationStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NextId        uint64                 `protobuf:"varint,1,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	Followers     []*FollowerStatus      `protobuf:"bytes,2,rep,name=followers,proto3" json:"followers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationStatus) Reset() {
+	*x = ReplicationStatus{}
+	mi := &file_proto_queue_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationStatus) ProtoMessage() {}
+
+func (x *ReplicationStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationStatus.ProtoReflect.Descriptor instead.
+func (*ReplicationStatus) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ReplicationStatus) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+func (x *ReplicationStatus) GetFollowers() []*FollowerStatus {
+	if x != nil {
+		return x.Followers
+	}
+	return nil
+}
+
+// QuotaLimits caps a flow of messages, a zero rate meaning unlimited.
+type QuotaLimits struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	MessagesPerSecond float64                `protobuf:"fixed64,1,opt,name=messagesPerSecond,proto3" json:"messagesPerSecond,omitempty"`
+	BytesPerSecond    float64                `protobuf:"fixed64,2,opt,name=bytesPerSecond,proto3" json:"bytesPerSecond,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *QuotaLimits) Reset() {
+	*x = QuotaLimits{}
+	mi := &file_proto_queue_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QuotaLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuotaLimits) ProtoMessage() {}
+
+func (x *QuotaLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuotaLimits.ProtoReflect.Descriptor instead.
+func (*QuotaLimits) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *QuotaLimits) GetMessagesPerSecond() float64 {
+	if x != nil {
+		return x.MessagesPerSecond
+	}
+	return 0
+}
+
+func (x *QuotaLimits) GetBytesPerSecond() float64 {
+	if x != nil {
+		return x.BytesPerSecond
+	}
+	return 0
+}
+
+// ClientQuota overrides the default limits of one client, identified by its principal or address.
+type ClientQuota struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Client        string                 `protobuf:"bytes,1,opt,name=client,proto3" json:"client,omitempty"`
+	Produce       *QuotaLimits           `protobuf:"bytes,2,opt,name=produce,proto3" json:"produce,omitempty"`
+	Consume       *QuotaLimits           `protobuf:"bytes,3,opt,name=consume,proto3" json:"consume,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientQuota) Reset() {
+	*x = ClientQuota{}
+	mi := &file_proto_queue_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientQuota) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientQuota) ProtoMessage() {}
+
+func (x *ClientQuota) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientQuota.ProtoReflect.Descriptor instead.
+func (*ClientQuota) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ClientQuota) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *ClientQuota) GetProduce() *QuotaLimits {
+	if x != nil {
+		return x.Produce
+	}
+	return nil
+}
+
+func (x *ClientQuota) GetConsume() *QuotaLimits {
+	if x != nil {
+		return x.Consume
+	}
+	return nil
+}
+
+type Quotas struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// produce and consume are the default limits of every client.
+	Produce *QuotaLimits `protobuf:"bytes,1,opt,name=produce,proto3" json:"produce,omitempty"`
+	Consume *QuotaLimits `protobuf:"bytes,2,opt,name=consume,proto3" json:"consume,omitempty"`
+	// queue caps the messages enqueued by all clients together.
+	Queue         *QuotaLimits   `protobuf:"bytes,3,opt,name=queue,proto3" json:"queue,omitempty"`
+	Clients       []*ClientQuota `protobuf:"bytes,4,rep,name=clients,proto3" json:"clients,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Quotas) Reset() {
+	*x = Quotas{}
+	mi := &file_proto_queue_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Quotas) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Quotas) ProtoMessage() {}
+
+func (x *Quotas) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Quotas.ProtoReflect.Descriptor instead.
+func (*Quotas) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *Quotas) GetProduce() *QuotaLimits {
+	if x != nil {
+		return x.Produce
+	}
+	return nil
+}
+
+func (x *Quotas) GetConsume() *QuotaLimits {
+	if x != nil {
+		return x.Consume
+	}
+	return nil
+}
+
+func (x *Quotas) GetQueue() *QuotaLimits {
+	if x != nil {
+		return x.Queue
+	}
+	return nil
+}
+
+func (x *Quotas) GetClients() []*ClientQuota {
+	if x != nil {
+		return x.Clients
+	}
+	return nil
+}
+
+type GetQuotasRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQuotasRequest) Reset() {
+	*x = GetQuotasRequest{}
+	mi := &file_proto_queue_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQuotasRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQuotasRequest) ProtoMessage() {}
+
+func (x *GetQuotasRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQuotasRequest.ProtoReflect.Descriptor instead.
+func (*GetQuotasRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{15}
+}
+
+type DescribeQueueRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DescribeQueueRequest) Reset() {
+	*x = DescribeQueueRequest{}
+	mi := &file_proto_queue_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DescribeQueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DescribeQueueRequest) ProtoMessage() {}
+
+func (x *DescribeQueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DescribeQueueRequest.ProtoReflect.Descriptor instead.
+func (*DescribeQueueRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{16}
+}
+
+type SegmentDescription struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// sizeInBytes is the size of the segment file, zero for a segment only present in the archive.
+	SizeInBytes uint64 `protobuf:"varint,2,opt,name=sizeInBytes,proto3" json:"sizeInBytes,omitempty"`
+	Messages    uint64 `protobuf:"varint,3,opt,name=messages,proto3" json:"messages,omitempty"`
+	// firstId and lastId are the range of the IDs of the messages of the segment, -1 without messages.
+	FirstId int64 `protobuf:"varint,4,opt,name=firstId,proto3" json:"firstId,omitempty"`
+	LastId  int64 `protobuf:"varint,5,opt,name=lastId,proto3" json:"lastId,omitempty"`
+	// local is false for a segment offloaded to the archive, active is set on the segment appended to.
+	Local         bool `protobuf:"varint,6,opt,name=local,proto3" json:"local,omitempty"`
+	Active        bool `protobuf:"varint,7,opt,name=active,proto3" json:"active,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SegmentDescription) Reset() {
+	*x = SegmentDescription{}
+	mi := &file_proto_queue_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SegmentDescription) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SegmentDescription) ProtoMessage() {}
+
+func (x *SegmentDescription) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SegmentDescription.ProtoReflect.Descriptor instead.
+func (*SegmentDescription) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *SegmentDescription) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *SegmentDescription) GetSizeInBytes() uint64 {
+	if x != nil {
+		return x.SizeInBytes
+	}
+	return 0
+}
+
+func (x *SegmentDescription) GetMessages() uint64 {
+	if x != nil {
+		return x.Messages
+	}
+	return 0
+}
+
+func (x *SegmentDescription) GetFirstId() int64 {
+	if x != nil {
+		return x.FirstId
+	}
+	return 0
+}
+
+func (x *SegmentDescription) GetLastId() int64 {
+	if x != nil {
+		return x.LastId
+	}
+	return 0
+}
+
+func (x *SegmentDescription) GetLocal() bool {
+	if x != nil {
+		return x.Local
+	}
+	return false
+}
+
+func (x *SegmentDescription) GetActive() bool {
+	if x != nil {
+		return x.Active
+	}
+	return false
+}
+
+type QueueDescription struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// headId is the ID of the oldest message stored and tailId of the newest, both -1 when the queue is empty.
+	HeadId   int64  `protobuf:"varint,2,opt,name=headId,proto3" json:"headId,omitempty"`
+	TailId   int64  `protobuf:"varint,3,opt,name=tailId,proto3" json:"tailId,omitempty"`
+	NextId   uint64 `protobuf:"varint,4,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	Messages uint64 `protobuf:"varint,5,opt,name=messages,proto3" json:"messages,omitempty"`
+	// totalBytes is the size of the segment files on local disk.
+	TotalBytes    uint64                `protobuf:"varint,6,opt,name=totalBytes,proto3" json:"totalBytes,omitempty"`
+	Segments      []*SegmentDescription `protobuf:"bytes,7,rep,name=segments,proto3" json:"segments,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueueDescription) Reset() {
+	*x = QueueDescription{}
+	mi := &file_proto_queue_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueueDescription) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueueDescription) ProtoMessage() {}
+
+func (x *QueueDescription) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueueDescription.ProtoReflect.Descriptor instead.
+func (*QueueDescription) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *QueueDescription) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *QueueDescription) GetHeadId() int64 {
+	if x != nil {
+		return x.HeadId
+	}
+	return 0
+}
+
+func (x *QueueDescription) GetTailId() int64 {
+	if x != nil {
+		return x.TailId
+	}
+	return 0
+}
+
+func (x *QueueDescription) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+func (x *QueueDescription) GetMessages() uint64 {
+	if x != nil {
+		return x.Messages
+	}
+	return 0
+}
+
+func (x *QueueDescription) GetTotalBytes() uint64 {
+	if x != nil {
+		return x.TotalBytes
+	}
+	return 0
+}
+
+func (x *QueueDescription) GetSegments() []*SegmentDescription {
+	if x != nil {
+		return x.Segments
+	}
+	return nil
+}
+
+type ListConsumersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConsumersRequest) Reset() {
+	*x = ListConsumersRequest{}
+	mi := &file_proto_queue_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConsumersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConsumersRequest) ProtoMessage() {}
+
+func (x *ListConsumersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConsumersRequest.ProtoReflect.Descriptor instead.
+func (*ListConsumersRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{19}
+}
+
+type ConsumerDescription struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	ConsumerId uint64                 `protobuf:"varint,1,opt,name=consumerId,proto3" json:"consumerId,omitempty"`
+	// offset is the last message ID delivered to the consumer, -1 when it has received none.
+	Offset int64 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	// lag is the number of messages enqueued after offset.
+	Lag uint64 `protobuf:"varint,3,opt,name=lag,proto3" json:"lag,omitempty"`
+	// online is set while the consumer observes the queue.
+	Online        bool `protobuf:"varint,4,opt,name=online,proto3" json:"online,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumerDescription) Reset() {
+	*x = ConsumerDescription{}
+	mi := &file_proto_queue_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumerDescription) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumerDescription) ProtoMessage() {}
+
+func (x *ConsumerDescription) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumerDescription.ProtoReflect.Descriptor instead.
+func (*ConsumerDescription) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ConsumerDescription) GetConsumerId() uint64 {
+	if x != nil {
+		return x.ConsumerId
+	}
+	return 0
+}
+
+func (x *ConsumerDescription) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ConsumerDescription) GetLag() uint64 {
+	if x != nil {
+		return x.Lag
+	}
+	return 0
+}
+
+func (x *ConsumerDescription) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+type ConsumerList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Consumers     []*ConsumerDescription `protobuf:"bytes,1,rep,name=consumers,proto3" json:"consumers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumerList) Reset() {
+	*x = ConsumerList{}
+	mi := &file_proto_queue_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumerList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumerList) ProtoMessage() {}
+
+func (x *ConsumerList) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumerList.ProtoReflect.Descriptor instead.
+func (*ConsumerList) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ConsumerList) GetConsumers() []*ConsumerDescription {
+	if x != nil {
+		return x.Consumers
+	}
+	return nil
+}
+
+type ResetConsumerRequest struct {
+	state      protoimpl.MessageState        `protogen:"open.v1"`
+	ConsumerId uint64                        `protobuf:"varint,1,opt,name=consumerId,proto3" json:"consumerId,omitempty"`
+	Position   ResetConsumerRequest_Position `protobuf:"varint,2,opt,name=position,proto3,enum=ResetConsumerRequest_Position" json:"position,omitempty"`
+	// messageId is the ID of the next message delivered to the consumer with the MESSAGE_ID position.
+	MessageId     uint64 `protobuf:"varint,3,opt,name=messageId,proto3" json:"messageId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResetConsumerRequest) Reset() {
+	*x = ResetConsumerRequest{}
+	mi := &file_proto_queue_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResetConsumerRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResetConsumerRequest) ProtoMessage() {}
+
+func (x *ResetConsumerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResetConsumerRequest.ProtoReflect.Descriptor instead.
+func (*ResetConsumerRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ResetConsumerRequest) GetConsumerId() uint64 {
+	if x != nil {
+		return x.ConsumerId
+	}
+	return 0
+}
+
+func (x *ResetConsumerRequest) GetPosition() ResetConsumerRequest_Position {
+	if x != nil {
+		return x.Position
+	}
+	return ResetConsumerRequest_MESSAGE_ID
+}
+
+func (x *ResetConsumerRequest) GetMessageId() uint64 {
+	if x != nil {
+		return x.MessageId
+	}
+	return 0
+}
+
+type PurgeQueueRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PurgeQueueRequest) Reset() {
+	*x = PurgeQueueRequest{}
+	mi := &file_proto_queue_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PurgeQueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeQueueRequest) ProtoMessage() {}
+
+func (x *PurgeQueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeQueueRequest.ProtoReflect.Descriptor instead.
+func (*PurgeQueueRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{23}
+}
+
+type PurgeQueueResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// dropped is the number of messages purged.
+	Dropped       uint64 `protobuf:"varint,1,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	NextId        uint64 `protobuf:"varint,2,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PurgeQueueResponse) Reset() {
+	*x = PurgeQueueResponse{}
+	mi := &file_proto_queue_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PurgeQueueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeQueueResponse) ProtoMessage() {}
+
+func (x *PurgeQueueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeQueueResponse.ProtoReflect.Descriptor instead.
+func (*PurgeQueueResponse) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *PurgeQueueResponse) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+func (x *PurgeQueueResponse) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+type ExportQueueRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// fromId is the ID of the first message exported, toId the ID following the last one,
+	// 0 exports up to the newest message when the export starts.
+	FromId        uint64                    `protobuf:"varint,1,opt,name=fromId,proto3" json:"fromId,omitempty"`
+	ToId          uint64                    `protobuf:"varint,2,opt,name=toId,proto3" json:"toId,omitempty"`
+	Format        ExportQueueRequest_Format `protobuf:"varint,3,opt,name=format,proto3,enum=ExportQueueRequest_Format" json:"format,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportQueueRequest) Reset() {
+	*x = ExportQueueRequest{}
+	mi := &file_proto_queue_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportQueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportQueueRequest) ProtoMessage() {}
+
+func (x *ExportQueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportQueueRequest.ProtoReflect.Descriptor instead.
+func (*ExportQueueRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *ExportQueueRequest) GetFromId() uint64 {
+	if x != nil {
+		return x.FromId
+	}
+	return 0
+}
+
+func (x *ExportQueueRequest) GetToId() uint64 {
+	if x != nil {
+		return x.ToId
+	}
+	return 0
+}
+
+func (x *ExportQueueRequest) GetFormat() ExportQueueRequest_Format {
+	if x != nil {
+		return x.Format
+	}
+	return ExportQueueRequest_JSONL
+}
+
+// ExportChunk is the next part of an export, to be concatenated with the previous ones.
+type ExportChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportChunk) Reset() {
+	*x = ExportChunk{}
+	mi := &file_proto_queue_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportChunk) ProtoMessage() {}
+
+func (x *ExportChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportChunk.ProtoReflect.Descriptor instead.
+func (*ExportChunk) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *ExportChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// ImportChunk is the next part of an export to import, in either format.
+type ImportChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// preserveIds, read from the first chunk, appends the messages under their exported IDs,
+	// which must follow the newest message of the queue. Otherwise they are enqueued as new messages.
+	PreserveIds   bool   `protobuf:"varint,1,opt,name=preserveIds,proto3" json:"preserveIds,omitempty"`
+	Data          []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImportChunk) Reset() {
+	*x = ImportChunk{}
+	mi := &file_proto_queue_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportChunk) ProtoMessage() {}
+
+func (x *ImportChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportChunk.ProtoReflect.Descriptor instead.
+func (*ImportChunk) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ImportChunk) GetPreserveIds() bool {
+	if x != nil {
+		return x.PreserveIds
+	}
+	return false
+}
+
+func (x *ImportChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type ImportQueueResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Imported      uint64                 `protobuf:"varint,1,opt,name=imported,proto3" json:"imported,omitempty"`
+	NextId        uint64                 `protobuf:"varint,2,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImportQueueResponse) Reset() {
+	*x = ImportQueueResponse{}
+	mi := &file_proto_queue_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportQueueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportQueueResponse) ProtoMessage() {}
+
+func (x *ImportQueueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportQueueResponse.ProtoReflect.Descriptor instead.
+func (*ImportQueueResponse) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *ImportQueueResponse) GetImported() uint64 {
+	if x != nil {
+		return x.Imported
+	}
+	return 0
+}
+
+func (x *ImportQueueResponse) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+type SnapshotRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// directory is the path on the server the snapshot is written to, it must be empty or missing.
+	Directory     string `protobuf:"bytes,1,opt,name=directory,proto3" json:"directory,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotRequest) Reset() {
+	*x = SnapshotRequest{}
+	mi := &file_proto_queue_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotRequest) ProtoMessage() {}
+
+func (x *SnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotRequest.ProtoReflect.Descriptor instead.
+func (*SnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *SnapshotRequest) GetDirectory() string {
+	if x != nil {
+		return x.Directory
+	}
+	return ""
+}
+
+type SnapshotResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Directory string                 `protobuf:"bytes,1,opt,name=directory,proto3" json:"directory,omitempty"`
+	// segments is the number of segment files copied, sizeInBytes their size along with the index.
+	Segments      uint64 `protobuf:"varint,2,opt,name=segments,proto3" json:"segments,omitempty"`
+	SizeInBytes   uint64 `protobuf:"varint,3,opt,name=sizeInBytes,proto3" json:"sizeInBytes,omitempty"`
+	NextId        uint64 `protobuf:"varint,4,opt,name=nextId,proto3" json:"nextId,omitempty"`
+	Consumers     uint64 `protobuf:"varint,5,opt,name=consumers,proto3" json:"consumers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotResponse) Reset() {
+	*x = SnapshotResponse{}
+	mi := &file_proto_queue_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotResponse) ProtoMessage() {}
+
+func (x *SnapshotResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_queue_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotResponse.ProtoReflect.Descriptor instead.
+func (*SnapshotResponse) Descriptor() ([]byte, []int) {
+	return file_proto_queue_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *SnapshotResponse) GetDirectory() string {
+	if x != nil {
+		return x.Directory
+	}
+	return ""
+}
+
+func (x *SnapshotResponse) GetSegments() uint64 {
+	if x != nil {
+		return x.Segments
+	}
+	return 0
+}
+
+func (x *SnapshotResponse) GetSizeInBytes() uint64 {
+	if x != nil {
+		return x.SizeInBytes
+	}
+	return 0
+}
+
+func (x *SnapshotResponse) GetNextId() uint64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+func (x *SnapshotResponse) GetConsumers() uint64 {
+	if x != nil {
+		return x.Consumers
+	}
+	return 0
+}
+
+var File_proto_queue_proto protoreflect.FileDescriptor
+
+const file_proto_queue_proto_rawDesc = "" +
+	"\n" +
+	"\x11proto/queue.proto\"\xb0\x01\n" +
+	"\x0eEnqueueRequest\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\fR\amessage\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\fR\x03key\x126\n" +
+	"\aheaders\x18\x03 \x03(\v2\x1c.EnqueueRequest.HeadersEntryR\aheaders\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"B\n" +
+	"\x16EnqueueRequestResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x04R\x02id\"W\n" +
+	"\x13ObserveQueueRequest\x12\x1e\n" +
+	"\n" +
+	"consumerId\x18\x01 \x01(\x04R\n" +
+	"consumerId\x12 \n" +
+	"\vmaxMessages\x18\x02 \x01(\x04R\vmaxMessages\"\xbc\x01\n" +
+	"\fQueueMessage\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\fR\amessage\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\fR\x03key\x124\n" +
+	"\aheaders\x18\x03 \x03(\v2\x1a.QueueMessage.HeadersEntryR\aheaders\x12\x0e\n" +
+	"\x02id\x18\x04 \x01(\x04R\x02id\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"H\n" +
+	"\fFetchRequest\x12\x16\n" +
+	"\x06fromId\x18\x01 \x01(\x04R\x06fromId\x12 \n" +
+	"\vmaxMessages\x18\x02 \x01(\x04R\vmaxMessages\"R\n" +
+	"\rFetchResponse\x12)\n" +
+	"\bmessages\x18\x01 \x03(\v2\r.QueueMessageR\bmessages\x12\x16\n" +
+	"\x06nextId\x18\x02 \x01(\x04R\x06nextId\"J\n" +
+	"\x10ReplicateRequest\x12\x1e\n" +
+	"\n" +
+	"followerId\x18\x01 \x01(\tR\n" +
+	"followerId\x12\x16\n" +
+	"\x06nextId\x18\x02 \x01(\x04R\x06nextId\"\xc4\x01\n" +
+	"\x10ReplicatedRecord\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\fR\x03key\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\fR\amessage\x128\n" +
+	"\aheaders\x18\x04 \x03(\v2\x1e.ReplicatedRecord.HeadersEntryR\aheaders\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"c\n" +
+	"\x10ReplicationBatch\x12+\n" +
+	"\arecords\x18\x01 \x03(\v2\x11.ReplicatedRecordR\arecords\x12\"\n" +
+	"\fleaderNextId\x18\x02 \x01(\x04R\fleaderNextId\"\x1a\n" +
+	"\x18ReplicationStatusRequest\"x\n" +
+	"\x0eFollowerStatus\x12\x1e\n" +
+	"\n" +
+	"followerId\x18\x01 \x01(\tR\n" +
+	"followerId\x12\x16\n" +
+	"\x06nextId\x18\x02 \x01(\x04R\x06nextId\x12\x10\n" +
+	"\x03lag\x18\x03 \x01(\x04R\x03lag\x12\x1c\n" +
+	"\tconnected\x18\x04 \x01(\bR\tconnected\"Z\n" +
+	"\x11ReplicationStatus\x12\x16\n" +
+	"\x06nextId\x18\x01 \x01(\x04R\x06nextId\x12-\n" +
+	"\tfollowers\x18\x02 \x03(\v2\x0f.FollowerStatusR\tfollowers\"c\n" +
+	"\vQuotaLimits\x12,\n" +
+	"\x11messagesPerSecond\x18\x01 \x01(\x01R\x11messagesPerSecond\x12&\n" +
+	"\x0ebytesPerSecond\x18\x02 \x01(\x01R\x0ebytesPerSecond\"u\n" +
+	"\vClientQuota\x12\x16\n" +
+	"\x06client\x18\x01 \x01(\tR\x06client\x12&\n" +
+	"\aproduce\x18\x02 \x01(\v2\f.QuotaLimitsR\aproduce\x12&\n" +
+	"\aconsume\x18\x03 \x01(\v2\f.QuotaLimitsR\aconsume\"\xa4\x01\n" +
+	"\x06Quotas\x12&\n" +
+	"\aproduce\x18\x01 \x01(\v2\f.QuotaLimitsR\aproduce\x12&\n" +
+	"\aconsume\x18\x02 \x01(\v2\f.QuotaLimitsR\aconsume\x12\"\n" +
+	"\x05queue\x18\x03 \x01(\v2\f.QuotaLimitsR\x05queue\x12&\n" +
+	"\aclients\x18\x04 \x03(\v2\f.ClientQuotaR\aclients\"\x12\n" +
+	"\x10GetQuotasRequest\"\x16\n" +
+	"\x14DescribeQueueRequest\"\xc2\x01\n" +
+	"\x12SegmentDescription\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12 \n" +
+	"\vsizeInBytes\x18\x02 \x01(\x04R\vsizeInBytes\x12\x1a\n" +
+	"\bmessages\x18\x03 \x01(\x04R\bmessages\x12\x18\n" +
+	"\afirstId\x18\x04 \x01(\x03R\afirstId\x12\x16\n" +
+	"\x06lastId\x18\x05 \x01(\x03R\x06lastId\x12\x14\n" +
+	"\x05local\x18\x06 \x01(\bR\x05local\x12\x16\n" +
+	"\x06active\x18\a \x01(\bR\x06active\"\xdb\x01\n" +
+	"\x10QueueDescription\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06headId\x18\x02 \x01(\x03R\x06headId\x12\x16\n" +
+	"\x06tailId\x18\x03 \x01(\x03R\x06tailId\x12\x16\n" +
+	"\x06nextId\x18\x04 \x01(\x04R\x06nextId\x12\x1a\n" +
+	"\bmessages\x18\x05 \x01(\x04R\bmessages\x12\x1e\n" +
+	"\n" +
+	"totalBytes\x18\x06 \x01(\x04R\n" +
+	"totalBytes\x12/\n" +
+	"\bsegments\x18\a \x03(\v2\x13.SegmentDescriptionR\bsegments\"\x16\n" +
+	"\x14ListConsumersRequest\"w\n" +
+	"\x13ConsumerDescription\x12\x1e\n" +
+	"\n" +
+	"consumerId\x18\x01 \x01(\x04R\n" +
+	"consumerId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x10\n" +
+	"\x03lag\x18\x03 \x01(\x04R\x03lag\x12\x16\n" +
+	"\x06online\x18\x04 \x01(\bR\x06online\"B\n" +
+	"\fConsumerList\x122\n" +
+	"\tconsumers\x18\x01 \x03(\v2\x14.ConsumerDescriptionR\tconsumers\"\xc6\x01\n" +
+	"\x14ResetConsumerRequest\x12\x1e\n" +
+	"\n" +
+	"consumerId\x18\x01 \x01(\x04R\n" +
+	"consumerId\x12:\n" +
+	"\bposition\x18\x02 \x01(\x0e2\x1e.ResetConsumerRequest.PositionR\bposition\x12\x1c\n" +
+	"\tmessageId\x18\x03 \x01(\x04R\tmessageId\"4\n" +
+	"\bPosition\x12\x0e\n" +
+	"\n" +
+	"MESSAGE_ID\x10\x00\x12\f\n" +
+	"\bEARLIEST\x10\x01\x12\n" +
+	"\n" +
+	"\x06LATEST\x10\x02\"\x13\n" +
+	"\x11PurgeQueueRequest\"F\n" +
+	"\x12PurgeQueueResponse\x12\x18\n" +
+	"\adropped\x18\x01 \x01(\x04R\adropped\x12\x16\n" +
+	"\x06nextId\x18\x02 \x01(\x04R\x06nextId\"\x95\x01\n" +
+	"\x12ExportQueueRequest\x12\x16\n" +
+	"\x06fromId\x18\x01 \x01(\x04R\x06fromId\x12\x12\n" +
+	"\x04toId\x18\x02 \x01(\x04R\x04toId\x122\n" +
+	"\x06format\x18\x03 \x01(\x0e2\x1a.ExportQueueRequest.FormatR\x06format\"\x1f\n" +
+	"\x06Format\x12\t\n" +
+	"\x05JSONL\x10\x00\x12\n" +
+	"\n" +
+	"\x06BINARY\x10\x01\"!\n" +
+	"\vExportChunk\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"C\n" +
+	"\vImportChunk\x12 \n" +
+	"\vpreserveIds\x18\x01 \x01(\bR\vpreserveIds\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"I\n" +
+	"\x13ImportQueueResponse\x12\x1a\n" +
+	"\bimported\x18\x01 \x01(\x04R\bimported\x12\x16\n" +
+	"\x06nextId\x18\x02 \x01(\x04R\x06nextId\"/\n" +
+	"\x0fSnapshotRequest\x12\x1c\n" +
+	"\tdirectory\x18\x01 \x01(\tR\tdirectory\"\xa4\x01\n" +
+	"\x10SnapshotResponse\x12\x1c\n" +
+	"\tdirectory\x18\x01 \x01(\tR\tdirectory\x12\x1a\n" +
+	"\bsegments\x18\x02 \x01(\x04R\bsegments\x12 \n" +
+	"\vsizeInBytes\x18\x03 \x01(\x04R\vsizeInBytes\x12\x16\n" +
+	"\x06nextId\x18\x04 \x01(\x04R\x06nextId\x12\x1c\n" +
+	"\tconsumers\x18\x05 \x01(\x04R\tconsumers2\xa2\x01\n" +
+	"\fQueueService\x123\n" +
+	"\aEnqueue\x12\x0f.EnqueueRequest\x1a\x17.EnqueueRequestResponse\x125\n" +
+	"\fObserveQueue\x12\x14.ObserveQueueRequest\x1a\r.QueueMessage0\x01\x12&\n" +
+	"\x05Fetch\x12\r.FetchRequest\x1a\x0e.FetchResponse2\x84\x01\n" +
+	"\x12ReplicationService\x125\n" +
+	"\tReplicate\x12\x11.ReplicateRequest\x1a\x11.ReplicationBatch(\x010\x01\x127\n" +
+	"\x06Status\x12\x19.ReplicationStatusRequest\x1a\x12.ReplicationStatus2\xd7\x03\n" +
+	"\fAdminService\x12'\n" +
+	"\tGetQuotas\x12\x11.GetQuotasRequest\x1a\a.Quotas\x12\x1d\n" +
+	"\tSetQuotas\x12\a.Quotas\x1a\a.Quotas\x129\n" +
+	"\rDescribeQueue\x12\x15.DescribeQueueRequest\x1a\x11.QueueDescription\x125\n" +
+	"\rListConsumers\x12\x15.ListConsumersRequest\x1a\r.ConsumerList\x12<\n" +
+	"\rResetConsumer\x12\x15.ResetConsumerRequest\x1a\x14.ConsumerDescription\x125\n" +
+	"\n" +
+	"PurgeQueue\x12\x12.PurgeQueueRequest\x1a\x13.PurgeQueueResponse\x122\n" +
+	"\vExportQueue\x12\x13.ExportQueueRequest\x1a\f.ExportChunk0\x01\x123\n" +
+	"\vImportQueue\x12\f.ImportChunk\x1a\x14.ImportQueueResponse(\x01\x12/\n" +
+	"\bSnapshot\x12\x10.SnapshotRequest\x1a\x11.SnapshotResponseB#Z!ashishkujoy/queue/net;netinternalb\x06proto3"
 
 var (
 	file_proto_queue_proto_rawDescOnce sync.Once
@@ -228,23 +2059,96 @@ func file_proto_queue_proto_rawDescGZIP() []byte {
 	return file_proto_queue_proto_rawDescData
 }
 
-var file_proto_queue_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_queue_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_proto_queue_proto_msgTypes = make([]protoimpl.MessageInfo, 34)
 var file_proto_queue_proto_goTypes = []any{
-	(*EnqueueRequest)(nil),         // 0: EnqueueRequest
-	(*EnqueueRequestResponse)(nil), // 1: EnqueueRequestResponse
-	(*ObserveQueueRequest)(nil),    // 2: ObserveQueueRequest
-	(*QueueMessage)(nil),           // 3: QueueMessage
+	(ResetConsumerRequest_Position)(0), // 0: ResetConsumerRequest.Position
+	(ExportQueueRequest_Format)(0),     // 1: ExportQueueRequest.Format
+	(*EnqueueRequest)(nil),             // 2: EnqueueRequest
+	(*EnqueueRequestResponse)(nil),     // 3: EnqueueRequestResponse
+	(*ObserveQueueRequest)(nil),        // 4: ObserveQueueRequest
+	(*QueueMessage)(nil),               // 5: QueueMessage
+	(*FetchRequest)(nil),               // 6: FetchRequest
+	(*FetchResponse)(nil),              // 7: FetchResponse
+	(*ReplicateRequest)(nil),           // 8: ReplicateRequest
+	(*ReplicatedRecord)(nil),           // 9: ReplicatedRecord
+	(*ReplicationBatch)(nil),           // 10: ReplicationBatch
+	(*ReplicationStatusRequest)(nil),   // 11: ReplicationStatusRequest
+	(*FollowerStatus)(nil),             // 12: FollowerStatus
+	(*ReplicationStatus)(nil),          // 13: ReplicationStatus
+	(*QuotaLimits)(nil),                // 14: QuotaLimits
+	(*ClientQuota)(nil),                // 15: ClientQuota
+	(*Quotas)(nil),                     // 16: Quotas
+	(*GetQuotasRequest)(nil),           // 17: GetQuotasRequest
+	(*DescribeQueueRequest)(nil),       // 18: DescribeQueueRequest
+	(*SegmentDescription)(nil),         // 19: SegmentDescription
+	(*QueueDescription)(nil),           // 20: QueueDescription
+	(*ListConsumersRequest)(nil),       // 21: ListConsumersRequest
+	(*ConsumerDescription)(nil),        // 22: ConsumerDescription
+	(*ConsumerList)(nil),               // 23: ConsumerList
+	(*ResetConsumerRequest)(nil),       // 24: ResetConsumerRequest
+	(*PurgeQueueRequest)(nil),          // 25: PurgeQueueRequest
+	(*PurgeQueueResponse)(nil),         // 26: PurgeQueueResponse
+	(*ExportQueueRequest)(nil),         // 27: ExportQueueRequest
+	(*ExportChunk)(nil),                // 28: ExportChunk
+	(*ImportChunk)(nil),                // 29: ImportChunk
+	(*ImportQueueResponse)(nil),        // 30: ImportQueueResponse
+	(*SnapshotRequest)(nil),            // 31: SnapshotRequest
+	(*SnapshotResponse)(nil),           // 32: SnapshotResponse
+	nil,                                // 33: EnqueueRequest.HeadersEntry
+	nil,                                // 34: QueueMessage.HeadersEntry
+	nil,                                // 35: ReplicatedRecord.HeadersEntry
 }
 var file_proto_queue_proto_depIdxs = []int32{
-	0, // 0: QueueService.Enqueue:input_type -> EnqueueRequest
-	2, // 1: QueueService.ObserveQueue:input_type -> ObserveQueueRequest
-	1, // 2: QueueService.Enqueue:output_type -> EnqueueRequestResponse
-	3, // 3: QueueService.ObserveQueue:output_type -> QueueMessage
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	33, // 0: EnqueueRequest.headers:type_name -> EnqueueRequest.HeadersEntry
+	34, // 1: QueueMessage.headers:type_name -> QueueMessage.HeadersEntry
+	5,  // 2: FetchResponse.messages:type_name -> QueueMessage
+	35, // 3: ReplicatedRecord.headers:type_name -> ReplicatedRecord.HeadersEntry
+	9,  // 4: ReplicationBatch.records:type_name -> ReplicatedRecord
+	12, // 5: ReplicationStatus.followers:type_name -> FollowerStatus
+	14, // 6: ClientQuota.produce:type_name -> QuotaLimits
+	14, // 7: ClientQuota.consume:type_name -> QuotaLimits
+	14, // 8: Quotas.produce:type_name -> QuotaLimits
+	14, // 9: Quotas.consume:type_name -> QuotaLimits
+	14, // 10: Quotas.queue:type_name -> QuotaLimits
+	15, // 11: Quotas.clients:type_name -> ClientQuota
+	19, // 12: QueueDescription.segments:type_name -> SegmentDescription
+	22, // 13: ConsumerList.consumers:type_name -> ConsumerDescription
+	0,  // 14: ResetConsumerRequest.position:type_name -> ResetConsumerRequest.Position
+	1,  // 15: ExportQueueRequest.format:type_name -> ExportQueueRequest.Format
+	2,  // 16: QueueService.Enqueue:input_type -> EnqueueRequest
+	4,  // 17: QueueService.ObserveQueue:input_type -> ObserveQueueRequest
+	6,  // 18: QueueService.Fetch:input_type -> FetchRequest
+	8,  // 19: ReplicationService.Replicate:input_type -> ReplicateRequest
+	11, // 20: ReplicationService.Status:input_type -> ReplicationStatusRequest
+	17, // 21: AdminService.GetQuotas:input_type -> GetQuotasRequest
+	16, // 22: AdminService.SetQuotas:input_type -> Quotas
+	18, // 23: AdminService.DescribeQueue:input_type -> DescribeQueueRequest
+	21, // 24: AdminService.ListConsumers:input_type -> ListConsumersRequest
+	24, // 25: AdminService.ResetConsumer:input_type -> ResetConsumerRequest
+	25, // 26: AdminService.PurgeQueue:input_type -> PurgeQueueRequest
+	27, // 27: AdminService.ExportQueue:input_type -> ExportQueueRequest
+	29, // 28: AdminService.ImportQueue:input_type -> ImportChunk
+	31, // 29: AdminService.Snapshot:input_type -> SnapshotRequest
+	3,  // 30: QueueService.Enqueue:output_type -> EnqueueRequestResponse
+	5,  // 31: QueueService.ObserveQueue:output_type -> QueueMessage
+	7,  // 32: QueueService.Fetch:output_type -> FetchResponse
+	10, // 33: ReplicationService.Replicate:output_type -> ReplicationBatch
+	13, // 34: ReplicationService.Status:output_type -> ReplicationStatus
+	16, // 35: AdminService.GetQuotas:output_type -> Quotas
+	16, // 36: AdminService.SetQuotas:output_type -> Quotas
+	20, // 37: AdminService.DescribeQueue:output_type -> QueueDescription
+	23, // 38: AdminService.ListConsumers:output_type -> ConsumerList
+	22, // 39: AdminService.ResetConsumer:output_type -> ConsumerDescription
+	26, // 40: AdminService.PurgeQueue:output_type -> PurgeQueueResponse
+	28, // 41: AdminService.ExportQueue:output_type -> ExportChunk
+	30, // 42: AdminService.ImportQueue:output_type -> ImportQueueResponse
+	32, // 43: AdminService.Snapshot:output_type -> SnapshotResponse
+	30, // [30:44] is the sub-list for method output_type
+	16, // [16:30] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
 }
 
 func init() { file_proto_queue_proto_init() }
@@ -257,13 +2161,14 @@ func file_proto_queue_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_queue_proto_rawDesc), len(file_proto_queue_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   4,
+			NumEnums:      2,
+			NumMessages:   34,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   3,
 		},
 		GoTypes:           file_proto_queue_proto_goTypes,
 		DependencyIndexes: file_proto_queue_proto_depIdxs,
+		EnumInfos:         file_proto_queue_proto_enumTypes,
 		MessageInfos:      file_proto_queue_proto_msgTypes,
 	}.Build()
 	File_proto_queue_proto = out.File